@@ -0,0 +1,283 @@
+// Package bridge implements relaying of a group's chat to an external
+// chat network.
+package bridge
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/logging"
+)
+
+var logger = logging.New("bridge")
+
+// A network is a connection to an external chat network.
+type network interface {
+	// Send sends a chat message to the external network.  Kind is
+	// either "" or "me".
+	Send(username, kind, text string) error
+	// Done returns a channel that is closed when the connection is lost.
+	Done() <-chan struct{}
+	// Close terminates the connection.
+	Close() error
+}
+
+// dial connects to the network described by desc.  The function receive
+// is called for every message received from the network.
+func dial(desc *group.ChatBridgeDescription, receive func(username, kind, text string)) (network, error) {
+	switch desc.Type {
+	case "irc":
+		return dialIRC(desc, receive)
+	default:
+		return nil, errors.New("unknown chat bridge type " + desc.Type)
+	}
+}
+
+type Client struct {
+	group *group.Group
+	id    string
+	desc  group.ChatBridgeDescription
+
+	mu      sync.Mutex
+	network network
+	closed  bool
+	done    chan struct{}
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newClient(g *group.Group, desc *group.ChatBridgeDescription) *Client {
+	return &Client{
+		group: g,
+		id:    newId(),
+		desc:  *desc,
+		done:  make(chan struct{}),
+	}
+}
+
+func (client *Client) Group() *group.Group {
+	return client.group
+}
+
+func (client *Client) Id() string {
+	return client.id
+}
+
+func (client *Client) Username() string {
+	return "BRIDGE"
+}
+
+func (client *Client) SetUsername(string) {
+	return
+}
+
+func (client *Client) SetPermissions(perms []string) {
+	return
+}
+
+func (client *Client) Permissions() []string {
+	return []string{"system"}
+}
+
+func (client *Client) Data() map[string]interface{} {
+	return nil
+}
+
+func (client *Client) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+
+func (client *Client) RequestConns(target group.Client, g *group.Group, id string) error {
+	return nil
+}
+
+func (client *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+
+func (client *Client) Addr() net.Addr {
+	return nil
+}
+
+func (client *Client) Joined(group, kind string) error {
+	return nil
+}
+
+func (client *Client) Kick(id string, user *string, message string) error {
+	err := client.Close()
+	group.DelClient(client)
+	return err
+}
+
+func (client *Client) Close() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.closed {
+		return nil
+	}
+	client.closed = true
+	close(client.done)
+	if client.network != nil {
+		err := client.network.Close()
+		client.network = nil
+		return err
+	}
+	return nil
+}
+
+// Chat is called by the group whenever a public chat message is sent.
+func (client *Client) Chat(source string, username *string, privileged, noecho bool, t time.Time, kind string, value interface{}) error {
+	if kind != "" && kind != "me" {
+		return nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	u := ""
+	if username != nil {
+		u = *username
+	}
+
+	client.mu.Lock()
+	network := client.network
+	client.mu.Unlock()
+
+	if network == nil {
+		return nil
+	}
+	return network.Send(u, kind, text)
+}
+
+func (client *Client) receive(username, kind, text string) {
+	client.group.Chat(
+		client, client.id, &username, false, false, time.Now(),
+		kind, text,
+	)
+}
+
+// run maintains the connection to the external network, reconnecting
+// with exponential backoff whenever it fails.
+func (client *Client) run() {
+	delay := 5 * time.Second
+	for {
+		connected := time.Now()
+		n, err := dial(&client.desc, client.receive)
+		if err != nil {
+			logger.Warn("Connect",
+				"group", client.group.Name(), "err", err)
+		} else {
+			client.mu.Lock()
+			if client.closed {
+				client.mu.Unlock()
+				n.Close()
+				return
+			}
+			client.network = n
+			client.mu.Unlock()
+
+			select {
+			case <-n.Done():
+			case <-client.done:
+			}
+
+			client.mu.Lock()
+			if client.network == n {
+				client.network = nil
+			}
+			client.mu.Unlock()
+			n.Close()
+		}
+
+		if time.Since(connected) > 5*time.Minute {
+			delay = 5 * time.Second
+		}
+		select {
+		case <-client.done:
+			return
+		case <-time.After(delay):
+		}
+		delay = delay * 2
+		if delay > 10*time.Minute {
+			delay = 10 * time.Minute
+		}
+	}
+}
+
+var bridges struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// Update starts a bridge for every group that has a chat bridge
+// configured, and stops the bridges that are no longer configured.
+func Update() {
+	names, err := group.GetAllDescriptionNames()
+	if err != nil {
+		logger.Warn("Get groups", "err", err)
+		return
+	}
+
+	bridges.mu.Lock()
+	defer bridges.mu.Unlock()
+
+	if bridges.clients == nil {
+		bridges.clients = make(map[string]*Client)
+	}
+
+	configured := make(map[string]bool)
+	for _, name := range names {
+		desc, err := group.GetDescription(name)
+		if err != nil || desc.ChatBridge == nil {
+			continue
+		}
+		configured[name] = true
+
+		client := bridges.clients[name]
+		if client != nil {
+			g := group.Get(name)
+			if g == client.group && g.GetClient(client.id) != nil &&
+				client.desc == *desc.ChatBridge {
+				continue
+			}
+			client.Close()
+			group.DelClient(client)
+			delete(bridges.clients, name)
+		}
+
+		g, err := group.Add(name, nil)
+		if err != nil {
+			logger.Warn("Start bridge", "group", name, "err", err)
+			continue
+		}
+		client = newClient(g, desc.ChatBridge)
+		_, err = group.AddClient(name, client,
+			group.ClientCredentials{System: true},
+		)
+		if err != nil {
+			logger.Warn("Start bridge", "group", name, "err", err)
+			continue
+		}
+		bridges.clients[name] = client
+		go client.run()
+	}
+
+	for name, client := range bridges.clients {
+		if !configured[name] {
+			client.Close()
+			group.DelClient(client)
+			delete(bridges.clients, name)
+		}
+	}
+}
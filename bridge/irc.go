@@ -0,0 +1,256 @@
+package bridge
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jech/galene/group"
+)
+
+// The maximum length of the text of a PRIVMSG.  The protocol limits
+// lines to 512 bytes, including the command and the channel name.
+const ircMaxText = 400
+
+// ircConn is a connection to an IRC server.
+type ircConn struct {
+	conn    net.Conn
+	channel string
+	nick    string
+	receive func(username, kind, text string)
+
+	sendCh chan string
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func dialIRC(desc *group.ChatBridgeDescription, receive func(username, kind, text string)) (*ircConn, error) {
+	if desc.Server == "" || desc.Channel == "" {
+		return nil, errors.New("IRC bridge requires a server and a channel")
+	}
+	nick := desc.Nick
+	if nick == "" {
+		nick = "galene"
+	}
+
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if desc.TLS {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", desc.Server, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", desc.Server)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ircConn{
+		conn:    conn,
+		channel: desc.Channel,
+		nick:    nick,
+		receive: receive,
+		sendCh:  make(chan string, 64),
+		done:    make(chan struct{}),
+	}
+
+	if desc.Password != "" {
+		c.writeLine("PASS " + desc.Password)
+	}
+	c.writeLine("NICK " + nick)
+	c.writeLine("USER " + nick + " 0 * :Galene chat bridge")
+
+	go c.reader()
+	go c.writer()
+	return c, nil
+}
+
+func (c *ircConn) writeLine(line string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func (c *ircConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *ircConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	return c.conn.Close()
+}
+
+// writer sends queued lines, pacing them in order to avoid being
+// disconnected for flooding.
+func (c *ircConn) writer() {
+	defer c.Close()
+	for {
+		select {
+		case line := <-c.sendCh:
+			err := c.writeLine(line)
+			if err != nil {
+				return
+			}
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-c.done:
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *ircConn) reader() {
+	defer c.Close()
+	r := bufio.NewReader(c.conn)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		prefix, command, params := parseIRCLine(line)
+		switch command {
+		case "PING":
+			arg := ""
+			if len(params) > 0 {
+				arg = params[0]
+			}
+			err = c.writeLine("PONG :" + arg)
+			if err != nil {
+				return
+			}
+		case "001":
+			err = c.writeLine("JOIN " + c.channel)
+			if err != nil {
+				return
+			}
+		case "433":
+			// nickname in use
+			c.nick = c.nick + "_"
+			err = c.writeLine("NICK " + c.nick)
+			if err != nil {
+				return
+			}
+		case "ERROR":
+			return
+		case "PRIVMSG":
+			if len(params) < 2 ||
+				!strings.EqualFold(params[0], c.channel) {
+				continue
+			}
+			nick, _, _ := strings.Cut(prefix, "!")
+			text := params[1]
+			kind := ""
+			if strings.HasPrefix(text, "\x01ACTION ") {
+				kind = "me"
+				text = strings.TrimSuffix(
+					text[len("\x01ACTION "):], "\x01",
+				)
+			} else if strings.HasPrefix(text, "\x01") {
+				// other CTCP messages
+				continue
+			}
+			if nick != "" && text != "" {
+				c.receive(nick, kind, text)
+			}
+		}
+	}
+}
+
+// parseIRCLine splits a line into its prefix, command and parameters.
+func parseIRCLine(line string) (string, string, []string) {
+	line = strings.TrimRight(line, "\r\n")
+	prefix := ""
+	if strings.HasPrefix(line, ":") {
+		var rest string
+		prefix, rest, _ = strings.Cut(line[1:], " ")
+		line = rest
+	}
+	var params []string
+	var trailing *string
+	if i := strings.Index(line, " :"); i >= 0 {
+		t := line[i+2:]
+		trailing = &t
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return prefix, "", nil
+	}
+	params = fields[1:]
+	if trailing != nil {
+		params = append(params, *trailing)
+	}
+	return prefix, strings.ToUpper(fields[0]), params
+}
+
+// splitText splits text into lines no longer than max bytes, without
+// splitting UTF-8 sequences.
+func splitText(text string, max int) []string {
+	var result []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.ReplaceAll(line, "\r", "")
+		for len(line) > max {
+			i := max
+			for i > 0 && !utf8.RuneStart(line[i]) {
+				i--
+			}
+			if i == 0 {
+				i = max
+			}
+			result = append(result, line[:i])
+			line = line[i:]
+		}
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func (c *ircConn) Send(username, kind, text string) error {
+	username = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+		return r
+	}, username)
+	if username == "" {
+		username = "(anonymous)"
+	}
+	for _, line := range splitText(text, ircMaxText) {
+		var m string
+		if kind == "me" {
+			m = fmt.Sprintf("PRIVMSG %v :\x01ACTION %v %v\x01",
+				c.channel, username, line)
+		} else {
+			m = fmt.Sprintf("PRIVMSG %v :<%v> %v",
+				c.channel, username, line)
+		}
+		select {
+		case c.sendCh <- m:
+		case <-c.done:
+			return errors.New("connection closed")
+		default:
+			return errors.New("IRC send queue full")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestParseIRCLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		prefix  string
+		command string
+		params  []string
+	}{
+		{"PING :irc.example.org\r\n", "", "PING",
+			[]string{"irc.example.org"}},
+		{":nick!user@host PRIVMSG #galene :hello world\r\n",
+			"nick!user@host", "PRIVMSG",
+			[]string{"#galene", "hello world"}},
+		{":irc.example.org 001 galene :Welcome\r\n",
+			"irc.example.org", "001",
+			[]string{"galene", "Welcome"}},
+		{":nick JOIN #galene", "nick", "JOIN", []string{"#galene"}},
+		{"", "", "", nil},
+	}
+
+	for _, test := range tests {
+		prefix, command, params := parseIRCLine(test.line)
+		if prefix != test.prefix || command != test.command ||
+			!reflect.DeepEqual(params, test.params) {
+			t.Errorf("Parse %q: got %q %q %q, expected %q %q %q",
+				test.line, prefix, command, params,
+				test.prefix, test.command, test.params,
+			)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	lines := splitText("a\r\nb\n\nc", 10)
+	if !reflect.DeepEqual(lines, []string{"a", "b", "c"}) {
+		t.Errorf("Got %q", lines)
+	}
+
+	long := strings.Repeat("é", 10)
+	lines = splitText(long, 5)
+	if len(lines) != 5 {
+		t.Errorf("Expected 5 lines, got %v", len(lines))
+	}
+	if strings.Join(lines, "") != long {
+		t.Errorf("Got %q", lines)
+	}
+	for _, l := range lines {
+		if len(l) > 5 {
+			t.Errorf("Line too long: %q", l)
+		}
+	}
+}
+
+func TestIRC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 8)
+	sent := make(chan string, 8)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, command, _ := parseIRCLine(line)
+			switch command {
+			case "USER":
+				fmt.Fprintf(conn, ":server 001 galene :Welcome\r\n")
+			case "JOIN":
+				fmt.Fprintf(conn,
+					":bob!bob@host PRIVMSG #galene :hello\r\n",
+				)
+			case "PRIVMSG":
+				sent <- strings.TrimRight(line, "\r\n")
+			}
+		}
+	}()
+
+	c, err := dialIRC(&group.ChatBridgeDescription{
+		Type:    "irc",
+		Server:  listener.Addr().String(),
+		Channel: "#galene",
+	}, func(username, kind, text string) {
+		received <- username + ": " + text
+	})
+	if err != nil {
+		t.Fatalf("dialIRC: %v", err)
+	}
+	defer c.Close()
+
+	select {
+	case m := <-received:
+		if m != "bob: hello" {
+			t.Errorf("Received %q", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout")
+	}
+
+	err = c.Send("alice", "", "hi")
+	if err != nil {
+		t.Errorf("Send: %v", err)
+	}
+	select {
+	case m := <-sent:
+		if m != "PRIVMSG #galene :<alice> hi" {
+			t.Errorf("Sent %q", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout")
+	}
+}
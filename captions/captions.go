@@ -0,0 +1,424 @@
+// Package captions implements live captioning.  Each speaker's audio is
+// sent to an external speech-to-text service, and the resulting text is
+// broadcast to the members of the group.
+package captions
+
+import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/logging"
+)
+
+var logger = logging.New("captions")
+
+// The number of packets buffered for each speaker.  If the transcriber
+// is slower than that, packets are dropped.
+const queueLength = 256
+
+var ErrNotConfigured = errors.New("captions are not configured")
+
+type Client struct {
+	group  *group.Group
+	id     string
+	config group.CaptionsConfiguration
+
+	mu     sync.Mutex
+	down   map[string]*captionsConn
+	closed bool
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// New creates a new captioning client for the given group.  It fails
+// if no speech-to-text service is defined in the server configuration.
+func New(g *group.Group) (*Client, error) {
+	conf, err := group.GetConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	if conf.Captions == nil ||
+		(len(conf.Captions.Command) == 0 && conf.Captions.URL == "") {
+		return nil, ErrNotConfigured
+	}
+	return &Client{group: g, id: newId(), config: *conf.Captions}, nil
+}
+
+func (client *Client) Group() *group.Group {
+	return client.group
+}
+
+func (client *Client) Id() string {
+	return client.id
+}
+
+func (client *Client) Username() string {
+	return "CAPTIONS"
+}
+
+func (client *Client) SetUsername(string) {
+	return
+}
+
+func (client *Client) SetPermissions(perms []string) {
+	return
+}
+
+func (client *Client) Permissions() []string {
+	return []string{"system"}
+}
+
+func (client *Client) Data() map[string]interface{} {
+	return nil
+}
+
+func (client *Client) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+
+func (client *Client) RequestConns(target group.Client, g *group.Group, id string) error {
+	return nil
+}
+
+func (client *Client) Close() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for _, down := range client.down {
+		down.Close()
+	}
+	client.down = nil
+	client.closed = true
+	return nil
+}
+
+func (client *Client) Kick(id string, user *string, message string) error {
+	err := client.Close()
+	group.DelClient(client)
+	return err
+}
+
+func (client *Client) Addr() net.Addr {
+	return nil
+}
+
+func (client *Client) Joined(group, kind string) error {
+	return nil
+}
+
+func (client *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	if client.group != g {
+		return nil
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.closed {
+		return errors.New("captions client is closed")
+	}
+
+	if replace != "" {
+		rp := client.down[replace]
+		if rp != nil {
+			rp.Close()
+			delete(client.down, replace)
+		}
+	}
+
+	old := client.down[id]
+	if old != nil {
+		old.Close()
+		delete(client.down, id)
+	}
+
+	if up == nil {
+		return nil
+	}
+
+	var audio conn.UpTrack
+	for _, t := range tracks {
+		if strings.EqualFold(t.Codec().MimeType, "audio/opus") {
+			audio = t
+			break
+		}
+	}
+	if audio == nil {
+		return nil
+	}
+
+	if client.down == nil {
+		client.down = make(map[string]*captionsConn)
+	}
+
+	down, err := newCaptionsConn(client, up, audio)
+	if err != nil {
+		g.WallOps("Captions: " + err.Error())
+		return err
+	}
+
+	client.down[up.Id()] = down
+	return nil
+}
+
+type captionsConn struct {
+	client *Client
+	remote conn.Up
+	track  *captionsTrack
+}
+
+func newCaptionsConn(client *Client, up conn.Up, remote conn.UpTrack) (*captionsConn, error) {
+	source, username := up.User()
+	id := up.Id()
+
+	t, err := newTranscriber(client.config, func(text string) {
+		client.group.Caption(source, id, username, text)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &captionsConn{
+		client: client,
+		remote: up,
+	}
+	conn.track = &captionsTrack{
+		remote: remote,
+		ch:     make(chan []byte, queueLength),
+	}
+
+	go conn.track.run(t)
+
+	err = remote.AddLocal(conn.track)
+	if err != nil {
+		close(conn.track.ch)
+		return nil, err
+	}
+	err = up.AddLocal(conn)
+	if err != nil {
+		remote.DelLocal(conn.track)
+		close(conn.track.ch)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (conn *captionsConn) Close() error {
+	conn.remote.DelLocal(conn)
+	conn.track.close()
+	return nil
+}
+
+type captionsTrack struct {
+	remote conn.UpTrack
+
+	mu     sync.Mutex
+	ch     chan []byte
+	closed bool
+}
+
+func (t *captionsTrack) close() {
+	t.remote.DelLocal(t)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		close(t.ch)
+		t.closed = true
+	}
+}
+
+func (t *captionsTrack) run(w io.WriteCloser) {
+	ogg, err := oggwriter.NewWith(w, 48000, 2)
+	if err != nil {
+		logger.Warn("Create Ogg writer", "err", err)
+		w.Close()
+		for range t.ch {
+		}
+		return
+	}
+	defer ogg.Close()
+
+	var lastSeqno uint16
+	first := true
+	for buf := range t.ch {
+		var p rtp.Packet
+		err := p.Unmarshal(buf)
+		if err != nil {
+			continue
+		}
+		// the Ogg writer doesn't deal with reordering.
+		if !first && ((p.SequenceNumber-lastSeqno)&0x8000) != 0 {
+			continue
+		}
+		first = false
+		lastSeqno = p.SequenceNumber
+		err = ogg.WriteRTP(&p)
+		if err != nil {
+			logger.Warn("Write RTP", "err", err)
+			return
+		}
+	}
+}
+
+// Write is called by the up track's writer, and must therefore never
+// block.
+func (t *captionsTrack) Write(buf []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return 0, nil
+	}
+
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	select {
+	case t.ch <- data:
+	default:
+	}
+	return len(buf), nil
+}
+
+func (t *captionsTrack) SetTimeOffset(ntp uint64, rtp uint32) {
+}
+
+func (t *captionsTrack) SetCname(string) {
+}
+
+func (t *captionsTrack) GetMaxBitrate() (uint64, int, int) {
+	return ^uint64(0), -1, -1
+}
+
+// newTranscriber starts the speech-to-text service.  The returned
+// writer accepts an Ogg/Opus stream, and caption is called for every
+// line of text produced by the service.
+func newTranscriber(config group.CaptionsConfiguration, caption func(string)) (io.WriteCloser, error) {
+	if len(config.Command) > 0 {
+		return startCommand(config.Command, caption)
+	}
+	return dialWebSocket(config.URL, caption)
+}
+
+func readCaptions(r io.Reader, caption func(string)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text != "" {
+			caption(text)
+		}
+	}
+}
+
+type commandTranscriber struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func startCommand(command []string, caption func(string)) (*commandTranscriber, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		readCaptions(stdout, caption)
+		err := cmd.Wait()
+		if err != nil {
+			logger.Warn("Speech-to-text command",
+				"command", command[0], "err", err)
+		}
+	}()
+
+	return &commandTranscriber{cmd: cmd, stdin: stdin}, nil
+}
+
+func (t *commandTranscriber) Write(buf []byte) (int, error) {
+	return t.stdin.Write(buf)
+}
+
+// Close closes the command's standard input, and kills the command if
+// it doesn't terminate in a reasonable amount of time.
+func (t *commandTranscriber) Close() error {
+	err := t.stdin.Close()
+	process := t.cmd.Process
+	time.AfterFunc(30*time.Second, func() {
+		process.Kill()
+	})
+	return err
+}
+
+type webSocketTranscriber struct {
+	conn *websocket.Conn
+}
+
+func dialWebSocket(url string, caption func(string)) (*webSocketTranscriber, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer conn.Close()
+		for {
+			tpe, r, err := conn.NextReader()
+			if err != nil {
+				if !websocket.IsCloseError(err,
+					websocket.CloseNormalClosure) {
+					logger.Warn("Speech-to-text service", "err", err)
+				}
+				return
+			}
+			if tpe == websocket.TextMessage {
+				readCaptions(r, caption)
+			}
+		}
+	}()
+
+	return &webSocketTranscriber{conn: conn}, nil
+}
+
+func (t *webSocketTranscriber) Write(buf []byte) (int, error) {
+	err := t.conn.WriteMessage(websocket.BinaryMessage, buf)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Close signals the end of the audio stream.  The server is given some
+// time to deliver the final captions before the connection is closed.
+func (t *webSocketTranscriber) Close() error {
+	err := t.conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+	)
+	t.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	return err
+}
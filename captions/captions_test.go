@@ -0,0 +1,169 @@
+package captions
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+func TestReadCaptions(t *testing.T) {
+	var captions []string
+	readCaptions(strings.NewReader("hello\n\n  world \r\nlast"),
+		func(text string) {
+			captions = append(captions, text)
+		},
+	)
+	expected := []string{"hello", "world", "last"}
+	if !reflect.DeepEqual(captions, expected) {
+		t.Errorf("Expected %v, got %v", expected, captions)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	var mu sync.Mutex
+	var captions []string
+	done := make(chan struct{})
+	tr, err := startCommand(
+		[]string{"sh", "-c", "wc -c; echo done"},
+		func(text string) {
+			mu.Lock()
+			defer mu.Unlock()
+			captions = append(captions, text)
+			if text == "done" {
+				close(done)
+			}
+		},
+	)
+	if err != nil {
+		t.Fatalf("startCommand: %v", err)
+	}
+	_, err = tr.Write([]byte("12345"))
+	if err != nil {
+		t.Errorf("Write: %v", err)
+	}
+	err = tr.Close()
+	if err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Command didn't terminate")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"5", "done"}
+	if !reflect.DeepEqual(captions, expected) {
+		t.Errorf("Expected %v, got %v", expected, captions)
+	}
+}
+
+type fakeUp struct{}
+
+func (fakeUp) AddLocal(conn.Down) error { return nil }
+func (fakeUp) DelLocal(conn.Down) bool  { return true }
+func (fakeUp) Id() string               { return "stream" }
+func (fakeUp) Label() string            { return "camera" }
+func (fakeUp) User() (string, string)   { return "speaker", "alice" }
+
+type fakeUpTrack struct{}
+
+func (fakeUpTrack) AddLocal(conn.DownTrack) error { return nil }
+func (fakeUpTrack) DelLocal(conn.DownTrack) bool  { return true }
+func (fakeUpTrack) Kind() webrtc.RTPCodecType {
+	return webrtc.RTPCodecTypeAudio
+}
+func (fakeUpTrack) Label() string { return "audio" }
+func (fakeUpTrack) Codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: "audio/opus"}
+}
+func (fakeUpTrack) GetPacket(uint16, []byte, bool) uint16 { return 0 }
+func (fakeUpTrack) RequestKeyframe() error                { return nil }
+
+// captionClient is a group member that records the captions it receives.
+type captionClient struct {
+	group    *group.Group
+	captions chan [4]string
+}
+
+func (c *captionClient) Group() *group.Group             { return c.group }
+func (c *captionClient) Addr() net.Addr                  { return nil }
+func (c *captionClient) Id() string                      { return "listener" }
+func (c *captionClient) Username() string                { return "" }
+func (c *captionClient) SetUsername(string)              {}
+func (c *captionClient) Permissions() []string           { return []string{"system"} }
+func (c *captionClient) SetPermissions([]string)         {}
+func (c *captionClient) Data() map[string]interface{}    { return nil }
+func (c *captionClient) Joined(group, kind string) error { return nil }
+func (c *captionClient) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+func (c *captionClient) RequestConns(target group.Client, g *group.Group, id string) error {
+	return nil
+}
+func (c *captionClient) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+func (c *captionClient) Kick(id string, user *string, message string) error {
+	return nil
+}
+func (c *captionClient) Caption(source, id, username, text string) error {
+	c.captions <- [4]string{source, id, username, text}
+	return nil
+}
+
+func TestCaptionFields(t *testing.T) {
+	defer func(d string) { group.Directory = d }(group.Directory)
+	group.Directory = t.TempDir()
+	err := os.WriteFile(
+		filepath.Join(group.Directory, "captions-test.json"),
+		[]byte("{}"), 0o600,
+	)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	listener := &captionClient{captions: make(chan [4]string, 1)}
+	g, err := group.AddClient("captions-test", listener,
+		group.ClientCredentials{})
+	if err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	listener.group = g
+	defer group.Delete("captions-test")
+	defer group.DelClient(listener)
+
+	client := &Client{
+		group: g,
+		id:    "captions",
+		config: group.CaptionsConfiguration{
+			Command: []string{"sh", "-c", "echo hello; cat > /dev/null"},
+		},
+		down: make(map[string]*captionsConn),
+	}
+	down, err := newCaptionsConn(client, fakeUp{}, fakeUpTrack{})
+	if err != nil {
+		t.Fatalf("newCaptionsConn: %v", err)
+	}
+	defer down.Close()
+
+	select {
+	case c := <-listener.captions:
+		expected := [4]string{"speaker", "stream", "alice", "hello"}
+		if c != expected {
+			t.Errorf("Expected %v, got %v", expected, c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No caption received")
+	}
+}
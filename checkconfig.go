@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/ice"
+)
+
+// checkConfig validates the configuration files and the group
+// definitions, and returns the number of errors found.
+func checkConfig(configFile string) int {
+	files, errs := 0, 0
+	report := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		errs++
+	}
+
+	if configFile != "" {
+		files++
+		config, err := readServerConfig(configFile, true, nil)
+		if err != nil {
+			report(err)
+		} else {
+			for i, s := range config.ICE.Servers {
+				err := ice.CheckServer(s)
+				if err != nil {
+					report(fmt.Errorf(
+						"%v: ICE server %v: %w",
+						configFile, i, err,
+					))
+				}
+			}
+		}
+	}
+
+	// the files are parsed by the same functions as in the server, and
+	// read again in order to annotate errors with their position
+	check := func(filename string, f func() error) {
+		data, err := os.ReadFile(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		files++
+		if err == nil {
+			err = f()
+			if err != nil {
+				err = jsonError(filename, data, err)
+			}
+		}
+		if err != nil {
+			report(err)
+		}
+	}
+
+	check(filepath.Join(group.DataDirectory, "config.json"), func() error {
+		conf, err := group.CheckConfiguration()
+		if err != nil || conf.Transcode == nil {
+			return err
+		}
+		ffmpeg := conf.Transcode.FFmpeg
+		if ffmpeg == "" {
+			ffmpeg = "ffmpeg"
+		}
+		_, err = exec.LookPath(ffmpeg)
+		if err != nil {
+			return fmt.Errorf("transcode: %w", err)
+		}
+		return nil
+	})
+
+	check(filepath.Join(group.DataDirectory, "users.json"),
+		group.CheckRegistry)
+
+	if ice.ICEServers == nil {
+		check(ice.ICEFilename, ice.CheckServers)
+	}
+
+	err := ice.CheckGeoIPDatabase()
+	if err == nil {
+		files++
+	} else if !errors.Is(err, os.ErrNotExist) {
+		report(err)
+	}
+
+	err = filepath.WalkDir(group.Directory,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				report(err)
+				return nil
+			}
+			base := filepath.Base(path)
+			if base[0] == '.' && path != group.Directory {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || !strings.HasSuffix(base, ".json") {
+				return nil
+			}
+			name, err := filepath.Rel(group.Directory, path)
+			if err != nil {
+				report(err)
+				return nil
+			}
+			name = filepath.ToSlash(strings.TrimSuffix(name, ".json"))
+			check(path, func() error {
+				return group.CheckDescription(name)
+			})
+			return nil
+		},
+	)
+	if err != nil {
+		report(err)
+	}
+
+	fmt.Printf("Checked %v files, found %v errors.\n", files, errs)
+	return errs
+}
@@ -0,0 +1,103 @@
+package codecs
+
+import (
+	"github.com/pion/rtp"
+)
+
+type av1Parser struct{}
+
+func init() {
+	// AV1 is forwarded but not recorded.
+	Register(Codec{
+		MimeType:    "video/AV1",
+		Parser:      av1Parser{},
+		ClockRate:   90000,
+		PayloadType: fixedPayloadType(35),
+	})
+}
+
+// av1Obu returns the next OBU in data, the number of bytes consumed, and
+// whether the OBU is truncated.
+func av1Obu(data []byte, last bool) ([]byte, int, bool) {
+	if last {
+		return data, len(data), false
+	}
+	offset := 0
+	length := 0
+	for {
+		if len(data) <= offset {
+			return nil, offset, offset > 0
+		}
+		if offset >= 4 {
+			return nil, offset, true
+		}
+		l := data[offset]
+		length |= int(l&0x7f) << (offset * 7)
+		offset++
+		if (l & 0x80) == 0 {
+			break
+		}
+	}
+	if len(data) < offset+length {
+		return data[offset:], len(data), true
+	}
+	return data[offset : offset+length],
+		offset + length, false
+}
+
+func (av1Parser) IsKeyframe(packet *rtp.Packet) (bool, bool) {
+	if len(packet.Payload) < 2 {
+		return false, true
+	}
+	// Z=0, N=1
+	if (packet.Payload[0] & 0x88) != 0x08 {
+		return false, true
+	}
+	w := (packet.Payload[0] & 0x30) >> 4
+
+	offset := 1
+	i := 0
+	for {
+		obu, length, truncated :=
+			av1Obu(packet.Payload[offset:], int(w) == i+1)
+		if len(obu) < 1 {
+			return false, false
+		}
+		tpe := (obu[0] & 0x38) >> 3
+		switch i {
+		case 0:
+			// OBU_SEQUENCE_HEADER
+			if tpe != 1 {
+				return false, true
+			}
+		default:
+			// OBU_FRAME_HEADER or OBU_FRAME
+			if tpe == 3 || tpe == 6 {
+				if len(obu) < 2 {
+					return false, false
+				}
+				// show_existing_frame == 0
+				if (obu[1] & 0x80) != 0 {
+					return false, true
+				}
+				// frame_type == KEY_FRAME
+				return (obu[1] & 0x60) == 0, true
+			}
+		}
+		if truncated || i >= int(w) {
+			// the first frame header is in a second
+			// packet, give up.
+			return false, false
+		}
+		offset += length
+		i++
+	}
+}
+
+func (av1Parser) Dimensions(packet *rtp.Packet) (uint32, uint32) {
+	return 0, 0
+}
+
+func (av1Parser) LayerInfo(buf []byte, flags *Flags) error {
+	return nil
+}
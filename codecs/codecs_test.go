@@ -1,9 +1,11 @@
 package codecs
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
 )
 
 func TestVP8Keyframe(t *testing.T) {
@@ -246,3 +248,124 @@ func TestRewriteVP9(t *testing.T) {
 		}
 	}
 }
+
+func TestLookup(t *testing.T) {
+	for _, m := range []string{"video/VP8", "video/vp8", "VIDEO/VP8"} {
+		c := Lookup(m)
+		if c == nil || c.MatroskaID != "V_VP8" || !c.IsVideo() {
+			t.Errorf("%v: got %v", m, c)
+		}
+	}
+	if c := Lookup("audio/opus"); c == nil || c.IsVideo() || !c.WebM {
+		t.Errorf("opus: got %v", c)
+	}
+	if c := Lookup("video/AV1"); c == nil || c.CanRecord() {
+		t.Errorf("AV1: got %v", c)
+	}
+	if c := Lookup("video/H264"); c == nil || !c.CanRecord() || c.WebM {
+		t.Errorf("H.264: got %v", c)
+	}
+	if c := Lookup("video/unknown"); c != nil {
+		t.Errorf("unknown: got %v", c)
+	}
+}
+
+func TestFmtpValue(t *testing.T) {
+	type fmtpTest struct {
+		fmtp  string
+		key   string
+		value string
+	}
+	fmtpTests := []fmtpTest{
+		{"", "foo", ""},
+		{"profile-id=0", "profile-id", "0"},
+		{"profile-id=0", "foo", ""},
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", "profile-level-id", "42001f"},
+		{"foo=1;bar=2;quux=3", "foo", "1"},
+		{"foo=1;bar=2;quux=3", "bar", "2"},
+		{"foo=1;bar=2;quux=3", "fu", ""},
+	}
+
+	for _, test := range fmtpTests {
+		v := fmtpValue(test.fmtp, test.key)
+		if v != test.value {
+			t.Errorf("fmtpValue(%v, %v) = %v, expected %v",
+				test.fmtp, test.key, v, test.value,
+			)
+		}
+	}
+}
+
+func TestPayloadType(t *testing.T) {
+	tests := []struct {
+		mimeType, fmtp string
+		ptype          webrtc.PayloadType
+	}{
+		{"video/VP8", "", 96},
+		{"video/VP9", "profile-id=0", 98},
+		{"video/VP9", "profile-id=2", 100},
+		{"video/AV1", "", 35},
+		{"video/H264", "profile-level-id=42001f", 102},
+		{"video/H264", "packetization-mode=1;profile-level-id=42e01f", 108},
+		{"audio/opus", "minptime=10", 111},
+		{"audio/G722", "", 9},
+		{"audio/PCMU", "", 0},
+		{"audio/PCMA", "", 8},
+	}
+	for _, tt := range tests {
+		pt, err := PayloadType(webrtc.RTPCodecCapability{
+			MimeType:    tt.mimeType,
+			SDPFmtpLine: tt.fmtp,
+		})
+		if err != nil || pt != tt.ptype {
+			t.Errorf("%v %v: got %v %v, expected %v",
+				tt.mimeType, tt.fmtp, pt, err, tt.ptype)
+		}
+	}
+
+	for _, c := range []webrtc.RTPCodecCapability{
+		{MimeType: "video/VP9", SDPFmtpLine: "profile-id=1"},
+		{MimeType: "video/H264", SDPFmtpLine: "profile-level-id=64"},
+		{MimeType: "video/unknown"},
+	} {
+		pt, err := PayloadType(c)
+		if err == nil {
+			t.Errorf("%v %v: got %v", c.MimeType, c.SDPFmtpLine, pt)
+		}
+	}
+}
+
+func TestParameters(t *testing.T) {
+	for _, name := range []string{
+		"vp8", "vp9", "av1", "h264", "opus", "g722", "pcmu", "pcma",
+	} {
+		c := LookupName(name)
+		if c == nil {
+			t.Errorf("LookupName(%v) failed", name)
+			continue
+		}
+		ps, err := c.Parameters()
+		if err != nil || len(ps) == 0 {
+			t.Errorf("%v: got %v %v", name, ps, err)
+			continue
+		}
+		for _, p := range ps {
+			if !strings.EqualFold(p.MimeType, c.MimeType) ||
+				p.ClockRate == 0 {
+				t.Errorf("%v: got %v", name, p)
+			}
+			if c.IsVideo() != (len(p.RTCPFeedback) > 0) {
+				t.Errorf("%v: feedback %v", name, p.RTCPFeedback)
+			}
+		}
+	}
+
+	ps, _ := LookupName("vp9").Parameters()
+	if len(ps) != 2 || ps[0].PayloadType != 98 || ps[1].PayloadType != 100 {
+		t.Errorf("VP9: got %v", ps)
+	}
+
+	if c := LookupName("unknown"); c != nil {
+		t.Errorf("unknown: got %v", c)
+	}
+}
@@ -0,0 +1,18 @@
+package codecs
+
+func init() {
+	// G.711 is forwarded but not recorded, and has no parser since
+	// there is nothing to learn from its payload.
+	Register(Codec{
+		MimeType:    "audio/PCMU",
+		ClockRate:   8000,
+		Channels:    1,
+		PayloadType: fixedPayloadType(0),
+	})
+	Register(Codec{
+		MimeType:    "audio/PCMA",
+		ClockRate:   8000,
+		Channels:    1,
+		PayloadType: fixedPayloadType(8),
+	})
+}
@@ -0,0 +1,11 @@
+package codecs
+
+func init() {
+	// G.722 is forwarded but not recorded.
+	Register(Codec{
+		MimeType:    "audio/G722",
+		ClockRate:   8000,
+		Channels:    1,
+		PayloadType: fixedPayloadType(9),
+	})
+}
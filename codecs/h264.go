@@ -0,0 +1,120 @@
+package codecs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+type h264Parser struct{}
+
+func init() {
+	Register(Codec{
+		MimeType: "video/H264",
+		Parser:   h264Parser{},
+		NewDepacketizer: func() rtp.Depacketizer {
+			return &codecs.H264Packet{}
+		},
+		MatroskaID: "V_MPEG4/ISO/AVC",
+		ClockRate:  90000,
+		Fmtp: []string{
+			"level-asymmetry-allowed=1;packetization-mode=1;" +
+				"profile-level-id=42e01f",
+		},
+		PayloadType: h264PayloadType,
+	})
+}
+
+func h264PayloadType(fmtp string) (webrtc.PayloadType, error) {
+	profile := fmtpValue(fmtp, "profile-level-id")
+	if profile == "" {
+		return 102, nil
+	}
+	if len(profile) < 4 {
+		return 0, errors.New("malformed H.264 profile")
+	}
+	switch strings.ToLower(profile[:4]) {
+	case "4200":
+		return 102, nil
+	case "42e0":
+		return 108, nil
+	default:
+		return 0, fmt.Errorf("unknown H.264 profile %v", profile)
+	}
+}
+
+func (h264Parser) IsKeyframe(packet *rtp.Packet) (bool, bool) {
+	if len(packet.Payload) < 1 {
+		return false, false
+	}
+	nalu := packet.Payload[0] & 0x1F
+	if nalu == 0 {
+		// reserved
+		return false, false
+	} else if nalu <= 23 {
+		// simple NALU
+		return nalu == 7, true
+	} else if nalu == 24 || nalu == 25 || nalu == 26 || nalu == 27 {
+		// STAP-A, STAP-B, MTAP16 or MTAP24
+		i := 1
+		if nalu == 25 || nalu == 26 || nalu == 27 {
+			// skip DON
+			i += 2
+		}
+		for i < len(packet.Payload) {
+			if i+2 > len(packet.Payload) {
+				return false, false
+			}
+			length := uint16(packet.Payload[i])<<8 |
+				uint16(packet.Payload[i+1])
+			i += 2
+			if i+int(length) > len(packet.Payload) {
+				return false, false
+			}
+			offset := 0
+			if nalu == 26 {
+				offset = 3
+			} else if nalu == 27 {
+				offset = 4
+			}
+			if offset >= int(length) {
+				return false, false
+			}
+			n := packet.Payload[i+offset] & 0x1F
+			if n == 7 {
+				return true, true
+			} else if n >= 24 {
+				// is this legal?
+				return false, false
+			}
+			i += int(length)
+		}
+		if i == len(packet.Payload) {
+			return false, true
+		}
+		return false, false
+	} else if nalu == 28 || nalu == 29 {
+		// FU-A or FU-B
+		if len(packet.Payload) < 2 {
+			return false, false
+		}
+		if (packet.Payload[1] & 0x80) == 0 {
+			// not a starting fragment
+			return false, true
+		}
+		return (packet.Payload[1]&0x1F == 7), true
+	}
+	return false, false
+}
+
+func (h264Parser) Dimensions(packet *rtp.Packet) (uint32, uint32) {
+	return 0, 0
+}
+
+func (h264Parser) LayerInfo(buf []byte, flags *Flags) error {
+	return nil
+}
@@ -0,0 +1,40 @@
+package codecs
+
+import (
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+type opusParser struct{}
+
+func init() {
+	Register(Codec{
+		MimeType: "audio/opus",
+		Parser:   opusParser{},
+		NewDepacketizer: func() rtp.Depacketizer {
+			return &codecs.OpusPacket{}
+		},
+		MatroskaID: "A_OPUS",
+		WebM:       true,
+		ClockRate:  48000,
+		Channels:   2,
+		Fmtp: []string{
+			"minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1",
+		},
+		PayloadType: fixedPayloadType(111),
+	})
+}
+
+// IsKeyframe returns (false, false), since there are no keyframes in
+// Opus.
+func (opusParser) IsKeyframe(packet *rtp.Packet) (bool, bool) {
+	return false, false
+}
+
+func (opusParser) Dimensions(packet *rtp.Packet) (uint32, uint32) {
+	return 0, 0
+}
+
+func (opusParser) LayerInfo(buf []byte, flags *Flags) error {
+	return nil
+}
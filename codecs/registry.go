@@ -0,0 +1,176 @@
+package codecs
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// A Parser interprets the payload of the RTP packets of a given codec.
+type Parser interface {
+	// IsKeyframe determines if packet is the start of a keyframe, with
+	// the same conventions as Keyframe.
+	IsKeyframe(packet *rtp.Packet) (bool, bool)
+	// Dimensions returns the dimensions of the frame started by a
+	// keyframe, or (0, 0) if they are not known.
+	Dimensions(packet *rtp.Packet) (uint32, uint32)
+	// LayerInfo fills in the codec-specific fields of flags, which are
+	// the frame boundaries, keyframe and scalability information, from
+	// the raw packet buf.
+	LayerInfo(buf []byte, flags *Flags) error
+}
+
+// A Codec describes a codec supported by Galene.
+type Codec struct {
+	// The MIME type of the codec, such as "video/vp8".
+	MimeType string
+	Parser   Parser
+
+	// NewDepacketizer returns a depacketizer used for recording, or is
+	// nil if the codec cannot be recorded.
+	NewDepacketizer func() rtp.Depacketizer
+	// The Matroska codec identifier, used for recording.
+	MatroskaID string
+	// Whether recordings may use the WebM subset of Matroska.
+	WebM bool
+
+	// RewritePictureID adds delta to the picture id carried in the
+	// payload of a packet, or is nil if the codec has no picture id
+	// that needs to be kept contiguous when switching streams.
+	RewritePictureID func(payload []byte, delta uint16) error
+
+	// The RTP clock rate and number of channels, used for negotiation.
+	ClockRate uint32
+	Channels  uint16
+	// The SDP format parameters of each variant of the codec that is
+	// offered to peers.  If empty, a single variant without parameters
+	// is offered.
+	Fmtp []string
+	// PayloadType returns the payload type used for the variant with
+	// the given format parameters, or is nil if the codec cannot be
+	// negotiated.
+	PayloadType func(fmtp string) (webrtc.PayloadType, error)
+}
+
+// IsVideo returns true if c is a video codec.
+func (c *Codec) IsVideo() bool {
+	return strings.HasPrefix(strings.ToLower(c.MimeType), "video/")
+}
+
+// CanRecord returns true if c can be written to disk.
+func (c *Codec) CanRecord() bool {
+	return c.NewDepacketizer != nil && c.MatroskaID != ""
+}
+
+var registry = make(map[string]*Codec)
+
+// Register adds a codec to the registry, replacing any codec with the
+// same MIME type.  It must be called during initialisation.
+func Register(c Codec) {
+	registry[strings.ToLower(c.MimeType)] = &c
+	// avoid lowercasing on the fast path
+	registry[c.MimeType] = &c
+}
+
+// Lookup returns the codec with the given MIME type, or nil if it is not
+// known.  The comparison is case-insensitive.
+func Lookup(mimeType string) *Codec {
+	c, ok := registry[mimeType]
+	if ok {
+		return c
+	}
+	return registry[strings.ToLower(mimeType)]
+}
+
+// LookupName returns the codec with the given name, the subtype of its
+// MIME type in lowercase ("vp8" for "video/VP8"), or nil if it is not
+// known.  This is the name used in the codecs field of group
+// descriptions.
+func LookupName(name string) *Codec {
+	for m, c := range registry {
+		_, subtype, _ := strings.Cut(m, "/")
+		if subtype == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// fixedPayloadType returns a PayloadType function for a codec that always
+// uses the same payload type.
+func fixedPayloadType(pt webrtc.PayloadType) func(string) (webrtc.PayloadType, error) {
+	return func(string) (webrtc.PayloadType, error) {
+		return pt, nil
+	}
+}
+
+// fmtpValue returns the value of a parameter in an SDP fmtp line.
+func fmtpValue(fmtp, key string) string {
+	fields := strings.Split(fmtp, ";")
+	for _, f := range fields {
+		k, v, found := strings.Cut(f, "=")
+		if found && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// PayloadType returns the payload type used for a codec capability.
+func PayloadType(codec webrtc.RTPCodecCapability) (webrtc.PayloadType, error) {
+	c := Lookup(codec.MimeType)
+	if c == nil || c.PayloadType == nil {
+		return 0, errors.New("unknown codec " + codec.MimeType)
+	}
+	return c.PayloadType(codec.SDPFmtpLine)
+}
+
+// Parameters returns the parameters of the variants of c that are
+// offered to peers.
+func (c *Codec) Parameters() ([]webrtc.RTPCodecParameters, error) {
+	if c.PayloadType == nil {
+		return nil, errors.New("codec " + c.MimeType +
+			" cannot be negotiated")
+	}
+	var fb []webrtc.RTCPFeedback
+	if c.IsVideo() {
+		fb = []webrtc.RTCPFeedback{
+			{Type: "goog-remb"},
+			{Type: "nack"},
+			{Type: "nack", Parameter: "pli"},
+			{Type: "ccm", Parameter: "fir"},
+		}
+	}
+	fmtps := c.Fmtp
+	if len(fmtps) == 0 {
+		fmtps = []string{""}
+	}
+	parms := make([]webrtc.RTPCodecParameters, 0, len(fmtps))
+	for _, fmtp := range fmtps {
+		ptype, err := c.PayloadType(fmtp)
+		if err != nil {
+			return nil, err
+		}
+		parms = append(parms, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     c.MimeType,
+				ClockRate:    c.ClockRate,
+				Channels:     c.Channels,
+				SDPFmtpLine:  fmtp,
+				RTCPFeedback: fb,
+			},
+			PayloadType: ptype,
+		})
+	}
+	return parms, nil
+}
+
+func parser(mimeType string) Parser {
+	c := Lookup(mimeType)
+	if c == nil {
+		return nil
+	}
+	return c.Parser
+}
@@ -0,0 +1,112 @@
+package codecs
+
+import (
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+type vp8Parser struct{}
+
+func init() {
+	Register(Codec{
+		MimeType: "video/VP8",
+		Parser:   vp8Parser{},
+		NewDepacketizer: func() rtp.Depacketizer {
+			return &codecs.VP8Packet{}
+		},
+		MatroskaID:       "V_VP8",
+		WebM:             true,
+		RewritePictureID: vp8RewritePictureID,
+		ClockRate:        90000,
+		PayloadType:      fixedPayloadType(96),
+	})
+}
+
+func vp8RewritePictureID(data []byte, delta uint16) error {
+	if len(data) < 1 {
+		return errTruncated
+	}
+	offset := 0
+	x := (data[offset] & 0x80) != 0
+	if !x {
+		return nil
+	}
+	offset++
+	if len(data) <= offset {
+		return errTruncated
+	}
+	i := (data[offset] & 0x80) != 0
+	if !i {
+		return nil
+	}
+	offset++
+	if len(data) <= offset {
+		return errTruncated
+	}
+	m := (data[offset] & 0x80) != 0
+	if m {
+		if len(data) <= offset+1 {
+			return errTruncated
+		}
+		pid := (uint16(data[offset]&0x7F) << 8) |
+			uint16(data[offset+1])
+		pid = (pid + delta) & 0x7FFF
+		data[offset] = 0x80 | byte((pid>>8)&0x7F)
+		data[offset+1] = byte(pid & 0xFF)
+	} else {
+		data[offset] = (data[offset] + uint8(delta)) & 0x7F
+	}
+	return nil
+}
+
+func (vp8Parser) IsKeyframe(packet *rtp.Packet) (bool, bool) {
+	var vp8 codecs.VP8Packet
+	_, err := vp8.Unmarshal(packet.Payload)
+	if err != nil || len(vp8.Payload) < 1 {
+		return false, false
+	}
+
+	if vp8.S != 0 && vp8.PID == 0 && (vp8.Payload[0]&0x1) == 0 {
+		return true, true
+	}
+	return false, true
+}
+
+func (vp8Parser) Dimensions(packet *rtp.Packet) (uint32, uint32) {
+	var vp8 codecs.VP8Packet
+	_, err := vp8.Unmarshal(packet.Payload)
+	if err != nil {
+		return 0, 0
+	}
+	if len(vp8.Payload) < 10 {
+		return 0, 0
+	}
+	raw := uint32(vp8.Payload[6]) | uint32(vp8.Payload[7])<<8 |
+		uint32(vp8.Payload[8])<<16 | uint32(vp8.Payload[9])<<24
+	width := raw & 0x3FFF
+	height := (raw >> 16) & 0x3FFF
+	return width, height
+}
+
+func (vp8Parser) LayerInfo(buf []byte, flags *Flags) error {
+	var packet rtp.Packet
+	err := packet.Unmarshal(buf)
+	if err != nil {
+		return err
+	}
+	var vp8 codecs.VP8Packet
+	_, err = vp8.Unmarshal(packet.Payload)
+	if err != nil {
+		return err
+	}
+	flags.Start = vp8.S != 0 && vp8.PID == 0
+	flags.End = packet.Marker
+	flags.Keyframe = vp8.S != 0 && vp8.PID == 0 &&
+		len(vp8.Payload) > 0 && (vp8.Payload[0]&0x1) == 0
+	flags.Pid = vp8.PictureID
+	flags.Tid = vp8.TID
+	flags.TidUpSync = flags.Keyframe || vp8.Y == 1
+	flags.SidUpSync = flags.Keyframe
+	flags.Discardable = vp8.N == 1
+	return nil
+}
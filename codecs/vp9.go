@@ -0,0 +1,116 @@
+package codecs
+
+import (
+	"fmt"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+type vp9Parser struct{}
+
+func init() {
+	Register(Codec{
+		MimeType: "video/VP9",
+		Parser:   vp9Parser{},
+		NewDepacketizer: func() rtp.Depacketizer {
+			return &codecs.VP9Packet{}
+		},
+		MatroskaID:  "V_VP9",
+		WebM:        true,
+		ClockRate:   90000,
+		Fmtp:        []string{"profile-id=0", "profile-id=2"},
+		PayloadType: vp9PayloadType,
+	})
+}
+
+func vp9PayloadType(fmtp string) (webrtc.PayloadType, error) {
+	profile := fmtpValue(fmtp, "profile-id")
+	switch profile {
+	case "", "0":
+		return 98, nil
+	case "2":
+		return 100, nil
+	default:
+		return 0, fmt.Errorf("unknown VP9 profile %v", profile)
+	}
+}
+
+// vp9Keyframe determines if the payload of the first packet of a frame
+// is a keyframe.
+func vp9Keyframe(payload []byte) (bool, bool) {
+	if (payload[0] & 0xc0) != 0x80 {
+		return false, false
+	}
+
+	profile := (payload[0] >> 4) & 0x3
+	if profile != 3 {
+		return (payload[0] & 0xC) == 0, true
+	}
+	return (payload[0] & 0x6) == 0, true
+}
+
+func (vp9Parser) IsKeyframe(packet *rtp.Packet) (bool, bool) {
+	var vp9 codecs.VP9Packet
+	_, err := vp9.Unmarshal(packet.Payload)
+	if err != nil || len(vp9.Payload) < 1 {
+		return false, false
+	}
+	if !vp9.B {
+		return false, true
+	}
+	return vp9Keyframe(vp9.Payload)
+}
+
+func (vp9Parser) Dimensions(packet *rtp.Packet) (uint32, uint32) {
+	if packet == nil {
+		return 0, 0
+	}
+	var vp9 codecs.VP9Packet
+	_, err := vp9.Unmarshal(packet.Payload)
+	if err != nil {
+		return 0, 0
+	}
+	if !vp9.V {
+		return 0, 0
+	}
+	w := uint32(0)
+	h := uint32(0)
+	for i := range vp9.Width {
+		if i >= len(vp9.Height) {
+			break
+		}
+		if w < uint32(vp9.Width[i]) {
+			w = uint32(vp9.Width[i])
+		}
+		if h < uint32(vp9.Height[i]) {
+			h = uint32(vp9.Height[i])
+		}
+	}
+	return w, h
+}
+
+func (vp9Parser) LayerInfo(buf []byte, flags *Flags) error {
+	var packet rtp.Packet
+	err := packet.Unmarshal(buf)
+	if err != nil {
+		return err
+	}
+	var vp9 codecs.VP9Packet
+	_, err = vp9.Unmarshal(packet.Payload)
+	if err != nil {
+		return err
+	}
+	flags.Start = vp9.B
+	flags.End = vp9.E
+	if vp9.B && len(vp9.Payload) > 0 {
+		flags.Keyframe, _ = vp9Keyframe(vp9.Payload)
+	}
+	flags.Tid = vp9.TID
+	flags.Sid = vp9.SID
+	flags.TidUpSync = flags.Keyframe || vp9.U
+	flags.SidUpSync = flags.Keyframe || !vp9.P
+	flags.SidNonReference = (packet.Payload[0] & 0x01) != 0
+	return nil
+}
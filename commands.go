@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/jech/galene/diskwriter"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/store"
+	"github.com/jech/galene/token"
+)
+
+// A command is a subcommand of the galene binary, such as
+// "recordings prune".  Commands that take no action on a running server
+// work directly on the files in the data, groups and recordings
+// directories.
+type command struct {
+	name     string
+	synopsis string
+	help     string
+	// nil for serve, which is implemented by main
+	run func(cmd *command, args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name: "serve",
+			help: "run the server (the default)",
+		},
+		{
+			name: "check-config",
+			help: "check the configuration and the group definitions",
+			run:  checkConfigCommand,
+		},
+		{
+			name:     "groups list",
+			synopsis: "[-l]",
+			help:     "list the groups",
+			run:      groupsList,
+		},
+		{
+			name:     "recordings list",
+			synopsis: "[group]",
+			help:     "list the recordings",
+			run:      recordingsList,
+		},
+		{
+			name:     "recordings prune",
+			synopsis: "-older-than duration [-n] [group]",
+			help:     "delete old recordings",
+			run:      recordingsPrune,
+		},
+		{
+			name:     "passwd",
+			synopsis: "[options] group [username]",
+			help:     "set a user's password, read from standard input",
+			run:      passwd,
+		},
+		{
+			name:     "token new",
+			synopsis: "[options] group",
+			help:     "create a stateful token",
+			run:      tokenNew,
+		},
+		{
+			name: "db migrate",
+			help: "create the database or upgrade its schema",
+			run:  dbMigrate,
+		},
+		{
+			name: "db check",
+			help: "check the integrity of the database",
+			run:  dbCheck,
+		},
+		{
+			name: "db vacuum",
+			help: "reclaim unused space in the database",
+			run:  dbVacuum,
+		},
+		{
+			name: "db import",
+			help: "import stateful tokens and usage logs into the database",
+			run:  dbImport,
+		},
+	}
+}
+
+// findCommand returns the command that matches the longest prefix of
+// args, together with the remaining arguments.
+func findCommand(args []string) (*command, []string) {
+	var best *command
+	var rest []string
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(words) > len(args) {
+			continue
+		}
+		match := true
+		for i, w := range words {
+			if args[i] != w {
+				match = false
+				break
+			}
+		}
+		if match && (best == nil || len(words) > len(strings.Fields(best.name))) {
+			best = cmd
+			rest = args[len(words):]
+		}
+	}
+	return best, rest
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Commands:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %v\t%v\n", cmd.name, cmd.help)
+	}
+	tw.Flush()
+}
+
+func (cmd *command) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v [options] %v %v\n",
+			os.Args[0], cmd.name, cmd.synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// usage prints the usage of a command and returns a suitable exit code.
+func (cmd *command) usage(flags *flag.FlagSet) int {
+	flags.Usage()
+	return 2
+}
+
+func checkConfigCommand(cmd *command, args []string) int {
+	flags := cmd.flagSet()
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return cmd.usage(flags)
+	}
+	if checkConfig(configFile) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func groupsList(cmd *command, args []string) int {
+	flags := cmd.flagSet()
+	long := flags.Bool("l", false, "display the group's properties")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return cmd.usage(flags)
+	}
+
+	names, err := group.GetAllDescriptionNames()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't list groups: %v\n", err)
+		return 1
+	}
+	sort.Strings(names)
+
+	if !*long {
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return 0
+	}
+
+	status := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tPUBLIC\tUSERS\tDISPLAY NAME\n")
+	for _, name := range names {
+		desc, err := group.GetDescription(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", name, err)
+			status = 1
+			continue
+		}
+		users := len(desc.Users)
+		if desc.WildcardUser != nil {
+			users++
+		}
+		public := "no"
+		if desc.Public {
+			public = "yes"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n",
+			name, public, users, desc.DisplayName)
+	}
+	tw.Flush()
+	return status
+}
+
+// recordingsDirectory returns the directory containing the recordings
+// of a group, or the whole recordings directory if group is empty.
+func recordingsDirectory(g string) string {
+	if g == "" {
+		return diskwriter.Directory
+	}
+	return filepath.Join(diskwriter.Directory, path.Clean("/"+g))
+}
+
+// walkRecordings calls f for every recording in a given directory.
+func walkRecordings(dir string, f func(name string, fi fs.FileInfo) error) error {
+	return filepath.WalkDir(dir,
+		func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if filepath.Base(p)[0] == '.' && p != dir {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(diskwriter.Directory, p)
+			if err != nil {
+				return err
+			}
+			return f(filepath.ToSlash(name), fi)
+		},
+	)
+}
+
+func recordingsList(cmd *command, args []string) int {
+	flags := cmd.flagSet()
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		return cmd.usage(flags)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	err := walkRecordings(recordingsDirectory(flags.Arg(0)),
+		func(name string, fi fs.FileInfo) error {
+			fmt.Fprintf(tw, "%v\t%v\t%v\n", name, fi.Size(),
+				fi.ModTime().Format(time.DateTime))
+			return nil
+		},
+	)
+	tw.Flush()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't list recordings: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func recordingsPrune(cmd *command, args []string) int {
+	flags := cmd.flagSet()
+	olderThan := flags.Duration("older-than", 0,
+		"delete recordings that were last written longer than `duration` ago")
+	dryRun := flags.Bool("n", false,
+		"display the recordings that would be deleted")
+	flags.Parse(args)
+	if flags.NArg() > 1 || *olderThan <= 0 {
+		return cmd.usage(flags)
+	}
+
+	// recordings in progress are written to continuously, so they are
+	// never old enough to be deleted.
+	cutoff := time.Now().Add(-*olderThan)
+	status := 0
+	err := walkRecordings(recordingsDirectory(flags.Arg(0)),
+		func(name string, fi fs.FileInfo) error {
+			if !fi.ModTime().Before(cutoff) {
+				return nil
+			}
+			fmt.Println(name)
+			if *dryRun {
+				return nil
+			}
+			err := os.Remove(filepath.Join(
+				diskwriter.Directory, filepath.FromSlash(name),
+			))
+			if err == nil && store.Enabled() {
+				err = store.DeleteRecording(name)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				status = 1
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't prune recordings: %v\n", err)
+		return 1
+	}
+	return status
+}
+
+func hashPassword(pw []byte, algorithm string) (group.Password, error) {
+	switch strings.ToLower(algorithm) {
+	case "pbkdf2":
+		salt := make([]byte, 8)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return group.Password{}, err
+		}
+		iterations := 4096
+		key := pbkdf2.Key(pw, salt, iterations, 32, sha256.New)
+		encoded := hex.EncodeToString(key)
+		return group.Password{
+			Type:       "pbkdf2",
+			Hash:       "sha-256",
+			Key:        &encoded,
+			Salt:       hex.EncodeToString(salt),
+			Iterations: iterations,
+		}, nil
+	case "bcrypt":
+		key, err := bcrypt.GenerateFromPassword(pw, bcrypt.DefaultCost)
+		if err != nil {
+			return group.Password{}, err
+		}
+		k := string(key)
+		return group.Password{
+			Type: "bcrypt",
+			Key:  &k,
+		}, nil
+	default:
+		return group.Password{},
+			fmt.Errorf("unknown hash type %v", algorithm)
+	}
+}
+
+func passwd(cmd *command, args []string) int {
+	flags := cmd.flagSet()
+	algorithm := flags.String("hash", "pbkdf2", "hashing `algorithm`")
+	permissions := flags.String("permissions", "",
+		"create the user with the given `permissions` if it doesn't exist")
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return cmd.usage(flags)
+	}
+	g, username := flags.Arg(0), flags.Arg(1)
+	wildcard := flags.NArg() < 2
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "Couldn't read password: %v\n", err)
+		return 1
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		fmt.Fprintf(os.Stderr, "Empty password\n")
+		return 1
+	}
+	pw, err := hashPassword([]byte(line), *algorithm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't hash password: %v\n", err)
+		return 1
+	}
+
+	err = group.SetUserPassword(g, username, wildcard, pw)
+	if errors.Is(err, os.ErrNotExist) && *permissions != "" {
+		var perms group.Permissions
+		perms, err = group.NewPermissions(*permissions)
+		if err == nil {
+			err = group.UpdateUser(g, username, wildcard, "",
+				&group.UserDescription{Permissions: perms},
+			)
+		}
+		if err == nil {
+			err = group.SetUserPassword(g, username, wildcard, pw)
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		err = errors.New("no such group or user")
+	} else if errors.Is(err, group.ErrDescriptionsNotWritable) {
+		err = errors.New(
+			"group definitions are not writable, " +
+				"please set \"writableGroups\" in config.json",
+		)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't set password: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func tokenNew(cmd *command, args []string) int {
+	flags := cmd.flagSet()
+	username := flags.String("user", "", "`username` of the token's holder")
+	permissions := flags.String("permissions", "present",
+		"comma-separated `list` of permissions")
+	expires := flags.Duration("expires", 24*time.Hour,
+		"`duration` of the token's validity")
+	base := flags.String("url", "",
+		"display a link to the group on the server at `URL`")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *expires <= 0 {
+		return cmd.usage(flags)
+	}
+	g := flags.Arg(0)
+
+	_, err := group.GetDescription(g)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Group %v: %v\n", g, err)
+		return 1
+	}
+
+	buf := make([]byte, 8)
+	_, err = rand.Read(buf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't create token: %v\n", err)
+		return 1
+	}
+	now := time.Now()
+	exp := now.Add(*expires)
+	t := &token.Stateful{
+		Token:       base64.RawURLEncoding.EncodeToString(buf),
+		Group:       g,
+		Permissions: []string{},
+		Expires:     &exp,
+		IssuedAt:    &now,
+	}
+	if *username != "" {
+		t.Username = username
+	}
+	for _, p := range strings.Split(*permissions, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			t.Permissions = append(t.Permissions, p)
+		}
+	}
+
+	t, err = token.Update(t, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't create token: %v\n", err)
+		return 1
+	}
+
+	if *base != "" {
+		fmt.Printf("%v/group/%v/?token=%v\n",
+			strings.TrimRight(*base, "/"), g, t.Token)
+	} else {
+		fmt.Println(t.Token)
+	}
+	return 0
+}
+
+// dbCommand parses the arguments of a command that takes none, and
+// checks that a database is open.
+func dbCommand(cmd *command, args []string) int {
+	flags := cmd.flagSet()
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return cmd.usage(flags)
+	}
+	if !store.Enabled() {
+		fmt.Fprintf(os.Stderr,
+			"No database, please specify one with -database\n")
+		return 1
+	}
+	return 0
+}
+
+func dbMigrate(cmd *command, args []string) int {
+	if status := dbCommand(cmd, args); status != 0 {
+		return status
+	}
+	// the schema was upgraded when the database was opened
+	version, err := store.Version()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't read schema version: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Schema version %v\n", version)
+	return 0
+}
+
+func dbCheck(cmd *command, args []string) int {
+	if status := dbCommand(cmd, args); status != 0 {
+		return status
+	}
+	problems, err := store.Check()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't check database: %v\n", err)
+		return 1
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func dbVacuum(cmd *command, args []string) int {
+	if status := dbCommand(cmd, args); status != 0 {
+		return status
+	}
+	err := store.Vacuum()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't vacuum database: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// dbImport copies the stateful tokens and the usage logs into the
+// database.  Tokens that already exist are skipped, but usage entries
+// are imported again every time the command is run.
+func dbImport(cmd *command, args []string) int {
+	if status := dbCommand(cmd, args); status != 0 {
+		return status
+	}
+
+	n, err := token.ImportFile()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't import tokens: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Imported %v tokens\n", n)
+
+	dir := filepath.Join(group.DataDirectory, "var", "usage")
+	n = 0
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && p == dir {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || filepath.Ext(p) != ".jsonl" {
+			return nil
+		}
+		m, err := importUsage(p)
+		n += m
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't import usage logs: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Imported %v usage entries\n", n)
+	return 0
+}
+
+func importUsage(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	n := 0
+	for {
+		var e struct {
+			Time  time.Time       `json:"time"`
+			Group string          `json:"group"`
+			Kind  string          `json:"kind"`
+			Value json.RawMessage `json:"value"`
+		}
+		err := decoder.Decode(&e)
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("%v: %w", filename, err)
+		}
+		err = store.LogUsage(e.Group, e.Time, e.Kind, e.Value)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{[]string{"serve"}, "serve", []string{}},
+		{[]string{"groups", "list", "-l"}, "groups list", []string{"-l"}},
+		{[]string{"recordings", "prune", "-n", "a"},
+			"recordings prune", []string{"-n", "a"}},
+		{[]string{"token", "new", "g"}, "token new", []string{"g"}},
+		{[]string{"groups"}, "", nil},
+		{[]string{"bogus"}, "", nil},
+	}
+	for _, test := range tests {
+		cmd, rest := findCommand(test.args)
+		name := ""
+		if cmd != nil {
+			name = cmd.name
+		}
+		if name != test.name {
+			t.Errorf("%v: got %v, expected %v",
+				test.args, name, test.name)
+			continue
+		}
+		if cmd != nil && !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("%v: got %v, expected %v",
+				test.args, rest, test.rest)
+		}
+	}
+}
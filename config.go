@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/jech/galene/ice"
+)
+
+// serverConfig is the contents of the file passed to -config.  Every
+// field has the same meaning as the command-line flag given in its
+// "flag" tag; flags given on the command line take precedence.
+type serverConfig struct {
+	Data     *string `json:"data,omitempty" flag:"data"`
+	Groups   *string `json:"groups,omitempty" flag:"groups"`
+	Static   *string `json:"static,omitempty" flag:"static"`
+	Database *string `json:"database,omitempty" flag:"database"`
+
+	HTTP struct {
+		Address       *string `json:"address,omitempty" flag:"http"`
+		Insecure      *bool   `json:"insecure,omitempty" flag:"insecure"`
+		ProxyProtocol *bool   `json:"proxyProtocol,omitempty" flag:"proxy-protocol"`
+		ACME          struct {
+			Domains   []string `json:"domains,omitempty" flag:"acme-domain"`
+			Email     *string  `json:"email,omitempty" flag:"acme-email"`
+			Directory *string  `json:"directory,omitempty" flag:"acme-directory"`
+			HTTP      *string  `json:"http,omitempty" flag:"acme-http"`
+		} `json:"acme"`
+	} `json:"http"`
+
+	ICE struct {
+		UDPRange     *string  `json:"udpRange,omitempty" flag:"udp-range"`
+		ReadBuffer   *int     `json:"readBuffer,omitempty" flag:"udp-read-buffer"`
+		WriteBuffer  *int     `json:"writeBuffer,omitempty" flag:"udp-write-buffer"`
+		MDNS         *bool    `json:"mdns,omitempty" flag:"mdns"`
+		RelayOnly    *bool    `json:"relayOnly,omitempty" flag:"relay-only"`
+		Interfaces   []string `json:"interfaces,omitempty" flag:"ice-interfaces"`
+		Addresses    []string `json:"addresses,omitempty" flag:"ice-addresses"`
+		NATAddresses []string `json:"natAddresses,omitempty" flag:"nat-address"`
+		// if set, ice-servers.json is ignored
+		Servers []ice.Server `json:"servers,omitempty"`
+	} `json:"ice"`
+
+	TURN struct {
+		Address *string `json:"address,omitempty" flag:"turn"`
+	} `json:"turn"`
+
+	Recordings struct {
+		Directory *string `json:"directory,omitempty" flag:"recordings"`
+	} `json:"recordings"`
+
+	Log struct {
+		Format *string `json:"format,omitempty" flag:"log-format"`
+		Level  *string `json:"level,omitempty" flag:"log-level"`
+	} `json:"log"`
+
+	Tracing struct {
+		Endpoint *string `json:"endpoint,omitempty" flag:"otlp-endpoint"`
+	} `json:"tracing"`
+
+	Shutdown struct {
+		DrainTimeout *string `json:"drainTimeout,omitempty" flag:"drain-timeout"`
+		Redirect     *string `json:"redirect,omitempty" flag:"shutdown-redirect"`
+	} `json:"shutdown"`
+}
+
+// jsonError annotates a JSON decoding error with the position in the
+// file and the offending line.
+func jsonError(filename string, data []byte, err error) error {
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	} else if _, field, found := strings.Cut(
+		err.Error(), "json: unknown field ",
+	); found {
+		// encoding/json doesn't give the position of unknown
+		// fields, look for the first occurrence of the key.
+		i := bytes.Index(data, []byte(field))
+		if i >= 0 {
+			offset = int64(i + len(field))
+		}
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return fmt.Errorf("%v: %w", filename, err)
+	}
+
+	// the offset is just after the offending byte
+	pos := int(max(offset-1, 0))
+	before := data[:pos]
+	line := bytes.Count(before, []byte("\n")) + 1
+	start := bytes.LastIndexByte(before, '\n') + 1
+	end := bytes.IndexByte(data[start:], '\n')
+	if end < 0 {
+		end = len(data)
+	} else {
+		end += start
+	}
+	column := pos - start + 1
+	text := strings.TrimRight(string(data[start:end]), "\r")
+	return fmt.Errorf("%v:%v:%v: %w\n\t%v\n\t%v^",
+		filename, line, column, err,
+		text, strings.Repeat(" ", column-1),
+	)
+}
+
+// decodeJSON strictly decodes the contents of a file.
+func decodeJSON(filename string, data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	err := d.Decode(v)
+	if err != nil {
+		return jsonError(filename, data, err)
+	}
+	if d.More() {
+		return fmt.Errorf("%v: trailing data after JSON value", filename)
+	}
+	return nil
+}
+
+// isUnknownField returns true if err was caused by an unknown field in
+// strictly decoded JSON.
+func isUnknownField(err error) bool {
+	return strings.Contains(err.Error(), "json: unknown field ")
+}
+
+// readServerConfig reads the configuration file.  If strict is false,
+// unknown fields are ignored, and warn is called with the error that
+// they would have caused.
+func readServerConfig(filename string, strict bool, warn func(error)) (*serverConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config serverConfig
+	err = decodeJSON(filename, data, &config)
+	if err != nil && !strict && isUnknownField(err) {
+		if warn != nil {
+			warn(err)
+		}
+		config = serverConfig{}
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			err = jsonError(filename, data, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// apply sets the flags that were not given on the command line from the
+// configuration.
+func (config *serverConfig) apply(flags *flag.FlagSet) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var walk func(v reflect.Value) error
+	walk = func(v reflect.Value) error {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := v.Field(i)
+			name := t.Field(i).Tag.Get("flag")
+			if name == "" {
+				if field.Kind() == reflect.Struct {
+					err := walk(field)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if set[name] || field.IsNil() {
+				continue
+			}
+			var value string
+			switch field.Kind() {
+			case reflect.Pointer:
+				value = fmt.Sprint(field.Elem().Interface())
+			case reflect.Slice:
+				s := field.Interface().([]string)
+				value = strings.Join(s, ",")
+			default:
+				panic("unexpected configuration field")
+			}
+			err := flags.Set(name, value)
+			if err != nil {
+				key, _, _ := strings.Cut(
+					t.Field(i).Tag.Get("json"), ",",
+				)
+				return fmt.Errorf("%v: %q: %w", key, value, err)
+			}
+		}
+		return nil
+	}
+	err := walk(reflect.ValueOf(config).Elem())
+	if err != nil {
+		return err
+	}
+
+	if config.ICE.Servers != nil {
+		ice.ICEServers = config.ICE.Servers
+	}
+	return nil
+}
+
+// parseList parses a comma-separated list, such as a list of host names
+// or of network interfaces, ignoring empty elements.
+func parseList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
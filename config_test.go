@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONError(t *testing.T) {
+	data := []byte("{\n  \"a\": 1,\n  \"b\": tru\n}\n")
+	var v map[string]interface{}
+	err := decodeJSON("test.json", data, &v)
+	if err == nil {
+		t.Fatalf("Decoding succeeded")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "test.json:3:") ||
+		lines[1] != "\t  \"b\": tru" {
+		t.Errorf("Got %q", err.Error())
+	}
+
+	var s struct {
+		A int `json:"a"`
+	}
+	err = decodeJSON("test.json", []byte("{\"a\": 1, \"c\": 2}"), &s)
+	if err == nil || !strings.HasPrefix(err.Error(), "test.json:1:12:") {
+		t.Errorf("Got %v", err)
+	}
+
+	err = decodeJSON("test.json", []byte("{\"a\": 1} {}"), &s)
+	if err == nil {
+		t.Errorf("Trailing data accepted")
+	}
+}
+
+func TestApply(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	var address, data, domains string
+	var insecure bool
+	var timeout time.Duration
+	flags.StringVar(&address, "http", ":8443", "")
+	flags.StringVar(&data, "data", "./data/", "")
+	flags.StringVar(&domains, "acme-domain", "", "")
+	flags.BoolVar(&insecure, "insecure", false, "")
+	flags.DurationVar(&timeout, "drain-timeout", 30*time.Second, "")
+
+	err := flags.Parse([]string{"-http", ":443"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var config serverConfig
+	err = decodeJSON("test.json", []byte(`{
+		"data": "/var/lib/galene",
+		"http": {
+			"address": ":8000",
+			"insecure": true,
+			"acme": {"domains": ["a.example.org", "b.example.org"]}
+		},
+		"shutdown": {"drainTimeout": "1m"}
+	}`), &config)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	err = config.apply(flags)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	if address != ":443" {
+		t.Errorf("Command line not honoured, got %v", address)
+	}
+	if data != "/var/lib/galene" || !insecure ||
+		domains != "a.example.org,b.example.org" ||
+		timeout != time.Minute {
+		t.Errorf("Got %v %v %v %v", data, insecure, domains, timeout)
+	}
+}
+
+func TestReadServerConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "galene.json")
+	err := os.WriteFile(filename,
+		[]byte(`{"data": "/var/lib/galene", "foo": 1}`), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, err = readServerConfig(filename, true, nil)
+	if err == nil {
+		t.Errorf("Unknown field accepted in strict mode")
+	}
+
+	var warning error
+	config, err := readServerConfig(filename, false, func(err error) {
+		warning = err
+	})
+	if err != nil {
+		t.Fatalf("readServerConfig: %v", err)
+	}
+	if warning == nil {
+		t.Errorf("Unknown field didn't cause a warning")
+	}
+	if config.Data == nil || *config.Data != "/var/lib/galene" {
+		t.Errorf("Got %v", config.Data)
+	}
+
+	err = os.WriteFile(filename, []byte(`{"data": 1}`), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	_, err = readServerConfig(filename, false, nil)
+	if err == nil {
+		t.Errorf("Type error accepted")
+	}
+}
+
+func TestParseList(t *testing.T) {
+	d := parseList(" a.example.org,b.example.org, ,")
+	if !reflect.DeepEqual(d, []string{"a.example.org", "b.example.org"}) {
+		t.Errorf("Got %v", d)
+	}
+	if d := parseList(""); d != nil {
+		t.Errorf("Got %v", d)
+	}
+}
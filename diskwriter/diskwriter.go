@@ -5,19 +5,18 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/at-wat/ebml-go/mkvcore"
 	"github.com/at-wat/ebml-go/webm"
 	"github.com/pion/rtp"
-	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3/pkg/media"
 
 	"github.com/jech/samplebuilder"
@@ -25,9 +24,14 @@ import (
 	gcodecs "github.com/jech/galene/codecs"
 	"github.com/jech/galene/conn"
 	"github.com/jech/galene/group"
+	"github.com/jech/galene/logging"
 	"github.com/jech/galene/rtptime"
+	"github.com/jech/galene/tracing"
+	"github.com/jech/galene/unbounded"
 )
 
+var logger = logging.New("diskwriter")
+
 const (
 	audioMaxLate = 32
 	videoMaxLate = 256
@@ -42,6 +46,18 @@ type Client struct {
 	mu     sync.Mutex
 	down   map[string]*diskConn
 	closed bool
+
+	// the whiteboard log, opened when the first operation is written
+	whiteboard *os.File
+	// the operations to be written by whiteboardLoop
+	whiteboardOps *unbounded.Channel[group.WhiteboardOp]
+
+	// recording statistics, see status.go
+	started   time.Time
+	done      chan struct{}
+	bytes     atomic.Int64
+	segments  atomic.Int64
+	lastWrite atomic.Int64
 }
 
 func newId() string {
@@ -51,7 +67,14 @@ func newId() string {
 }
 
 func New(g *group.Group) *Client {
-	return &Client{group: g, id: newId()}
+	return &Client{
+		group:   g,
+		id:      newId(),
+		started: time.Now(),
+		done:    make(chan struct{}),
+
+		whiteboardOps: unbounded.New[group.WhiteboardOp](),
+	}
 }
 
 func (client *Client) Group() *group.Group {
@@ -98,10 +121,39 @@ func (client *Client) Close() error {
 		down.Close()
 	}
 	client.down = nil
+	if client.whiteboard != nil {
+		client.whiteboard.Close()
+		client.whiteboard = nil
+	}
+	if !client.closed {
+		close(client.done)
+	}
 	client.closed = true
 	return nil
 }
 
+// Shutdown finalises all recordings in progress, and stops converting
+// recordings to MP4.
+func Shutdown() {
+	stopTranscodes()
+
+	var clients []*Client
+	group.Range(func(g *group.Group) bool {
+		for _, c := range g.GetClients(nil) {
+			client, ok := c.(*Client)
+			if ok {
+				clients = append(clients, client)
+			}
+		}
+		return true
+	})
+
+	for _, client := range clients {
+		client.Close()
+		group.DelClient(client)
+	}
+}
+
 func (client *Client) Kick(id string, user *string, message string) error {
 	err := client.Close()
 	group.DelClient(client)
@@ -113,6 +165,11 @@ func (client *Client) Addr() net.Addr {
 }
 
 func (client *Client) Joined(group, kind string) error {
+	if kind == "join" {
+		// we're called with the group locked
+		go client.whiteboardLoop()
+		go client.statusLoop()
+	}
 	return nil
 }
 
@@ -134,7 +191,8 @@ func (client *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []c
 			rp.Close()
 			delete(client.down, replace)
 		} else {
-			log.Printf("Disk writer: replacing unknown connection")
+			logger.Warn("Replacing unknown connection",
+				"conn", replace)
 		}
 	}
 
@@ -173,6 +231,7 @@ type diskConn struct {
 	client    *Client
 	directory string
 	username  string
+	label     string
 	hasVideo  bool
 
 	mu            sync.Mutex
@@ -183,6 +242,12 @@ type diskConn struct {
 	lastWarning   time.Time
 	originLocal   time.Time
 	originRemote  uint64
+	// the id of the recording in the database, 0 if none
+	recording int64
+	// the time at which the current file was opened
+	opened time.Time
+
+	span *tracing.Span
 }
 
 // called locked
@@ -191,7 +256,8 @@ func (conn *diskConn) warn(message string) {
 	if now.Sub(conn.lastWarning) < 10*time.Second {
 		return
 	}
-	log.Println(message)
+	logger.Warn(message, "group", conn.client.group.Name())
+	conn.span.AddEvent("warning", "message", message)
 	conn.client.group.WallOps(message)
 	conn.lastWarning = now
 }
@@ -202,12 +268,18 @@ func (conn *diskConn) open(extension string) error {
 		return errors.New("already open")
 	}
 
-	file, err := openDiskFile(conn.directory, conn.username, extension)
+	file, err := openDiskFile(
+		conn.directory, conn.username, conn.label, extension,
+	)
 	if err != nil {
+		conn.span.AddEvent("open failed", "error", err.Error())
 		return err
 	}
 
 	conn.file = file
+	conn.opened = time.Now()
+	conn.client.segments.Add(1)
+	conn.span.AddEvent("file opened", "file", file.Name())
 	return nil
 }
 
@@ -226,6 +298,13 @@ func (conn *diskConn) close() []*diskTrack {
 		t.origin = none
 		tracks = append(tracks, t)
 	}
+	if conn.file != nil {
+		conn.span.AddEvent("file closed", "file", conn.file.Name())
+		recording := conn.recording
+		conn.recordEnd()
+		queueTranscode(conn.client.group.Name(), conn.file.Name(),
+			conn.opened, time.Since(conn.opened), recording)
+	}
 	conn.file = nil
 	return tracks
 }
@@ -240,10 +319,27 @@ func (conn *diskConn) Close() error {
 	for _, t := range tracks {
 		t.remote.DelLocal(t)
 	}
+	conn.span.End()
 	return nil
 }
 
-func openDiskFile(directory, username, extension string) (*os.File, error) {
+// sanitiseLabel returns a version of a stream label that is safe to
+// include in a file name.
+func sanitiseLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, label)
+}
+
+// openDiskFile creates a new file named after the current time, the
+// username and the label of the stream, so that the streams published by
+// a single user, such as a camera and a screen share, are recorded to
+// distinct files.
+func openDiskFile(directory, username, label, extension string) (*os.File, error) {
 	filenameFormat := "2006-01-02T15:04:05.000"
 	if runtime.GOOS == "windows" {
 		filenameFormat = "2006-01-02T15-04-05-000"
@@ -253,6 +349,9 @@ func openDiskFile(directory, username, extension string) (*os.File, error) {
 	if username != "" {
 		filename = filename + "-" + username
 	}
+	if label != "" {
+		filename = filename + "-" + label
+	}
 	for counter := 0; counter < 100; counter++ {
 		var fn string
 		if counter == 0 {
@@ -315,22 +414,21 @@ func newDiskConn(client *Client, directory string, up conn.Up, remoteTracks []co
 
 	for _, remote := range remoteTracks {
 		codec := remote.Codec().MimeType
-		if strings.EqualFold(codec, "audio/opus") {
+		c := gcodecs.Lookup(codec)
+		if c == nil || !c.CanRecord() {
+			client.group.WallOps("Unknown codec, " + codec + ", not recording")
+		} else if !c.IsVideo() {
 			if audio == nil {
 				audio = remote
 			} else {
 				client.group.WallOps("Multiple audio tracks, recording just one")
 			}
-		} else if strings.EqualFold(codec, "video/vp8") ||
-			strings.EqualFold(codec, "video/vp9") ||
-			strings.EqualFold(codec, "video/h264") {
+		} else {
 			if video == nil || video.Label() == "l" {
 				video = remote
 			} else if remote.Label() != "l" {
 				client.group.WallOps("Multiple video tracks, recording just one")
 			}
-		} else {
-			client.group.WallOps("Unknown codec, " + codec + ", not recording")
 		}
 	}
 
@@ -351,41 +449,39 @@ func newDiskConn(client *Client, directory string, up conn.Up, remoteTracks []co
 		client:    client,
 		directory: directory,
 		username:  username,
+		label:     sanitiseLabel(up.Label()),
 		tracks:    make([]*diskTrack, 0, len(tracks)),
 		remote:    up,
+		span: tracing.Start(nil, "recording",
+			"group", client.group.Name(), "conn", up.Id(),
+			"username", username, "label", up.Label(),
+		),
 	}
 
 	for _, remote := range tracks {
 		var builder *samplebuilder.SampleBuilder
 		codec := remote.Codec()
-		if strings.EqualFold(codec.MimeType, "audio/opus") {
-			builder = samplebuilder.New(
-				audioMaxLate,
-				&codecs.OpusPacket{}, codec.ClockRate,
-			)
-		} else if strings.EqualFold(codec.MimeType, "video/vp8") {
-			builder = samplebuilder.New(
-				videoMaxLate,
-				&codecs.VP8Packet{}, codec.ClockRate,
+		c := gcodecs.Lookup(codec.MimeType)
+		if c == nil || !c.CanRecord() {
+			// this shouldn't happen
+			err := errors.New(
+				"cannot record codec " + codec.MimeType,
 			)
-			conn.hasVideo = true
-		} else if strings.EqualFold(codec.MimeType, "video/vp9") {
+			conn.span.SetError(err)
+			conn.span.End()
+			return nil, err
+		}
+		if c.IsVideo() {
 			builder = samplebuilder.New(
-				videoMaxLate, &codecs.VP9Packet{},
+				videoMaxLate, c.NewDepacketizer(),
 				codec.ClockRate,
 			)
 			conn.hasVideo = true
-		} else if strings.EqualFold(codec.MimeType, "video/h264") {
+		} else {
 			builder = samplebuilder.New(
-				videoMaxLate, &codecs.H264Packet{},
+				audioMaxLate, c.NewDepacketizer(),
 				codec.ClockRate,
 			)
-			conn.hasVideo = true
-		} else {
-			// this shouldn't happen
-			return nil, errors.New(
-				"cannot record codec " + codec.MimeType,
-			)
 		}
 		track := &diskTrack{
 			remote:  remote,
@@ -400,12 +496,14 @@ func newDiskConn(client *Client, directory string, up conn.Up, remoteTracks []co
 	for _, t := range conn.tracks {
 		err := t.remote.AddLocal(t)
 		if err != nil {
-			log.Printf("Couldn't add disk track: %v", err)
+			logger.Warn("Couldn't add disk track", "err", err)
 			conn.warn("Couldn't add disk track: " + err.Error())
 		}
 	}
 	err := up.AddLocal(&conn)
 	if err != nil {
+		conn.span.SetError(err)
+		conn.span.End()
 		return nil, err
 	}
 
@@ -429,7 +527,7 @@ func (t *diskTrack) Write(buf []byte) (int, error) {
 	p := new(rtp.Packet)
 	err := p.Unmarshal(data)
 	if err != nil {
-		log.Printf("Diskwriter: %v", err)
+		logger.Warn("Unmarshal RTP", "err", err)
 		return 0, nil
 	}
 
@@ -591,7 +689,7 @@ func (t *diskTrack) writeBuffered(force bool) error {
 		}
 
 		if !valid(t.origin) {
-			log.Println("Invalid origin")
+			logger.Warn("Invalid origin")
 			return nil
 		}
 
@@ -725,53 +823,35 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 	for i, t := range conn.tracks {
 		var entry webm.TrackEntry
 		codec := t.remote.Codec()
-		if strings.EqualFold(codec.MimeType, "audio/opus") {
-			entry = webm.TrackEntry{
-				Name:        "Audio",
-				TrackNumber: uint64(i + 1),
-				CodecID:     "A_OPUS",
-				TrackType:   2,
-				Audio: &webm.Audio{
-					SamplingFrequency: float64(codec.ClockRate),
-					Channels:          uint64(codec.Channels),
-				},
-			}
-		} else if strings.EqualFold(codec.MimeType, "video/vp8") {
-			entry = webm.TrackEntry{
-				Name:        "Video",
-				TrackNumber: uint64(i + 1),
-				CodecID:     "V_VP8",
-				TrackType:   1,
-				Video: &webm.Video{
-					PixelWidth:  uint64(width),
-					PixelHeight: uint64(height),
-				},
-			}
-		} else if strings.EqualFold(codec.MimeType, "video/vp9") {
+		c := gcodecs.Lookup(codec.MimeType)
+		if c == nil || !c.CanRecord() {
+			return errors.New("unknown track type")
+		}
+		if c.IsVideo() {
 			entry = webm.TrackEntry{
 				Name:        "Video",
 				TrackNumber: uint64(i + 1),
-				CodecID:     "V_VP9",
+				CodecID:     c.MatroskaID,
 				TrackType:   1,
 				Video: &webm.Video{
 					PixelWidth:  uint64(width),
 					PixelHeight: uint64(height),
 				},
 			}
-		} else if strings.EqualFold(codec.MimeType, "video/h264") {
+		} else {
 			entry = webm.TrackEntry{
-				Name:        "Video",
+				Name:        "Audio",
 				TrackNumber: uint64(i + 1),
-				CodecID:     "V_MPEG4/ISO/AVC",
-				TrackType:   1,
-				Video: &webm.Video{
-					PixelWidth:  uint64(width),
-					PixelHeight: uint64(height),
+				CodecID:     c.MatroskaID,
+				TrackType:   2,
+				Audio: &webm.Audio{
+					SamplingFrequency: float64(codec.ClockRate),
+					Channels:          uint64(codec.Channels),
 				},
 			}
+		}
+		if !c.WebM {
 			isWebm = false
-		} else {
-			return errors.New("unknown track type")
 		}
 		desc = append(desc,
 			mkvcore.TrackDescription{
@@ -811,7 +891,8 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 	}
 
 	ws, err := mkvcore.NewSimpleBlockWriter(
-		conn.file, desc,
+		countingWriter{WriteCloser: conn.file, client: conn.client},
+		desc,
 		mkvcore.WithEBMLHeader(header),
 		mkvcore.WithSegmentInfo(webm.DefaultSegmentInfo),
 		mkvcore.WithBlockInterceptor(interceptor),
@@ -834,6 +915,7 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 	for i, t := range conn.tracks {
 		t.writer = ws[i]
 	}
+	conn.recordStart()
 	return nil
 }
 
@@ -1,6 +1,8 @@
 package diskwriter
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -155,3 +157,68 @@ func TestAdjustOriginLocalRemote(t *testing.T) {
 		t.Errorf("Expected 132, got %v", value(c.tracks[0].origin))
 	}
 }
+
+func TestOpenDiskFile(t *testing.T) {
+	dir := t.TempDir()
+	open := func(label string) string {
+		f, err := openDiskFile(dir, "john", sanitiseLabel(label), "webm")
+		if err != nil {
+			t.Fatalf("openDiskFile: %v", err)
+		}
+		defer f.Close()
+		return filepath.Base(f.Name())
+	}
+	camera := open("camera")
+	screen := open("screen/share")
+	if !strings.HasSuffix(camera, "-john-camera.webm") {
+		t.Errorf("Bad filename %v", camera)
+	}
+	if !strings.HasSuffix(screen, "-john-screenshare.webm") {
+		t.Errorf("Bad filename %v", screen)
+	}
+	if plain := open(""); !strings.HasSuffix(plain, "-john.webm") {
+		t.Errorf("Bad filename %v", plain)
+	}
+}
+
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestRecordingStatus(t *testing.T) {
+	now := time.Now()
+	client := &Client{
+		started: now.Add(-time.Minute),
+		down:    map[string]*diskConn{"a": &diskConn{}},
+	}
+
+	s := client.status(now)
+	if s.Elapsed != 60 || s.Streams != 1 || !s.Stalled {
+		t.Errorf("Unexpected status %#v", s)
+	}
+
+	w := countingWriter{WriteCloser: nopWriteCloser{}, client: client}
+	w.Write(make([]byte, 42))
+	w.Write(make([]byte, 8))
+	s = client.status(time.Now())
+	if s.Bytes != 50 || s.Stalled {
+		t.Errorf("Unexpected status %#v", s)
+	}
+
+	s = client.status(time.Now().Add(time.Minute))
+	if !s.Stalled {
+		t.Errorf("Expected stalled, got %#v", s)
+	}
+
+	client.down = nil
+	s = client.status(time.Now().Add(time.Minute))
+	if s.Stalled {
+		t.Errorf("Expected not stalled, got %#v", s)
+	}
+}
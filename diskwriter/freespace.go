@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package diskwriter
+
+import (
+	"errors"
+)
+
+func FreeSpace() (uint64, error) {
+	return 0, errors.New("not implemented")
+}
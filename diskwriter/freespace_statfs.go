@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package diskwriter
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// FreeSpace returns the number of bytes available to unprivileged users
+// in the recordings directory.
+func FreeSpace() (uint64, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(Directory, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,47 @@
+package diskwriter
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jech/galene/store"
+)
+
+// recordStart adds the file being written to the list of recordings in
+// the database, if any.  Called locked.
+func (conn *diskConn) recordStart() {
+	conn.recording = 0
+	if !store.Enabled() {
+		return
+	}
+	name, err := filepath.Rel(Directory, conn.file.Name())
+	if err != nil {
+		name = conn.file.Name()
+	}
+	id, err := store.AddRecording(conn.client.group.Name(),
+		filepath.ToSlash(name), conn.username, conn.label, time.Now(),
+	)
+	if err != nil {
+		logger.Warn("Add recording", "err", err)
+		return
+	}
+	conn.recording = id
+}
+
+// recordEnd records that the file has been closed.  Called locked.
+func (conn *diskConn) recordEnd() {
+	if conn.recording == 0 {
+		return
+	}
+	var size int64
+	fi, err := os.Stat(conn.file.Name())
+	if err == nil {
+		size = fi.Size()
+	}
+	err = store.FinishRecording(conn.recording, time.Now(), size)
+	if err != nil {
+		logger.Warn("Finish recording", "err", err)
+	}
+	conn.recording = 0
+}
@@ -0,0 +1,126 @@
+package diskwriter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+const (
+	defaultOverlaySize = 24
+	overlayMargin      = 16
+)
+
+// filterEscape escapes a string so that it may be used as the value of
+// a filter option in an ffmpeg filtergraph.  Two levels of escaping are
+// needed, one for the option and one for the filtergraph.
+func filterEscape(s string) string {
+	escape := func(s string, special string) string {
+		var b strings.Builder
+		for _, r := range s {
+			if strings.ContainsRune(special, r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+	return escape(escape(s, `\':`), `\'[],;`)
+}
+
+// overlayLines returns the lines of text of an overlay.  The timestamp,
+// if any, is last.
+func overlayLines(o *group.Overlay, groupname string) []string {
+	var lines []string
+	var static []string
+	if o.Group && groupname != "" {
+		static = append(static, groupname)
+	}
+	if o.Text != "" {
+		static = append(static, o.Text)
+	}
+	if len(static) > 0 {
+		lines = append(lines, strings.Join(static, " - "))
+	}
+	return lines
+}
+
+// overlayFilter returns the ffmpeg video filter that burns an overlay
+// into a recording that started at the given time, or the empty string
+// if there is nothing to burn in.
+func overlayFilter(o *group.Overlay, groupname string, start time.Time) string {
+	if o == nil {
+		return ""
+	}
+	lines := overlayLines(o, groupname)
+	n := len(lines)
+	if o.Timestamp {
+		n++
+	}
+	if n == 0 {
+		return ""
+	}
+
+	size := o.Size
+	if size <= 0 {
+		size = defaultOverlaySize
+	}
+	lh := size * 3 / 2
+
+	var x string
+	switch o.Position {
+	case "top-right", "bottom-right":
+		x = fmt.Sprintf("w-tw-%d", overlayMargin)
+	case "center":
+		x = "(w-tw)/2"
+	default:
+		x = fmt.Sprintf("%d", overlayMargin)
+	}
+	y := func(i int) string {
+		switch o.Position {
+		case "top-left", "top-right":
+			return fmt.Sprintf("%d", overlayMargin+i*lh)
+		case "center":
+			return fmt.Sprintf("(h-%d)/2+%d", n*lh, i*lh)
+		default:
+			return fmt.Sprintf("h-%d", overlayMargin+(n-i)*lh)
+		}
+	}
+
+	drawtext := func(i int, text, expansion string) string {
+		f := "drawtext=expansion=" + expansion +
+			":text=" + filterEscape(text) +
+			":x=" + x + ":y=" + y(i) +
+			fmt.Sprintf(":fontsize=%d", size) +
+			":fontcolor=white@0.8:box=1:boxcolor=black@0.4:boxborderw=4"
+		if o.Font != "" {
+			f += ":fontfile=" + filterEscape(o.Font)
+		}
+		return f
+	}
+
+	var filters []string
+	for i, l := range lines {
+		filters = append(filters, drawtext(i, l, "none"))
+	}
+	if o.Timestamp {
+		filters = append(filters, drawtext(
+			len(lines),
+			fmt.Sprintf("%%{pts:gmtime:%d} UTC", start.Unix()),
+			"normal",
+		))
+	}
+	return strings.Join(filters, ",")
+}
+
+// recordingOverlay returns the overlay that applies to the recordings of
+// a group: the group's own, or else the server's.
+func recordingOverlay(groupname string, conf *group.TranscodeConfiguration) *group.Overlay {
+	desc, err := group.GetDescription(groupname)
+	if err == nil && desc.RecordingOverlay != nil {
+		return desc.RecordingOverlay
+	}
+	return conf.Overlay
+}
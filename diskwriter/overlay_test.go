@@ -0,0 +1,61 @@
+package diskwriter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestFilterEscape(t *testing.T) {
+	// the example in the ffmpeg documentation
+	s := filterEscape(
+		"this is a 'string': may contain one, or more, special characters",
+	)
+	expected := `this is a \\\'string\\\'\\: may contain one\, ` +
+		`or more\, special characters`
+	if s != expected {
+		t.Errorf("Got %v, expected %v", s, expected)
+	}
+}
+
+func TestOverlayFilter(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	if f := overlayFilter(nil, "test", start); f != "" {
+		t.Errorf("Nil overlay: got %v", f)
+	}
+	if f := overlayFilter(&group.Overlay{}, "test", start); f != "" {
+		t.Errorf("Empty overlay: got %v", f)
+	}
+
+	f := overlayFilter(&group.Overlay{
+		Text:      "Confidential",
+		Group:     true,
+		Timestamp: true,
+		Position:  "top-right",
+	}, "test", start)
+	filters := strings.Split(f, ",")
+	if len(filters) != 2 {
+		t.Fatalf("Got %v", f)
+	}
+	if !strings.Contains(filters[0], `:text=test - Confidential:`) ||
+		!strings.Contains(filters[0], "expansion=none") ||
+		!strings.Contains(filters[0], ":x=w-tw-16:y=16:") {
+		t.Errorf("Got %v", filters[0])
+	}
+	if !strings.Contains(filters[1],
+		`:text=%{pts\\:gmtime\\:1700000000} UTC:`) ||
+		!strings.Contains(filters[1], "expansion=normal") ||
+		!strings.Contains(filters[1], ":y=52:") {
+		t.Errorf("Got %v", filters[1])
+	}
+
+	f = overlayFilter(&group.Overlay{Text: "a, b"}, "test", start)
+	if strings.Count(f, "drawtext") != 1 ||
+		!strings.Contains(f, `text=a\, b:`) ||
+		!strings.Contains(f, ":x=16:y=h-52:") {
+		t.Errorf("Got %v", f)
+	}
+}
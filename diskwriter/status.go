@@ -0,0 +1,70 @@
+package diskwriter
+
+import (
+	"io"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+const (
+	// the interval at which the recording status is sent to operators
+	statusInterval = 10 * time.Second
+	// the time after which a recording that wrote no data is stalled
+	stallTimeout = 20 * time.Second
+)
+
+// countingWriter counts the bytes written to a recording file.
+type countingWriter struct {
+	io.WriteCloser
+	client *Client
+}
+
+func (w countingWriter) Write(buf []byte) (int, error) {
+	n, err := w.WriteCloser.Write(buf)
+	if n > 0 {
+		w.client.bytes.Add(int64(n))
+		w.client.lastWrite.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// status returns the current status of the recording.
+func (client *Client) status(now time.Time) group.RecordingStatus {
+	client.mu.Lock()
+	streams := len(client.down)
+	client.mu.Unlock()
+
+	s := group.RecordingStatus{
+		Elapsed:  int64(now.Sub(client.started) / time.Second),
+		Bytes:    client.bytes.Load(),
+		Segments: client.segments.Load(),
+		Streams:  streams,
+	}
+	free, err := FreeSpace()
+	if err == nil {
+		s.Available = &free
+	}
+
+	last := client.started
+	if lw := client.lastWrite.Load(); lw != 0 {
+		last = time.Unix(0, lw)
+	}
+	s.Stalled = streams > 0 && now.Sub(last) > stallTimeout
+	return s
+}
+
+// statusLoop periodically sends the recording status to operators
+// until the client is closed.
+func (client *Client) statusLoop() {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			client.group.RecordingStatus(client.status(now))
+		case <-client.done:
+			return
+		}
+	}
+}
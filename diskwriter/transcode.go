@@ -0,0 +1,287 @@
+package diskwriter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/store"
+)
+
+// A TranscodeJob is the conversion of a finished recording to MP4.
+// Filename is relative to the recordings directory, and uses slashes.
+// Progress is a fraction between 0 and 1, and is only meaningful if the
+// job is running.
+type TranscodeJob struct {
+	Group    string  `json:"group"`
+	Filename string  `json:"filename"`
+	Running  bool    `json:"running"`
+	Progress float64 `json:"progress"`
+}
+
+type transcodeJob struct {
+	TranscodeJob
+	path      string
+	duration  time.Duration
+	recording int64
+	cmd       *exec.Cmd
+
+	// the video filter that burns in the overlay, may be empty
+	filter string
+}
+
+var transcoder struct {
+	mu      sync.Mutex
+	jobs    []*transcodeJob
+	stopped bool
+}
+
+var errTranscodeStopped = errors.New("transcoding stopped")
+
+func transcodeConfiguration() *group.TranscodeConfiguration {
+	conf, err := group.GetConfiguration()
+	if err != nil {
+		return nil
+	}
+	return conf.Transcode
+}
+
+// queueTranscode schedules the conversion of a recording, if enabled in
+// the server configuration.  Recording is the id of the recording in the
+// database, or 0.
+func queueTranscode(groupname, path string, start time.Time, duration time.Duration, recording int64) {
+	conf := transcodeConfiguration()
+	if conf == nil {
+		return
+	}
+
+	name, err := filepath.Rel(Directory, path)
+	if err != nil {
+		name = path
+	}
+	job := &transcodeJob{
+		TranscodeJob: TranscodeJob{
+			Group:    groupname,
+			Filename: filepath.ToSlash(name),
+		},
+		path:      path,
+		duration:  duration,
+		recording: recording,
+		filter: overlayFilter(
+			recordingOverlay(groupname, conf), groupname, start,
+		),
+	}
+
+	transcoder.mu.Lock()
+	defer transcoder.mu.Unlock()
+	if transcoder.stopped {
+		return
+	}
+	transcoder.jobs = append(transcoder.jobs, job)
+	scheduleTranscodes(conf)
+}
+
+// scheduleTranscodes starts queued jobs, up to the configured limit.
+// Called locked.
+func scheduleTranscodes(conf *group.TranscodeConfiguration) {
+	max := 1
+	if conf.MaxJobs > 0 {
+		max = conf.MaxJobs
+	}
+	running := 0
+	for _, job := range transcoder.jobs {
+		if job.Running {
+			running++
+		}
+	}
+	for _, job := range transcoder.jobs {
+		if running >= max {
+			break
+		}
+		if !job.Running {
+			job.Running = true
+			running++
+			go runTranscode(job, conf)
+		}
+	}
+}
+
+func runTranscode(job *transcodeJob, conf *group.TranscodeConfiguration) {
+	start := time.Now()
+	err := transcode(job, conf)
+	if err != nil {
+		logger.Warn("Transcode recording",
+			"file", job.Filename, "err", err)
+	} else {
+		logger.Info("Transcoded recording", "file", job.Filename,
+			"time", time.Since(start).Round(time.Second))
+	}
+
+	transcoder.mu.Lock()
+	defer transcoder.mu.Unlock()
+	for i, j := range transcoder.jobs {
+		if j == job {
+			transcoder.jobs = append(
+				transcoder.jobs[:i], transcoder.jobs[i+1:]...,
+			)
+			break
+		}
+	}
+	if transcoder.stopped {
+		return
+	}
+	if c := transcodeConfiguration(); c != nil {
+		conf = c
+	}
+	scheduleTranscodes(conf)
+}
+
+func mp4Filename(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".mp4"
+}
+
+// transcode runs ffmpeg on a recording, and replaces the original with
+// the MP4 file unless configured to keep it.
+func transcode(job *transcodeJob, conf *group.TranscodeConfiguration) error {
+	ffmpeg := conf.FFmpeg
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	out := mp4Filename(job.path)
+	_, err := os.Stat(out)
+	if err == nil {
+		return errors.New(out + " already exists")
+	}
+	tmp := out + ".part"
+
+	args := []string{
+		"-nostdin", "-loglevel", "error", "-y",
+		"-i", job.path,
+	}
+	if job.filter != "" {
+		args = append(args, "-vf", job.filter)
+	}
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-movflags", "+faststart",
+		"-progress", "pipe:1",
+		"-f", "mp4", tmp,
+	)
+	cmd := exec.Command(ffmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	transcoder.mu.Lock()
+	if transcoder.stopped {
+		transcoder.mu.Unlock()
+		return errTranscodeStopped
+	}
+	err = cmd.Start()
+	if err == nil {
+		job.cmd = cmd
+	}
+	transcoder.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		us, ok := strings.CutPrefix(scanner.Text(), "out_time_us=")
+		if !ok || job.duration <= 0 {
+			continue
+		}
+		t, err := strconv.ParseInt(us, 10, 64)
+		if err != nil {
+			continue
+		}
+		progress := min(float64(t)/float64(job.duration.Microseconds()), 1)
+		transcoder.mu.Lock()
+		job.Progress = progress
+		transcoder.mu.Unlock()
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		os.Remove(tmp)
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:]
+		}
+		if msg != "" {
+			return fmt.Errorf("%w (%v)", err, msg)
+		}
+		return err
+	}
+
+	err = os.Rename(tmp, out)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if conf.KeepOriginal {
+		return nil
+	}
+	err = os.Remove(job.path)
+	if err != nil {
+		return err
+	}
+	if job.recording != 0 {
+		var size int64
+		fi, err := os.Stat(out)
+		if err == nil {
+			size = fi.Size()
+		}
+		name, err := filepath.Rel(Directory, out)
+		if err != nil {
+			name = out
+		}
+		err = store.RenameRecording(
+			job.recording, filepath.ToSlash(name), size,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transcodes returns the conversions that are queued or running.
+func Transcodes() []TranscodeJob {
+	transcoder.mu.Lock()
+	defer transcoder.mu.Unlock()
+	jobs := make([]TranscodeJob, len(transcoder.jobs))
+	for i, job := range transcoder.jobs {
+		jobs[i] = job.TranscodeJob
+	}
+	return jobs
+}
+
+// stopTranscodes kills any running conversions and drops queued ones.
+// The original recordings are left in place.
+func stopTranscodes() {
+	transcoder.mu.Lock()
+	defer transcoder.mu.Unlock()
+	transcoder.stopped = true
+	for _, job := range transcoder.jobs {
+		if job.cmd != nil {
+			job.cmd.Process.Kill()
+		}
+	}
+	transcoder.jobs = nil
+}
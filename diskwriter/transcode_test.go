@@ -0,0 +1,100 @@
+package diskwriter
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// fakeFFmpeg writes a script that behaves like ffmpeg: it reports
+// progress on standard output and writes its last argument.
+func fakeFFmpeg(t *testing.T, dir string, fail bool) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	script := "#!/bin/sh\n" +
+		"for last; do :; done\n" +
+		"echo out_time_us=500000\n" +
+		"echo progress=continue\n"
+	if fail {
+		script += "echo 'Invalid data found' >&2\nexit 1\n"
+	} else {
+		script += "echo mp4 > \"$last\"\n"
+	}
+	filename := filepath.Join(dir, "ffmpeg")
+	err := os.WriteFile(filename, []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return filename
+}
+
+func TestMP4Filename(t *testing.T) {
+	if f := mp4Filename("a/b.webm"); f != "a/b.mp4" {
+		t.Errorf("Expected a/b.mp4, got %v", f)
+	}
+	if f := mp4Filename("a/b.c.mkv"); f != "a/b.c.mp4" {
+		t.Errorf("Expected a/b.c.mp4, got %v", f)
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "test.webm")
+	for _, keep := range []bool{true, false} {
+		err := os.WriteFile(in, []byte("webm"), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		os.Remove(mp4Filename(in))
+
+		job := &transcodeJob{path: in, duration: time.Second}
+		err = transcode(job, &group.TranscodeConfiguration{
+			FFmpeg:       fakeFFmpeg(t, dir, false),
+			KeepOriginal: keep,
+		})
+		if err != nil {
+			t.Fatalf("transcode: %v", err)
+		}
+		if job.Progress != 0.5 {
+			t.Errorf("Expected progress 0.5, got %v", job.Progress)
+		}
+		data, err := os.ReadFile(mp4Filename(in))
+		if err != nil || string(data) != "mp4\n" {
+			t.Errorf("ReadFile: got %#v %v", string(data), err)
+		}
+		_, err = os.Stat(in)
+		if keep != (err == nil) {
+			t.Errorf("Keep %v: got %v", keep, err)
+		}
+	}
+}
+
+func TestTranscodeFailure(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "test.webm")
+	err := os.WriteFile(in, []byte("webm"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	err = transcode(&transcodeJob{path: in}, &group.TranscodeConfiguration{
+		FFmpeg: fakeFFmpeg(t, dir, true),
+	})
+	if err == nil {
+		t.Errorf("transcode succeeded")
+	}
+	if _, err := os.Stat(in); err != nil {
+		t.Errorf("Original removed: %v", err)
+	}
+	for _, f := range []string{".mp4", ".mp4.part"} {
+		_, err := os.Stat(filepath.Join(dir, "test"+f))
+		if err == nil {
+			t.Errorf("%v not removed", f)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package diskwriter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// whiteboardRecord is a line of the whiteboard log, which uses the same
+// representation as the protocol.
+type whiteboardRecord struct {
+	Time     string      `json:"time"`
+	Kind     string      `json:"kind"`
+	Id       string      `json:"id,omitempty"`
+	Source   string      `json:"source,omitempty"`
+	Username *string     `json:"username,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// whiteboardLoop records the current state of the whiteboard followed by
+// the operations queued by Whiteboard, if required by the group's
+// configuration.  It runs until the client is closed.
+func (client *Client) whiteboardLoop() {
+	desc := client.group.Description()
+	record := desc.AllowWhiteboard && desc.RecordWhiteboard
+
+	var seq uint64
+	if record {
+		var ops []group.WhiteboardOp
+		seq, ops = client.group.GetWhiteboard()
+		for _, op := range ops {
+			if !client.recordWhiteboard(op) {
+				record = false
+				break
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-client.whiteboardOps.Ch:
+			for _, op := range client.whiteboardOps.Get() {
+				// operations older than the initial state
+				// are already recorded
+				if !record || op.Seq <= seq {
+					continue
+				}
+				seq = op.Seq
+				record = client.recordWhiteboard(op)
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// recordWhiteboard writes an operation to the whiteboard log, and
+// returns false if recording should stop.
+func (client *Client) recordWhiteboard(op group.WhiteboardOp) bool {
+	client.mu.Lock()
+	if client.closed {
+		client.mu.Unlock()
+		return false
+	}
+	err := client.writeWhiteboard(op)
+	client.mu.Unlock()
+
+	if err != nil {
+		client.group.WallOps("Write to disk: " + err.Error())
+		return false
+	}
+	return true
+}
+
+// Whiteboard queues an operation for whiteboardLoop.  It is called while
+// the whiteboard is locked, and must therefore not block.
+func (client *Client) Whiteboard(op group.WhiteboardOp) error {
+	client.whiteboardOps.Put(op)
+	return nil
+}
+
+// called locked
+func (client *Client) writeWhiteboard(op group.WhiteboardOp) error {
+	if client.whiteboard == nil {
+		directory := filepath.Join(Directory, client.group.Name())
+		err := os.MkdirAll(directory, 0700)
+		if err != nil {
+			return err
+		}
+		f, err := openDiskFile(directory, "whiteboard", "", "jsonl")
+		if err != nil {
+			return err
+		}
+		client.whiteboard = f
+	}
+
+	r := whiteboardRecord{
+		Time:     op.Time.Format(time.RFC3339Nano),
+		Kind:     op.Kind,
+		Source:   op.Source,
+		Username: op.Username,
+	}
+	switch op.Kind {
+	case "draw":
+		r.Id = strconv.FormatUint(op.Seq, 10)
+		r.Value = op.Value
+	case "undo":
+		r.Id = strconv.FormatUint(op.Target, 10)
+	}
+	return json.NewEncoder(client.whiteboard).Encode(r)
+}
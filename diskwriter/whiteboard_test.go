@@ -0,0 +1,79 @@
+package diskwriter
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestWhiteboardRecording(t *testing.T) {
+	defer func(d string) { Directory = d }(Directory)
+	Directory = t.TempDir()
+
+	g, err := group.Add("whiteboard-test", &group.Description{
+		AllowWhiteboard:  true,
+		RecordWhiteboard: true,
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("whiteboard-test")
+
+	err = g.Whiteboard(group.WhiteboardOp{
+		Kind: "draw", Source: "a", Value: "first",
+	}, false)
+	if err != nil {
+		t.Fatalf("Whiteboard: %v", err)
+	}
+
+	client := New(g)
+	go client.whiteboardLoop()
+
+	// the first operation is part of the initial state, and must not
+	// be recorded twice
+	client.Whiteboard(group.WhiteboardOp{
+		Seq: 1, Kind: "draw", Source: "a", Value: "first",
+	})
+	client.Whiteboard(group.WhiteboardOp{
+		Seq: 2, Kind: "draw", Source: "a", Value: "second",
+	})
+
+	var records []whiteboardRecord
+	deadline := time.Now().Add(5 * time.Second)
+	for len(records) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		records = nil
+		files, _ := filepath.Glob(filepath.Join(
+			Directory, "whiteboard-test", "*.jsonl",
+		))
+		if len(files) != 1 {
+			continue
+		}
+		f, err := os.Open(files[0])
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r whiteboardRecord
+			err := json.Unmarshal(scanner.Bytes(), &r)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			records = append(records, r)
+		}
+		f.Close()
+	}
+	client.Close()
+
+	if len(records) != 2 ||
+		records[0].Id != "1" || records[0].Value != "first" ||
+		records[1].Id != "2" || records[1].Value != "second" {
+		t.Errorf("Unexpected records %v", records)
+	}
+}
@@ -0,0 +1,277 @@
+// Package filedrop implements the storage of the files shared in a group.
+package filedrop
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/logging"
+)
+
+var logger = logging.New("filedrop")
+
+// Directory is the directory where shared files are stored, with one
+// subdirectory per group.
+var Directory string
+
+// MaxFiles is the maximum number of files shared in a single group.
+const MaxFiles = 256
+
+var ErrTooLarge = errors.New("file too large")
+var ErrBadType = errors.New("file type not allowed")
+var ErrTooManyFiles = errors.New("too many files")
+
+// A File is the description of a shared file.  It is stored alongside
+// the file's contents.
+type File struct {
+	Id          string    `json:"id"`
+	Group       string    `json:"group"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Username    string    `json:"username,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+func groupDirectory(group string) string {
+	return filepath.Join(Directory, filepath.FromSlash(path.Clean("/"+group)))
+}
+
+func validId(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// typeAllowed returns true if a content type matches one of a list of
+// patterns, which are either content types or of the form "image/*".
+// An empty list allows all types.
+func typeAllowed(ctype string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	t, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == t || p == "*/*" {
+			return true
+		}
+		prefix, found := strings.CutSuffix(p, "/*")
+		if found && strings.HasPrefix(t, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Add stores a new file.  The content type is determined from the file's
+// contents, and must match one of types.
+func Add(group, name, username string, r io.Reader, maxSize int64, types []string) (*File, error) {
+	files, err := List(group)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(files) >= MaxFiles {
+		return nil, ErrTooManyFiles
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	ctype := http.DetectContentType(head)
+	if !typeAllowed(ctype, types) {
+		return nil, ErrBadType
+	}
+
+	buf := make([]byte, 16)
+	crand.Read(buf)
+	file := &File{
+		Id:          hex.EncodeToString(buf),
+		Group:       group,
+		Name:        path.Base(filepath.ToSlash(name)),
+		ContentType: ctype,
+		Username:    username,
+		Time:        time.Now(),
+	}
+
+	dir := groupDirectory(group)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(dir, "*.temp")
+	if err != nil {
+		return nil, err
+	}
+	temp := f.Name()
+	size, err := io.Copy(f, io.LimitReader(
+		io.MultiReader(bytes.NewReader(head), r), maxSize+1,
+	))
+	if err == nil && size > maxSize {
+		err = ErrTooLarge
+	}
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(temp)
+		return nil, err
+	}
+	file.Size = size
+
+	desc, err := json.Marshal(file)
+	if err == nil {
+		err = os.WriteFile(
+			filepath.Join(dir, file.Id+".json"), desc, 0600,
+		)
+	}
+	if err == nil {
+		err = os.Rename(temp, filepath.Join(dir, file.Id))
+	}
+	if err != nil {
+		os.Remove(temp)
+		os.Remove(filepath.Join(dir, file.Id+".json"))
+		return nil, err
+	}
+	return file, nil
+}
+
+// Get returns the description of a shared file.
+func Get(group, id string) (*File, error) {
+	if !validId(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(
+		filepath.Join(groupDirectory(group), id+".json"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Open opens the contents of a shared file.
+func Open(group, id string) (*os.File, error) {
+	if !validId(id) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(groupDirectory(group), id))
+}
+
+// List returns the files shared in a group, oldest first.
+func List(group string) ([]*File, error) {
+	entries, err := os.ReadDir(groupDirectory(group))
+	if err != nil {
+		return nil, err
+	}
+	var files []*File
+	for _, e := range entries {
+		id, found := strings.CutSuffix(e.Name(), ".json")
+		if !found || !validId(id) {
+			continue
+		}
+		file, err := Get(group, id)
+		if err != nil {
+			logger.Warn("Read file description",
+				"group", group, "id", id, "err", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Time.Before(files[j].Time)
+	})
+	return files, nil
+}
+
+// Delete deletes a shared file.
+func Delete(group, id string) error {
+	if !validId(id) {
+		return os.ErrNotExist
+	}
+	dir := groupDirectory(group)
+	err := os.Remove(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(dir, id))
+}
+
+// Expire deletes the files that are older than the retention time of
+// their group, which is given by the function retention.
+func Expire(retention func(group string) time.Duration) error {
+	var groups []string
+	err := filepath.WalkDir(Directory,
+		func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() && p != Directory {
+				name, err := filepath.Rel(Directory, p)
+				if err != nil {
+					return err
+				}
+				groups = append(groups, filepath.ToSlash(name))
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, g := range groups {
+		files, err := List(g)
+		if err != nil {
+			logger.Warn("List files", "group", g, "err", err)
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		r := retention(g)
+		for _, file := range files {
+			if now.Sub(file.Time) <= r {
+				continue
+			}
+			err := Delete(g, file.Id)
+			if err != nil {
+				logger.Warn("Delete file",
+					"group", g, "id", file.Id, "err", err)
+			}
+		}
+	}
+	return nil
+}
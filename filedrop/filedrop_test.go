@@ -0,0 +1,128 @@
+package filedrop
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypeAllowed(t *testing.T) {
+	tests := []struct {
+		ctype    string
+		patterns []string
+		result   bool
+	}{
+		{"text/plain; charset=utf-8", nil, true},
+		{"text/plain; charset=utf-8", []string{"text/plain"}, true},
+		{"text/plain; charset=utf-8", []string{"TEXT/*"}, true},
+		{"text/plain; charset=utf-8", []string{"image/*"}, false},
+		{"image/png", []string{"text/plain", "image/*"}, true},
+		{"image/png", []string{"*/*"}, true},
+		{"application/pdf", []string{"application/pd"}, false},
+		{"", []string{"text/plain"}, false},
+	}
+
+	for _, test := range tests {
+		r := typeAllowed(test.ctype, test.patterns)
+		if r != test.result {
+			t.Errorf("typeAllowed(%v, %v) = %v, expected %v",
+				test.ctype, test.patterns, r, test.result)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	Directory = t.TempDir()
+
+	_, err := Add("test", "a.txt", "alice",
+		strings.NewReader("hello"), 1024, []string{"image/*"},
+	)
+	if !errors.Is(err, ErrBadType) {
+		t.Errorf("Add: expected ErrBadType, got %v", err)
+	}
+
+	_, err = Add("test", "a.txt", "alice",
+		strings.NewReader(strings.Repeat("a", 1025)), 1024, nil,
+	)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Add: expected ErrTooLarge, got %v", err)
+	}
+
+	files, err := List("test")
+	if err != nil || len(files) != 0 {
+		t.Errorf("List: %v %v", files, err)
+	}
+
+	file, err := Add("test", "../dir/a.txt", "alice",
+		strings.NewReader("hello"), 1024, []string{"text/*"},
+	)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if file.Name != "a.txt" || file.Size != 5 ||
+		!strings.HasPrefix(file.ContentType, "text/plain") {
+		t.Errorf("Add: got %#v", file)
+	}
+
+	f, err := Open("test", file.Id)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Read: %v %v", string(data), err)
+	}
+
+	_, err = Get("test", "../test/"+file.Id)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get: expected ErrNotExist, got %v", err)
+	}
+	_, err = Get("other", file.Id)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get: expected ErrNotExist, got %v", err)
+	}
+
+	files, err = List("test")
+	if err != nil || len(files) != 1 || files[0].Id != file.Id {
+		t.Errorf("List: %v %v", files, err)
+	}
+
+	err = Expire(func(group string) time.Duration {
+		return time.Hour
+	})
+	if err != nil {
+		t.Errorf("Expire: %v", err)
+	}
+	_, err = Get("test", file.Id)
+	if err != nil {
+		t.Errorf("Get after Expire: %v", err)
+	}
+
+	err = Expire(func(group string) time.Duration {
+		return 0
+	})
+	if err != nil {
+		t.Errorf("Expire: %v", err)
+	}
+	_, err = Get("test", file.Id)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get: expected ErrNotExist, got %v", err)
+	}
+
+	file, err = Add("test", "b.txt", "", strings.NewReader("b"), 1024, nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	err = Delete("test", file.Id)
+	if err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	err = Delete("test", file.Id)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Delete: expected ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,732 @@
+// Galene-bench is a load-testing client for Galène.  It connects
+// a number of synthetic clients to a group, some of which publish
+// generated audio and video streams while the others subscribe to them,
+// and periodically reports the join latency, bitrate and packet loss
+// observed by the clients.
+package main
+
+import (
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// message is a subset of the messages defined in README.PROTOCOL.
+type message struct {
+	Type             string                   `json:"type"`
+	Version          []string                 `json:"version,omitempty"`
+	Kind             string                   `json:"kind,omitempty"`
+	Id               string                   `json:"id,omitempty"`
+	Source           string                   `json:"source,omitempty"`
+	Username         *string                  `json:"username,omitempty"`
+	Password         string                   `json:"password,omitempty"`
+	Group            string                   `json:"group,omitempty"`
+	Value            interface{}              `json:"value,omitempty"`
+	Label            string                   `json:"label,omitempty"`
+	SDP              string                   `json:"sdp,omitempty"`
+	Candidate        *webrtc.ICECandidateInit `json:"candidate,omitempty"`
+	Request          interface{}              `json:"request,omitempty"`
+	RTCConfiguration *webrtc.Configuration    `json:"rtcConfiguration,omitempty"`
+}
+
+var username, password string
+var videoRate, audioRate int
+var insecure bool
+
+func main() {
+	var publishers, subscribers int
+	var duration, interval, spread time.Duration
+	flag.IntVar(&publishers, "publishers", 1,
+		"`number` of clients that publish streams")
+	flag.IntVar(&subscribers, "subscribers", 10,
+		"`number` of clients that only subscribe")
+	flag.StringVar(&username, "username", "bench", "`username`")
+	flag.StringVar(&password, "password", "", "`password`")
+	flag.IntVar(&videoRate, "video-rate", 500,
+		"video `bitrate` of each publisher, in kbit/s")
+	flag.IntVar(&audioRate, "audio-rate", 48,
+		"audio `bitrate` of each publisher, in kbit/s")
+	flag.DurationVar(&duration, "duration", time.Minute,
+		"`duration` of the test")
+	flag.DurationVar(&interval, "interval", 5*time.Second,
+		"`interval` between reports")
+	flag.DurationVar(&spread, "spread", 50*time.Millisecond,
+		"`delay` between client connections")
+	flag.BoolVar(&insecure, "insecure", false,
+		"don't check server certificates")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"%v [option...] group-url\n",
+			os.Args[0],
+		)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	endpoint, groupname, err := getEndpoint(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Get status: %v", err)
+	}
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < publishers+subscribers; i++ {
+		wg.Add(1)
+		go func(publish bool) {
+			defer wg.Done()
+			err := runClient(endpoint, groupname, publish, done)
+			if err != nil {
+				log.Printf("Client: %v", err)
+				results.failed.Add(1)
+			}
+		}(i < publishers)
+		select {
+		case <-time.After(spread):
+		case <-terminate:
+			close(done)
+			wg.Wait()
+			report(time.Now())
+			return
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			report(now)
+		case <-timer.C:
+			close(done)
+			wg.Wait()
+			report(time.Now())
+			return
+		case <-terminate:
+			close(done)
+			wg.Wait()
+			report(time.Now())
+			return
+		}
+	}
+}
+
+func httpClient() *http.Client {
+	if !insecure {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+}
+
+// getEndpoint fetches the status of a group, and returns its websocket
+// endpoint and its name.
+func getEndpoint(groupURL string) (string, string, error) {
+	u, err := url.Parse(groupURL)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path = u.Path + "/"
+	}
+	resp, err := httpClient().Get(u.JoinPath(".status").String())
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.New(resp.Status)
+	}
+	var status struct {
+		Name     string `json:"name"`
+		Endpoint string `json:"endpoint"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return "", "", err
+	}
+	if status.Endpoint == "" {
+		return "", "", errors.New("no endpoint in status")
+	}
+	return status.Endpoint, status.Name, nil
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// results accumulates the statistics of all clients.
+var results struct {
+	joined atomic.Int64
+	failed atomic.Int64
+
+	mu       sync.Mutex
+	latency  []time.Duration
+	tracks   []*trackStats
+	lastTime time.Time
+	last     map[string]trackTotals
+}
+
+type trackStats struct {
+	kind string
+
+	mu       sync.Mutex
+	bytes    uint64
+	packets  uint64
+	first    uint32
+	extended uint32
+	started  bool
+}
+
+type trackTotals struct {
+	bytes, packets, expected uint64
+	tracks                   int
+}
+
+func (t *trackStats) packet(seqno uint16, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytes += uint64(size)
+	t.packets++
+	if !t.started {
+		t.started = true
+		t.first = uint32(seqno)
+		t.extended = uint32(seqno)
+		return
+	}
+	delta := seqno - uint16(t.extended)
+	if delta < 0x8000 {
+		t.extended += uint32(delta)
+	}
+}
+
+func report(now time.Time) {
+	results.mu.Lock()
+	defer results.mu.Unlock()
+
+	totals := make(map[string]trackTotals)
+	for _, t := range results.tracks {
+		t.mu.Lock()
+		tt := totals[t.kind]
+		tt.bytes += t.bytes
+		tt.packets += t.packets
+		if t.started {
+			tt.expected += uint64(t.extended-t.first) + 1
+		}
+		tt.tracks++
+		totals[t.kind] = tt
+		t.mu.Unlock()
+	}
+
+	fmt.Printf("%v: %v clients joined, %v failed",
+		now.Format(time.TimeOnly),
+		results.joined.Load(), results.failed.Load(),
+	)
+	if len(results.latency) > 0 {
+		l := append([]time.Duration(nil), results.latency...)
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Printf(", join latency median %v, max %v",
+			l[len(l)/2].Round(time.Millisecond),
+			l[len(l)-1].Round(time.Millisecond),
+		)
+	}
+	fmt.Println()
+
+	elapsed := now.Sub(results.lastTime).Seconds()
+	for _, kind := range []string{"audio", "video"} {
+		tt := totals[kind]
+		last := results.last[kind]
+		if tt.tracks == 0 {
+			continue
+		}
+		var rate, loss float64
+		if !results.lastTime.IsZero() && elapsed > 0 {
+			rate = float64(tt.bytes-last.bytes) * 8 /
+				elapsed / float64(tt.tracks) / 1000
+		}
+		expected := tt.expected - last.expected
+		packets := tt.packets - last.packets
+		if expected > 0 && packets <= expected {
+			loss = float64(expected-packets) /
+				float64(expected) * 100
+		}
+		fmt.Printf("  %v: %v tracks, %.0f kbit/s per track, "+
+			"%.2f%% loss\n",
+			kind, tt.tracks, rate, loss)
+	}
+	results.last = totals
+	results.lastTime = now
+}
+
+type client struct {
+	id     string
+	ws     *websocket.Conn
+	config webrtc.Configuration
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pcs     map[string]*webrtc.PeerConnection
+	pending map[string][]webrtc.ICECandidateInit
+}
+
+func (c *client) write(m message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(m)
+}
+
+func runClient(endpoint, groupname string, publish bool, done <-chan struct{}) error {
+	dialer := *websocket.DefaultDialer
+	if insecure {
+		dialer.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+	start := time.Now()
+	ws, _, err := dialer.Dial(endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	c := &client{
+		id:      newId(),
+		ws:      ws,
+		pcs:     make(map[string]*webrtc.PeerConnection),
+		pending: make(map[string][]webrtc.ICECandidateInit),
+	}
+	defer func() {
+		c.mu.Lock()
+		for _, pc := range c.pcs {
+			pc.Close()
+		}
+		c.mu.Unlock()
+	}()
+
+	err = c.write(message{
+		Type:    "handshake",
+		Version: []string{"2"},
+		Id:      c.id,
+	})
+	if err != nil {
+		return err
+	}
+	user := username
+	err = c.write(message{
+		Type:     "join",
+		Kind:     "join",
+		Group:    groupname,
+		Username: &user,
+		Password: password,
+	})
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.readLoop(start, publish, done)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-done:
+		c.write(message{
+			Type:  "join",
+			Kind:  "leave",
+			Group: groupname,
+		})
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(
+				websocket.CloseNormalClosure, "",
+			), time.Now().Add(time.Second))
+		return nil
+	}
+}
+
+func (c *client) readLoop(start time.Time, publish bool, done <-chan struct{}) error {
+	for {
+		var m message
+		err := c.ws.ReadJSON(&m)
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return err
+			}
+		}
+		switch m.Type {
+		case "handshake":
+		case "ping":
+			err = c.write(message{Type: "pong"})
+		case "joined":
+			switch m.Kind {
+			case "join":
+				if m.RTCConfiguration != nil {
+					c.config = *m.RTCConfiguration
+				}
+				results.joined.Add(1)
+				results.mu.Lock()
+				results.latency = append(results.latency,
+					time.Since(start))
+				results.mu.Unlock()
+				if publish {
+					err = c.publish(done)
+					if err != nil {
+						return err
+					}
+				}
+				err = c.write(message{
+					Type: "request",
+					Request: map[string][]string{
+						"": {"audio", "video"},
+					},
+				})
+			case "fail":
+				return fmt.Errorf("join failed: %v", m.Value)
+			case "leave":
+				return errors.New("server kicked us out")
+			}
+		case "offer":
+			err = c.gotOffer(m)
+		case "answer":
+			err = c.gotAnswer(m)
+		case "ice":
+			if m.Candidate != nil {
+				err = c.gotICE(m.Id, *m.Candidate)
+			}
+		case "close":
+			c.closeConn(m.Id)
+		case "abort":
+			return fmt.Errorf("server aborted stream %v", m.Id)
+		case "usermessage":
+			if m.Kind == "error" {
+				log.Printf("Server error: %v", m.Value)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *client) newPeerConnection(id string) (*webrtc.PeerConnection, error) {
+	pc, err := webrtc.NewPeerConnection(c.config)
+	if err != nil {
+		return nil, err
+	}
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		init := candidate.ToJSON()
+		c.write(message{
+			Type:      "ice",
+			Id:        id,
+			Candidate: &init,
+		})
+	})
+	c.mu.Lock()
+	c.pcs[id] = pc
+	c.mu.Unlock()
+	return pc, nil
+}
+
+func (c *client) getConn(id string) *webrtc.PeerConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pcs[id]
+}
+
+func (c *client) closeConn(id string) {
+	c.mu.Lock()
+	pc := c.pcs[id]
+	delete(c.pcs, id)
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if pc != nil {
+		pc.Close()
+	}
+}
+
+// flushICE adds the candidates that were received before the remote
+// description.
+func (c *client) flushICE(id string, pc *webrtc.PeerConnection) error {
+	c.mu.Lock()
+	pending := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	for _, candidate := range pending {
+		err := pc.AddICECandidate(candidate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *client) gotICE(id string, candidate webrtc.ICECandidateInit) error {
+	pc := c.getConn(id)
+	if pc == nil {
+		return nil
+	}
+	if pc.RemoteDescription() == nil {
+		c.mu.Lock()
+		c.pending[id] = append(c.pending[id], candidate)
+		c.mu.Unlock()
+		return nil
+	}
+	return pc.AddICECandidate(candidate)
+}
+
+func (c *client) gotOffer(m message) error {
+	pc := c.getConn(m.Id)
+	if pc == nil {
+		var err error
+		pc, err = c.newPeerConnection(m.Id)
+		if err != nil {
+			return err
+		}
+		pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			go receive(track)
+		})
+	}
+	err := pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  m.SDP,
+	})
+	if err != nil {
+		return err
+	}
+	err = c.flushICE(m.Id, pc)
+	if err != nil {
+		return err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	err = pc.SetLocalDescription(answer)
+	if err != nil {
+		return err
+	}
+	return c.write(message{
+		Type:   "answer",
+		Id:     m.Id,
+		Source: c.id,
+		SDP:    pc.LocalDescription().SDP,
+	})
+}
+
+func (c *client) gotAnswer(m message) error {
+	pc := c.getConn(m.Id)
+	if pc == nil {
+		return nil
+	}
+	err := pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  m.SDP,
+	})
+	if err != nil {
+		return err
+	}
+	return c.flushICE(m.Id, pc)
+}
+
+func receive(track *webrtc.TrackRemote) {
+	t := &trackStats{kind: track.Kind().String()}
+	results.mu.Lock()
+	results.tracks = append(results.tracks, t)
+	results.mu.Unlock()
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := track.Read(buf)
+		if err != nil {
+			return
+		}
+		if n < 4 {
+			continue
+		}
+		seqno := uint16(buf[2])<<8 | uint16(buf[3])
+		t.packet(seqno, n)
+	}
+}
+
+func (c *client) publish(done <-chan struct{}) error {
+	id := newId()
+	pc, err := c.newPeerConnection(id)
+	if err != nil {
+		return err
+	}
+
+	stream := newId()
+	video, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8},
+		"video", stream,
+	)
+	if err != nil {
+		return err
+	}
+	audio, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: 48000,
+			Channels:  2,
+		},
+		"audio", stream,
+	)
+	if err != nil {
+		return err
+	}
+	for _, t := range []webrtc.TrackLocal{video, audio} {
+		sender, err := pc.AddTrack(t)
+		if err != nil {
+			return err
+		}
+		// consume RTCP, which is needed for the interceptors
+		go func(sender *webrtc.RTPSender) {
+			buf := make([]byte, 1500)
+			for {
+				_, _, err := sender.Read(buf)
+				if err != nil {
+					return
+				}
+			}
+		}(sender)
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	err = pc.SetLocalDescription(offer)
+	if err != nil {
+		return err
+	}
+	user := username
+	err = c.write(message{
+		Type:     "offer",
+		Id:       id,
+		Label:    "camera",
+		Source:   c.id,
+		Username: &user,
+		SDP:      pc.LocalDescription().SDP,
+	})
+	if err != nil {
+		return err
+	}
+
+	go sendVideo(video, done)
+	go sendAudio(audio, done)
+	return nil
+}
+
+// vp8Frame generates a VP8 frame of the given size, with a valid
+// header but random contents.  Galène doesn't decode the frames, but it
+// does look at their headers in order to detect keyframes.
+func vp8Frame(size int, keyframe bool) []byte {
+	frame := make([]byte, max(size, 10))
+	crand.Read(frame)
+	if keyframe {
+		// frame tag: keyframe, version 0, show frame
+		frame[0] = 0x10
+		frame[1] = 0
+		frame[2] = 0
+		// start code, then 640x480
+		copy(frame[3:], []byte{0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01})
+	} else {
+		frame[0] = 0x11
+	}
+	return frame
+}
+
+func sendVideo(track *webrtc.TrackLocalStaticSample, done <-chan struct{}) {
+	const fps = 30
+	const keyframeInterval = 2 * time.Second
+	ticker := time.NewTicker(time.Second / fps)
+	defer ticker.Stop()
+	size := videoRate * 1000 / 8 / fps
+	var lastKeyframe time.Time
+	for {
+		select {
+		case now := <-ticker.C:
+			kf := now.Sub(lastKeyframe) >= keyframeInterval
+			if kf {
+				lastKeyframe = now
+			}
+			err := track.WriteSample(media.Sample{
+				Data:     vp8Frame(size, kf),
+				Duration: time.Second / fps,
+			})
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func sendAudio(track *webrtc.TrackLocalStaticSample, done <-chan struct{}) {
+	const interval = 20 * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	size := audioRate * 1000 / 8 / int(time.Second/interval)
+	for {
+		select {
+		case <-ticker.C:
+			frame := make([]byte, max(size, 2))
+			crand.Read(frame)
+			// TOC byte: CELT fullband, 20ms, single frame
+			frame[0] = 0xfc
+			err := track.WriteSample(media.Sample{
+				Data:     frame,
+				Duration: interval,
+			})
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -12,30 +12,57 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/jech/galene/bridge"
 	"github.com/jech/galene/diskwriter"
+	"github.com/jech/galene/filedrop"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/ice"
 	"github.com/jech/galene/limit"
+	"github.com/jech/galene/logging"
+	"github.com/jech/galene/sockbuf"
+	"github.com/jech/galene/stats"
+	"github.com/jech/galene/store"
 	"github.com/jech/galene/token"
+	"github.com/jech/galene/tracing"
 	"github.com/jech/galene/turnserver"
 	"github.com/jech/galene/webserver"
 )
 
+var logger = logging.New("main")
+
+var configFile, databaseFile string
+
 func main() {
 	var cpuprofile, memprofile, mutexprofile, httpAddr string
-	var udpRange string
+	var udpRange, shutdownRedirect, logFormat, logLevel string
+	var acmeDomains, iceInterfaces, iceAddresses, natAddresses string
+	var drainTimeout time.Duration
 
+	flag.StringVar(&configFile, "config", "",
+		"read configuration from `file`, overridden by the command line")
 	flag.StringVar(&httpAddr, "http", ":8443", "web server `address`")
 	flag.StringVar(&webserver.StaticRoot, "static", "./static/",
 		"web server root `directory`")
 	flag.BoolVar(&webserver.Insecure, "insecure", false,
 		"act as an HTTP server rather than HTTPS")
+	flag.BoolVar(&webserver.ProxyProtocol, "proxy-protocol", false,
+		"expect a PROXY protocol header on every connection")
+	flag.StringVar(&acmeDomains, "acme-domain", "",
+		"comma-separated `list` of host names to obtain a certificate for using ACME")
+	flag.StringVar(&webserver.ACMEEmail, "acme-email", "",
+		"contact `address` sent to the ACME certificate authority")
+	flag.StringVar(&webserver.ACMEDirectory, "acme-directory", "",
+		"directory `URL` of the ACME certificate authority (default Let's Encrypt)")
+	flag.StringVar(&webserver.ACMEHTTPAddress, "acme-http", "",
+		"`address` to listen on for ACME HTTP-01 challenges, e.g. \":80\"")
 	flag.StringVar(&group.DataDirectory, "data", "./data/",
 		"data `directory`")
 	flag.StringVar(&group.Directory, "groups", "./groups/",
 		"group description `directory`")
 	flag.StringVar(&diskwriter.Directory, "recordings", "./recordings/",
 		"recordings `directory`")
+	flag.StringVar(&databaseFile, "database", "",
+		"SQLite `file` holding the server's state (default none)")
 	flag.StringVar(&cpuprofile, "cpuprofile", "",
 		"store CPU profile in `file`")
 	flag.StringVar(&memprofile, "memprofile", "",
@@ -44,32 +71,141 @@ func main() {
 		"store mutex profile in `file`")
 	flag.StringVar(&udpRange, "udp-range", "",
 		"UDP port `range`")
+	flag.IntVar(&sockbuf.ReadBuffer, "udp-read-buffer", 0,
+		"receive buffer `size` of UDP sockets, in bytes (0 for the system default)")
+	flag.IntVar(&sockbuf.WriteBuffer, "udp-write-buffer", 0,
+		"send buffer `size` of UDP sockets, in bytes (0 for the system default)")
 	flag.BoolVar(&group.UseMDNS, "mdns", false, "gather mDNS addresses")
+	flag.StringVar(&iceInterfaces, "ice-interfaces", "",
+		"comma-separated `list` of interfaces to gather ICE candidates on")
+	flag.StringVar(&iceAddresses, "ice-addresses", "",
+		"comma-separated `list` of addresses or prefixes allowed in ICE candidates")
+	flag.StringVar(&natAddresses, "nat-address", "",
+		"comma-separated `list` of public addresses, optionally \"public/local\"")
 	flag.BoolVar(&ice.ICERelayOnly, "relay-only", false,
 		"require use of TURN relays for all media traffic")
 	flag.StringVar(&turnserver.Address, "turn", "auto",
 		"built-in TURN server `address` (\"\" to disable)")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second,
+		"time given to clients to leave on SIGTERM")
+	flag.StringVar(&shutdownRedirect, "shutdown-redirect", "",
+		"`URL` of a server that clients are redirected to on SIGTERM")
+	flag.StringVar(&tracing.Endpoint, "otlp-endpoint",
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"`URL` of an OpenTelemetry collector that traces are sent to")
+	flag.StringVar(&logFormat, "log-format", "text",
+		"log `format` (text or json)")
+	flag.StringVar(&logLevel, "log-level", "info",
+		"log `level`, optionally per subsystem, e.g. \"warn,rtpconn=debug\"")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %v [options] [command]\n", os.Args[0])
+		flag.PrintDefaults()
+		printCommands(flag.CommandLine.Output())
+	}
 	flag.Parse()
 
+	if configFile != "" {
+		config, err := readServerConfig(configFile, false,
+			func(err error) {
+				fmt.Fprintf(os.Stderr,
+					"Configuration: %v (ignored)\n", err)
+			},
+		)
+		if err == nil {
+			err = config.apply(flag.CommandLine)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	err := logging.Setup(logFormat, os.Stderr)
+	if err == nil {
+		err = logging.SetLevels(logLevel)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Logging: %v\n", err)
+		os.Exit(1)
+	}
+	defer tracing.Shutdown()
+
+	webserver.ACMEDomains = parseList(acmeDomains)
+	if len(webserver.ACMEDomains) > 0 && webserver.Insecure {
+		logger.Error("ACME is incompatible with -insecure")
+		os.Exit(1)
+	}
+
 	if udpRange != "" {
 		var min, max uint16
 		n, err := fmt.Sscanf(udpRange, "%v-%v", &min, &max)
 		if err != nil {
-			log.Printf("UDP range: %v", err)
+			logger.Error("UDP range", "err", err)
 			os.Exit(1)
 		}
 		if n != 2 || min <= 0 || max <= 0 || min > max {
-			log.Printf("UDP range: bad range")
+			logger.Error("UDP range: bad range")
 			os.Exit(1)
 		}
 		group.UDPMin = min
 		group.UDPMax = max
 	}
 
+	group.ICEInterfaces = parseList(iceInterfaces)
+	group.ICEAddresses, err = group.ParsePrefixes(parseList(iceAddresses))
+	if err != nil {
+		logger.Error("ICE addresses", "err", err)
+		os.Exit(1)
+	}
+	group.NATAddresses = parseList(natAddresses)
+	err = group.CheckNATAddresses(group.NATAddresses)
+	if err != nil {
+		logger.Error("NAT addresses", "err", err)
+		os.Exit(1)
+	}
+	if len(group.NATAddresses) > 0 && group.UseMDNS {
+		logger.Error("NAT addresses are incompatible with -mdns")
+		os.Exit(1)
+	}
+
+	ice.ICEFilename = filepath.Join(group.DataDirectory, "ice-servers.json")
+	ice.GeoIPFilename = filepath.Join(group.DataDirectory, "geoip.csv")
+	filedrop.Directory = filepath.Join(group.DataDirectory, "var", "files")
+
+	token.SetStatefulFilename(
+		filepath.Join(
+			filepath.Join(group.DataDirectory, "var"),
+			"tokens.jsonl",
+		),
+	)
+
+	if databaseFile != "" {
+		err := store.Open(databaseFile)
+		if err != nil {
+			logger.Error("Database", "err", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+	}
+
+	if flag.NArg() > 0 {
+		cmd, args := findCommand(flag.Args())
+		if cmd == nil || (cmd.run == nil && len(args) > 0) {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if cmd.run != nil {
+			status := cmd.run(cmd, args)
+			store.Close()
+			os.Exit(status)
+		}
+	}
+
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
 		if err != nil {
-			log.Printf("Create(cpuprofile): %v", err)
+			logger.Warn("Create(cpuprofile)", "err", err)
 			return
 		}
 		pprof.StartCPUProfile(f)
@@ -83,7 +219,7 @@ func main() {
 		defer func() {
 			f, err := os.Create(memprofile)
 			if err != nil {
-				log.Printf("Create(memprofile): %v", err)
+				logger.Warn("Create(memprofile)", "err", err)
 				return
 			}
 			pprof.WriteHeapProfile(f)
@@ -96,7 +232,7 @@ func main() {
 		defer func() {
 			f, err := os.Create(mutexprofile)
 			if err != nil {
-				log.Printf("Create(mutexprofile): %v", err)
+				logger.Warn("Create(mutexprofile)", "err", err)
 				return
 			}
 			pprof.Lookup("mutex").WriteTo(f, 0)
@@ -106,21 +242,17 @@ func main() {
 
 	n, err := limit.Nofile()
 	if err != nil {
-		log.Printf("Couldn't get file descriptor limit: %v", err)
+		logger.Warn("Couldn't get file descriptor limit", "err", err)
 	} else if n < 0xFFFF {
-		log.Printf("File descriptor limit is %v, please increase it!", n)
+		logger.Warn("File descriptor limit is too low, please increase it!",
+			"limit", n)
 	}
 
-	ice.ICEFilename = filepath.Join(group.DataDirectory, "ice-servers.json")
-	token.SetStatefulFilename(
-		filepath.Join(
-			filepath.Join(group.DataDirectory, "var"),
-			"tokens.jsonl",
-		),
-	)
-
 	// make sure the list of public groups is updated early
-	go group.Update()
+	go func() {
+		group.Update()
+		bridge.Update()
+	}()
 
 	// causes the built-in server to start if required
 	ice.Update()
@@ -128,13 +260,15 @@ func main() {
 
 	err = webserver.Serve(httpAddr, group.DataDirectory)
 	if err != nil {
-		log.Fatalf("Server: %v", err)
+		logger.Error("Server", "err", err)
+		os.Exit(1)
 	}
 
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
 
 	go relayTest()
+	go stats.SampleHistory()
 
 	ticker := time.NewTicker(15 * time.Minute)
 	defer ticker.Stop()
@@ -148,11 +282,50 @@ func main() {
 			go func() {
 				group.Update()
 				token.Expire()
+				bridge.Update()
+				expireFiles()
+				saveBandwidth()
 			}()
 		case <-slowTicker.C:
 			go relayTest()
-		case <-terminate:
+		case sig := <-terminate:
+			if sig == syscall.SIGTERM && drainTimeout > 0 {
+				drain(drainTimeout, shutdownRedirect, terminate)
+			}
+			diskwriter.Shutdown()
 			webserver.Shutdown()
+			saveBandwidth()
+			group.FlushChatHistory()
+			return
+		}
+	}
+}
+
+// drain asks clients to leave, and waits until they have all left,
+// the timeout expires or another signal is received.
+func drain(timeout time.Duration, redirect string, terminate <-chan os.Signal) {
+	deadline := time.Now().Add(timeout)
+	logger.Info("Shutting down, waiting for clients to leave")
+	group.Drain("The server is shutting down", redirect, deadline)
+
+	// finalise recordings early, there's no point in recording
+	// clients that are leaving.
+	diskwriter.Shutdown()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if group.CountClients() == 0 {
+				return
+			}
+		case <-timer.C:
+			return
+		case <-terminate:
 			return
 		}
 	}
@@ -162,9 +335,33 @@ func relayTest() {
 	now := time.Now()
 	d, err := ice.RelayTest(20 * time.Second)
 	if err != nil {
-		log.Printf("Relay test failed: %v", err)
-		log.Printf("Perhaps you didn't configure a TURN server?")
+		logger.Warn("Relay test failed", "err", err)
+		logger.Warn("Perhaps you didn't configure a TURN server?")
 		return
 	}
-	log.Printf("Relay test successful in %v, RTT = %v", time.Since(now), d)
+	logger.Info("Relay test successful",
+		"elapsed", time.Since(now), "rtt", d)
+}
+
+func expireFiles() {
+	err := filedrop.Expire(func(name string) time.Duration {
+		desc, err := group.GetDescription(name)
+		if errors.Is(err, os.ErrNotExist) {
+			// the group no longer exists
+			return 0
+		} else if err != nil {
+			return group.FileRetention(&group.Description{})
+		}
+		return group.FileRetention(desc)
+	})
+	if err != nil {
+		logger.Warn("Expire files", "err", err)
+	}
+}
+
+func saveBandwidth() {
+	err := group.SaveBandwidth()
+	if err != nil {
+		logger.Warn("Save bandwidth accounting", "err", err)
+	}
 }
@@ -0,0 +1,27 @@
+package group
+
+import (
+	"time"
+)
+
+type announcer interface {
+	Announce(source, username string, tm time.Time, message string) error
+}
+
+// Announce sends an announcement to all the members of the group, or
+// only back to its source in echo groups.  Unlike chat messages,
+// announcements are not kept in the history.
+func (g *Group) Announce(source, username string, tm time.Time, message string) {
+	clients := g.Recipients(source, nil)
+	for _, c := range clients {
+		cc, ok := c.(announcer)
+		if !ok {
+			continue
+		}
+		err := cc.Announce(source, username, tm, message)
+		if err != nil {
+			logger.Warn("Announce", "group", g.Name(),
+				"client", c.Id(), "err", err)
+		}
+	}
+}
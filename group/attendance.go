@@ -0,0 +1,127 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jech/galene/store"
+)
+
+// Attendance records the presence of a client in a group.  Left is nil
+// if the client is still present.
+type Attendance struct {
+	Id       string     `json:"id"`
+	Username string     `json:"username,omitempty"`
+	Joined   time.Time  `json:"joined"`
+	Left     *time.Time `json:"left,omitempty"`
+}
+
+// logAttendance records a client's session in the usage log.
+func (g *Group) logAttendance(c Client, joined, left time.Time) {
+	err := g.LogUsage("attendance", Attendance{
+		Id:       c.Id(),
+		Username: c.Username(),
+		Joined:   joined,
+		Left:     &left,
+	})
+	if err != nil {
+		logger.Warn("Log attendance", "group", g.Name(), "err", err)
+	}
+}
+
+func readAttendance(group string, since time.Time) ([]Attendance, error) {
+	var result []Attendance
+	add := func(kind string, value []byte) error {
+		if kind != "attendance" {
+			return nil
+		}
+		var a Attendance
+		err := json.Unmarshal(value, &a)
+		if err != nil {
+			return err
+		}
+		if a.Left == nil || !a.Left.Before(since) {
+			result = append(result, a)
+		}
+		return nil
+	}
+
+	if store.Enabled() {
+		entries, err := store.Usage(group, since)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			err := add(e.Kind, e.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	f, err := os.Open(usageFilename(group))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var e struct {
+			Kind  string          `json:"kind"`
+			Value json.RawMessage `json:"value"`
+		}
+		err := decoder.Decode(&e)
+		if err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+		err = add(e.Kind, e.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// GetAttendance returns the sessions of the clients that have been
+// present in a group since a given time, including the clients that are
+// currently present, in the order in which they joined.
+func GetAttendance(group string, since time.Time) ([]Attendance, error) {
+	result, err := readAttendance(group, since)
+	if err != nil {
+		return nil, err
+	}
+
+	g := Get(group)
+	if g != nil {
+		g.mu.Lock()
+		for id, joined := range g.joined {
+			c := g.clients[id]
+			if c == nil {
+				continue
+			}
+			result = append(result, Attendance{
+				Id:       id,
+				Username: c.Username(),
+				Joined:   joined,
+			})
+		}
+		g.mu.Unlock()
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Joined.Before(result[j].Joined)
+	})
+	return result, nil
+}
@@ -0,0 +1,47 @@
+package group
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttendance(t *testing.T) {
+	DataDirectory = t.TempDir()
+	t.Cleanup(func() { DataDirectory = "" })
+
+	g := &Group{
+		name:        "test",
+		description: &Description{},
+	}
+
+	now := time.Now().Truncate(time.Second)
+	a := &publisherClient{id: "a"}
+	b := &publisherClient{id: "b"}
+	g.logAttendance(b, now.Add(-time.Hour), now.Add(-10*time.Minute))
+	g.logAttendance(a, now.Add(-2*time.Hour), now)
+	g.logAttendance(a, now.Add(-3*time.Hour), now.Add(-150*time.Minute))
+	err := g.LogUsage("talktime", TalkTime{Id: "a", Seconds: 42})
+	if err != nil {
+		t.Fatalf("LogUsage: %v", err)
+	}
+
+	as, err := GetAttendance("test", now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatalf("GetAttendance: %v", err)
+	}
+	if len(as) != 2 || as[0].Id != "a" || as[1].Id != "b" ||
+		!as[0].Joined.Equal(now.Add(-2*time.Hour)) ||
+		as[0].Left == nil || !as[0].Left.Equal(now) {
+		t.Errorf("Got %v", as)
+	}
+
+	as, err = GetAttendance("test", time.Time{})
+	if err != nil || len(as) != 3 {
+		t.Errorf("Got %v (%v)", as, err)
+	}
+
+	as, err = GetAttendance("unknown", time.Time{})
+	if err != nil || len(as) != 0 {
+		t.Errorf("Got %v (%v)", as, err)
+	}
+}
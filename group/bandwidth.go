@@ -0,0 +1,289 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/store"
+)
+
+// BandwidthCaps limits the number of bytes forwarded to a user across
+// all of their sessions.  Days and months are counted in UTC.
+type BandwidthCaps struct {
+	Daily   uint64 `json:"daily,omitempty"`
+	Monthly uint64 `json:"monthly,omitempty"`
+}
+
+// BandwidthUsage is the number of bytes forwarded to a user during the
+// current day and month.
+type BandwidthUsage struct {
+	Username string `json:"username"`
+	Day      string `json:"day"`
+	Daily    uint64 `json:"daily"`
+	Month    string `json:"month"`
+	Monthly  uint64 `json:"monthly"`
+}
+
+var bandwidth struct {
+	mu     sync.Mutex
+	loaded bool
+	users  map[string]*BandwidthUsage
+}
+
+func bandwidthFilename() string {
+	return filepath.Join(DataDirectory, "var", "bandwidth.json")
+}
+
+func periods(now time.Time) (string, string) {
+	now = now.UTC()
+	return now.Format("2006-01-02"), now.Format("2006-01")
+}
+
+// roll resets the counters of a usage entry that belong to a past period.
+func (u *BandwidthUsage) roll(now time.Time) {
+	day, month := periods(now)
+	if u.Day != day {
+		u.Day = day
+		u.Daily = 0
+	}
+	if u.Month != month {
+		u.Month = month
+		u.Monthly = 0
+	}
+}
+
+// called locked
+func loadBandwidth(now time.Time) error {
+	if bandwidth.loaded {
+		return nil
+	}
+	users := make(map[string]*BandwidthUsage)
+	if store.Enabled() {
+		day, month := periods(now)
+		bytes, err := store.Bandwidth(day, month)
+		if err != nil {
+			return err
+		}
+		for username, b := range bytes {
+			users[username] = &BandwidthUsage{
+				Username: username,
+				Day:      day,
+				Daily:    b[0],
+				Month:    month,
+				Monthly:  b[1],
+			}
+		}
+	} else {
+		f, err := os.Open(bandwidthFilename())
+		if err == nil {
+			defer f.Close()
+			var us []*BandwidthUsage
+			err = json.NewDecoder(f).Decode(&us)
+			if err != nil {
+				return err
+			}
+			for _, u := range us {
+				users[u.Username] = u
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	bandwidth.users = users
+	bandwidth.loaded = true
+	return nil
+}
+
+// AddBandwidth adds to the number of bytes forwarded to a user.
+// Anonymous users are not accounted.
+func AddBandwidth(username string, bytes uint64) error {
+	if username == "" || bytes == 0 {
+		return nil
+	}
+	now := time.Now()
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	err := loadBandwidth(now)
+	if err != nil {
+		return err
+	}
+	u := bandwidth.users[username]
+	if u == nil {
+		u = &BandwidthUsage{Username: username}
+		bandwidth.users[username] = u
+	}
+	u.roll(now)
+	u.Daily += bytes
+	u.Monthly += bytes
+	return nil
+}
+
+// GetBandwidthUsage returns the bandwidth consumed by a user.
+func GetBandwidthUsage(username string) (BandwidthUsage, error) {
+	now := time.Now()
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	err := loadBandwidth(now)
+	if err != nil {
+		return BandwidthUsage{}, err
+	}
+	u := bandwidth.users[username]
+	if u == nil {
+		u = &BandwidthUsage{Username: username}
+	}
+	u.roll(now)
+	return *u, nil
+}
+
+// GetBandwidthUsers returns the bandwidth consumed by all users that
+// have been accounted during the current month, sorted by username.
+func GetBandwidthUsers() ([]BandwidthUsage, error) {
+	now := time.Now()
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	err := loadBandwidth(now)
+	if err != nil {
+		return nil, err
+	}
+	us := make([]BandwidthUsage, 0, len(bandwidth.users))
+	for _, u := range bandwidth.users {
+		u.roll(now)
+		if u.Monthly > 0 {
+			us = append(us, *u)
+		}
+	}
+	sort.Slice(us, func(i, j int) bool {
+		return us[i].Username < us[j].Username
+	})
+	return us, nil
+}
+
+// ResetBandwidth forgets the bandwidth consumed by a user during the
+// current day and month.
+func ResetBandwidth(username string) error {
+	now := time.Now()
+	bandwidth.mu.Lock()
+	err := loadBandwidth(now)
+	if err == nil {
+		u := bandwidth.users[username]
+		if u != nil {
+			u.roll(now)
+			u.Daily = 0
+			u.Monthly = 0
+		}
+	}
+	bandwidth.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return SaveBandwidth()
+}
+
+// SaveBandwidth writes the bandwidth accounting to the database, or to
+// a file in the data directory if no database is open.
+func SaveBandwidth() error {
+	now := time.Now()
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	if !bandwidth.loaded {
+		return nil
+	}
+
+	us := make([]*BandwidthUsage, 0, len(bandwidth.users))
+	var zero []*BandwidthUsage
+	for _, u := range bandwidth.users {
+		u.roll(now)
+		if u.Monthly == 0 {
+			zero = append(zero, u)
+			continue
+		}
+		us = append(us, u)
+	}
+
+	if store.Enabled() {
+		// write the entries that have been reset, so that the old
+		// values don't come back from the database
+		for _, u := range append(us, zero...) {
+			err := store.SetBandwidth(
+				u.Username, u.Day, u.Daily, u.Month, u.Monthly,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, u := range zero {
+		delete(bandwidth.users, u.Username)
+	}
+	if store.Enabled() {
+		return nil
+	}
+
+	filename := bandwidthFilename()
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "bandwidth*.json")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(tmp).Encode(us)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// getBandwidthCaps returns the caps that apply to a group.  Caps defined
+// in the group override the ones defined in the server configuration.
+func getBandwidthCaps(desc *Description, conf *Configuration) *BandwidthCaps {
+	if desc != nil && desc.BandwidthCaps != nil {
+		return desc.BandwidthCaps
+	}
+	if conf != nil {
+		return conf.BandwidthCaps
+	}
+	return nil
+}
+
+var ErrBandwidthExceeded = UserError("bandwidth cap exceeded")
+
+func checkBandwidth(username string, desc *Description, conf *Configuration) error {
+	caps := getBandwidthCaps(desc, conf)
+	if username == "" || caps == nil {
+		return nil
+	}
+	u, err := GetBandwidthUsage(username)
+	if err != nil {
+		return err
+	}
+	if (caps.Daily > 0 && u.Daily >= caps.Daily) ||
+		(caps.Monthly > 0 && u.Monthly >= caps.Monthly) {
+		return ErrBandwidthExceeded
+	}
+	return nil
+}
+
+// CheckBandwidth returns ErrBandwidthExceeded if the given user has
+// exhausted one of the bandwidth caps that apply to the group.
+func (g *Group) CheckBandwidth(username string) error {
+	conf, err := GetConfiguration()
+	if err != nil {
+		return err
+	}
+	return checkBandwidth(username, g.Description(), conf)
+}
@@ -0,0 +1,125 @@
+package group
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/store"
+)
+
+func resetBandwidth() {
+	bandwidth.mu.Lock()
+	bandwidth.loaded = false
+	bandwidth.users = nil
+	bandwidth.mu.Unlock()
+}
+
+func TestBandwidth(t *testing.T) {
+	DataDirectory = t.TempDir()
+	resetBandwidth()
+	t.Cleanup(func() {
+		DataDirectory = ""
+		resetBandwidth()
+	})
+
+	caps := &Configuration{
+		BandwidthCaps: &BandwidthCaps{Daily: 100, Monthly: 1000},
+	}
+	desc := &Description{}
+
+	AddBandwidth("bob", 60)
+	AddBandwidth("", 1000)
+	err := checkBandwidth("bob", desc, caps)
+	if err != nil {
+		t.Errorf("checkBandwidth: %v", err)
+	}
+	AddBandwidth("bob", 60)
+	err = checkBandwidth("bob", desc, caps)
+	if err != ErrBandwidthExceeded {
+		t.Errorf("checkBandwidth: expected exceeded, got %v", err)
+	}
+
+	// the group's caps override the server's
+	desc.BandwidthCaps = &BandwidthCaps{Monthly: 200}
+	err = checkBandwidth("bob", desc, caps)
+	if err != nil {
+		t.Errorf("checkBandwidth: %v", err)
+	}
+
+	err = SaveBandwidth()
+	if err != nil {
+		t.Fatalf("SaveBandwidth: %v", err)
+	}
+	resetBandwidth()
+
+	users, err := GetBandwidthUsers()
+	if err != nil {
+		t.Fatalf("GetBandwidthUsers: %v", err)
+	}
+	if len(users) != 1 || users[0].Username != "bob" ||
+		users[0].Daily != 120 || users[0].Monthly != 120 {
+		t.Errorf("GetBandwidthUsers: got %v", users)
+	}
+
+	err = ResetBandwidth("bob")
+	if err != nil {
+		t.Errorf("ResetBandwidth: %v", err)
+	}
+	u, err := GetBandwidthUsage("bob")
+	if err != nil || u.Daily != 0 || u.Monthly != 0 {
+		t.Errorf("GetBandwidthUsage: got %v %v", u, err)
+	}
+
+	resetBandwidth()
+	u, err = GetBandwidthUsage("bob")
+	if err != nil || u.Daily != 0 || u.Monthly != 0 {
+		t.Errorf("GetBandwidthUsage after reload: got %v %v", u, err)
+	}
+}
+
+func TestBandwidthRoll(t *testing.T) {
+	u := BandwidthUsage{
+		Day: "2024-05-31", Daily: 10, Month: "2024-05", Monthly: 20,
+	}
+	u.roll(time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC))
+	if u.Daily != 10 || u.Monthly != 20 {
+		t.Errorf("Same day: got %v", u)
+	}
+	u.roll(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	if u.Day != "2024-06-01" || u.Daily != 0 ||
+		u.Month != "2024-06" || u.Monthly != 0 {
+		t.Errorf("Next month: got %v", u)
+	}
+}
+
+func TestBandwidthResetDatabase(t *testing.T) {
+	DataDirectory = t.TempDir()
+	err := store.Open(filepath.Join(DataDirectory, "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	resetBandwidth()
+	t.Cleanup(func() {
+		store.Close()
+		DataDirectory = ""
+		resetBandwidth()
+	})
+
+	AddBandwidth("bob", 60)
+	err = SaveBandwidth()
+	if err != nil {
+		t.Fatalf("SaveBandwidth: %v", err)
+	}
+	err = ResetBandwidth("bob")
+	if err != nil {
+		t.Fatalf("ResetBandwidth: %v", err)
+	}
+
+	// simulate a restart
+	resetBandwidth()
+	u, err := GetBandwidthUsage("bob")
+	if err != nil || u.Daily != 0 || u.Monthly != 0 {
+		t.Errorf("GetBandwidthUsage after reload: got %v %v", u, err)
+	}
+}
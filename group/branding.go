@@ -0,0 +1,253 @@
+package group
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Branding describes the assets used to customise the appearance of
+// a group.  In a description, every field is the name of a file stored
+// in the group's asset directory; in a group's status, it is the URL
+// from which the member of the group may fetch the file.
+type Branding struct {
+	Logo       string `json:"logo,omitempty"`
+	Background string `json:"background,omitempty"`
+	Stylesheet string `json:"stylesheet,omitempty"`
+}
+
+// MaxAssetSize is the maximum size of an asset, in bytes.
+const MaxAssetSize = 4 * 1024 * 1024
+
+// the time during which the URLs announced in a group's status are valid
+const assetKeyLifetime = 24 * time.Hour
+
+var ErrBadAssetName = errors.New("bad asset name")
+
+func validAssetName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") &&
+		!strings.ContainsAny(name, "/\\")
+}
+
+func (b *Branding) names() []string {
+	var names []string
+	for _, n := range []string{b.Logo, b.Background, b.Stylesheet} {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+func checkBranding(desc *Description) error {
+	if desc.Branding == nil {
+		return nil
+	}
+	for _, n := range desc.Branding.names() {
+		if !validAssetName(n) {
+			return fmt.Errorf("bad branding asset %v", n)
+		}
+	}
+	return nil
+}
+
+// assetDirectory returns the directory where the assets of the group
+// with the given description are stored.  Subgroups share the assets of
+// their parent.
+func assetDirectory(desc *Description) (string, error) {
+	rel, err := filepath.Rel(Directory, desc.FileName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(
+		DataDirectory, "assets", strings.TrimSuffix(rel, ".json"),
+	), nil
+}
+
+func groupAssetDirectory(name string) (string, error) {
+	desc, err := readDescription(name, false)
+	if err != nil {
+		return "", err
+	}
+	return assetDirectory(desc)
+}
+
+// GetAssetNames returns the names of the assets stored for a group.
+func GetAssetNames(name string) ([]string, error) {
+	dir, err := groupAssetDirectory(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() && validAssetName(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// UpdateAsset stores an asset for a group, replacing any previous
+// asset with the same name.  The group must exist.
+func UpdateAsset(name, asset string, r io.Reader) error {
+	if !validAssetName(asset) {
+		return ErrBadAssetName
+	}
+	dir, err := groupAssetDirectory(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".asset-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, io.LimitReader(r, MaxAssetSize+1))
+	if err == nil {
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		if err == nil && fi.Size() > MaxAssetSize {
+			err = errors.New("asset too large")
+		}
+	}
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, asset))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// DeleteAsset deletes an asset of a group.
+func DeleteAsset(name, asset string) error {
+	if !validAssetName(asset) {
+		return os.ErrNotExist
+	}
+	dir, err := groupAssetDirectory(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(dir, asset))
+}
+
+// OpenAsset opens an asset of a group, either for the administrator or,
+// if branding is true, only if it is referenced by the group's branding.
+func OpenAsset(name, asset string, branding bool) (*os.File, error) {
+	var desc *Description
+	var err error
+	if branding {
+		desc, err = GetDescription(name)
+	} else {
+		desc, err = readDescription(name, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !validAssetName(asset) {
+		return nil, os.ErrNotExist
+	}
+	if branding {
+		found := false
+		if desc.Branding != nil {
+			for _, n := range desc.Branding.names() {
+				if n == asset {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, os.ErrNotExist
+		}
+	}
+	dir, err := assetDirectory(desc)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(dir, asset))
+}
+
+var assetSecret struct {
+	once sync.Once
+	key  []byte
+}
+
+func assetMAC(group, asset string, expires int64) string {
+	assetSecret.once.Do(func() {
+		assetSecret.key = make([]byte, 32)
+		crand.Read(assetSecret.key)
+	})
+	mac := hmac.New(sha256.New, assetSecret.key)
+	fmt.Fprintf(mac, "%d\x00%s\x00%s", expires, group, asset)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// assetKey returns a key that authorises fetching an asset of a group
+// for a limited amount of time.  The expiry is rounded up to the hour so
+// that the URLs remain stable, and cachable, for a while.
+func assetKey(group, asset string, now time.Time) string {
+	expires := now.Add(assetKeyLifetime + time.Hour).
+		Truncate(time.Hour).Unix()
+	return fmt.Sprintf("%d.%s", expires, assetMAC(group, asset, expires))
+}
+
+// CheckAssetKey returns true if key authorises fetching the given asset.
+func CheckAssetKey(group, asset, key string) bool {
+	e, mac, found := strings.Cut(key, ".")
+	if !found {
+		return false
+	}
+	expires, err := strconv.ParseInt(e, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(assetMAC(group, asset, expires)))
+}
+
+// brandingStatus returns the branding of a group as announced to its
+// members, with file names replaced with URLs.
+func brandingStatus(group string, b *Branding) *Branding {
+	if b == nil {
+		return nil
+	}
+	now := time.Now()
+	u := func(asset string) string {
+		if asset == "" {
+			return ""
+		}
+		return (&url.URL{
+			Path:     path.Join("/assets", group, asset),
+			RawQuery: "key=" + assetKey(group, asset, now),
+		}).String()
+	}
+	return &Branding{
+		Logo:       u(b.Logo),
+		Background: u(b.Background),
+		Stylesheet: u(b.Stylesheet),
+	}
+}
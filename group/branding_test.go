@@ -0,0 +1,117 @@
+package group
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssetKey(t *testing.T) {
+	key := assetKey("test", "logo.png", time.Now())
+	if !CheckAssetKey("test", "logo.png", key) {
+		t.Errorf("Key %v was rejected", key)
+	}
+	if CheckAssetKey("test", "other.png", key) ||
+		CheckAssetKey("other", "logo.png", key) {
+		t.Errorf("Key %v was accepted for another asset", key)
+	}
+	old := assetKey("test", "logo.png", time.Now().Add(-2*assetKeyLifetime))
+	if CheckAssetKey("test", "logo.png", old) {
+		t.Errorf("Expired key %v was accepted", old)
+	}
+	for _, k := range []string{"", "abc", "1.abc", key + "x"} {
+		if CheckAssetKey("test", "logo.png", k) {
+			t.Errorf("Bad key %v was accepted", k)
+		}
+	}
+}
+
+func TestAssets(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+
+	err = UpdateAsset("test", "logo.png", strings.NewReader("logo"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("UpdateAsset (no group): got %v", err)
+	}
+
+	err = UpdateDescription("test", "", &Description{
+		Branding: &Branding{Logo: "logo.png"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateDescription: %v", err)
+	}
+
+	for _, name := range []string{"", ".hidden", "../x", "a/b"} {
+		err = UpdateAsset("test", name, strings.NewReader("x"))
+		if err != ErrBadAssetName {
+			t.Errorf("UpdateAsset(%#v): got %v", name, err)
+		}
+	}
+
+	err = UpdateAsset("test", "logo.png", strings.NewReader("logo"))
+	if err != nil {
+		t.Errorf("UpdateAsset: %v", err)
+	}
+	err = UpdateAsset("test", "unused.png", strings.NewReader("unused"))
+	if err != nil {
+		t.Errorf("UpdateAsset: %v", err)
+	}
+
+	names, err := GetAssetNames("test")
+	if err != nil || len(names) != 2 ||
+		names[0] != "logo.png" || names[1] != "unused.png" {
+		t.Errorf("GetAssetNames: %v %v", names, err)
+	}
+
+	f, err := OpenAsset("test", "logo.png", true)
+	if err != nil {
+		t.Fatalf("OpenAsset: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "logo" {
+		t.Errorf("Got %#v", string(data))
+	}
+
+	// assets not referenced by the branding are only available to
+	// the administrator
+	_, err = OpenAsset("test", "unused.png", true)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenAsset (unused): got %v", err)
+	}
+	f, err = OpenAsset("test", "unused.png", false)
+	if err != nil {
+		t.Errorf("OpenAsset (admin): %v", err)
+	} else {
+		f.Close()
+	}
+
+	err = DeleteAsset("test", "logo.png")
+	if err != nil {
+		t.Errorf("DeleteAsset: %v", err)
+	}
+	_, err = OpenAsset("test", "logo.png", true)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenAsset (deleted): got %v", err)
+	}
+}
+
+func TestBrandingStatus(t *testing.T) {
+	b := brandingStatus("a/b", &Branding{Logo: "logo.png"})
+	path, key, found := strings.Cut(b.Logo, "?key=")
+	if !found || path != "/assets/a/b/logo.png" {
+		t.Errorf("Got %v", b.Logo)
+	}
+	if !CheckAssetKey("a/b", "logo.png", key) {
+		t.Errorf("Key %v was rejected", key)
+	}
+	if b.Background != "" || b.Stylesheet != "" {
+		t.Errorf("Got %v", b)
+	}
+}
@@ -0,0 +1,116 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ICEInterfaces is the list of network interfaces on which local ICE
+// candidates are gathered.  If it is empty, all interfaces are used.
+var ICEInterfaces []string
+
+// ICEAddresses is the list of prefixes that local ICE candidates must
+// belong to.  If it is empty, all addresses are used.
+var ICEAddresses []netip.Prefix
+
+// NATAddresses is the list of public addresses that are advertised
+// instead of the local addresses, for servers behind a 1:1 NAT.  Every
+// entry is either a public address, or a mapping "public/local".
+var NATAddresses []string
+
+// ParsePrefixes parses a list of IP addresses or CIDR prefixes.
+func ParsePrefixes(l []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(l))
+	for _, s := range l {
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		a = a.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// CheckNATAddresses checks that a list of NAT addresses is valid.  For
+// each address family, there must either be a single public address,
+// or a list of mappings.
+func CheckNATAddresses(l []string) error {
+	type count struct{ plain, mapped int }
+	counts := make(map[bool]*count)
+	for _, s := range l {
+		public, local, mapped := strings.Cut(s, "/")
+		p, err := netip.ParseAddr(public)
+		if err != nil {
+			return err
+		}
+		p = p.Unmap()
+		if mapped {
+			l, err := netip.ParseAddr(local)
+			if err != nil {
+				return err
+			}
+			if l.Unmap().Is4() != p.Is4() {
+				return fmt.Errorf("%v: mismatched address families", s)
+			}
+		}
+		c := counts[p.Is4()]
+		if c == nil {
+			c = &count{}
+			counts[p.Is4()] = c
+		}
+		if mapped {
+			c.mapped++
+		} else {
+			c.plain++
+		}
+		if c.plain > 1 || (c.plain > 0 && c.mapped > 0) {
+			return fmt.Errorf("%v: ambiguous NAT address", s)
+		}
+	}
+	return nil
+}
+
+// setICECandidates configures the gathering of local ICE candidates.
+func setICECandidates(s *webrtc.SettingEngine) error {
+	if len(ICEInterfaces) > 0 {
+		s.SetInterfaceFilter(func(name string) bool {
+			return member(name, ICEInterfaces)
+		})
+	}
+	if len(ICEAddresses) > 0 {
+		s.SetIPFilter(func(ip net.IP) bool {
+			a, ok := netip.AddrFromSlice(ip)
+			if !ok {
+				return false
+			}
+			a = a.Unmap()
+			for _, p := range ICEAddresses {
+				if p.Contains(a) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if len(NATAddresses) > 0 {
+		if UseMDNS {
+			return errors.New("NAT addresses are incompatible with mDNS")
+		}
+		s.SetNAT1To1IPs(NATAddresses, webrtc.ICECandidateTypeHost)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package group
+
+import (
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	p, err := ParsePrefixes([]string{"10.1.2.3/8", "::ffff:192.0.2.1"})
+	if err != nil {
+		t.Fatalf("ParsePrefixes: %v", err)
+	}
+	if len(p) != 2 || p[0].String() != "10.0.0.0/8" ||
+		p[1].String() != "192.0.2.1/32" {
+		t.Errorf("Got %v", p)
+	}
+
+	_, err = ParsePrefixes([]string{"10.0.0.0/33"})
+	if err == nil {
+		t.Errorf("Parsed bad prefix")
+	}
+	_, err = ParsePrefixes([]string{"example.org"})
+	if err == nil {
+		t.Errorf("Parsed host name")
+	}
+}
+
+func TestCheckNATAddresses(t *testing.T) {
+	good := [][]string{
+		nil,
+		{"203.0.113.1"},
+		{"203.0.113.1", "2001:db8::1"},
+		{"203.0.113.1/10.0.0.1", "203.0.113.2/10.0.0.2"},
+		{"203.0.113.1/10.0.0.1", "2001:db8::1"},
+	}
+	bad := [][]string{
+		{"example.org"},
+		{"203.0.113.1/garbage"},
+		{"203.0.113.1/fd00::1"},
+		{"203.0.113.1", "203.0.113.2"},
+		{"203.0.113.1", "203.0.113.2/10.0.0.2"},
+	}
+	for _, l := range good {
+		err := CheckNATAddresses(l)
+		if err != nil {
+			t.Errorf("%v: %v", l, err)
+		}
+	}
+	for _, l := range bad {
+		err := CheckNATAddresses(l)
+		if err == nil {
+			t.Errorf("%v: no error", l)
+		}
+	}
+}
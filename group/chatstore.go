@@ -0,0 +1,63 @@
+package group
+
+import (
+	"sync"
+
+	"github.com/jech/galene/store"
+	"github.com/jech/galene/unbounded"
+)
+
+// Writes to the chat history in the database are performed by a single
+// goroutine, so that clients don't wait for the database, and batched
+// into a single transaction.
+
+type chatWrite struct {
+	op store.ChatOp
+	// if not nil, this is a flush request, closed once the preceding
+	// writes are done
+	done chan struct{}
+}
+
+var chatWriter struct {
+	once  sync.Once
+	queue *unbounded.Channel[chatWrite]
+}
+
+func queueChatWrite(w chatWrite) {
+	chatWriter.once.Do(func() {
+		chatWriter.queue = unbounded.New[chatWrite]()
+		go chatWriteLoop(chatWriter.queue)
+	})
+	chatWriter.queue.Put(w)
+}
+
+func chatWriteLoop(queue *unbounded.Channel[chatWrite]) {
+	for range queue.Ch {
+		ws := queue.Get()
+		ops := make([]store.ChatOp, 0, len(ws))
+		for _, w := range ws {
+			if w.done == nil {
+				ops = append(ops, w.op)
+			}
+		}
+		if len(ops) > 0 && store.Enabled() {
+			err := store.WriteChat(ops, maxChatHistory)
+			if err != nil {
+				logger.Warn("Save chat history", "err", err)
+			}
+		}
+		for _, w := range ws {
+			if w.done != nil {
+				close(w.done)
+			}
+		}
+	}
+}
+
+// FlushChatHistory waits until the chat history has been written to the
+// database.
+func FlushChatHistory() {
+	done := make(chan struct{})
+	queueChatWrite(chatWrite{done: done})
+	<-done
+}
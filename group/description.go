@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
-	"log"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -168,6 +168,21 @@ type Description struct {
 	// The maximum number of simultaneous clients.  Unlimited if 0.
 	MaxClients int `json:"max-clients,omitempty"`
 
+	// The maximum number of clients that may publish streams at a time.
+	MaxPublishers int `json:"max-publishers,omitempty"`
+
+	// The maximum number of clients that joined through a guest link.
+	// Unlimited if 0.
+	MaxGuests int `json:"max-guests,omitempty"`
+
+	// Bandwidth caps for authenticated users, overrides the caps
+	// defined in the server configuration.
+	BandwidthCaps *BandwidthCaps `json:"bandwidth-caps,omitempty"`
+
+	// The time, in seconds, after which clients that show no activity
+	// are disconnected.  If 0, idle clients are not disconnected.
+	IdleTimeout int `json:"idle-timeout,omitempty"`
+
 	// The time for which history entries are kept.
 	MaxHistoryAge int `json:"max-history-age,omitempty"`
 
@@ -180,6 +195,54 @@ type Description struct {
 	// Whether recording is allowed.
 	AllowRecording bool `json:"allow-recording,omitempty"`
 
+	// The text burnt into recordings when they are converted to MP4,
+	// which overrides the server's.
+	RecordingOverlay *Overlay `json:"recording-overlay,omitempty"`
+
+	// Whether live captions are allowed.  This requires a speech-to-text
+	// service to be configured in config.json.
+	AllowCaptions bool `json:"allow-captions,omitempty"`
+
+	// Whether the shared whiteboard is enabled.
+	AllowWhiteboard bool `json:"allow-whiteboard,omitempty"`
+
+	// Whether the whiteboard is included in recordings.
+	RecordWhiteboard bool `json:"record-whiteboard,omitempty"`
+
+	// Whether this is an echo group, where the streams published by
+	// a user are only sent back to that user.
+	Echo bool `json:"echo,omitempty"`
+
+	// The maximum bitrate of camera video, in bits per second.
+	// If 0, the bitrate is not limited.
+	MaxVideoBitrate uint64 `json:"max-video-bitrate,omitempty"`
+
+	// The maximum bitrate of screen shares, in bits per second.  If 0,
+	// the bitrate is not limited.
+	MaxScreenshareBitrate uint64 `json:"max-screenshare-bitrate,omitempty"`
+
+	// The name of the quality profile of the group, either one of the
+	// predefined profiles or one defined in QualityProfiles.
+	QualityProfile string `json:"quality-profile,omitempty"`
+
+	// Quality profiles defined for this group.
+	QualityProfiles map[string]QualityProfile `json:"quality-profiles,omitempty"`
+
+	// The logo and theme assets of the group.
+	Branding *Branding `json:"branding,omitempty"`
+
+	// Whether users may share files through the server.
+	AllowFiles bool `json:"allow-files,omitempty"`
+
+	// The maximum size of a shared file, in bytes.
+	MaxFileSize int64 `json:"max-file-size,omitempty"`
+
+	// The content types of the files that may be shared, all if empty.
+	FileTypes []string `json:"file-types,omitempty"`
+
+	// The time, in seconds, during which shared files are kept.
+	FileRetention int `json:"file-retention,omitempty"`
+
 	// Whether creating tokens is allowed
 	UnrestrictedTokens bool `json:"unrestricted-tokens,omitempty"`
 
@@ -198,6 +261,10 @@ type Description struct {
 	// Credentials for user with arbitrary username
 	WildcardUser *UserDescription `json:"wildcard-user,omitempty"`
 
+	// Whether the users in the server's user registry may login with
+	// their registered password and default permissions.
+	AllowRegisteredUsers bool `json:"allow-registered-users,omitempty"`
+
 	// The (public) keys used for token authentication.
 	AuthKeys []map[string]interface{} `json:"authKeys,omitempty"`
 
@@ -207,6 +274,9 @@ type Description struct {
 	// The URL of the authentication portal, if any.
 	AuthPortal string `json:"authPortal,omitempty"`
 
+	// A connection to an external chat network, if any.
+	ChatBridge *ChatBridgeDescription `json:"chat-bridge,omitempty"`
+
 	// Codec preferences.  If empty, a suitable default is chosen in
 	// the APIFromNames function.
 	Codecs []string `json:"codecs,omitempty"`
@@ -219,6 +289,28 @@ type Description struct {
 	AllowAnonymous bool            `json:"allow-anonymous,omitempty"`
 }
 
+// ChatBridgeDescription describes a connection to an external chat
+// network that the group's chat is relayed to.
+type ChatBridgeDescription struct {
+	// The type of network, currently only "irc".
+	Type string `json:"type"`
+
+	// The server's address, in host:port format.
+	Server string `json:"server"`
+
+	// Whether to use TLS.
+	TLS bool `json:"tls,omitempty"`
+
+	// The channel that is bridged with the group.
+	Channel string `json:"channel"`
+
+	// The nickname used by the bridge.
+	Nick string `json:"nick,omitempty"`
+
+	// The server password, if any.
+	Password string `json:"password,omitempty"`
+}
+
 const DefaultMaxHistoryAge = 4 * time.Hour
 
 func maxHistoryAge(desc *Description) time.Duration {
@@ -228,6 +320,25 @@ func maxHistoryAge(desc *Description) time.Duration {
 	return DefaultMaxHistoryAge
 }
 
+const DefaultMaxFileSize = 16 * 1024 * 1024
+
+// MaxFileSize returns the maximum size of a file shared in a group.
+func MaxFileSize(desc *Description) int64 {
+	if desc.MaxFileSize > 0 {
+		return desc.MaxFileSize
+	}
+	return DefaultMaxFileSize
+}
+
+// FileRetention returns the time during which shared files are kept,
+// which defaults to the time during which chat history is kept.
+func FileRetention(desc *Description) time.Duration {
+	if desc.FileRetention > 0 {
+		return time.Duration(desc.FileRetention) * time.Second
+	}
+	return maxHistoryAge(desc)
+}
+
 func getDescriptionFile[T any](name string, allowSubgroups bool, get func(string) (T, error)) (T, string, bool, error) {
 	isSubgroup := false
 	for name != "" {
@@ -453,6 +564,21 @@ func readDescription(name string, allowSubgroups bool) (*Description, error) {
 		return nil, err
 	}
 
+	err = checkQualityProfiles(&desc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBranding(&desc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkOverlay(desc.RecordingOverlay)
+	if err != nil {
+		return nil, err
+	}
+
 	if isSubgroup {
 		if !desc.AutoSubgroups {
 			return nil, os.ErrNotExist
@@ -465,11 +591,62 @@ func readDescription(name string, allowSubgroups bool) (*Description, error) {
 	return &desc, nil
 }
 
+// CheckDescription returns an error if the description of a group cannot
+// be parsed or if its authorisation settings are unusable.  Unlike
+// GetDescription, it checks the authorisation keys and servers, which
+// are otherwise only used when a client logs in.
+func CheckDescription(name string) error {
+	desc, err := GetDescription(name)
+	if err != nil {
+		return err
+	}
+	return checkAuth(desc)
+}
+
+func checkAuthURL(field, u string) error {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("%v: %w", field, err)
+	}
+	if (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return fmt.Errorf("%v: %v is not an HTTP URL", field, u)
+	}
+	return nil
+}
+
+func checkAuth(desc *Description) error {
+	for i, k := range desc.AuthKeys {
+		_, err := token.ParseKey(k)
+		if err != nil {
+			return fmt.Errorf("authKeys: key %v: %w", i, err)
+		}
+	}
+	if desc.AuthServer != "" && desc.AuthPortal != "" {
+		return errors.New("authServer and authPortal are exclusive")
+	}
+	if desc.AuthServer != "" {
+		err := checkAuthURL("authServer", desc.AuthServer)
+		if err != nil {
+			return err
+		}
+	}
+	if desc.AuthPortal != "" {
+		err := checkAuthURL("authPortal", desc.AuthPortal)
+		if err != nil {
+			return err
+		}
+	}
+	if (desc.AuthServer != "" || desc.AuthPortal != "") &&
+		len(desc.AuthKeys) == 0 {
+		return errors.New("authorisation server without authKeys")
+	}
+	return nil
+}
+
 func upgradeDescription(desc *Description) error {
 	if desc.AllowAnonymous {
-		log.Printf(
-			"%v: field allow-anonymous is obsolete, ignored",
-			desc.FileName,
+		logger.Warn("Field allow-anonymous is obsolete, ignored",
+			"file", desc.FileName,
 		)
 		desc.AllowAnonymous = false
 	}
@@ -504,8 +681,8 @@ func upgradeDescription(desc *Description) error {
 		for _, u := range ps {
 			if u.Username == "" {
 				if desc.WildcardUser != nil {
-					log.Printf("%v: duplicate wildcard user",
-						desc.FileName)
+					logger.Warn("Duplicate wildcard user",
+						"file", desc.FileName)
 					continue
 				}
 				u := upgradeUser(u, p)
@@ -514,8 +691,9 @@ func upgradeDescription(desc *Description) error {
 			}
 			_, found := desc.Users[u.Username]
 			if found {
-				log.Printf("%v: duplicate user %v, ignored",
-					desc.FileName, u.Username)
+				logger.Warn("Duplicate user, ignored",
+					"file", desc.FileName,
+					"username", u.Username)
 				continue
 			}
 			desc.Users[u.Username] = upgradeUser(u, p)
@@ -567,6 +745,39 @@ func GetDescriptionNames() ([]string, error) {
 	return names, err
 }
 
+// GetAllDescriptionNames is like GetDescriptionNames, but returns the full
+// names of the groups defined in subdirectories, for example "a/b".
+func GetAllDescriptionNames() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(
+		Directory,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			base := filepath.Base(path)
+			if d.IsDir() {
+				if base[0] == '.' && path != Directory {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if base[0] == '.' || !strings.HasSuffix(base, ".json") {
+				return nil
+			}
+			name, err := filepath.Rel(Directory, path)
+			if err != nil {
+				return nil
+			}
+			names = append(names, filepath.ToSlash(
+				strings.TrimSuffix(name, ".json"),
+			))
+			return nil
+		},
+	)
+	return names, err
+}
+
 func SetKeys(group string, keys []map[string]any) error {
 	if keys != nil {
 		_, err := token.ParseKeys(keys, "", "")
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -319,3 +320,72 @@ func TestSubGroup(t *testing.T) {
 		t.Fatalf("UpdateDescription: got %v", err)
 	}
 }
+
+func TestDescriptionNames(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+
+	for _, name := range []string{"a", "dir/b"} {
+		err = UpdateDescription(name, "", &Description{})
+		if err != nil {
+			t.Fatalf("UpdateDescription: got %v", err)
+		}
+	}
+
+	names, err := GetDescriptionNames()
+	sort.Strings(names)
+	if err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("GetDescriptionNames: got %v %v", names, err)
+	}
+
+	names, err = GetAllDescriptionNames()
+	sort.Strings(names)
+	if err != nil || !reflect.DeepEqual(names, []string{"a", "dir/b"}) {
+		t.Errorf("GetAllDescriptionNames: got %v %v", names, err)
+	}
+}
+
+func TestCheckAuth(t *testing.T) {
+	key := map[string]interface{}{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   "MYz3IfCq4Yq-UmPdNqWEOdPl4C_m9imHHs9uveDUJGQ",
+	}
+	keys := []map[string]interface{}{key}
+	bad := []map[string]interface{}{{"kty": "oct"}}
+
+	tests := []struct {
+		desc Description
+		ok   bool
+	}{
+		{Description{}, true},
+		{Description{AuthKeys: keys}, true},
+		{Description{AuthKeys: bad}, false},
+		{Description{
+			AuthKeys:   keys,
+			AuthServer: "https://auth.example.org",
+		}, true},
+		{Description{
+			AuthKeys:   keys,
+			AuthPortal: "https://auth.example.org/portal",
+		}, true},
+		{Description{AuthServer: "https://auth.example.org"}, false},
+		{Description{
+			AuthKeys:   keys,
+			AuthServer: "auth.example.org",
+		}, false},
+		{Description{
+			AuthKeys:   keys,
+			AuthServer: "https://auth.example.org",
+			AuthPortal: "https://auth.example.org/portal",
+		}, false},
+	}
+	for i, tt := range tests {
+		err := checkAuth(&tt.desc)
+		if (err == nil) != tt.ok {
+			t.Errorf("Test %v: got %v", i, err)
+		}
+	}
+}
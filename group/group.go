@@ -3,9 +3,7 @@ package group
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/fs"
-	"log"
 	"net/url"
 	"os"
 	"path"
@@ -13,15 +11,22 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/ice/v2"
 	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 
+	"github.com/jech/galene/codecs"
+	"github.com/jech/galene/logging"
+	"github.com/jech/galene/sockbuf"
+	"github.com/jech/galene/store"
 	"github.com/jech/galene/token"
 )
 
+var logger = logging.New("group")
+
 var Directory, DataDirectory string
 var UseMDNS bool
 var UDPMin, UDPMax uint16
@@ -44,6 +49,10 @@ var ErrDuplicateUsername = &NotAuthorisedError{
 	errors.New("this username is taken"),
 }
 
+var ErrRegisteredUsername = &NotAuthorisedError{
+	errors.New("this username is registered"),
+}
+
 type UserError string
 
 func (err UserError) Error() string {
@@ -90,13 +99,25 @@ const (
 type Group struct {
 	name string
 
+	// protected by its own mutex, since it is updated by the RTP readers
+	talkTime talkTime
+
 	mu          sync.Mutex
 	description *Description
 	locked      *string
 	clients     map[string]Client
+	publishers  map[string]Client
 	history     []ChatHistoryEntry
+	whiteboard  whiteboard
+	polls       map[string]*poll
 	timestamp   time.Time
 	data        map[string]interface{}
+
+	// whether guest links are disabled
+	guestsLocked bool
+
+	// the time at which every client joined
+	joined map[string]time.Time
 }
 
 func (g *Group) Name() string {
@@ -166,6 +187,41 @@ func (g *Group) ClientCount() int {
 	return len(g.clients)
 }
 
+// ErrTooManyPublishers is returned by AddPublisher when the group's
+// max-publishers limit has been reached.
+var ErrTooManyPublishers = UserError("too many publishers")
+
+// AddPublisher records that a client publishes streams in the group.
+// It fails if the group already has max-publishers publishers, unless
+// the client is an operator.
+func (g *Group) AddPublisher(c Client) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.publishers[c.Id()] == c {
+		return nil
+	}
+	max := g.description.MaxPublishers
+	if max > 0 && len(g.publishers) >= max &&
+		!member("op", c.Permissions()) {
+		return ErrTooManyPublishers
+	}
+	if g.publishers == nil {
+		g.publishers = make(map[string]Client)
+	}
+	g.publishers[c.Id()] = c
+	return nil
+}
+
+// DelPublisher records that a client no longer publishes any streams.
+func (g *Group) DelPublisher(c Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.publishers[c.Id()] == c {
+		delete(g.publishers, c.Id())
+	}
+}
+
 func (g *Group) mayExpire() bool {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -192,165 +248,6 @@ func (g *Group) API() (*webrtc.API, error) {
 	return APIFromNames(codecs)
 }
 
-func fmtpValue(fmtp, key string) string {
-	fields := strings.Split(fmtp, ";")
-	for _, f := range fields {
-		k, v, found := strings.Cut(f, "=")
-		if found && k == key {
-			return v
-		}
-	}
-	return ""
-}
-
-func CodecPayloadType(codec webrtc.RTPCodecCapability) (webrtc.PayloadType, error) {
-	switch strings.ToLower(codec.MimeType) {
-	case "video/vp8":
-		return 96, nil
-	case "video/vp9":
-		profile := fmtpValue(codec.SDPFmtpLine, "profile-id")
-		switch profile {
-		case "", "0":
-			return 98, nil
-		case "2":
-			return 100, nil
-		default:
-			return 0, fmt.Errorf("unknown VP9 profile %v", profile)
-
-		}
-	case "video/av1":
-		return 35, nil
-	case "video/h264":
-		profile := fmtpValue(codec.SDPFmtpLine, "profile-level-id")
-		if profile == "" {
-			return 102, nil
-		}
-		if len(profile) < 4 {
-			return 0, errors.New("malforned H.264 profile")
-		}
-		switch strings.ToLower(profile[:4]) {
-		case "4200":
-			return 102, nil
-		case "42e0":
-			return 108, nil
-		default:
-			return 0, fmt.Errorf(
-				"unknown H.264 profile %v", profile,
-			)
-		}
-	case "audio/opus":
-		return 111, nil
-	case "audio/g722":
-		return 9, nil
-	case "audio/pcmu":
-		return 0, nil
-	case "audio/pcma":
-		return 8, nil
-	default:
-		return 0, fmt.Errorf("unknown codec %v", codec.MimeType)
-	}
-}
-
-func codecsFromName(name string) ([]webrtc.RTPCodecParameters, error) {
-	fb := []webrtc.RTCPFeedback{
-		{"goog-remb", ""},
-		{"nack", ""},
-		{"nack", "pli"},
-		{"ccm", "fir"},
-	}
-
-	var codecs []webrtc.RTPCodecCapability
-
-	switch name {
-	case "vp8":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"video/VP8", 90000, 0,
-				"",
-				fb,
-			},
-		}
-	case "vp9":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"video/VP9", 90000, 0,
-				"profile-id=0",
-				fb,
-			},
-			{
-				"video/VP9", 90000, 0,
-				"profile-id=2",
-				fb,
-			},
-		}
-	case "av1":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"video/AV1", 90000, 0,
-				"",
-				fb,
-			},
-		}
-	case "h264":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"video/H264", 90000, 0,
-				"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
-				fb,
-			},
-		}
-	case "opus":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"audio/opus", 48000, 2,
-				"minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1",
-				nil,
-			},
-		}
-	case "g722":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"audio/G722", 8000, 1,
-				"",
-				nil,
-			},
-		}
-	case "pcmu":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"audio/PCMU", 8000, 1,
-				"",
-				nil,
-			},
-		}
-	case "pcma":
-		codecs = []webrtc.RTPCodecCapability{
-			{
-				"audio/PCMU", 8000, 1,
-				"",
-				nil,
-			},
-		}
-	default:
-		return nil, errors.New("unknown codec")
-	}
-
-	parms := make([]webrtc.RTPCodecParameters, 0, len(codecs))
-	for _, c := range codecs {
-		ptype, err := CodecPayloadType(c)
-		if err != nil {
-			log.Printf("Couldn't determine ptype for codec %v: %v",
-				c.MimeType, err)
-			continue
-		}
-		parms = append(parms, webrtc.RTPCodecParameters{
-			RTPCodecCapability: c,
-			PayloadType:        ptype,
-		})
-	}
-	return parms, nil
-}
-
 func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 	s := webrtc.SettingEngine{}
 	s.SetSRTPReplayProtectionWindow(512)
@@ -367,7 +264,8 @@ func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 		}
 		err := m.RegisterCodec(codec, tpe)
 		if err != nil {
-			log.Printf("%v", err)
+			logger.Warn("Register codec",
+				"codec", codec.MimeType, "err", err)
 			continue
 		}
 	}
@@ -375,12 +273,27 @@ func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 	if UDPMin > 0 && UDPMax > 0 {
 		s.SetEphemeralUDPPortRange(UDPMin, UDPMax)
 	}
+	if sockbuf.Enabled() {
+		n, err := sockbuf.NewNet()
+		if err != nil {
+			return nil, err
+		}
+		s.SetNet(n)
+	}
+	err := setICECandidates(&s)
+	if err != nil {
+		return nil, err
+	}
 	m.RegisterHeaderExtension(
 		webrtc.RTPHeaderExtensionCapability{sdp.SDESMidURI},
 		webrtc.RTPCodecTypeVideo)
 	m.RegisterHeaderExtension(
 		webrtc.RTPHeaderExtensionCapability{sdp.SDESRTPStreamIDURI},
 		webrtc.RTPCodecTypeVideo)
+	// used for computing talk time, stripped before forwarding
+	m.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI},
+		webrtc.RTPCodecTypeAudio)
 
 	return webrtc.NewAPI(
 		webrtc.WithSettingEngine(s),
@@ -392,17 +305,22 @@ func APIFromNames(names []string) (*webrtc.API, error) {
 	if len(names) == 0 {
 		names = []string{"vp8", "opus"}
 	}
-	var codecs []webrtc.RTPCodecParameters
+	var parms []webrtc.RTPCodecParameters
 	for _, n := range names {
-		cs, err := codecsFromName(n)
+		c := codecs.LookupName(n)
+		if c == nil {
+			logger.Warn("Unknown codec", "codec", n)
+			continue
+		}
+		cs, err := c.Parameters()
 		if err != nil {
-			log.Printf("Codec %v: %v", n, err)
+			logger.Warn("Unknown codec", "codec", n, "err", err)
 			continue
 		}
-		codecs = append(codecs, cs...)
+		parms = append(parms, cs...)
 	}
 
-	return APIFromCodecs(codecs)
+	return APIFromCodecs(parms)
 }
 
 func Add(name string, desc *Description) (*Group, error) {
@@ -432,6 +350,16 @@ func add(name string, desc *Description) (*Group, []Client, error) {
 		return nil, nil, UserError("illegal group name")
 	}
 
+	// load the chat history before taking the lock, since it involves
+	// a database query
+	groups.mu.Lock()
+	exists := groups.groups[name] != nil
+	groups.mu.Unlock()
+	var history []ChatHistoryEntry
+	if !exists {
+		history = loadChatHistory(name)
+	}
+
 	groups.mu.Lock()
 	defer groups.mu.Unlock()
 
@@ -455,6 +383,7 @@ func add(name string, desc *Description) (*Group, []Client, error) {
 			description: desc,
 			clients:     make(map[string]Client),
 			timestamp:   time.Now(),
+			history:     history,
 		}
 		groups.groups[name] = g
 	}
@@ -472,7 +401,8 @@ func add(name string, desc *Description) (*Group, []Client, error) {
 		desc, err = readDescription(name, true)
 		if err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
-				log.Printf("Reading group %v: %v", name, err)
+				logger.Warn("Reading group",
+					"group", name, "err", err)
 			}
 			deleteUnlocked(g)
 			return nil, nil, err
@@ -589,6 +519,10 @@ func AddClient(group string, c Client, creds ClientCredentials) (*Group, error)
 	clients := g.getClientsUnlocked(nil)
 
 	if !member("system", c.Permissions()) {
+		if draining.Load() {
+			return nil, UserError("the server is shutting down")
+		}
+
 		username, perms, err := g.getPermission(creds)
 		if err != nil {
 			return nil, err
@@ -651,8 +585,28 @@ func AddClient(group string, c Client, creds ClientCredentials) (*Group, error)
 	if g.clients[id] != nil {
 		return nil, ProtocolError("duplicate client id")
 	}
+	if !member("system", c.Permissions()) {
+		conf, err := GetConfiguration()
+		if err != nil {
+			return nil, err
+		}
+		err = checkBandwidth(c.Username(), g.description, conf)
+		if err != nil {
+			return nil, err
+		}
+		err = addUserClient(c.Username(), conf.MaxConnectionsPerUser)
+		if err != nil {
+			return nil, err
+		}
+	}
 	g.clients[id] = c
 	g.timestamp = time.Now()
+	if !member("system", c.Permissions()) {
+		if g.joined == nil {
+			g.joined = make(map[string]time.Time)
+		}
+		g.joined[id] = g.timestamp
+	}
 
 	c.Joined(g.Name(), "join")
 
@@ -703,21 +657,34 @@ func DelClient(c Client) {
 	}
 	g.mu.Lock()
 	if g.clients[c.Id()] != c {
-		log.Printf("Deleting unknown client")
+		logger.Warn("Deleting unknown client",
+			"group", g.name, "client", c.Id())
 		g.mu.Unlock()
 		return
 	}
 	delete(g.clients, c.Id())
+	if !member("system", c.Permissions()) {
+		delUserClient(c.Username())
+	}
+	if g.publishers[c.Id()] == c {
+		delete(g.publishers, c.Id())
+	}
 	g.timestamp = time.Now()
+	joined, ok := g.joined[c.Id()]
+	delete(g.joined, c.Id())
 	clients := g.getClientsUnlocked(nil)
 	g.mu.Unlock()
 
+	if ok {
+		g.logAttendance(c, joined, g.timestamp)
+	}
 	c.Joined(g.Name(), "leave")
 	for _, cc := range clients {
 		cc.PushClient(
 			g.Name(), "delete", c.Id(), c.Username(), nil, nil,
 		)
 	}
+	g.logTalkTime(c)
 	autoLockKick(g)
 }
 
@@ -737,6 +704,27 @@ func (g *Group) getClientsUnlocked(except Client) []Client {
 	return clients
 }
 
+// Recipients returns the clients that receive a message sent to the
+// whole group by the client with id source, except except, which may be
+// nil.  In echo groups, clients don't see each other, so this is at most
+// the source itself.
+func (g *Group) Recipients(source string, except Client) []Client {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.getRecipientsUnlocked(source, except)
+}
+
+func (g *Group) getRecipientsUnlocked(source string, except Client) []Client {
+	if !g.description.Echo {
+		return g.getClientsUnlocked(except)
+	}
+	c := g.clients[source]
+	if c == nil || c == except {
+		return nil
+	}
+	return []Client{c}
+}
+
 func (g *Group) GetClient(id string) Client {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -778,6 +766,70 @@ func Shutdown(message string) {
 	})
 }
 
+var draining atomic.Bool
+
+type drainer interface {
+	Drain(message, redirect string, deadline time.Time) error
+}
+
+// Draining returns true if the server is shutting down.
+func Draining() bool {
+	return draining.Load()
+}
+
+// Drain causes new clients to be rejected, and tells existing clients
+// that the server will shut down at deadline.  If redirect is not
+// empty, it is the base URL of a server that clients should move to.
+func Drain(message, redirect string, deadline time.Time) {
+	draining.Store(true)
+
+	var gs []*Group
+	Range(func(g *Group) bool {
+		gs = append(gs, g)
+		return true
+	})
+
+	for _, g := range gs {
+		r := ""
+		if redirect != "" {
+			u, err := url.JoinPath(redirect, "group", g.Name())
+			if err != nil {
+				logger.Warn("Drain",
+					"group", g.Name(), "err", err)
+			} else {
+				r = u + "/"
+			}
+		}
+		for _, c := range g.GetClients(nil) {
+			d, ok := c.(drainer)
+			if !ok {
+				continue
+			}
+			err := d.Drain(message, r, deadline)
+			if err != nil {
+				logger.Warn("Drain", "group", g.Name(),
+					"client", c.Id(), "err", err)
+			}
+		}
+	}
+}
+
+// CountClients returns the number of clients in all groups, not
+// counting system clients.
+func CountClients() int {
+	count := 0
+	Range(func(g *Group) bool {
+		g.Range(func(c Client) bool {
+			if !member("system", c.Permissions()) {
+				count++
+			}
+			return true
+		})
+		return true
+	})
+	return count
+}
+
 type warner interface {
 	Warn(oponly bool, message string) error
 }
@@ -791,7 +843,63 @@ func (g *Group) WallOps(message string) {
 		}
 		err := w.Warn(true, message)
 		if err != nil {
-			log.Printf("WallOps: %v", err)
+			logger.Warn("WallOps", "group", g.Name(),
+				"client", c.Id(), "err", err)
+		}
+	}
+}
+
+type chatter interface {
+	Chat(source string, username *string, privileged, noecho bool, time time.Time, kind string, value interface{}) error
+}
+
+// Chat records a public chat message in the chat history and sends it
+// to all members of the group except except, which may be nil.  If
+// noecho is true, it is not sent back to the client with id source.  In
+// echo groups, the message is not recorded and only sent back to its
+// source.
+func (g *Group) Chat(except Client, source string, username *string, privileged, noecho bool, time time.Time, kind string, value interface{}) {
+	if !g.Description().Echo {
+		g.AddToChatHistory(source, username, time, kind, value)
+	}
+
+	clients := g.Recipients(source, except)
+	for _, c := range clients {
+		if noecho && c.Id() == source {
+			continue
+		}
+		cc, ok := c.(chatter)
+		if !ok {
+			continue
+		}
+		err := cc.Chat(
+			source, username, privileged, noecho, time, kind, value,
+		)
+		if err != nil {
+			logger.Warn("Chat", "group", g.Name(),
+				"client", c.Id(), "err", err)
+		}
+	}
+}
+
+type captioner interface {
+	Caption(source, id, username, text string) error
+}
+
+// Caption sends a line of live captions to all members of the group.
+// Source is the id of the client that is speaking, id the id of the
+// stream that is being transcribed.
+func (g *Group) Caption(source, id, username, text string) {
+	clients := g.Recipients(source, nil)
+	for _, c := range clients {
+		cc, ok := c.(captioner)
+		if !ok {
+			continue
+		}
+		err := cc.Caption(source, id, username, text)
+		if err != nil {
+			logger.Warn("Caption", "group", g.Name(),
+				"client", c.Id(), "err", err)
 		}
 	}
 }
@@ -800,21 +908,65 @@ const maxChatHistory = 50
 
 func (g *Group) ClearChatHistory() {
 	g.mu.Lock()
-	defer g.mu.Unlock()
 	g.history = nil
+	g.mu.Unlock()
+
+	if store.Enabled() {
+		queueChatWrite(chatWrite{op: store.ChatOp{Group: g.name}})
+	}
 }
 
 func (g *Group) AddToChatHistory(id string, user *string, time time.Time, kind string, value interface{}) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	entry := ChatHistoryEntry{
+		Id: id, User: user, Time: time, Kind: kind, Value: value,
+	}
 
+	g.mu.Lock()
 	if len(g.history) >= maxChatHistory {
 		copy(g.history, g.history[1:])
 		g.history = g.history[:len(g.history)-1]
 	}
-	g.history = append(g.history,
-		ChatHistoryEntry{Id: id, User: user, Time: time, Kind: kind, Value: value},
-	)
+	g.history = append(g.history, entry)
+	g.mu.Unlock()
+
+	if store.Enabled() {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			logger.Warn("Save chat history",
+				"group", g.name, "err", err)
+			return
+		}
+		queueChatWrite(chatWrite{op: store.ChatOp{
+			Group: g.name, Time: time, Data: data,
+		}})
+	}
+}
+
+// loadChatHistory returns the chat history of a group saved in the
+// database, if any.  It performs database I/O, don't call it locked.
+func loadChatHistory(name string) []ChatHistoryEntry {
+	if !store.Enabled() {
+		return nil
+	}
+	// the group might have been deleted with writes still pending
+	FlushChatHistory()
+	data, err := store.ChatHistory(name)
+	if err != nil {
+		logger.Warn("Load chat history", "group", name, "err", err)
+		return nil
+	}
+	var h []ChatHistoryEntry
+	for _, d := range data {
+		var e ChatHistoryEntry
+		err := json.Unmarshal(d, &e)
+		if err != nil {
+			logger.Warn("Load chat history",
+				"group", name, "err", err)
+			continue
+		}
+		h = append(h, e)
+	}
+	return h
 }
 
 func discardObsoleteHistory(h []ChatHistoryEntry, duration time.Duration) []ChatHistoryEntry {
@@ -858,10 +1010,92 @@ type Configuration struct {
 	WritableGroups bool   `json:"writableGroups"`
 	Users          map[string]UserDescription
 
+	// The addresses of reverse proxies that are trusted to set
+	// X-Forwarded-For and to send PROXY headers.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+
+	// The speech-to-text service used for live captions.
+	Captions *CaptionsConfiguration `json:"captions,omitempty"`
+
+	// If not nil, finished recordings are converted to MP4.
+	Transcode *TranscodeConfiguration `json:"transcode,omitempty"`
+
+	// The maximum number of simultaneous connections from a single
+	// address, and by a single user across all groups.  Zero means no
+	// limit.
+	MaxConnectionsPerAddress int `json:"maxConnectionsPerAddress,omitempty"`
+	MaxConnectionsPerUser    int `json:"maxConnectionsPerUser,omitempty"`
+
+	// The default bandwidth caps for authenticated users.
+	BandwidthCaps *BandwidthCaps `json:"bandwidthCaps,omitempty"`
+
+	// The limits on the rate of protocol messages sent by every
+	// client, indexed by class of message.
+	MessageLimits map[string]MessageLimit `json:"messageLimits,omitempty"`
+
 	// obsolete fields
 	Admin []ClientPattern `json:"admin"`
 }
 
+// CaptionsConfiguration describes a speech-to-text service.  Exactly
+// one of Command and URL should be set.  The service is fed an Ogg/Opus
+// stream for each speaker, either on standard input or as binary
+// WebSocket messages, and produces one line of text per caption, either
+// on standard output or as text WebSocket messages.
+type CaptionsConfiguration struct {
+	Command []string `json:"command,omitempty"`
+	URL     string   `json:"url,omitempty"`
+}
+
+// TranscodeConfiguration describes how recordings are converted to
+// H.264/AAC in an MP4 container.  Conversion is performed in the
+// background by running ffmpeg.
+type TranscodeConfiguration struct {
+	// The ffmpeg binary, "ffmpeg" if empty.
+	FFmpeg string `json:"ffmpeg,omitempty"`
+	// The maximum number of conversions running at a time, 1 if 0.
+	MaxJobs int `json:"maxJobs,omitempty"`
+	// Whether to keep the original WebM or Matroska file.
+	KeepOriginal bool `json:"keepOriginal,omitempty"`
+	// The text burnt into the video, unless overridden by the group.
+	Overlay *Overlay `json:"overlay,omitempty"`
+}
+
+// An Overlay describes the text burnt into the video of recordings when
+// they are converted to MP4.
+type Overlay struct {
+	// Literal text, such as "Confidential".
+	Text string `json:"text,omitempty"`
+	// Whether to include the name of the group.
+	Group bool `json:"group,omitempty"`
+	// Whether to include the date and time at which every frame was
+	// recorded, in UTC.
+	Timestamp bool `json:"timestamp,omitempty"`
+	// One of "top-left", "top-right", "bottom-left", "bottom-right"
+	// or "center"; "bottom-left" if empty.
+	Position string `json:"position,omitempty"`
+	// The font size, in pixels, 24 if 0.
+	Size int `json:"size,omitempty"`
+	// The font file, the default font if empty.
+	Font string `json:"font,omitempty"`
+}
+
+func checkOverlay(o *Overlay) error {
+	if o == nil {
+		return nil
+	}
+	switch o.Position {
+	case "", "top-left", "top-right", "bottom-left", "bottom-right",
+		"center":
+	default:
+		return errors.New("bad overlay position " + o.Position)
+	}
+	if o.Size < 0 {
+		return errors.New("negative overlay size")
+	}
+	return nil
+}
+
 func (conf Configuration) Zero() bool {
 	return conf.modTime.Equal(time.Time{}) &&
 		conf.fileSize == 0
@@ -872,6 +1106,24 @@ var configuration struct {
 	configuration *Configuration
 }
 
+// CheckConfiguration returns the global configuration, or an error if
+// the configuration file cannot be parsed or if its limits are invalid.
+func CheckConfiguration() (*Configuration, error) {
+	conf, err := GetConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	if conf.MaxConnectionsPerAddress < 0 ||
+		conf.MaxConnectionsPerUser < 0 {
+		return nil, errors.New("negative connection limit")
+	}
+	err = checkMessageLimits(conf.MessageLimits)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
 func GetConfiguration() (*Configuration, error) {
 	configuration.mu.Lock()
 	defer configuration.mu.Unlock()
@@ -911,7 +1163,8 @@ func GetConfiguration() (*Configuration, error) {
 		return nil, err
 	}
 	if conf.Admin != nil {
-		log.Printf("%v: field \"admin\" is obsolete, ignored", filename)
+		logger.Warn("Field \"admin\" is obsolete, ignored",
+			"file", filename)
 		conf.Admin = nil
 	}
 	configuration.configuration = &conf
@@ -939,6 +1192,32 @@ func (g *Group) getPasswordPermission(creds ClientCredentials) (Permissions, err
 		}
 	}
 
+	// registered usernames may only be used by their owner
+	ru, err := GetRegisteredUser(*creds.Username)
+	if err != nil {
+		return Permissions{}, err
+	}
+	if ru != nil {
+		if !desc.AllowRegisteredUsers {
+			return Permissions{}, ErrRegisteredUsername
+		}
+		ok, err := ru.Password.Match(creds.Password)
+		if err != nil {
+			return Permissions{}, err
+		}
+		if !ok {
+			return Permissions{}, &NotAuthorisedError{}
+		}
+		return ru.Permissions, nil
+	}
+	reserved, err := ReservedUsername(*creds.Username)
+	if err != nil {
+		return Permissions{}, err
+	}
+	if reserved {
+		return Permissions{}, ErrRegisteredUsername
+	}
+
 	if desc.WildcardUser != nil {
 		ok, _ := desc.WildcardUser.Password.Match(creds.Password)
 		if ok {
@@ -984,13 +1263,31 @@ func (g *Group) getPermission(creds ClientCredentials) (string, []string, error)
 
 		username, perms, err =
 			tok.Check(conf.CanonicalHost, g.name, creds.Username)
-		if err != nil {
+		if errors.Is(err, token.ErrDisabled) {
+			return "", nil, ErrGuestsLocked
+		} else if err != nil {
 			return "", nil, &NotAuthorisedError{err: err}
 		}
-		if username == "" && creds.Username != nil {
+		if member("guest", perms) {
+			err := g.checkGuests()
+			if err != nil {
+				return "", nil, err
+			}
+			username, err = g.guestUsername()
+			if err != nil {
+				return "", nil, err
+			}
+		} else if username == "" && creds.Username != nil {
 			if g.userExists(*creds.Username) {
 				return "", nil, ErrDuplicateUsername
 			}
+			reserved, err := ReservedUsername(*creds.Username)
+			if err != nil {
+				return "", nil, err
+			}
+			if reserved {
+				return "", nil, ErrRegisteredUsername
+			}
 			username = *creds.Username
 		}
 	} else if creds.Username != nil {
@@ -1014,17 +1311,23 @@ func (g *Group) GetPermission(creds ClientCredentials) (string, []string, error)
 }
 
 type Status struct {
-	Name              string `json:"name"`
-	Redirect          string `json:"redirect,omitempty"`
-	Location          string `json:"location,omitempty"`
-	Endpoint          string `json:"endpoint,omitempty"`
-	DisplayName       string `json:"displayName,omitempty"`
-	Description       string `json:"description,omitempty"`
-	AuthServer        string `json:"authServer,omitempty"`
-	AuthPortal        string `json:"authPortal,omitempty"`
-	Locked            bool   `json:"locked,omitempty"`
-	ClientCount       *int   `json:"clientCount,omitempty"`
-	CanChangePassword bool   `json:"canChangePassword,omitempty"`
+	Name              string          `json:"name"`
+	Redirect          string          `json:"redirect,omitempty"`
+	Location          string          `json:"location,omitempty"`
+	Endpoint          string          `json:"endpoint,omitempty"`
+	DisplayName       string          `json:"displayName,omitempty"`
+	Description       string          `json:"description,omitempty"`
+	AuthServer        string          `json:"authServer,omitempty"`
+	AuthPortal        string          `json:"authPortal,omitempty"`
+	Locked            bool            `json:"locked,omitempty"`
+	ClientCount       *int            `json:"clientCount,omitempty"`
+	CanChangePassword bool            `json:"canChangePassword,omitempty"`
+	Whiteboard        bool            `json:"whiteboard,omitempty"`
+	Echo              bool            `json:"echo,omitempty"`
+	Profile           *QualityProfile `json:"profile,omitempty"`
+	GuestsLocked      bool            `json:"guestsLocked,omitempty"`
+
+	Branding *Branding `json:"branding,omitempty"`
 }
 
 // Status returns a group's status.
@@ -1072,6 +1375,8 @@ func (g *Group) Status(authentified bool, base *url.URL) Status {
 		AuthServer:  desc.AuthServer,
 		AuthPortal:  desc.AuthPortal,
 		Description: desc.Description,
+		Echo:        desc.Echo,
+		Profile:     desc.Profile(),
 	}
 
 	if authentified || desc.Public {
@@ -1086,6 +1391,9 @@ func (g *Group) Status(authentified bool, base *url.URL) Status {
 		if err == nil {
 			d.CanChangePassword = conf.WritableGroups
 		}
+		d.Whiteboard = desc.AllowWhiteboard
+		d.GuestsLocked = g.GuestsLocked()
+		d.Branding = brandingStatus(g.name, desc.Branding)
 	}
 	return d
 }
@@ -1110,9 +1418,9 @@ func GetPublic(base *url.URL) []Status {
 func Update() {
 	_, err := GetConfiguration()
 	if err != nil {
-		log.Printf("%v: %v",
-			filepath.Join(DataDirectory, "config.json"),
-			err,
+		logger.Warn("Reading configuration",
+			"file", filepath.Join(DataDirectory, "config.json"),
+			"err", err,
 		)
 	}
 
@@ -1139,15 +1447,14 @@ func Update() {
 		Directory,
 		func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
-				log.Printf("Group file %v: %v", path, err)
+				logger.Warn("Group file", "file", path, "err", err)
 				return nil
 			}
 			if d.IsDir() {
 				base := filepath.Base(path)
 				if base[0] == '.' {
-					log.Printf(
-						"Ignoring group directory %v",
-						path,
+					logger.Info("Ignoring group directory",
+						"file", path,
 					)
 					return fs.SkipDir
 				}
@@ -1155,25 +1462,25 @@ func Update() {
 			}
 			filename, err := filepath.Rel(Directory, path)
 			if err != nil {
-				log.Printf("Group file %v: %v", path, err)
+				logger.Warn("Group file", "file", path, "err", err)
 				return nil
 			}
 			if !strings.HasSuffix(filename, ".json") {
-				log.Printf(
-					"Unexpected extension for group file %v",
-					path,
+				logger.Warn("Unexpected extension for group file",
+					"file", path,
 				)
 				return nil
 			}
 			base := filepath.Base(filename)
 			if base[0] == '.' {
-				log.Printf("Ignoring group file %v", filename)
+				logger.Info("Ignoring group file",
+					"file", filename)
 				return nil
 			}
 			name := strings.TrimSuffix(filename, ".json")
 			desc, err := GetDescription(name)
 			if err != nil {
-				log.Printf("Group file %v: %v", path, err)
+				logger.Warn("Group file", "file", path, "err", err)
 				return nil
 			}
 			if desc.Public {
@@ -1184,6 +1491,6 @@ func Update() {
 	)
 
 	if err != nil {
-		log.Printf("Couldn't read groups: %v", err)
+		logger.Warn("Couldn't read groups", "err", err)
 	}
 }
@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
 	"testing"
 	"time"
 	"sort"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/store"
 )
 
 func TestGroup(t *testing.T) {
@@ -70,6 +75,54 @@ func TestChatHistory(t *testing.T) {
 	}
 }
 
+func TestChatHistoryDatabase(t *testing.T) {
+	err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	groups.groups = nil
+	t.Cleanup(func() {
+		FlushChatHistory()
+		store.Close()
+		groups.groups = nil
+	})
+
+	g, err := Add("chat", &Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	user := "user"
+	for i := 0; i < 3; i++ {
+		g.AddToChatHistory("id", &user, time.Now(), "",
+			fmt.Sprintf("%v", i),
+		)
+	}
+	FlushChatHistory()
+	data, err := store.ChatHistory("chat")
+	if err != nil || len(data) != 3 {
+		t.Errorf("ChatHistory: got %q %v", data, err)
+	}
+
+	// the history is reloaded when the group is recreated
+	g.AddToChatHistory("id", &user, time.Now(), "", "3")
+	Delete("chat")
+	g, err = Add("chat", &Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	h := g.GetChatHistory()
+	if len(h) != 4 || h[3].Value != "3" {
+		t.Errorf("Expected 4 entries, got %v", h)
+	}
+
+	g.ClearChatHistory()
+	FlushChatHistory()
+	data, err = store.ChatHistory("chat")
+	if err != nil || len(data) != 0 {
+		t.Errorf("After clear: got %q %v", data, err)
+	}
+}
+
 func permissionsEqual(a, b []string) bool {
 	// nil case
 	if len(a) == 0 && len(b) == 0 {
@@ -236,32 +289,6 @@ func TestUsernameTaken(t *testing.T) {
 	}
 }
 
-func TestFmtpValue(t *testing.T) {
-	type fmtpTest struct {
-		fmtp  string
-		key   string
-		value string
-	}
-	fmtpTests := []fmtpTest{
-		{"", "foo", ""},
-		{"profile-id=0", "profile-id", "0"},
-		{"profile-id=0", "foo", ""},
-		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", "profile-level-id", "42001f"},
-		{"foo=1;bar=2;quux=3", "foo", "1"},
-		{"foo=1;bar=2;quux=3", "bar", "2"},
-		{"foo=1;bar=2;quux=3", "fu", ""},
-	}
-
-	for _, test := range fmtpTests {
-		v := fmtpValue(test.fmtp, test.key)
-		if v != test.value {
-			t.Errorf("fmtpValue(%v, %v) = %v, expected %v",
-				test.fmtp, test.key, v, test.value,
-			)
-		}
-	}
-}
-
 func TestValidGroupName(t *testing.T) {
 	type nameTest struct {
 		name   string
@@ -290,3 +317,179 @@ func TestValidGroupName(t *testing.T) {
 		}
 	}
 }
+
+type publisherClient struct {
+	id          string
+	permissions []string
+}
+
+func (c *publisherClient) Group() *Group                { return nil }
+func (c *publisherClient) Addr() net.Addr               { return nil }
+func (c *publisherClient) Id() string                   { return c.id }
+func (c *publisherClient) Username() string             { return c.id }
+func (c *publisherClient) SetUsername(string)           {}
+func (c *publisherClient) Permissions() []string        { return c.permissions }
+func (c *publisherClient) SetPermissions([]string)      {}
+func (c *publisherClient) Data() map[string]interface{} { return nil }
+func (c *publisherClient) PushConn(g *Group, id string, conn conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+func (c *publisherClient) RequestConns(target Client, g *Group, id string) error {
+	return nil
+}
+func (c *publisherClient) Joined(group, kind string) error { return nil }
+func (c *publisherClient) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+func (c *publisherClient) Kick(id string, user *string, message string) error {
+	return nil
+}
+
+func TestMaxPublishers(t *testing.T) {
+	g := &Group{description: &Description{MaxPublishers: 2}}
+	a := &publisherClient{id: "a"}
+	b := &publisherClient{id: "b"}
+	c := &publisherClient{id: "c"}
+	op := &publisherClient{id: "op", permissions: []string{"op"}}
+
+	for _, cc := range []*publisherClient{a, b, a} {
+		if err := g.AddPublisher(cc); err != nil {
+			t.Errorf("AddPublisher(%v): %v", cc.id, err)
+		}
+	}
+	if err := g.AddPublisher(c); err != ErrTooManyPublishers {
+		t.Errorf("AddPublisher(c): expected error, got %v", err)
+	}
+	if err := g.AddPublisher(op); err != nil {
+		t.Errorf("AddPublisher(op): %v", err)
+	}
+	g.DelPublisher(a)
+	g.DelPublisher(op)
+	if err := g.AddPublisher(c); err != nil {
+		t.Errorf("AddPublisher(c): %v", err)
+	}
+}
+
+func TestUserClients(t *testing.T) {
+	if err := addUserClient("bob", 2); err != nil {
+		t.Errorf("addUserClient: %v", err)
+	}
+	if err := addUserClient("bob", 2); err != nil {
+		t.Errorf("addUserClient: %v", err)
+	}
+	if err := addUserClient("bob", 2); err == nil {
+		t.Errorf("Third client accepted")
+	}
+	if err := addUserClient("", 2); err != nil {
+		t.Errorf("Anonymous client refused: %v", err)
+	}
+	delUserClient("bob")
+	if err := addUserClient("bob", 2); err != nil {
+		t.Errorf("addUserClient after delete: %v", err)
+	}
+	delUserClient("bob")
+	delUserClient("bob")
+	if n := userClients.m["bob"]; n != 0 {
+		t.Errorf("Expected 0, got %v", n)
+	}
+}
+
+type announceClient struct {
+	publisherClient
+	announcements []string
+}
+
+func (c *announceClient) Announce(source, username string, tm time.Time, message string) error {
+	c.announcements = append(c.announcements, message)
+	return nil
+}
+
+func TestEchoRecipients(t *testing.T) {
+	a := &announceClient{publisherClient: publisherClient{id: "a"}}
+	b := &announceClient{publisherClient: publisherClient{id: "b"}}
+	g := &Group{
+		description: &Description{Echo: true},
+		clients:     map[string]Client{"a": a, "b": b},
+	}
+
+	g.Announce("a", "a", time.Now(), "hello")
+	if len(a.announcements) != 1 || len(b.announcements) != 0 {
+		t.Errorf("Announce: got %v and %v",
+			a.announcements, b.announcements)
+	}
+
+	if r := g.Recipients("a", a); len(r) != 0 {
+		t.Errorf("Recipients: got %v", r)
+	}
+
+	g.Chat(nil, "a", nil, false, false, time.Now(), "", "hello")
+	if h := g.GetChatHistory(); len(h) != 0 {
+		t.Errorf("Chat history: got %v", h)
+	}
+
+	_, err := g.CreatePoll("a", "?", []string{"yes", "no"}, false, 0)
+	if err == nil {
+		t.Errorf("CreatePoll succeeded")
+	}
+
+	err = g.Whiteboard(WhiteboardOp{Kind: "draw", Source: "a"}, false)
+	if err == nil {
+		t.Errorf("Whiteboard succeeded")
+	}
+
+	g.description = &Description{}
+	if r := g.Recipients("a", a); len(r) != 1 || r[0] != Client(b) {
+		t.Errorf("Recipients: got %v", r)
+	}
+}
+
+type chatClient struct {
+	publisherClient
+	noecho []bool
+}
+
+func (c *chatClient) Chat(source string, username *string, privileged, noecho bool, time time.Time, kind string, value interface{}) error {
+	c.noecho = append(c.noecho, noecho)
+	return nil
+}
+
+func TestChatNoEcho(t *testing.T) {
+	a := &chatClient{publisherClient: publisherClient{id: "a"}}
+	b := &chatClient{publisherClient: publisherClient{id: "b"}}
+	g := &Group{
+		description: &Description{},
+		clients:     map[string]Client{"a": a, "b": b},
+	}
+
+	g.Chat(nil, "a", nil, false, true, time.Now(), "", "hello")
+	if len(a.noecho) != 0 {
+		t.Errorf("Message echoed to its sender")
+	}
+	if len(b.noecho) != 1 || !b.noecho[0] {
+		t.Errorf("Got %v", b.noecho)
+	}
+
+	g.Chat(nil, "a", nil, false, false, time.Now(), "", "hello")
+	if len(a.noecho) != 1 || len(b.noecho) != 2 {
+		t.Errorf("Got %v %v", a.noecho, b.noecho)
+	}
+}
+
+func TestCheckMessageLimits(t *testing.T) {
+	tests := []struct {
+		limits map[string]MessageLimit
+		ok     bool
+	}{
+		{nil, true},
+		{map[string]MessageLimit{"chat": {Rate: 1, Burst: 2}}, true},
+		{map[string]MessageLimit{"chat": {Rate: -1}}, false},
+		{map[string]MessageLimit{"chat": {Rate: 1, Kick: -1}}, false},
+		{map[string]MessageLimit{"foo": {Rate: 1}}, false},
+	}
+	for i, tt := range tests {
+		err := checkMessageLimits(tt.limits)
+		if (err == nil) != tt.ok {
+			t.Errorf("Test %v: got %v", i, err)
+		}
+	}
+}
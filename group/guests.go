@@ -0,0 +1,100 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/jech/galene/token"
+)
+
+var ErrGuestsLocked = UserError("guest access to this group is closed")
+
+// guestUsername returns a username for a guest that is neither used by
+// a client of the group nor defined in the group or the user registry.
+// Called locked.
+func (g *Group) guestUsername() (string, error) {
+	low := 1000
+	for tries := 0; tries < 100; tries++ {
+		if tries > 0 && tries%20 == 0 {
+			low *= 10
+		}
+		name := fmt.Sprintf("Guest-%d", low+rand.Intn(9*low))
+		if g.userExists(name) {
+			continue
+		}
+		taken := false
+		for _, c := range g.clients {
+			if c.Username() == name {
+				taken = true
+				break
+			}
+		}
+		if taken {
+			continue
+		}
+		reserved, err := ReservedUsername(name)
+		if err != nil {
+			return "", err
+		}
+		if !reserved {
+			return name, nil
+		}
+	}
+	return "", errors.New("couldn't generate a username for a guest")
+}
+
+// checkGuests checks whether a new guest may join.  Called locked.
+func (g *Group) checkGuests() error {
+	if g.guestsLocked {
+		return ErrGuestsLocked
+	}
+	max := g.description.MaxGuests
+	if max <= 0 {
+		return nil
+	}
+	n := 0
+	for _, c := range g.clients {
+		if member("guest", c.Permissions()) {
+			n++
+		}
+	}
+	if n >= max {
+		return UserError("too many guests")
+	}
+	return nil
+}
+
+// GuestsLocked returns true if guest links are disabled.
+func (g *Group) GuestsLocked() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.guestsLocked
+}
+
+// SetGuestsLocked enables or disables guest links.  Disabling them also
+// disconnects the guests currently in the group.  Id and user identify
+// the operator that performed the action.  The stateful guest tokens of
+// the group are disabled too, so that the lock survives the group being
+// reloaded; guest links created while the lock is in effect are only
+// refused until then.
+func (g *Group) SetGuestsLocked(locked bool, id string, user *string) error {
+	err := token.SetGuestsDisabled(g.Name(), locked)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.guestsLocked = locked
+	clients := g.getClientsUnlocked(nil)
+	g.mu.Unlock()
+
+	for _, c := range clients {
+		if locked && member("guest", c.Permissions()) {
+			c.Kick(id, user, "guest access has been revoked")
+		} else {
+			c.Joined(g.Name(), "change")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package group
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGuests(t *testing.T) {
+	DataDirectory = t.TempDir()
+	t.Cleanup(func() { DataDirectory = "" })
+
+	g := &Group{
+		description: &Description{MaxGuests: 2},
+		clients:     make(map[string]Client),
+	}
+	guest := []string{"message", "guest"}
+
+	for i := 0; i < 2; i++ {
+		if err := g.checkGuests(); err != nil {
+			t.Fatalf("checkGuests: %v", err)
+		}
+		name, err := g.guestUsername()
+		if err != nil {
+			t.Fatalf("guestUsername: %v", err)
+		}
+		if !strings.HasPrefix(name, "Guest-") || g.clients[name] != nil {
+			t.Errorf("Bad username %v", name)
+		}
+		g.clients[name] = &publisherClient{id: name, permissions: guest}
+	}
+	if err := g.checkGuests(); err == nil {
+		t.Errorf("checkGuests succeeded with too many guests")
+	}
+
+	g.clients["op"] = &publisherClient{id: "op", permissions: []string{"op"}}
+	g.description.MaxGuests = 0
+	if err := g.checkGuests(); err != nil {
+		t.Errorf("checkGuests: %v", err)
+	}
+
+	g.SetGuestsLocked(true, "op", nil)
+	if err := g.checkGuests(); err != ErrGuestsLocked {
+		t.Errorf("checkGuests: expected locked, got %v", err)
+	}
+	g.SetGuestsLocked(false, "op", nil)
+	if err := g.checkGuests(); err != nil {
+		t.Errorf("checkGuests: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package group
+
+import (
+	"sync"
+)
+
+// userClients counts the clients of each user across all groups.
+var userClients struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+// addUserClient records a new client for the given user.  It fails if
+// the user already has max clients.  Anonymous users are not counted.
+func addUserClient(username string, max int) error {
+	if username == "" {
+		return nil
+	}
+	userClients.mu.Lock()
+	defer userClients.mu.Unlock()
+	if max > 0 && userClients.m[username] >= max {
+		return UserError("too many connections for this user")
+	}
+	if userClients.m == nil {
+		userClients.m = make(map[string]int)
+	}
+	userClients.m[username]++
+	return nil
+}
+
+func delUserClient(username string) {
+	if username == "" {
+		return
+	}
+	userClients.mu.Lock()
+	defer userClients.mu.Unlock()
+	n := userClients.m[username] - 1
+	if n <= 0 {
+		delete(userClients.m, username)
+	} else {
+		userClients.m[username] = n
+	}
+}
@@ -0,0 +1,61 @@
+package group
+
+import (
+	"fmt"
+)
+
+// A MessageLimit limits the rate at which a client may send a class of
+// protocol messages.  Messages over the limit are dropped, refused or
+// delayed, depending on the class.
+type MessageLimit struct {
+	// The average number of messages per second.  Zero means no limit.
+	Rate float64 `json:"rate"`
+
+	// The number of messages that may be sent in a burst.
+	Burst int `json:"burst,omitempty"`
+
+	// The number of refused messages per minute after which the client
+	// is disconnected.  Zero means that the client is never
+	// disconnected.  Delayed messages are not counted, but messages
+	// dropped because too many messages are delayed are.
+	Kick int `json:"kick,omitempty"`
+}
+
+// DefaultMessageLimits are the limits that apply to the classes of
+// messages that are not set in the configuration file.
+var DefaultMessageLimits = map[string]MessageLimit{
+	"chat":     {Rate: 5, Burst: 20, Kick: 100},
+	"offer":    {Rate: 2, Burst: 20, Kick: 100},
+	"request":  {Rate: 10, Burst: 50, Kick: 100},
+	"keyframe": {Rate: 5, Burst: 20},
+}
+
+func checkMessageLimits(limits map[string]MessageLimit) error {
+	for class, l := range limits {
+		if _, ok := DefaultMessageLimits[class]; !ok {
+			return fmt.Errorf("messageLimits: unknown class %v", class)
+		}
+		if l.Rate < 0 || l.Burst < 0 || l.Kick < 0 {
+			return fmt.Errorf("messageLimits: %v: negative value",
+				class)
+		}
+	}
+	return nil
+}
+
+// GetMessageLimits returns the limits on the rate of protocol messages,
+// indexed by class of message.
+func GetMessageLimits() map[string]MessageLimit {
+	limits := make(map[string]MessageLimit, len(DefaultMessageLimits))
+	for k, v := range DefaultMessageLimits {
+		limits[k] = v
+	}
+	conf, err := GetConfiguration()
+	if err != nil {
+		return limits
+	}
+	for k, v := range conf.MessageLimits {
+		limits[k] = v
+	}
+	return limits
+}
@@ -0,0 +1,195 @@
+package group
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+const (
+	maxPolls       = 16
+	maxPollOptions = 16
+)
+
+// PollStatus is the state of a poll, as announced to clients and stored
+// in the usage log.  Voters maps voters to the index of the option they
+// chose, and is only populated for named polls.
+type PollStatus struct {
+	Id        string         `json:"id"`
+	Question  string         `json:"question"`
+	Options   []string       `json:"options"`
+	Anonymous bool           `json:"anonymous,omitempty"`
+	Creator   string         `json:"creator,omitempty"`
+	Created   time.Time      `json:"created"`
+	Deadline  *time.Time     `json:"deadline,omitempty"`
+	Closed    bool           `json:"closed,omitempty"`
+	Votes     []int          `json:"votes"`
+	Voters    map[string]int `json:"voters,omitempty"`
+}
+
+type poll struct {
+	status PollStatus
+	// indexed by username, or by client id for anonymous users
+	votes map[string]int
+	timer *time.Timer
+}
+
+type poller interface {
+	Poll(status PollStatus) error
+}
+
+// called locked
+func (p *poll) getStatus() PollStatus {
+	s := p.status
+	s.Options = append([]string(nil), p.status.Options...)
+	s.Votes = make([]int, len(s.Options))
+	if !s.Anonymous {
+		s.Voters = make(map[string]int, len(p.votes))
+	}
+	for voter, v := range p.votes {
+		s.Votes[v]++
+		if !s.Anonymous {
+			s.Voters[voter] = v
+		}
+	}
+	return s
+}
+
+func (g *Group) broadcastPoll(s PollStatus) {
+	// polls have no source client, so nobody receives them in echo
+	// groups, where they cannot be created anyway
+	clients := g.Recipients("", nil)
+	for _, c := range clients {
+		cc, ok := c.(poller)
+		if !ok {
+			continue
+		}
+		err := cc.Poll(s)
+		if err != nil {
+			logger.Warn("Poll", "group", g.Name(),
+				"client", c.Id(), "err", err)
+		}
+	}
+}
+
+// CreatePoll creates a new poll and announces it to the members of the
+// group.  If duration is not zero, the poll is closed automatically
+// after the given time.
+func (g *Group) CreatePoll(creator, question string, options []string, anonymous bool, duration time.Duration) (string, error) {
+	if question == "" {
+		return "", UserError("the poll has no question")
+	}
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return "", UserError("bad number of options")
+	}
+	if duration < 0 {
+		return "", UserError("bad poll duration")
+	}
+	if g.Description().Echo {
+		return "", UserError("polls are disabled in echo groups")
+	}
+
+	buf := make([]byte, 8)
+	crand.Read(buf)
+	id := hex.EncodeToString(buf)
+	now := time.Now()
+
+	g.mu.Lock()
+	if len(g.polls) >= maxPolls {
+		g.mu.Unlock()
+		return "", UserError("too many polls")
+	}
+	p := &poll{
+		status: PollStatus{
+			Id:        id,
+			Question:  question,
+			Options:   append([]string(nil), options...),
+			Anonymous: anonymous,
+			Creator:   creator,
+			Created:   now,
+		},
+		votes: make(map[string]int),
+	}
+	if duration > 0 {
+		deadline := now.Add(duration)
+		p.status.Deadline = &deadline
+		p.timer = time.AfterFunc(duration, func() {
+			g.ClosePoll(id)
+		})
+	}
+	if g.polls == nil {
+		g.polls = make(map[string]*poll)
+	}
+	g.polls[id] = p
+	s := p.getStatus()
+	g.mu.Unlock()
+
+	g.broadcastPoll(s)
+	return id, nil
+}
+
+// Vote records a vote in an open poll.  Voter is the username of the
+// voter, or its client id if it is anonymous; every voter may only vote
+// once.
+func (g *Group) Vote(voter, id string, option int) error {
+	g.mu.Lock()
+	p := g.polls[id]
+	if p == nil {
+		g.mu.Unlock()
+		return UserError("unknown poll")
+	}
+	if option < 0 || option >= len(p.status.Options) {
+		g.mu.Unlock()
+		return UserError("bad option")
+	}
+	if _, ok := p.votes[voter]; ok {
+		g.mu.Unlock()
+		return UserError("you have already voted")
+	}
+	p.votes[voter] = option
+	s := p.getStatus()
+	g.mu.Unlock()
+
+	g.broadcastPoll(s)
+	return nil
+}
+
+// ClosePoll closes a poll, announces the results and records them in the
+// usage log.
+func (g *Group) ClosePoll(id string) error {
+	g.mu.Lock()
+	p := g.polls[id]
+	if p == nil {
+		g.mu.Unlock()
+		return UserError("unknown poll")
+	}
+	delete(g.polls, id)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.status.Closed = true
+	s := p.getStatus()
+	g.mu.Unlock()
+
+	g.broadcastPoll(s)
+	err := g.LogUsage("poll", s)
+	if err != nil {
+		logger.Warn("Log poll", "group", g.Name(), "err", err)
+	}
+	return nil
+}
+
+// GetPolls returns the status of the open polls.
+func (g *Group) GetPolls() []PollStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	polls := make([]PollStatus, 0, len(g.polls))
+	for _, p := range g.polls {
+		polls = append(polls, p.getStatus())
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].Created.Before(polls[j].Created)
+	})
+	return polls
+}
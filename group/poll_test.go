@@ -0,0 +1,121 @@
+package group
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestPoll(t *testing.T) {
+	DataDirectory = t.TempDir()
+	defer func() {
+		DataDirectory = ""
+	}()
+
+	g := &Group{
+		name:        "test",
+		description: &Description{},
+	}
+
+	_, err := g.CreatePoll("op", "", []string{"yes", "no"}, false, 0)
+	if err == nil {
+		t.Errorf("Created poll without a question")
+	}
+	_, err = g.CreatePoll("op", "Question?", []string{"yes"}, false, 0)
+	if err == nil {
+		t.Errorf("Created poll with a single option")
+	}
+
+	id, err := g.CreatePoll("op", "Question?",
+		[]string{"yes", "no"}, false, 0,
+	)
+	if err != nil {
+		t.Fatalf("CreatePoll: %v", err)
+	}
+
+	err = g.Vote("alice", id, 0)
+	if err != nil {
+		t.Errorf("Vote: %v", err)
+	}
+	err = g.Vote("alice", id, 1)
+	if err == nil {
+		t.Errorf("Voted twice")
+	}
+	err = g.Vote("bob", id, 2)
+	if err == nil {
+		t.Errorf("Voted for unknown option")
+	}
+	err = g.Vote("bob", id, 1)
+	if err != nil {
+		t.Errorf("Vote: %v", err)
+	}
+	err = g.Vote("bob", "unknown", 1)
+	if err == nil {
+		t.Errorf("Voted in unknown poll")
+	}
+
+	polls := g.GetPolls()
+	if len(polls) != 1 || polls[0].Votes[0] != 1 ||
+		polls[0].Votes[1] != 1 || polls[0].Voters["bob"] != 1 {
+		t.Errorf("Got %v", polls)
+	}
+
+	err = g.ClosePoll(id)
+	if err != nil {
+		t.Errorf("ClosePoll: %v", err)
+	}
+	if len(g.GetPolls()) != 0 {
+		t.Errorf("Poll is still open")
+	}
+	err = g.Vote("charlie", id, 1)
+	if err == nil {
+		t.Errorf("Voted in closed poll")
+	}
+
+	f, err := os.Open(usageFilename("test"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatalf("Empty usage log")
+	}
+	var entry struct {
+		Kind  string     `json:"kind"`
+		Value PollStatus `json:"value"`
+	}
+	err = json.Unmarshal(scanner.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if entry.Kind != "poll" || !entry.Value.Closed ||
+		entry.Value.Votes[0] != 1 || entry.Value.Votes[1] != 1 {
+		t.Errorf("Got %v", entry)
+	}
+}
+
+func TestAnonymousPoll(t *testing.T) {
+	DataDirectory = t.TempDir()
+	defer func() {
+		DataDirectory = ""
+	}()
+
+	g := &Group{
+		name:        "test",
+		description: &Description{},
+	}
+	id, err := g.CreatePoll("op", "Question?",
+		[]string{"yes", "no"}, true, 0,
+	)
+	if err != nil {
+		t.Fatalf("CreatePoll: %v", err)
+	}
+	g.Vote("alice", id, 0)
+	polls := g.GetPolls()
+	if len(polls) != 1 || polls[0].Votes[0] != 1 ||
+		polls[0].Voters != nil {
+		t.Errorf("Got %v", polls)
+	}
+}
@@ -0,0 +1,103 @@
+package group
+
+import (
+	"errors"
+)
+
+// A QualityProfile describes the quality of the media sent in a group.
+// It is sent to clients when they join, and enforced by the server.
+type QualityProfile struct {
+	// The maximum dimensions of camera video, in pixels.
+	MaxWidth  int `json:"max-width,omitempty"`
+	MaxHeight int `json:"max-height,omitempty"`
+
+	// The maximum framerate of camera video, in frames per second.
+	// The server enforces it by dropping temporal layers.
+	MaxFramerate float64 `json:"max-framerate,omitempty"`
+
+	// The maximum bitrate of camera video, in bits per second.
+	MaxVideoBitrate uint64 `json:"max-video-bitrate,omitempty"`
+
+	// The bitrate of audio, in bits per second.
+	AudioBitrate uint64 `json:"audio-bitrate,omitempty"`
+
+	// Whether clients should use simulcast, one of "on", "off" or
+	// "auto".  The empty string means that clients decide.  The server
+	// refuses simulcast offers if this is "off".
+	Simulcast string `json:"simulcast,omitempty"`
+}
+
+// QualityProfiles are the predefined quality profiles.
+var QualityProfiles = map[string]QualityProfile{
+	// a few speakers and many listeners
+	"lecture": {
+		MaxWidth:        1920,
+		MaxHeight:       1080,
+		MaxFramerate:    30,
+		MaxVideoBitrate: 2500000,
+		AudioBitrate:    64000,
+		Simulcast:       "on",
+	},
+	// everyone speaks
+	"conference": {
+		MaxWidth:        1280,
+		MaxHeight:       720,
+		MaxFramerate:    30,
+		MaxVideoBitrate: 1000000,
+		AudioBitrate:    32000,
+		Simulcast:       "auto",
+	},
+	// participants behind poor links
+	"low-bandwidth": {
+		MaxWidth:        640,
+		MaxHeight:       360,
+		MaxFramerate:    15,
+		MaxVideoBitrate: 250000,
+		AudioBitrate:    16000,
+		Simulcast:       "off",
+	},
+}
+
+// Profile returns the quality profile of a group, or nil if the group
+// doesn't have one.  Profiles defined in the description take precedence
+// over the predefined ones.
+func (desc *Description) Profile() *QualityProfile {
+	if desc.QualityProfile == "" {
+		return nil
+	}
+	p, ok := desc.QualityProfiles[desc.QualityProfile]
+	if !ok {
+		p, ok = QualityProfiles[desc.QualityProfile]
+		if !ok {
+			return nil
+		}
+	}
+	return &p
+}
+
+func checkQualityProfiles(desc *Description) error {
+	if desc.QualityProfile != "" && desc.Profile() == nil {
+		return errors.New(
+			"unknown quality profile " + desc.QualityProfile,
+		)
+	}
+	for _, p := range desc.QualityProfiles {
+		if p.MaxWidth < 0 || p.MaxHeight < 0 || p.MaxFramerate < 0 {
+			return errors.New("negative value in quality profile")
+		}
+		if (p.MaxWidth == 0) != (p.MaxHeight == 0) {
+			return errors.New(
+				"quality profile must specify both " +
+					"max-width and max-height",
+			)
+		}
+		switch p.Simulcast {
+		case "", "on", "off", "auto":
+		default:
+			return errors.New(
+				"bad simulcast policy " + p.Simulcast,
+			)
+		}
+	}
+	return nil
+}
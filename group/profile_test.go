@@ -0,0 +1,48 @@
+package group
+
+import (
+	"testing"
+)
+
+func TestQualityProfile(t *testing.T) {
+	desc := &Description{}
+	if p := desc.Profile(); p != nil {
+		t.Errorf("Expected nil, got %v", p)
+	}
+
+	desc.QualityProfile = "lecture"
+	p := desc.Profile()
+	if p == nil || *p != QualityProfiles["lecture"] {
+		t.Errorf("Expected lecture, got %v", p)
+	}
+
+	desc.QualityProfiles = map[string]QualityProfile{
+		"lecture": {MaxWidth: 320, MaxHeight: 240},
+	}
+	p = desc.Profile()
+	if p == nil || p.MaxWidth != 320 {
+		t.Errorf("Expected custom profile, got %v", p)
+	}
+	if err := checkQualityProfiles(desc); err != nil {
+		t.Errorf("checkQualityProfiles: %v", err)
+	}
+
+	desc.QualityProfile = "unknown"
+	if err := checkQualityProfiles(desc); err == nil {
+		t.Errorf("Unknown profile accepted")
+	}
+
+	bad := []QualityProfile{
+		{MaxWidth: 320},
+		{MaxWidth: -1, MaxHeight: -1},
+		{Simulcast: "maybe"},
+	}
+	for _, b := range bad {
+		desc := &Description{
+			QualityProfiles: map[string]QualityProfile{"bad": b},
+		}
+		if err := checkQualityProfiles(desc); err == nil {
+			t.Errorf("Bad profile %v accepted", b)
+		}
+	}
+}
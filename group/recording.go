@@ -0,0 +1,39 @@
+package group
+
+// RecordingStatus describes a recording in progress.  It is sent
+// periodically to the operators of a group.
+type RecordingStatus struct {
+	// the time since the recording started, in seconds
+	Elapsed int64 `json:"elapsed"`
+	// the number of bytes written to disk
+	Bytes int64 `json:"bytes"`
+	// the number of bytes available on disk, nil if unknown
+	Available *uint64 `json:"available,omitempty"`
+	// the number of files created
+	Segments int64 `json:"segments"`
+	// the number of streams being recorded
+	Streams int `json:"streams"`
+	// true if no data has been written for a while
+	Stalled bool `json:"stalled,omitempty"`
+}
+
+type recorder interface {
+	RecordingStatus(status RecordingStatus) error
+}
+
+// RecordingStatus sends the status of a recording to the members of the
+// group that are interested.
+func (g *Group) RecordingStatus(status RecordingStatus) {
+	clients := g.GetClients(nil)
+	for _, c := range clients {
+		cc, ok := c.(recorder)
+		if !ok {
+			continue
+		}
+		err := cc.RecordingStatus(status)
+		if err != nil {
+			logger.Warn("RecordingStatus", "group", g.Name(),
+				"client", c.Id(), "err", err)
+		}
+	}
+}
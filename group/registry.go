@@ -0,0 +1,137 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A RegisteredUser is an entry in the user registry, which is shared by
+// all groups.
+type RegisteredUser struct {
+	Password    Password    `json:"password"`
+	DisplayName string      `json:"display-name,omitempty"`
+	Avatar      string      `json:"avatar,omitempty"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// the user registry, read from users.json in the data directory
+var registry struct {
+	mu       sync.Mutex
+	modTime  time.Time
+	fileSize int64
+	users    map[string]RegisteredUser
+}
+
+// readRegistry reads the registry of users.  If strict is true, unknown
+// fields are errors.
+func readRegistry(filename string, strict bool) (map[string]RegisteredUser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	if strict {
+		d.DisallowUnknownFields()
+	}
+	var users map[string]RegisteredUser
+	err = d.Decode(&users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CheckRegistry returns an error if the registry of users cannot be
+// parsed.  It returns an error satisfying os.ErrNotExist if there is no
+// registry.
+func CheckRegistry() error {
+	_, err := readRegistry(
+		filepath.Join(DataDirectory, "users.json"), true,
+	)
+	return err
+}
+
+// loadRegistry rereads the registry if it has changed.  Called locked.
+func loadRegistry() error {
+	filename := filepath.Join(DataDirectory, "users.json")
+	fi, err := os.Stat(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			registry.users = nil
+			registry.modTime = time.Time{}
+			registry.fileSize = 0
+			return nil
+		}
+		return err
+	}
+
+	if !registry.modTime.Equal(fi.ModTime()) ||
+		registry.fileSize != fi.Size() {
+		users, err := readRegistry(filename, false)
+		if err != nil {
+			return err
+		}
+		registry.users = users
+		registry.modTime = fi.ModTime()
+		registry.fileSize = fi.Size()
+	}
+	return nil
+}
+
+// GetRegisteredUser returns the registry entry for the given username,
+// or nil if the user is not registered or there is no registry.
+func GetRegisteredUser(username string) (*RegisteredUser, error) {
+	if username == "" {
+		return nil, nil
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	err := loadRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	u, ok := registry.users[username]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+// ReservedUsername returns true if a username may only be used by
+// a registered user, either because it is registered or because it
+// looks like the display name of a registered user.
+func ReservedUsername(username string) (bool, error) {
+	if username == "" {
+		return false, nil
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	err := loadRegistry()
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := registry.users[username]; ok {
+		return true, nil
+	}
+	name := strings.TrimSpace(username)
+	for _, u := range registry.users {
+		if u.DisplayName != "" &&
+			strings.EqualFold(strings.TrimSpace(u.DisplayName), name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
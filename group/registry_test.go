@@ -0,0 +1,103 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var registryJSON = `
+{
+    "alice": {
+        "password": "wonderland",
+        "display-name": "Alice Liddell",
+        "permissions": "present"
+    },
+    "john": {"password": "other"}
+}`
+
+func TestRegistry(t *testing.T) {
+	DataDirectory = t.TempDir()
+	defer func() {
+		DataDirectory = ""
+	}()
+
+	u, err := GetRegisteredUser("alice")
+	if err != nil || u != nil {
+		t.Errorf("Expected nil, got %v %v", u, err)
+	}
+
+	err = os.WriteFile(filepath.Join(DataDirectory, "users.json"),
+		[]byte(registryJSON), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	u, err = GetRegisteredUser("alice")
+	if err != nil || u == nil || u.DisplayName != "Alice Liddell" {
+		t.Errorf("Expected alice, got %v %v", u, err)
+	}
+	u, err = GetRegisteredUser("bob")
+	if err != nil || u != nil {
+		t.Errorf("Expected nil, got %v %v", u, err)
+	}
+
+	var g Group
+	err = json.Unmarshal([]byte(descJSON), &g.description)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	alice := "alice"
+	_, _, err = g.GetPermission(
+		ClientCredentials{Username: &alice, Password: "wonderland"},
+	)
+	if !errors.Is(err, ErrRegisteredUsername) {
+		t.Errorf("Expected ErrRegisteredUsername, got %v", err)
+	}
+
+	// group entries take precedence
+	_, p, err := g.GetPermission(
+		ClientCredentials{Username: &john, Password: "secret"},
+	)
+	if err != nil || !permissionsEqual(p, []string{"present", "message"}) {
+		t.Errorf("Got %v %v", p, err)
+	}
+
+	g.description.AllowRegisteredUsers = true
+	_, p, err = g.GetPermission(
+		ClientCredentials{Username: &alice, Password: "wonderland"},
+	)
+	if err != nil || !permissionsEqual(p, []string{"present", "message"}) {
+		t.Errorf("Got %v %v", p, err)
+	}
+	var autherr *NotAuthorisedError
+	_, _, err = g.GetPermission(
+		ClientCredentials{Username: &alice, Password: "wrong"},
+	)
+	if !errors.As(err, &autherr) {
+		t.Errorf("Expected NotAuthorisedError, got %v", err)
+	}
+
+	// the display names of registered users may not be used as
+	// usernames by other users
+	for _, name := range []string{"Alice Liddell", "alice liddell "} {
+		n := name
+		_, _, err = g.GetPermission(
+			ClientCredentials{Username: &n, Password: "any"},
+		)
+		if !errors.Is(err, ErrRegisteredUsername) {
+			t.Errorf("%q: expected ErrRegisteredUsername, got %v",
+				name, err)
+		}
+	}
+	bob := "bob"
+	_, _, err = g.GetPermission(
+		ClientCredentials{Username: &bob, Password: "any"},
+	)
+	if err != nil {
+		t.Errorf("bob: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package group
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TalkTime is the total time during which a user has been speaking.
+// Anonymous users are identified by their client id.
+type TalkTime struct {
+	Username string        `json:"username,omitempty"`
+	Id       string        `json:"id,omitempty"`
+	Time     time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+}
+
+type talkTime struct {
+	mu sync.Mutex
+	// indexed by username, or by client id for anonymous users
+	users map[string]*TalkTime
+	// the talk time of the current session of each client
+	clients map[string]time.Duration
+}
+
+func talkTimeKey(c Client) string {
+	if c.Username() != "" {
+		return "u:" + c.Username()
+	}
+	return "c:" + c.Id()
+}
+
+// AddTalkTime records that a client has been speaking for a given
+// duration.
+func (g *Group) AddTalkTime(c Client, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if g.GetClient(c.Id()) != c {
+		// the client has already left, and its session has been
+		// logged
+		return
+	}
+	t := &g.talkTime
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.users == nil {
+		t.users = make(map[string]*TalkTime)
+		t.clients = make(map[string]time.Duration)
+	}
+	k := talkTimeKey(c)
+	u := t.users[k]
+	if u == nil {
+		u = &TalkTime{Username: c.Username()}
+		if u.Username == "" {
+			u.Id = c.Id()
+		}
+		t.users[k] = u
+	}
+	u.Time += d
+	t.clients[c.Id()] += d
+}
+
+// endTalkTime returns the talk time of a client's session, and forgets
+// about the session.  The users' totals are kept.
+func (g *Group) endTalkTime(c Client) time.Duration {
+	t := &g.talkTime
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.clients[c.Id()]
+	delete(t.clients, c.Id())
+	return d
+}
+
+// GetTalkTime returns the total talk time of every user that has spoken
+// in the group, most talkative first.
+func (g *Group) GetTalkTime() []TalkTime {
+	t := &g.talkTime
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := make([]TalkTime, 0, len(t.users))
+	for _, u := range t.users {
+		uu := *u
+		uu.Seconds = uu.Time.Seconds()
+		users = append(users, uu)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Time > users[j].Time
+	})
+	return users
+}
+
+// logTalkTime records the talk time of a client's session in the usage
+// log, if the client has spoken.
+func (g *Group) logTalkTime(c Client) {
+	d := g.endTalkTime(c)
+	if d <= 0 {
+		return
+	}
+	err := g.LogUsage("talktime", TalkTime{
+		Username: c.Username(),
+		Id:       c.Id(),
+		Seconds:  d.Seconds(),
+	})
+	if err != nil {
+		logger.Warn("Log talk time", "group", g.Name(), "err", err)
+	}
+}
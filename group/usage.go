@@ -0,0 +1,72 @@
+package group
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/store"
+)
+
+// usageEntry is a line of a group's usage log.
+type usageEntry struct {
+	Time  time.Time   `json:"time"`
+	Group string      `json:"group"`
+	Kind  string      `json:"kind"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+var usageMu sync.Mutex
+
+// usageFilename returns the name of the file containing the usage log
+// of a given group.
+func usageFilename(group string) string {
+	return filepath.Join(
+		DataDirectory, "var", "usage",
+		filepath.FromSlash(path.Clean("/"+group))+".jsonl",
+	)
+}
+
+// LogUsage appends an entry to the group's usage log.  The usage log is
+// a JSONL file stored under var/usage in the data directory, or a table
+// in the database if one is open, and is intended to be processed by
+// external tools.
+func (g *Group) LogUsage(kind string, value interface{}) error {
+	if store.Enabled() {
+		var v []byte
+		if value != nil {
+			var err error
+			v, err = json.Marshal(value)
+			if err != nil {
+				return err
+			}
+		}
+		return store.LogUsage(g.Name(), time.Now(), kind, v)
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	filename := usageFilename(g.Name())
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename,
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(usageEntry{
+		Time:  time.Now(),
+		Group: g.Name(),
+		Kind:  kind,
+		Value: value,
+	})
+}
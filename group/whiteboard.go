@@ -0,0 +1,148 @@
+package group
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// A WhiteboardOp is an operation on a group's whiteboard.  Kind is one
+// of "draw", which adds the drawing operation in Value to the board,
+// "undo", which removes the drawing operation with sequence number
+// Target, or "clear".
+type WhiteboardOp struct {
+	Seq      uint64
+	Kind     string
+	Source   string
+	Username *string
+	Time     time.Time
+	Target   uint64
+	Value    interface{}
+
+	// the size of Value, in bytes
+	size int
+}
+
+const maxWhiteboardOps = 10000
+
+// the maximum size of the value of a single operation, and of all the
+// operations on the board, in bytes
+const maxWhiteboardOpSize = 64 * 1024
+const maxWhiteboardSize = 16 * 1024 * 1024
+
+type whiteboard struct {
+	// the sequence number of the last operation
+	seq uint64
+	// the drawing operations currently on the board
+	ops []WhiteboardOp
+	// the total size of ops
+	size int
+
+	// held while operations are being delivered, so that they are
+	// delivered in order of sequence number
+	sendMu sync.Mutex
+}
+
+type whiteboarder interface {
+	Whiteboard(op WhiteboardOp) error
+}
+
+// Whiteboard applies an operation to the group's whiteboard and sends it
+// to all members of the group.  The fields Seq and Time are set by this
+// function.  Only privileged clients may clear the whiteboard or undo
+// operations performed by other clients.
+func (g *Group) Whiteboard(op WhiteboardOp, privileged bool) error {
+	op.size = 0
+	if op.Value != nil {
+		v, err := json.Marshal(op.Value)
+		if err != nil {
+			return ProtocolError("bad whiteboard operation")
+		}
+		op.size = len(v)
+		if op.size > maxWhiteboardOpSize {
+			return UserError("whiteboard operation too large")
+		}
+	}
+
+	g.mu.Lock()
+	if g.description.Echo {
+		// the whiteboard is shared by all the members of the group
+		g.mu.Unlock()
+		return UserError("the whiteboard is disabled in echo groups")
+	}
+	w := &g.whiteboard
+	switch op.Kind {
+	case "draw":
+		if len(w.ops) >= maxWhiteboardOps ||
+			w.size+op.size > maxWhiteboardSize {
+			g.mu.Unlock()
+			return UserError("the whiteboard is full")
+		}
+	case "undo":
+		i := -1
+		for j, o := range w.ops {
+			if o.Seq == op.Target {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			g.mu.Unlock()
+			return UserError("unknown whiteboard operation")
+		}
+		if !privileged && w.ops[i].Source != op.Source {
+			g.mu.Unlock()
+			return UserError("not authorised")
+		}
+		w.size -= w.ops[i].size
+		w.ops = append(w.ops[:i], w.ops[i+1:]...)
+	case "clear":
+		if !privileged {
+			g.mu.Unlock()
+			return UserError("not authorised")
+		}
+		w.ops = nil
+		w.size = 0
+	default:
+		g.mu.Unlock()
+		return ProtocolError("unknown whiteboard operation")
+	}
+	w.seq++
+	op.Seq = w.seq
+	op.Time = time.Now()
+	if op.Kind == "draw" {
+		w.ops = append(w.ops, op)
+		w.size += op.size
+	}
+	clients := g.getRecipientsUnlocked(op.Source, nil)
+	// take the delivery lock before releasing the group lock, so that
+	// concurrent operations are delivered in order
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	g.mu.Unlock()
+
+	for _, c := range clients {
+		cc, ok := c.(whiteboarder)
+		if !ok {
+			continue
+		}
+		err := cc.Whiteboard(op)
+		if err != nil {
+			logger.Warn("Whiteboard", "group", g.Name(),
+				"client", c.Id(), "err", err)
+		}
+	}
+	return nil
+}
+
+// GetWhiteboard returns the drawing operations currently on the
+// whiteboard, together with the sequence number of the last operation.
+// Operations with a sequence number lower or equal to the one returned
+// are already accounted for.
+func (g *Group) GetWhiteboard() (uint64, []WhiteboardOp) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ops := make([]WhiteboardOp, len(g.whiteboard.ops))
+	copy(ops, g.whiteboard.ops)
+	return g.whiteboard.seq, ops
+}
@@ -0,0 +1,161 @@
+package group
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWhiteboard(t *testing.T) {
+	g := Group{
+		description: &Description{},
+	}
+	for i := 0; i < 3; i++ {
+		err := g.Whiteboard(WhiteboardOp{
+			Kind: "draw", Source: "a", Value: i,
+		}, false)
+		if err != nil {
+			t.Fatalf("Draw: %v", err)
+		}
+	}
+	err := g.Whiteboard(WhiteboardOp{
+		Kind: "draw", Source: "b", Value: 3,
+	}, false)
+	if err != nil {
+		t.Fatalf("Draw: %v", err)
+	}
+
+	seq, ops := g.GetWhiteboard()
+	if seq != 4 || len(ops) != 4 {
+		t.Fatalf("Got %v %v", seq, ops)
+	}
+
+	err = g.Whiteboard(WhiteboardOp{
+		Kind: "undo", Source: "a", Target: 4,
+	}, false)
+	if err == nil {
+		t.Errorf("Undid another client's operation")
+	}
+	err = g.Whiteboard(WhiteboardOp{
+		Kind: "undo", Source: "a", Target: 2,
+	}, false)
+	if err != nil {
+		t.Errorf("Undo: %v", err)
+	}
+	err = g.Whiteboard(WhiteboardOp{
+		Kind: "undo", Source: "a", Target: 2,
+	}, false)
+	if err == nil {
+		t.Errorf("Undid twice")
+	}
+
+	seq, ops = g.GetWhiteboard()
+	if seq != 5 || len(ops) != 3 {
+		t.Fatalf("Got %v %v", seq, ops)
+	}
+	for i, v := range []int{0, 2, 3} {
+		if ops[i].Value.(int) != v {
+			t.Errorf("Expected %v, got %v", v, ops[i].Value)
+		}
+	}
+
+	err = g.Whiteboard(WhiteboardOp{Kind: "clear", Source: "a"}, false)
+	if err == nil {
+		t.Errorf("Unprivileged clear")
+	}
+	err = g.Whiteboard(WhiteboardOp{Kind: "clear", Source: "a"}, true)
+	if err != nil {
+		t.Errorf("Clear: %v", err)
+	}
+	seq, ops = g.GetWhiteboard()
+	if seq != 6 || len(ops) != 0 {
+		t.Errorf("Got %v %v", seq, ops)
+	}
+
+	err = g.Whiteboard(WhiteboardOp{Kind: "bogus"}, true)
+	if err == nil {
+		t.Errorf("Accepted unknown operation")
+	}
+}
+
+type whiteboardClient struct {
+	publisherClient
+	mu   sync.Mutex
+	seqs []uint64
+}
+
+func (c *whiteboardClient) Whiteboard(op WhiteboardOp) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seqs = append(c.seqs, op.Seq)
+	return nil
+}
+
+func TestWhiteboardOrder(t *testing.T) {
+	c := &whiteboardClient{publisherClient: publisherClient{id: "c"}}
+	g := Group{
+		description: &Description{},
+		clients:     map[string]Client{"c": c},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				g.Whiteboard(WhiteboardOp{
+					Kind: "draw", Source: "a", Value: j,
+				}, false)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(c.seqs) != 800 {
+		t.Fatalf("Got %v operations", len(c.seqs))
+	}
+	for i, s := range c.seqs {
+		if s != uint64(i+1) {
+			t.Fatalf("Operation %v has seqno %v", i, s)
+		}
+	}
+}
+
+func TestWhiteboardSize(t *testing.T) {
+	g := Group{
+		description: &Description{},
+	}
+	err := g.Whiteboard(WhiteboardOp{
+		Kind: "draw", Source: "a",
+		Value: strings.Repeat("x", maxWhiteboardOpSize),
+	}, false)
+	if err == nil {
+		t.Errorf("Accepted large operation")
+	}
+
+	v := strings.Repeat("x", maxWhiteboardOpSize-100)
+	n := 0
+	for {
+		err := g.Whiteboard(WhiteboardOp{
+			Kind: "draw", Source: "a", Value: v,
+		}, false)
+		if err != nil {
+			break
+		}
+		n++
+	}
+	if n == 0 || n*len(v) > maxWhiteboardSize {
+		t.Errorf("Accepted %v operations", n)
+	}
+
+	err = g.Whiteboard(WhiteboardOp{Kind: "clear", Source: "a"}, true)
+	if err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	err = g.Whiteboard(WhiteboardOp{
+		Kind: "draw", Source: "a", Value: v,
+	}, false)
+	if err != nil {
+		t.Errorf("Draw after clear: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package ice
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GeoIPFilename is the name of a CSV file mapping client addresses to
+// the region tags of ICE servers.
+var GeoIPFilename string
+
+type geoIPRange struct {
+	start, end netip.Addr
+	region     string
+}
+
+// A geoIPDatabase is a sorted list of non-overlapping address ranges.
+type geoIPDatabase struct {
+	modTime time.Time
+	size    int64
+	ranges  []geoIPRange
+}
+
+// lastAddr returns the last address of a prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(a)*8; i++ {
+		a[i/8] |= 0x80 >> (i % 8)
+	}
+	b, _ := netip.AddrFromSlice(a)
+	return b
+}
+
+// parseGeoIPRecord parses a record of a GeoIP database, which is either
+// of the form "prefix,region" or "first,last,region".
+func parseGeoIPRecord(record []string) (geoIPRange, error) {
+	if len(record) == 2 {
+		p, err := netip.ParsePrefix(strings.TrimSpace(record[0]))
+		if err != nil {
+			return geoIPRange{}, err
+		}
+		return geoIPRange{
+			start:  p.Masked().Addr().Unmap(),
+			end:    lastAddr(p).Unmap(),
+			region: strings.TrimSpace(record[1]),
+		}, nil
+	} else if len(record) == 3 {
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			return geoIPRange{}, err
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return geoIPRange{}, err
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return geoIPRange{}, errors.New("bad range")
+		}
+		return geoIPRange{
+			start:  start,
+			end:    end,
+			region: strings.TrimSpace(record[2]),
+		}, nil
+	}
+	return geoIPRange{}, errors.New("wrong number of fields")
+}
+
+func readGeoIPDatabase(r io.Reader) (*geoIPDatabase, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	var db geoIPDatabase
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		rg, err := parseGeoIPRecord(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %v: %w", line, err)
+		}
+		db.ranges = append(db.ranges, rg)
+	}
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	// lookup only examines the range with the closest start
+	for i := 1; i < len(db.ranges); i++ {
+		prev, cur := db.ranges[i-1], db.ranges[i]
+		if !prev.end.Less(cur.start) {
+			return nil, fmt.Errorf(
+				"range %v-%v overlaps %v-%v",
+				cur.start, cur.end, prev.start, prev.end,
+			)
+		}
+	}
+	return &db, nil
+}
+
+// loadGeoIPDatabase loads the GeoIP database, reusing old if the file
+// hasn't changed.  It returns nil if there is no database.
+func loadGeoIPDatabase(old *geoIPDatabase) *geoIPDatabase {
+	if GeoIPFilename == "" {
+		return nil
+	}
+	file, err := os.Open(GeoIPFilename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Open GeoIP database",
+				"file", GeoIPFilename, "err", err)
+		}
+		return nil
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		logger.Warn("Stat GeoIP database",
+			"file", GeoIPFilename, "err", err)
+		return old
+	}
+	if old != nil && old.modTime.Equal(fi.ModTime()) &&
+		old.size == fi.Size() {
+		return old
+	}
+
+	db, err := readGeoIPDatabase(file)
+	if err != nil {
+		logger.Warn("Read GeoIP database",
+			"file", GeoIPFilename, "err", err)
+		return old
+	}
+	db.modTime = fi.ModTime()
+	db.size = fi.Size()
+	return db
+}
+
+// CheckGeoIPDatabase returns an error if the GeoIP database cannot be
+// parsed.  It returns an error satisfying os.ErrNotExist if there is no
+// database.
+func CheckGeoIPDatabase() error {
+	if GeoIPFilename == "" {
+		return os.ErrNotExist
+	}
+	file, err := os.Open(GeoIPFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = readGeoIPDatabase(file)
+	if err != nil {
+		return fmt.Errorf("%v: %w", GeoIPFilename, err)
+	}
+	return nil
+}
+
+// lookup returns the region of an address, or the empty string if it is
+// not known.
+func (db *geoIPDatabase) lookup(addr netip.Addr) string {
+	if db == nil || !addr.IsValid() {
+		return ""
+	}
+	addr = addr.Unmap()
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	})
+	if i == 0 {
+		return ""
+	}
+	rg := db.ranges[i-1]
+	if rg.end.Less(addr) || rg.start.Is4() != addr.Is4() {
+		return ""
+	}
+	return rg.region
+}
+
+func ipAddr(a net.Addr) netip.Addr {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort().Addr()
+	case *net.UDPAddr:
+		return a.AddrPort().Addr()
+	}
+	if a == nil {
+		return netip.Addr{}
+	}
+	ap, err := netip.ParseAddrPort(a.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr()
+}
@@ -7,7 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"net"
 	"os"
 	"strings"
 	"sync/atomic"
@@ -15,9 +15,12 @@ import (
 
 	"github.com/pion/webrtc/v3"
 
+	"github.com/jech/galene/logging"
 	"github.com/jech/galene/turnserver"
 )
 
+var logger = logging.New("ice")
+
 type timeoutError struct{}
 
 func (e timeoutError) Error() string {
@@ -35,6 +38,7 @@ type Server struct {
 	Username       string      `json:"username,omitempty"`
 	Credential     interface{} `json:"credential,omitempty"`
 	CredentialType string      `json:"credentialType,omitempty"`
+	Region         string      `json:"region,omitempty"`
 }
 
 func getServer(server Server) (webrtc.ICEServer, error) {
@@ -76,12 +80,68 @@ func getServer(server Server) (webrtc.ICEServer, error) {
 	return s, nil
 }
 
+// CheckServer returns an error if a server is not usable.
+func CheckServer(server Server) error {
+	if len(server.URLs) == 0 {
+		return errors.New("no URLs")
+	}
+	_, err := getServer(server)
+	return err
+}
+
 var ICEFilename string
 var ICERelayOnly bool
 
+// readServers reads a list of ICE servers.  If strict is true, unknown
+// fields are errors.
+func readServers(filename string, strict bool) ([]Server, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	d := json.NewDecoder(file)
+	if strict {
+		d.DisallowUnknownFields()
+	}
+	var servers []Server
+	err = d.Decode(&servers)
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// CheckServers returns an error if the file of ICE servers cannot be
+// parsed or contains an unusable server.  It returns an error satisfying
+// os.ErrNotExist if there is no such file.
+func CheckServers() error {
+	if ICEFilename == "" {
+		return os.ErrNotExist
+	}
+	servers, err := readServers(ICEFilename, true)
+	if err != nil {
+		return err
+	}
+	for i, s := range servers {
+		err := CheckServer(s)
+		if err != nil {
+			return fmt.Errorf("ICE server %v: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ICEServers, if not nil, is used instead of the contents of ICEFilename.
+var ICEServers []Server
+
 type configuration struct {
 	conf      webrtc.Configuration
 	timestamp time.Time
+
+	// the region of each server in conf, or the empty string
+	regions []string
+	geoip   *geoIPDatabase
 }
 
 var conf atomic.Value
@@ -89,67 +149,149 @@ var conf atomic.Value
 func Update() *configuration {
 	now := time.Now()
 	var cf webrtc.Configuration
+	var regions []string
 
 	found := false
-	if ICEFilename != "" {
+	if ICEServers != nil {
 		found = true
-		file, err := os.Open(ICEFilename)
+		for _, s := range ICEServers {
+			ss, err := getServer(s)
+			if err != nil {
+				logger.Warn("Parse ICE server", "err", err)
+				continue
+			}
+			cf.ICEServers = append(cf.ICEServers, ss)
+			regions = append(regions, s.Region)
+		}
+	} else if ICEFilename != "" {
+		found = true
+		servers, err := readServers(ICEFilename, false)
 		if err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
-				log.Printf("Open %v: %v", ICEFilename, err)
+				logger.Warn("Get ICE configuration",
+					"file", ICEFilename, "err", err)
 			} else {
 				found = false
 			}
 		} else {
-			defer file.Close()
-			d := json.NewDecoder(file)
-			var servers []Server
-			err = d.Decode(&servers)
-			if err != nil {
-				log.Printf("Get ICE configuration: %v", err)
-			}
 			for _, s := range servers {
 				ss, err := getServer(s)
 				if err != nil {
-					log.Printf("parse ICE server: %v", err)
+					logger.Warn("Parse ICE server", "err", err)
 					continue
 				}
 				cf.ICEServers = append(cf.ICEServers, ss)
+				regions = append(regions, s.Region)
 			}
 		}
 	}
 
 	err := turnserver.StartStop(!found)
 	if err != nil {
-		log.Printf("TURN: %v", err)
+		logger.Warn("TURN", "err", err)
 	}
 
 	cf.ICEServers = append(cf.ICEServers, turnserver.ICEServers()...)
+	for len(regions) < len(cf.ICEServers) {
+		regions = append(regions, "")
+	}
 
 	if ICERelayOnly {
 		cf.ICETransportPolicy = webrtc.ICETransportPolicyRelay
 	}
 
+	var geoip *geoIPDatabase
+	if old, ok := conf.Load().(*configuration); ok {
+		geoip = old.geoip
+	}
+
 	iceConf := configuration{
 		conf:      cf,
 		timestamp: now,
+		regions:   regions,
+		geoip:     loadGeoIPDatabase(geoip),
 	}
 	conf.Store(&iceConf)
 	return &iceConf
 }
 
-func ICEConfiguration() *webrtc.Configuration {
+func getConfiguration() *configuration {
 	conf, ok := conf.Load().(*configuration)
 	if !ok || time.Since(conf.timestamp) > 5*time.Minute {
 		conf = Update()
 	} else if time.Since(conf.timestamp) > 2*time.Minute {
 		go Update()
 	}
+	return conf
+}
+
+func ICEConfiguration() *webrtc.Configuration {
+	return &getConfiguration().conf
+}
+
+// ICEConfigurationFor returns the ICE configuration to advertise to
+// a client with the given address.  If the client's region is known from
+// the GeoIP database, and some servers are tagged with that region, then
+// servers tagged with a different region are omitted.
+func ICEConfigurationFor(addr net.Addr) *webrtc.Configuration {
+	conf := getConfiguration()
+	region := conf.geoip.lookup(ipAddr(addr))
+	if region == "" {
+		return &conf.conf
+	}
+	return conf.forRegion(region)
+}
+
+func (conf *configuration) forRegion(region string) *webrtc.Configuration {
+	found := false
+	for _, r := range conf.regions {
+		if r == region {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return &conf.conf
+	}
+
+	cf := conf.conf
+	cf.ICEServers = nil
+	for i, s := range conf.conf.ICEServers {
+		if conf.regions[i] == "" || conf.regions[i] == region {
+			cf.ICEServers = append(cf.ICEServers, s)
+		}
+	}
+	return &cf
+}
 
-	return &conf.conf
+// RelayTestResult is the result of the most recent relay test.
+type RelayTestResult struct {
+	Time  time.Time
+	RTT   time.Duration
+	Error error
 }
 
+var lastRelayTest atomic.Pointer[RelayTestResult]
+
+// LastRelayTest returns the result of the most recent call to RelayTest,
+// or nil if no test has been performed.
+func LastRelayTest() *RelayTestResult {
+	return lastRelayTest.Load()
+}
+
+// RelayTest checks that media can flow through the configured TURN
+// servers, and returns the round-trip time.
 func RelayTest(timeout time.Duration) (time.Duration, error) {
+	d, err := relayTest(timeout)
+	lastRelayTest.Store(&RelayTestResult{
+		Time:  time.Now(),
+		RTT:   d,
+		Error: err,
+	})
+	return d, err
+}
+
+func relayTest(timeout time.Duration) (time.Duration, error) {
 
 	conf := ICEConfiguration()
 	conf2 := *conf
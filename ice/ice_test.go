@@ -4,7 +4,9 @@ import (
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -85,6 +87,46 @@ func TestICEConfiguration(t *testing.T) {
 	}
 }
 
+func TestCheckServers(t *testing.T) {
+	defer func(f string) { ICEFilename = f }(ICEFilename)
+	ICEFilename = "/tmp/no/such/file"
+	err := CheckServers()
+	if !os.IsNotExist(err) {
+		t.Errorf("CheckServers: %v", err)
+	}
+
+	ICEFilename = filepath.Join(t.TempDir(), "ice-servers.json")
+	for _, tt := range []struct {
+		data string
+		ok   bool
+	}{
+		{`[{"urls": ["stun:stun.example.org"]}]`, true},
+		{`[{"urls": ["stun:stun.example.org"], "foo": 1}]`, false},
+		{`[{"urls": []}]`, false},
+	} {
+		err := os.WriteFile(ICEFilename, []byte(tt.data), 0o600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		err = CheckServers()
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: got %v", tt.data, err)
+		}
+	}
+
+	// the server ignores unknown fields
+	err = os.WriteFile(ICEFilename,
+		[]byte(`[{"urls": ["stun:stun.example.org"], "foo": 1}]`),
+		0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	servers, err := readServers(ICEFilename, false)
+	if err != nil || len(servers) != 1 {
+		t.Errorf("readServers: got %v %v", servers, err)
+	}
+}
+
 func TestRelayTest(t *testing.T) {
 	ICEFilename = "/tmp/no/such/file"
 	turnserver.Address = ""
@@ -94,3 +136,95 @@ func TestRelayTest(t *testing.T) {
 		t.Errorf("Relay test returned %v", err)
 	}
 }
+
+func TestGeoIP(t *testing.T) {
+	db, err := readGeoIPDatabase(strings.NewReader(`# test
+192.0.2.0/24,eu
+198.51.100.0,198.51.100.127,us
+2001:db8::/32,asia
+`))
+	if err != nil {
+		t.Fatalf("readGeoIPDatabase: %v", err)
+	}
+
+	tests := []struct {
+		addr, region string
+	}{
+		{"192.0.2.0", "eu"},
+		{"192.0.2.255", "eu"},
+		{"::ffff:192.0.2.1", "eu"},
+		{"192.0.3.0", ""},
+		{"198.51.100.127", "us"},
+		{"198.51.100.128", ""},
+		{"2001:db8:1::1", "asia"},
+		{"2001:db9::1", ""},
+		{"10.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		r := db.lookup(netip.MustParseAddr(tt.addr))
+		if r != tt.region {
+			t.Errorf("%v: got %v, expected %v", tt.addr, r, tt.region)
+		}
+	}
+
+	_, err = readGeoIPDatabase(strings.NewReader("192.0.2.0,eu\n"))
+	if err == nil {
+		t.Errorf("Bad prefix accepted")
+	}
+
+	overlapping := []string{
+		"192.0.2.0/24,eu\n192.0.2.128/25,us\n",
+		"192.0.2.0,192.0.2.10,eu\n192.0.2.10,192.0.2.20,us\n",
+		"2001:db8::/32,asia\n2001:db8:1::/48,eu\n",
+	}
+	for _, o := range overlapping {
+		_, err = readGeoIPDatabase(strings.NewReader(o))
+		if err == nil {
+			t.Errorf("Overlapping ranges accepted: %q", o)
+		}
+	}
+
+	_, err = readGeoIPDatabase(strings.NewReader(
+		"192.0.2.0,192.0.2.10,eu\n192.0.2.11,192.0.2.20,us\n",
+	))
+	if err != nil {
+		t.Errorf("Adjacent ranges: %v", err)
+	}
+}
+
+func TestForRegion(t *testing.T) {
+	conf := configuration{
+		conf: webrtc.Configuration{
+			ICEServers: []webrtc.ICEServer{
+				{URLs: []string{"turn:eu.example.org"}},
+				{URLs: []string{"turn:us.example.org"}},
+				{URLs: []string{"turn:turn.example.org"}},
+			},
+		},
+		regions: []string{"eu", "us", ""},
+	}
+
+	servers := func(cf *webrtc.Configuration) []string {
+		var s []string
+		for _, server := range cf.ICEServers {
+			s = append(s, server.URLs...)
+		}
+		return s
+	}
+
+	eu := servers(conf.forRegion("eu"))
+	if !reflect.DeepEqual(eu, []string{
+		"turn:eu.example.org", "turn:turn.example.org",
+	}) {
+		t.Errorf("eu: got %v", eu)
+	}
+
+	asia := servers(conf.forRegion("asia"))
+	if len(asia) != 3 {
+		t.Errorf("asia: got %v", asia)
+	}
+
+	if len(conf.conf.ICEServers) != 3 {
+		t.Errorf("Configuration was modified")
+	}
+}
@@ -0,0 +1,213 @@
+// Package logging implements leveled, structured logging.  Every
+// subsystem has its own logger, the verbosity of which may be set
+// independently.
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var output atomic.Pointer[slog.Handler]
+
+var levels struct {
+	mu         sync.Mutex
+	defaultLvl slog.LevelVar
+	subsystems map[string]*slog.LevelVar
+}
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stderr,
+		&slog.HandlerOptions{Level: slog.LevelDebug},
+	)
+	output.Store(&h)
+}
+
+// Setup sets the format of the logs, either "text" or "json", and the
+// writer they are sent to.
+func Setup(format string, w io.Writer) error {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return errors.New("unknown log format " + format)
+	}
+	output.Store(&h)
+
+	// the standard logger is used by our dependencies
+	log.SetFlags(0)
+	log.SetOutput(&writer{New("")})
+	return nil
+}
+
+func levelVar(subsystem string) *slog.LevelVar {
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+	if subsystem == "" {
+		return &levels.defaultLvl
+	}
+	if levels.subsystems == nil {
+		levels.subsystems = make(map[string]*slog.LevelVar)
+	}
+	l := levels.subsystems[subsystem]
+	if l == nil {
+		l = new(slog.LevelVar)
+		l.Set(levels.defaultLvl.Level())
+		levels.subsystems[subsystem] = l
+	}
+	return l
+}
+
+// SetLevels parses a comma-separated list of levels, either of the form
+// "level", which sets the default level, or "subsystem=level".  For
+// example, "warn,rtpconn=debug" logs warnings and errors, except for
+// the subsystem rtpconn, for which everything is logged.
+func SetLevels(spec string) error {
+	var def *slog.Level
+	subsystems := make(map[string]slog.Level)
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		name, value, found := strings.Cut(s, "=")
+		if !found {
+			value = name
+			name = ""
+		}
+		var l slog.Level
+		err := l.UnmarshalText([]byte(value))
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			def = &l
+		} else {
+			subsystems[name] = l
+		}
+	}
+
+	if def != nil {
+		levels.mu.Lock()
+		levels.defaultLvl.Set(*def)
+		for name, l := range levels.subsystems {
+			if _, ok := subsystems[name]; !ok {
+				l.Set(*def)
+			}
+		}
+		levels.mu.Unlock()
+	}
+	for name, l := range subsystems {
+		levelVar(name).Set(l)
+	}
+	return nil
+}
+
+// handler filters records according to the level of a subsystem, and
+// passes them to the current output handler.
+type handler struct {
+	level *slog.LevelVar
+	// attributes and groups are recorded and applied lazily, since
+	// the output handler may change after the logger is created.
+	apply func(slog.Handler) slog.Handler
+	cache atomic.Pointer[cachedHandler]
+
+	// the same attributes, flattened, for the ring of recent records
+	attrs  []slog.Attr
+	prefix string
+}
+
+// cachedHandler is the result of applying a handler's attributes to
+// a given output handler.
+type cachedHandler struct {
+	output  *slog.Handler
+	handler slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) inner() slog.Handler {
+	out := output.Load()
+	if h.apply == nil {
+		return *out
+	}
+	c := h.cache.Load()
+	if c != nil && c.output == out {
+		return c.handler
+	}
+	c = &cachedHandler{output: out, handler: h.apply(*out)}
+	h.cache.Store(c)
+	return c.handler
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	record(r, h.prefix, h.attrs)
+	return h.inner().Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	apply := h.apply
+	with := append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		with = append(with, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &handler{
+		level: h.level,
+		apply: func(out slog.Handler) slog.Handler {
+			if apply != nil {
+				out = apply(out)
+			}
+			return out.WithAttrs(attrs)
+		},
+		attrs:  with,
+		prefix: h.prefix,
+	}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	apply := h.apply
+	return &handler{
+		level: h.level,
+		apply: func(out slog.Handler) slog.Handler {
+			if apply != nil {
+				out = apply(out)
+			}
+			return out.WithGroup(name)
+		},
+		attrs:  h.attrs,
+		prefix: h.prefix + name + ".",
+	}
+}
+
+// New returns the logger for the given subsystem.  The logger may be
+// created before Setup is called.
+func New(subsystem string) *slog.Logger {
+	l := slog.New(&handler{level: levelVar(subsystem)})
+	if subsystem != "" {
+		l = l.With("subsystem", subsystem)
+	}
+	return l
+}
+
+// writer adapts the standard logger to slog.
+type writer struct {
+	logger *slog.Logger
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.logger.Info(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSetLevels(t *testing.T) {
+	defer SetLevels("info")
+
+	err := SetLevels("warn,test1=debug")
+	if err != nil {
+		t.Fatalf("SetLevels: %v", err)
+	}
+	if l := levelVar("test1").Level(); l != slog.LevelDebug {
+		t.Errorf("test1: got %v", l)
+	}
+	if l := levelVar("test2").Level(); l != slog.LevelWarn {
+		t.Errorf("test2: got %v", l)
+	}
+
+	err = SetLevels("error")
+	if err != nil {
+		t.Fatalf("SetLevels: %v", err)
+	}
+	if l := levelVar("test1").Level(); l != slog.LevelError {
+		t.Errorf("test1: got %v", l)
+	}
+
+	err = SetLevels("test1=verbose")
+	if err == nil {
+		t.Errorf("SetLevels succeeded with unknown level")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	defer Setup("text", os.Stderr)
+	defer SetLevels("info")
+
+	logger := New("test3").With("client", "abc")
+
+	var buf bytes.Buffer
+	err := Setup("json", &buf)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	SetLevels("warn")
+
+	logger.Info("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("Info was logged: %v", buf.String())
+	}
+
+	logger.Warn("shown", "conn", "def")
+	var m map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &m)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if m["msg"] != "shown" || m["level"] != "WARN" ||
+		m["subsystem"] != "test3" || m["client"] != "abc" ||
+		m["conn"] != "def" {
+		t.Errorf("Got %v", m)
+	}
+
+	err = Setup("xml", &buf)
+	if err == nil {
+		t.Errorf("Setup succeeded with unknown format")
+	}
+	if !strings.Contains(err.Error(), "xml") {
+		t.Errorf("Got %v", err)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	defer Setup("text", os.Stderr)
+	defer SetLevels("info")
+
+	var buf bytes.Buffer
+	Setup("text", &buf)
+	SetLevels("warn")
+
+	logger := New("test4").With("client", "abc")
+	logger.Info("hidden")
+	logger.WithGroup("g").Warn("recent", "conn", "def")
+
+	entries := Recent()
+	if len(entries) == 0 {
+		t.Fatalf("No recent entries")
+	}
+	e := entries[len(entries)-1]
+	if e.Message != "recent" || e.Level != "WARN" ||
+		e.Attrs["subsystem"] != "test4" || e.Attrs["client"] != "abc" ||
+		e.Attrs["g.conn"] != "def" {
+		t.Errorf("Got %v", e)
+	}
+
+	for i := 0; i < recentSize+10; i++ {
+		logger.Warn("filler", "i", i)
+	}
+	entries = Recent()
+	if len(entries) != recentSize {
+		t.Errorf("Expected %v entries, got %v", recentSize, len(entries))
+	}
+	if entries[len(entries)-1].Attrs["i"] != fmt.Sprint(recentSize+9) {
+		t.Errorf("Last entry is %v", entries[len(entries)-1])
+	}
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// the number of log records kept in memory
+const recentSize = 1000
+
+// A RecentEntry is a log record kept in memory for diagnostics.
+type RecentEntry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+var recent struct {
+	mu      sync.Mutex
+	entries []RecentEntry
+	next    int
+}
+
+func addAttr(attrs map[string]string, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		for _, aa := range a.Value.Group() {
+			addAttr(attrs, prefix+a.Key+".", aa)
+		}
+		return
+	}
+	attrs[prefix+a.Key] = a.Value.String()
+}
+
+// record stores a log record in the ring of recent records.
+func record(r slog.Record, prefix string, with []slog.Attr) {
+	e := RecentEntry{
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Message: r.Message,
+	}
+	if len(with)+r.NumAttrs() > 0 {
+		e.Attrs = make(map[string]string)
+		for _, a := range with {
+			addAttr(e.Attrs, "", a)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			addAttr(e.Attrs, prefix, a)
+			return true
+		})
+	}
+
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	if len(recent.entries) < recentSize {
+		recent.entries = append(recent.entries, e)
+		return
+	}
+	recent.entries[recent.next] = e
+	recent.next = (recent.next + 1) % recentSize
+}
+
+// Recent returns the most recent log records, oldest first.
+func Recent() []RecentEntry {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	entries := make([]RecentEntry, 0, len(recent.entries))
+	entries = append(entries, recent.entries[recent.next:]...)
+	entries = append(entries, recent.entries[:recent.next]...)
+	return entries
+}
@@ -0,0 +1,53 @@
+// Package ratelimit implements a token bucket rate limiter.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// A Limiter allows events at a given rate, with bursts of up to burst
+// events.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	time   time.Time
+}
+
+// New creates a new limiter that allows rate events per second on
+// average, with bursts of up to burst events.  The limiter starts full.
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// Allow returns true if an event may happen now, in which case it is
+// accounted for.
+func (l *Limiter) Allow() bool {
+	return l.allow(time.Now())
+}
+
+func (l *Limiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.time.IsZero() {
+		delta := now.Sub(l.time).Seconds()
+		if delta > 0 {
+			l.tokens = min(l.tokens+delta*l.rate, l.burst)
+		}
+	}
+	l.time = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
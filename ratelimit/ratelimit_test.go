@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !l.allow(now) {
+			t.Errorf("event %v not allowed", i)
+		}
+	}
+	if l.allow(now) {
+		t.Errorf("burst exceeded")
+	}
+
+	now = now.Add(250 * time.Millisecond)
+	if l.allow(now) {
+		t.Errorf("allowed too early")
+	}
+	now = now.Add(250 * time.Millisecond)
+	if !l.allow(now) {
+		t.Errorf("not allowed after refill")
+	}
+	if l.allow(now) {
+		t.Errorf("allowed twice")
+	}
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.allow(now) {
+			t.Errorf("event %v not allowed after idle", i)
+		}
+	}
+	if l.allow(now) {
+		t.Errorf("burst exceeded after idle")
+	}
+}
@@ -0,0 +1,110 @@
+package rtpconn
+
+import (
+	"github.com/jech/galene/rtptime"
+)
+
+// AudioOnlyRate is the estimated bandwidth, in bits per second, below
+// which a subscriber stops receiving video.  Zero disables the audio-only
+// fallback.
+var AudioOnlyRate uint64 = 100 * 1000
+
+const (
+	// how long the estimate must remain low before we stop video
+	audioOnlyDelay = 3 * rtptime.JiffiesPerSec
+	// how long we ignore the estimate after resuming video
+	audioOnlyProbe = 5 * rtptime.JiffiesPerSec
+	// how long we wait before resuming video, doubled whenever the
+	// estimate drops again soon after resuming
+	minAudioOnlyBackoff = 10 * rtptime.JiffiesPerSec
+	maxAudioOnlyBackoff = 160 * rtptime.JiffiesPerSec
+	// the time after resuming after which the backoff is reset
+	audioOnlyRecovered = 30 * rtptime.JiffiesPerSec
+)
+
+// audioOnlyState decides when a down connection falls back to audio.
+// Since we cannot estimate the bandwidth while we're not sending video,
+// we periodically resume video in order to probe, with exponential
+// backoff.
+type audioOnlyState struct {
+	active  bool
+	low     uint64 // when the estimate went low, 0 if it is not low
+	changed uint64 // when active last changed
+	backoff uint64
+}
+
+// update is called periodically with the lowest bandwidth estimate of
+// a connection's video tracks, 0 if unknown.  It returns true if the
+// state has changed.
+func (s *audioOnlyState) update(rate uint64, now uint64) bool {
+	if s.active {
+		if now-s.changed < s.backoff {
+			return false
+		}
+		s.active = false
+		s.changed = now
+		s.low = 0
+		return true
+	}
+
+	if AudioOnlyRate == 0 || rate == 0 || rate >= AudioOnlyRate ||
+		(s.changed != 0 && now-s.changed < audioOnlyProbe) {
+		s.low = 0
+		return false
+	}
+	if s.low == 0 {
+		s.low = now
+		return false
+	}
+	if now-s.low < audioOnlyDelay {
+		return false
+	}
+
+	if s.backoff != 0 && now-s.changed < audioOnlyRecovered {
+		s.backoff = min(2*s.backoff, maxAudioOnlyBackoff)
+	} else {
+		s.backoff = minAudioOnlyBackoff
+	}
+	s.active = true
+	s.changed = now
+	s.low = 0
+	return true
+}
+
+// videoRate returns the lowest bandwidth estimate of the video tracks
+// of a connection, or 0 if the subscriber hasn't reported congestion.
+func (down *rtpDownConnection) videoRate(now uint64) uint64 {
+	var rate uint64
+	for _, t := range down.getTracks() {
+		if t.queue == nil {
+			continue
+		}
+		r := t.congestionRate(now)
+		if r != 0 && (rate == 0 || r < rate) {
+			rate = r
+		}
+	}
+	return rate
+}
+
+// checkAudioOnly is called periodically by rtcpDownSender.  It switches
+// the connection to and from audio-only mode.
+func (down *rtpDownConnection) checkAudioOnly(now uint64) {
+	if !down.fallback.update(down.videoRate(now), now) {
+		return
+	}
+	active := down.fallback.active
+	for _, t := range down.getTracks() {
+		if t.queue == nil {
+			continue
+		}
+		t.queue.setAudioOnly(active)
+		if !active {
+			// the estimate is stale, start probing afresh
+			t.maxBitrate.Set(initLossRate, now)
+		}
+	}
+	if down.audioOnly != nil {
+		down.audioOnly(active)
+	}
+}
@@ -0,0 +1,109 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/rtptime"
+)
+
+func TestAudioOnlyState(t *testing.T) {
+	var s audioOnlyState
+	now := uint64(rtptime.JiffiesPerSec)
+	low := AudioOnlyRate / 2
+
+	if s.update(0, now) || s.update(4*AudioOnlyRate, now) {
+		t.Errorf("Changed state with a good estimate")
+	}
+	if s.update(low, now) {
+		t.Errorf("Changed state too early")
+	}
+	now += audioOnlyDelay
+	if !s.update(low, now) || !s.active {
+		t.Fatalf("Expected audio-only")
+	}
+	if s.backoff != minAudioOnlyBackoff {
+		t.Errorf("Expected %v, got %v", minAudioOnlyBackoff, s.backoff)
+	}
+
+	now += s.backoff - 1
+	if s.update(low, now) {
+		t.Errorf("Resumed too early")
+	}
+	now++
+	if !s.update(low, now) || s.active {
+		t.Fatalf("Expected resume")
+	}
+
+	// the estimate is ignored while probing
+	now += audioOnlyProbe - 1
+	if s.update(low, now) {
+		t.Errorf("Changed state while probing")
+	}
+	now++
+	s.update(low, now)
+	now += audioOnlyDelay
+	if !s.update(low, now) || !s.active {
+		t.Fatalf("Expected audio-only")
+	}
+	if s.backoff != 2*minAudioOnlyBackoff {
+		t.Errorf("Expected %v, got %v",
+			2*minAudioOnlyBackoff, s.backoff)
+	}
+
+	// recover for good
+	now += s.backoff
+	s.update(low, now)
+	now += audioOnlyRecovered
+	s.update(4*AudioOnlyRate, now)
+	s.update(low, now)
+	now += audioOnlyDelay
+	if !s.update(low, now) {
+		t.Fatalf("Expected audio-only")
+	}
+	if s.backoff != minAudioOnlyBackoff {
+		t.Errorf("Backoff not reset, got %v", s.backoff)
+	}
+}
+
+func TestDownQueueAudioOnly(t *testing.T) {
+	var q downQueue
+	now := uint64(rtptime.JiffiesPerSec)
+	rate := uint64(800 * 1000)
+
+	// congest the queue before entering audio-only mode
+	q.sent(int(4 * rate / 8 * queueLimitSeconds))
+	drop, _ := q.drop(true, false, rate, now)
+	if !drop {
+		t.Fatalf("Didn't drop when congested")
+	}
+	if q.check(now) {
+		t.Errorf("Congestion timeout expired early")
+	}
+
+	q.setAudioOnly(true)
+	drop, _ = q.drop(true, true, rate, now)
+	if !drop {
+		t.Errorf("Didn't drop in audio-only mode")
+	}
+	for i := uint64(1); i <= 4; i++ {
+		drop, _ = q.drop(true, false, rate, now+i*congestionTimeout)
+		if !drop {
+			t.Errorf("Didn't drop in audio-only mode")
+		}
+		if q.check(now + i*congestionTimeout + 1) {
+			t.Errorf("Audio-only counts as congestion")
+		}
+	}
+	now += 4*congestionTimeout + 1
+
+	q.setAudioOnly(false)
+	drop, kf := q.drop(true, false, rate, now)
+	if !drop || !kf {
+		t.Errorf("Expected keyframe request, got %v %v", drop, kf)
+	}
+	drop, _ = q.drop(true, true, rate, now)
+	if drop {
+		t.Errorf("Dropped keyframe")
+	}
+	q.release()
+}
@@ -0,0 +1,178 @@
+package rtpconn
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/jech/galene/rtptime"
+)
+
+// MaxQueuedBytes is the total amount of data that may be queued towards
+// subscribers.  When it is exceeded, video is dropped more aggressively
+// for congested subscribers.
+var MaxQueuedBytes int64 = 64 * 1024 * 1024
+
+// queuedBytes is the amount of data queued towards all subscribers.
+var queuedBytes atomic.Int64
+
+const (
+	// the minimum queue size before we start dropping
+	minQueueLimit = 128 * 1024
+	// the queue limit, in seconds at the subscriber's rate
+	queueLimitSeconds = 2
+	// how long a subscriber may be congested before it is disconnected
+	congestionTimeout = 30 * rtptime.JiffiesPerSec
+)
+
+// A downQueue estimates the amount of data queued towards a subscriber.
+// It is a leaky bucket that is filled by the packets sent on a video
+// track and drained at the rate that the subscriber has reported being
+// able to receive.  When the bucket overflows, video is dropped until
+// the next keyframe.
+type downQueue struct {
+	mu        sync.Mutex
+	closed    bool
+	bytes     int64
+	rate      uint64
+	last      uint64
+	dropping  bool
+	requested bool
+	dropped   bool
+	congested uint64
+	audioOnly bool
+}
+
+// drain drains the queue, and updates the global count.  Called locked.
+func (q *downQueue) drain(rate uint64, now uint64) {
+	old := q.bytes
+	if rate == 0 {
+		// no feedback from the subscriber, we cannot tell
+		q.bytes = 0
+	} else if q.last != 0 && now > q.last {
+		elapsed := min(now-q.last, 10*rtptime.JiffiesPerSec)
+		d := int64(rate / 8 * elapsed / rtptime.JiffiesPerSec)
+		q.bytes = max(q.bytes-d, 0)
+	}
+	q.rate = rate
+	q.last = now
+	queuedBytes.Add(q.bytes - old)
+}
+
+func (q *downQueue) limit() int64 {
+	l := max(int64(q.rate/8*queueLimitSeconds), minQueueLimit)
+	if queuedBytes.Load() > MaxQueuedBytes {
+		l /= 4
+	}
+	return l
+}
+
+// drop returns true if the packet should be dropped, and whether
+// a keyframe should be requested in order to resume sending.  Start and
+// keyframe are the packet's flags.
+func (q *downQueue) drop(start, keyframe bool, rate uint64, now uint64) (bool, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false, false
+	}
+	q.drain(rate, now)
+	if q.audioOnly {
+		return true, false
+	}
+	if !start {
+		return q.dropping, false
+	}
+	limit := q.limit()
+	request := false
+	if q.dropping {
+		if q.bytes < limit/2 {
+			if keyframe {
+				q.dropping = false
+			} else if !q.requested {
+				q.requested = true
+				request = true
+			}
+		} else if keyframe {
+			// we dropped the keyframe, we'll need another one
+			q.requested = false
+		}
+	} else if !keyframe && q.bytes > limit {
+		q.dropping = true
+		q.requested = false
+	}
+	if q.dropping {
+		q.dropped = true
+	}
+	return q.dropping, request
+}
+
+// setAudioOnly causes all video to be dropped.  When audio-only mode
+// ends, video resumes at the next keyframe.  The subscriber is not
+// considered to be congested while in audio-only mode.
+func (q *downQueue) setAudioOnly(audioOnly bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.audioOnly = audioOnly
+	if audioOnly {
+		q.dropping = true
+		q.requested = false
+		q.dropped = false
+		q.congested = 0
+	}
+}
+
+// sent records that a packet was sent.
+func (q *downQueue) sent(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.bytes += int64(n)
+	queuedBytes.Add(int64(n))
+}
+
+// check is called periodically.  It returns true if the subscriber has
+// been congested for too long.
+func (q *downQueue) check(now uint64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.dropped || q.audioOnly {
+		q.dropped = false
+		q.congested = 0
+		return false
+	}
+	q.dropped = q.dropping
+	if q.congested == 0 {
+		q.congested = now
+	}
+	return now-q.congested > congestionTimeout
+}
+
+// release removes the queue from the global count.
+func (q *downQueue) release() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		queuedBytes.Add(-q.bytes)
+		q.bytes = 0
+		q.closed = true
+	}
+}
+
+// congestionRate returns the rate that the subscriber has reported being
+// able to receive, or 0 if it hasn't reported congestion.
+func (down *rtpDownTrack) congestionRate(now uint64) uint64 {
+	if r := down.maxREMBBitrate.Get(now); r != 0 {
+		return r
+	}
+	// the loss-based estimate is only meaningful if it has been
+	// reduced by loss
+	if r := down.maxBitrate.Get(now); r < initLossRate {
+		return r
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/rtptime"
+)
+
+func TestDownQueue(t *testing.T) {
+	var q downQueue
+	now := uint64(rtptime.JiffiesPerSec)
+	rate := uint64(800 * 1000)
+
+	drop, _ := q.drop(true, true, rate, now)
+	if drop {
+		t.Errorf("Dropped on empty queue")
+	}
+
+	// send 1s worth of data at 4 times the rate
+	for i := 0; i < 400; i++ {
+		now += rtptime.JiffiesPerSec / 400
+		drop, _ = q.drop(true, false, rate, now)
+		if drop {
+			break
+		}
+		q.sent(1000)
+	}
+	if !drop {
+		t.Fatalf("Didn't drop, queue %v", q.bytes)
+	}
+	if queuedBytes.Load() != q.bytes {
+		t.Errorf("Global count %v, expected %v",
+			queuedBytes.Load(), q.bytes)
+	}
+
+	// the queue drains at 100kB/s
+	now += 2 * rtptime.JiffiesPerSec
+	drop, kf := q.drop(true, false, rate, now)
+	if !drop || !kf {
+		t.Errorf("Expected keyframe request, got %v %v", drop, kf)
+	}
+	drop, kf = q.drop(true, false, rate, now)
+	if !drop || kf {
+		t.Errorf("Expected drop, got %v %v", drop, kf)
+	}
+	drop, _ = q.drop(true, true, rate, now)
+	if drop {
+		t.Errorf("Dropped keyframe")
+	}
+
+	if q.check(now) {
+		t.Errorf("Congested too early")
+	}
+	if q.check(now + congestionTimeout + 1) {
+		t.Errorf("Congested after recovery")
+	}
+
+	q.sent(1000)
+	q.release()
+	if queuedBytes.Load() != 0 {
+		t.Errorf("Global count %v after release", queuedBytes.Load())
+	}
+}
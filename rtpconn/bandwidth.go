@@ -0,0 +1,30 @@
+package rtpconn
+
+import (
+	"github.com/jech/galene/group"
+)
+
+// accountBandwidth adds the bytes forwarded to the client since the last
+// call to the bandwidth accounting of its user, and disconnects the
+// client if the user has exceeded its caps.  Called periodically from
+// the client's goroutine.
+func (c *webClient) accountBandwidth() error {
+	n := c.sent.Swap(0)
+	if c.group == nil || c.username == "" ||
+		member("system", c.permissions) {
+		return nil
+	}
+	err := group.AddBandwidth(c.username, n)
+	if err != nil {
+		c.log().Warn("Bandwidth accounting", "err", err)
+		return nil
+	}
+	err = c.group.CheckBandwidth(c.username)
+	if err == group.ErrBandwidthExceeded {
+		c.log().Info("Disconnecting client over bandwidth cap")
+		return err
+	} else if err != nil {
+		c.log().Warn("Bandwidth accounting", "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package rtpconn
+
+import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpdump"
+)
+
+// MaxCaptureDuration is the maximum duration of a packet capture.
+const MaxCaptureDuration = 5 * time.Minute
+
+// A capture dumps the packets received on an up connection to an rtpdump
+// file, for debugging.
+type capture struct {
+	payloads bool
+
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	writer *rtpdump.Writer
+	timer  *time.Timer
+}
+
+// captureFilename returns the name of a new capture file.  The name is
+// chosen by the server, since connection ids are chosen by the client.
+func captureFilename(g string, now time.Time) (string, error) {
+	dir := filepath.Join(group.DataDirectory, "var", "captures")
+	var suffix [4]byte
+	_, err := crand.Read(suffix[:])
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(
+		dir, filepath.FromSlash(path.Clean("/"+g)),
+		fmt.Sprintf("%v-%v.rtpdump",
+			now.Format("2006-01-02T15:04:05"),
+			hex.EncodeToString(suffix[:])),
+	)
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("bad capture filename")
+	}
+	return filename, nil
+}
+
+// startCapture starts capturing the packets received on an up connection
+// for the given duration.  Only the headers of RTP packets are captured,
+// unless payloads is true.  It returns the name of the capture file.
+func startCapture(up *rtpUpConnection, duration time.Duration, payloads bool) (string, error) {
+	if duration <= 0 || duration > MaxCaptureDuration {
+		return "", errors.New("bad capture duration")
+	}
+	g := up.client.Group()
+	if g == nil {
+		return "", errors.New("connection is not in a group")
+	}
+
+	now := time.Now()
+	filename, err := captureFilename(g.Name(), now)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	var source netip.AddrPort
+	if a := up.client.Addr(); a != nil {
+		source, _ = netip.ParseAddrPort(a.String())
+	}
+	buf := bufio.NewWriter(f)
+	w, err := rtpdump.NewWriter(buf, source, now)
+	if err != nil {
+		f.Close()
+		os.Remove(filename)
+		return "", err
+	}
+
+	c := &capture{
+		payloads: payloads,
+		file:     f,
+		buf:      buf,
+		writer:   w,
+	}
+	if !up.capture.CompareAndSwap(nil, c) {
+		f.Close()
+		os.Remove(filename)
+		return "", errors.New("a capture is already running")
+	}
+	c.timer = time.AfterFunc(duration, func() {
+		if up.capture.CompareAndSwap(c, nil) {
+			c.close()
+		}
+	})
+	return filename, nil
+}
+
+func (c *capture) write(data []byte, length int, rtcp bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return
+	}
+	err := c.writer.WritePacket(data, length, rtcp, time.Now())
+	if err != nil {
+		logger.Warn("Write capture", "file", c.file.Name(), "err", err)
+		c.closeLocked()
+	}
+}
+
+// rtp captures an RTP packet.
+func (c *capture) rtp(buf []byte) {
+	data := buf
+	if !c.payloads {
+		var h rtp.Header
+		n, err := h.Unmarshal(buf)
+		if err != nil {
+			return
+		}
+		data = buf[:n]
+	}
+	c.write(data, len(buf), false)
+}
+
+// rtcp captures an RTCP packet.
+func (c *capture) rtcp(buf []byte) {
+	c.write(buf, len(buf), true)
+}
+
+func (c *capture) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.closeLocked()
+}
+
+// closeLocked closes the capture file.  The capture remains attached to
+// the connection until its timer fires.
+func (c *capture) closeLocked() {
+	if c.file == nil {
+		return
+	}
+	err := c.buf.Flush()
+	err2 := c.file.Close()
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		logger.Warn("Close capture", "file", c.file.Name(), "err", err)
+	}
+	c.file = nil
+}
+
+// findUpConn returns the up connection with the given id in a group.
+func findUpConn(g *group.Group, id string) *rtpUpConnection {
+	for _, c := range g.GetClients(nil) {
+		switch c := c.(type) {
+		case *webClient:
+			if up := getUpConn(c, id); up != nil {
+				return up
+			}
+		case *WhipClient:
+			c.mu.Lock()
+			up := c.connection
+			c.mu.Unlock()
+			if up != nil && up.id == id {
+				return up
+			}
+		}
+	}
+	return nil
+}
+
+// stopCapture stops any capture running on an up connection.
+func (up *rtpUpConnection) stopCapture() {
+	c := up.capture.Swap(nil)
+	if c != nil {
+		c.close()
+	}
+}
@@ -0,0 +1,34 @@
+package rtpconn
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestCaptureFilename(t *testing.T) {
+	group.DataDirectory = t.TempDir()
+	defer func() {
+		group.DataDirectory = ""
+	}()
+	dir := filepath.Join(group.DataDirectory, "var", "captures")
+
+	now := time.Now()
+	for _, g := range []string{"test", "a/b", "../../../tmp", "/.."} {
+		f1, err := captureFilename(g, now)
+		if err != nil {
+			t.Errorf("%v: %v", g, err)
+			continue
+		}
+		if !strings.HasPrefix(f1, dir+string(filepath.Separator)) {
+			t.Errorf("%v: %v is not under %v", g, f1, dir)
+		}
+		f2, err := captureFilename(g, now)
+		if err != nil || f1 == f2 {
+			t.Errorf("%v: got %v and %v (%v)", g, f1, f2, err)
+		}
+	}
+}
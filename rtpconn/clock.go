@@ -0,0 +1,86 @@
+package rtpconn
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jech/galene/rtptime"
+)
+
+// A clockTrack maps the RTP timestamps of a down track to the server's
+// wall clock: the timestamp RTP corresponds to the time Transmit of the
+// enclosing clockValue.
+type clockTrack struct {
+	Stream    string `json:"stream"`
+	Kind      string `json:"kind"`
+	SSRC      uint32 `json:"ssrc"`
+	ClockRate uint32 `json:"clockrate"`
+	RTP       uint32 `json:"rtp"`
+}
+
+// clockValue is the value of a clock message.  Times are in milliseconds;
+// Origin is echoed from the client, Receive and Transmit are wall-clock
+// times since the Unix epoch, and Monotonic is the server's monotonic
+// clock at the time Transmit, from an arbitrary origin.
+type clockValue struct {
+	Origin    float64      `json:"origin"`
+	Receive   float64      `json:"receive"`
+	Transmit  float64      `json:"transmit"`
+	Monotonic float64      `json:"monotonic"`
+	Tracks    []clockTrack `json:"tracks,omitempty"`
+}
+
+func unixMilli(tm time.Time) float64 {
+	return float64(tm.UnixNano()) / 1e6
+}
+
+// clockTracks returns the RTP to wall-clock mappings of the tracks sent
+// to a client at a given time.
+func clockTracks(c *webClient, now time.Time) []clockTrack {
+	c.mu.Lock()
+	down := make([]*rtpDownConnection, 0, len(c.down))
+	for _, d := range c.down {
+		down = append(down, d)
+	}
+	c.mu.Unlock()
+
+	var tracks []clockTrack
+	for _, d := range down {
+		for _, t := range d.getTracks() {
+			rtp, ok := t.rtpTime(now)
+			if !ok {
+				continue
+			}
+			tracks = append(tracks, clockTrack{
+				Stream:    d.id,
+				Kind:      t.track.Kind().String(),
+				SSRC:      uint32(t.ssrc),
+				ClockRate: t.track.Codec().ClockRate,
+				RTP:       rtp,
+			})
+		}
+	}
+	sort.Slice(tracks, func(i, j int) bool {
+		if tracks[i].Stream != tracks[j].Stream {
+			return tracks[i].Stream < tracks[j].Stream
+		}
+		return tracks[i].SSRC < tracks[j].SSRC
+	})
+	return tracks
+}
+
+// clockSync replies to a clock message sent by the client.
+func (c *webClient) clockSync(origin float64, receive time.Time) error {
+	now := time.Now()
+	monotonic := rtptime.Microseconds()
+	return c.write(clientMessage{
+		Type: "clock",
+		Value: clockValue{
+			Origin:    origin,
+			Receive:   unixMilli(receive),
+			Transmit:  unixMilli(now),
+			Monotonic: float64(monotonic) / 1000,
+			Tracks:    clockTracks(c, now),
+		},
+	})
+}
@@ -0,0 +1,42 @@
+package rtpconn
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestClockSync(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	c := &webClient{
+		writeCh:    ch,
+		writerDone: make(chan struct{}),
+	}
+	receive := time.Now()
+	err := c.clockSync(1234.5, receive)
+	if err != nil {
+		t.Fatalf("clockSync: %v", err)
+	}
+
+	m := (<-ch).(clientMessage)
+	if m.Type != "clock" {
+		t.Errorf("Expected clock, got %v", m.Type)
+	}
+	v := m.Value.(clockValue)
+	if v.Origin != 1234.5 || v.Receive != unixMilli(receive) ||
+		v.Transmit < v.Receive || len(v.Tracks) != 0 {
+		t.Errorf("Unexpected value %v", v)
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var vv map[string]interface{}
+	json.Unmarshal(buf, &vv)
+	for _, k := range []string{"origin", "receive", "transmit", "monotonic"} {
+		if _, ok := vv[k].(float64); !ok {
+			t.Errorf("Missing %v in %v", k, string(buf))
+		}
+	}
+}
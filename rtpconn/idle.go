@@ -0,0 +1,60 @@
+package rtpconn
+
+import (
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// the time between the warning and the disconnection of an idle client
+const idleGrace = 60 * time.Second
+
+// checkIdle warns, then disconnects, clients that have shown no activity
+// for longer than the group's idle timeout.  Publishing streams counts
+// as activity.  Called periodically from the client's goroutine.
+func (c *webClient) checkIdle(now time.Time) error {
+	if c.group == nil {
+		return nil
+	}
+	timeout := time.Duration(c.group.Description().IdleTimeout) *
+		time.Second
+	if timeout <= 0 {
+		return nil
+	}
+
+	if len(getUpConns(c)) > 0 {
+		c.markActive(now)
+		return nil
+	}
+
+	idle := now.Sub(c.active)
+	if idle < timeout {
+		return nil
+	}
+	if !c.idleWarned {
+		c.idleWarned = true
+		return c.write(clientMessage{
+			Type:       "usermessage",
+			Kind:       "warning",
+			Error:      "idle",
+			Dest:       c.id,
+			Privileged: true,
+			Value: "You have been inactive for a while, and " +
+				"will be disconnected in a minute.",
+		})
+	}
+	if idle >= timeout+idleGrace {
+		c.log().Info("Disconnecting idle client",
+			"idle", idle.Round(time.Second))
+		return group.UserError(
+			"you have been disconnected due to inactivity",
+		)
+	}
+	return nil
+}
+
+// markActive records that the client has shown some activity.
+func (c *webClient) markActive(now time.Time) {
+	c.active = now
+	c.idleWarned = false
+}
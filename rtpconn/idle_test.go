@@ -0,0 +1,55 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestCheckIdle(t *testing.T) {
+	g, err := group.Add("idle-test", &group.Description{IdleTimeout: 600})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("idle-test")
+
+	c := &webClient{
+		group:      g,
+		id:         "id",
+		writeCh:    make(chan interface{}, 10),
+		writerDone: make(chan struct{}),
+	}
+	now := time.Now()
+	c.markActive(now)
+
+	if err := c.checkIdle(now.Add(time.Minute)); err != nil {
+		t.Errorf("checkIdle: %v", err)
+	}
+	if len(c.writeCh) != 0 {
+		t.Errorf("Active client was warned")
+	}
+
+	if err := c.checkIdle(now.Add(10 * time.Minute)); err != nil {
+		t.Errorf("checkIdle: %v", err)
+	}
+	if len(c.writeCh) != 1 {
+		t.Fatalf("Idle client was not warned")
+	}
+	m := (<-c.writeCh).(clientMessage)
+	if m.Kind != "warning" || m.Error != "idle" {
+		t.Errorf("Unexpected warning %v", m)
+	}
+
+	if err := c.checkIdle(now.Add(10*time.Minute + 30*time.Second)); err != nil {
+		t.Errorf("checkIdle: %v", err)
+	}
+	if err := c.checkIdle(now.Add(11 * time.Minute)); err == nil {
+		t.Errorf("Idle client was not disconnected")
+	}
+
+	c.markActive(now.Add(11 * time.Minute))
+	if err := c.checkIdle(now.Add(12 * time.Minute)); err != nil {
+		t.Errorf("checkIdle: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package rtpconn
+
+import (
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/ratelimit"
+)
+
+// messageClasses maps the types of the rate-limited protocol messages to
+// their class.  Keyframe requests, which are sent over RTCP, belong to
+// the class "keyframe".
+var messageClasses = map[string]string{
+	"chat":          "chat",
+	"usermessage":   "chat",
+	"reaction":      "chat",
+	"offer":         "offer",
+	"renegotiate":   "request",
+	"request":       "request",
+	"requestStream": "request",
+	"subscribe":     "request",
+	"resolution":    "request",
+}
+
+// coalescedClasses are the classes of messages that are delayed rather
+// than dropped when over the limit.  Only the latest message of a given
+// type and id is kept, since it supersedes the earlier ones.
+var coalescedClasses = map[string]bool{
+	"request": true,
+}
+
+// maxDelayedMessages is the maximum number of delayed messages queued
+// for a client.  Further messages are dropped, and count against the
+// kick limit of their class.
+const maxDelayedMessages = 32
+
+// A messageGuard limits the rate of a class of messages sent by a client.
+// Excess counts the messages refused, and is nil if the client should
+// never be disconnected.
+type messageGuard struct {
+	limiter *ratelimit.Limiter
+	excess  *ratelimit.Limiter
+	// the time it takes to gain a token
+	interval time.Duration
+}
+
+func newMessageGuards(limits map[string]group.MessageLimit) map[string]*messageGuard {
+	guards := make(map[string]*messageGuard, len(limits))
+	for class, l := range limits {
+		if l.Rate <= 0 {
+			continue
+		}
+		g := &messageGuard{
+			limiter: ratelimit.New(l.Rate, max(l.Burst, 1)),
+			interval: max(
+				time.Duration(float64(time.Second)/l.Rate),
+				10*time.Millisecond,
+			),
+		}
+		if l.Kick > 0 {
+			g.excess = ratelimit.New(float64(l.Kick)/60, l.Kick)
+		}
+		guards[class] = g
+	}
+	return guards
+}
+
+// allowMessage returns true if a message of the given class may be
+// processed.  Kick is true if the client has exceeded the limit so often
+// that it should be disconnected; messages of the coalesced classes only
+// count when they are dropped by delayMessage.  This may be called from
+// any goroutine.
+func (c *webClient) allowMessage(class string) (allow bool, kick bool) {
+	g := c.guards[class]
+	if g == nil || g.limiter.Allow() {
+		return true, false
+	}
+	if coalescedClasses[class] {
+		return false, false
+	}
+	return false, g.excess != nil && !g.excess.Allow()
+}
+
+type pendingMessage struct {
+	class string
+	m     clientMessage
+}
+
+// delayMessage queues a message that was over the limit, replacing any
+// queued message with the same type and id.  If the queue is full, the
+// message is dropped, and kick is true if the client should be
+// disconnected.  Called from the client goroutine.
+func (c *webClient) delayMessage(class string, m clientMessage) (dropped bool, kick bool) {
+	for i, p := range c.pending {
+		if p.m.Type == m.Type && p.m.Id == m.Id {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	if len(c.pending) >= maxDelayedMessages {
+		g := c.guards[class]
+		return true, g.excess != nil && !g.excess.Allow()
+	}
+	c.pending = append(c.pending, pendingMessage{class, m})
+	if c.pendingTimer == nil {
+		c.pendingTimer = time.NewTimer(c.guards[class].interval)
+	}
+	return false, false
+}
+
+// flushPending processes the queued messages that are now within the
+// limit.  Called from the client goroutine when the pending timer fires.
+func (c *webClient) flushPending() error {
+	c.pendingTimer = nil
+	var interval time.Duration
+	i := 0
+	for i < len(c.pending) {
+		p := c.pending[i]
+		g := c.guards[p.class]
+		if g != nil && !g.limiter.Allow() {
+			if interval == 0 || g.interval < interval {
+				interval = g.interval
+			}
+			i++
+			continue
+		}
+		c.pending = append(c.pending[:i], c.pending[i+1:]...)
+		err := processClientMessage(c, p.m)
+		if err == ErrUnknownId {
+			// the connection went away while the message was
+			// queued
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.pending) > 0 {
+		c.pendingTimer = time.NewTimer(interval)
+	}
+	return nil
+}
+
+func timerChannel(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+// limitMessage applies the rate limits to a message received from the
+// client.  It returns true if the message has been dealt with, in which
+// case it must not be processed any further.
+func (c *webClient) limitMessage(m clientMessage) (bool, error) {
+	class, ok := messageClasses[m.Type]
+	if !ok {
+		return false, nil
+	}
+	if coalescedClasses[class] && c.pendingFor(m) {
+		// don't overtake the queued message
+		c.delayMessage(class, m)
+		return true, nil
+	}
+	allow, kick := c.allowMessage(class)
+	if !allow && coalescedClasses[class] {
+		var dropped bool
+		dropped, kick = c.delayMessage(class, m)
+		if !dropped {
+			return true, nil
+		}
+	}
+	if kick {
+		c.log().Warn("Disconnecting client",
+			"reason", "too many messages", "class", class)
+		return true, group.KickError{Message: "too many messages"}
+	}
+	if allow {
+		return false, nil
+	}
+	if m.Type == "offer" {
+		c.log().Debug("Refusing offer", "conn", m.Id)
+		if m.Id != "" {
+			err := c.write(clientMessage{
+				Type: "abort",
+				Id:   m.Id,
+			})
+			if err != nil {
+				return true, err
+			}
+		}
+		return true, c.error(group.UserError("too many offers"))
+	}
+	c.log().Debug("Dropping message", "type", m.Type, "class", class)
+	return true, nil
+}
+
+// pendingFor returns true if a message with the same type and id as m
+// is queued.
+func (c *webClient) pendingFor(m clientMessage) bool {
+	for _, p := range c.pending {
+		if p.m.Type == m.Type && p.m.Id == m.Id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package rtpconn
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestAllowMessage(t *testing.T) {
+	c := &webClient{
+		guards: newMessageGuards(map[string]group.MessageLimit{
+			"chat":     {Rate: 0.001, Burst: 2, Kick: 3},
+			"keyframe": {Rate: 0.001, Burst: 1},
+			"other":    {Rate: 0},
+		}),
+	}
+
+	for i := 0; i < 2; i++ {
+		if allow, kick := c.allowMessage("chat"); !allow || kick {
+			t.Errorf("chat %v: %v %v", i, allow, kick)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if allow, kick := c.allowMessage("chat"); allow || kick {
+			t.Errorf("excess chat %v: %v %v", i, allow, kick)
+		}
+	}
+	if allow, kick := c.allowMessage("chat"); allow || !kick {
+		t.Errorf("flood: %v %v", allow, kick)
+	}
+
+	if allow, _ := c.allowMessage("keyframe"); !allow {
+		t.Errorf("keyframe not allowed")
+	}
+	for i := 0; i < 100; i++ {
+		if allow, kick := c.allowMessage("keyframe"); allow || kick {
+			t.Errorf("excess keyframe %v: %v %v", i, allow, kick)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if allow, kick := c.allowMessage("other"); !allow || kick {
+			t.Errorf("unlimited %v: %v %v", i, allow, kick)
+		}
+		if allow, kick := c.allowMessage("unknown"); !allow || kick {
+			t.Errorf("unknown %v: %v %v", i, allow, kick)
+		}
+	}
+}
+
+func TestLimitMessage(t *testing.T) {
+	c := &webClient{
+		id:      "c",
+		writeCh: make(chan interface{}, 10),
+		guards: newMessageGuards(map[string]group.MessageLimit{
+			"offer":   {Rate: 0.001, Burst: 1, Kick: 100},
+			"request": {Rate: 1000, Burst: 1},
+		}),
+	}
+
+	done, err := c.limitMessage(clientMessage{Type: "offer", Id: "a"})
+	if done || err != nil {
+		t.Errorf("First offer: %v %v", done, err)
+	}
+	done, err = c.limitMessage(clientMessage{Type: "offer", Id: "b"})
+	if !done || err != nil {
+		t.Errorf("Second offer: %v %v", done, err)
+	}
+	m, ok := (<-c.writeCh).(clientMessage)
+	if !ok || m.Type != "abort" || m.Id != "b" {
+		t.Errorf("Expected abort, got %v", m)
+	}
+
+	done, err = c.limitMessage(clientMessage{
+		Type: "requestStream", Id: "x", Request: []string{"audio"},
+	})
+	if done || err != nil {
+		t.Errorf("First request: %v %v", done, err)
+	}
+	for _, id := range []string{"x", "y", "x"} {
+		done, err = c.limitMessage(clientMessage{
+			Type: "requestStream", Id: id, Request: []string{id},
+		})
+		if !done || err != nil {
+			t.Errorf("Request %v: %v %v", id, done, err)
+		}
+	}
+	if len(c.pending) != 2 || c.pending[0].m.Id != "y" ||
+		c.pending[1].m.Id != "x" || c.pendingTimer == nil {
+		t.Fatalf("Pending: %v", c.pending)
+	}
+
+	for len(c.pending) > 0 {
+		<-c.pendingTimer.C
+		// the connections are unknown, which is not an error
+		err := c.flushPending()
+		if err != nil {
+			t.Fatalf("flushPending: %v", err)
+		}
+	}
+	if c.pendingTimer != nil {
+		t.Errorf("Timer still running")
+	}
+}
+
+func TestLimitMessageFlood(t *testing.T) {
+	c := &webClient{
+		id: "c",
+		guards: newMessageGuards(map[string]group.MessageLimit{
+			"request": {Rate: 0.001, Burst: 1, Kick: 10},
+		}),
+	}
+
+	var err error
+	n := 0
+	for n < 1000 {
+		_, err = c.limitMessage(clientMessage{
+			Type:    "requestStream",
+			Id:      fmt.Sprintf("id%v", n),
+			Request: []string{"audio"},
+		})
+		if err != nil {
+			break
+		}
+		if len(c.pending) > maxDelayedMessages {
+			t.Fatalf("Pending: %v messages", len(c.pending))
+		}
+		n++
+	}
+	if _, ok := err.(group.KickError); !ok {
+		t.Fatalf("Flooding client not kicked: %v", err)
+	}
+	// one message is allowed, the queue is filled, and ten messages
+	// are dropped before the client is disconnected
+	if n != 1+maxDelayedMessages+10 {
+		t.Errorf("Kicked after %v messages", n)
+	}
+}
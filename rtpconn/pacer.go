@@ -0,0 +1,219 @@
+package rtpconn
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// the pacing rate, as a multiple of the congestion controller's
+	// estimate, which leaves room for bursts
+	pacingFactor = 2.5
+	// the maximum time a packet may be delayed by the pacer
+	pacingMaxDelay = 20 * time.Millisecond
+	// the amount of data that may be sent back-to-back, in seconds at
+	// the pacing rate
+	pacingBurst = 5 * time.Millisecond
+	// the minimum pacing rate, in bytes per second
+	minPacingRate = 64 * 1024
+	// the number of packets above which the pacer drops packets
+	maxPacedPackets = 1024
+	// how often the pacing rate is recomputed
+	pacingRateInterval = 20 * time.Millisecond
+)
+
+type pacedPacket struct {
+	w    io.Writer
+	buf  []byte
+	n    int
+	time time.Time
+}
+
+// A pacer spreads the video packets sent on a down connection over time,
+// so that keyframes and screenshare spikes don't cause losses on links
+// with small buffers.  Packets are sent by a goroutine that only runs
+// while the queue is not empty.
+type pacer struct {
+	// returns the rate, in bits per second, that the subscriber is
+	// estimated to be able to receive
+	rate func() uint64
+
+	mu      sync.Mutex
+	queue   []pacedPacket
+	bytes   int
+	running bool
+	closed  bool
+	// the writers that have failed or have been removed, they are
+	// never written to again
+	dead map[io.Writer]error
+}
+
+func newPacer(rate func() uint64) *pacer {
+	return &pacer{rate: rate}
+}
+
+// push queues a packet to be written to w.  The packet is copied.  If
+// too many packets are queued, it is dropped, and will be recovered by
+// the subscriber using NACK.  It returns an error if the pacer has been
+// closed or if writing to w has failed.
+func (p *pacer) push(w io.Writer, buf []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return io.ErrClosedPipe
+	}
+	if err := p.dead[w]; err != nil {
+		return err
+	}
+	if len(p.queue) >= maxPacedPackets {
+		return nil
+	}
+	ibuf := packetBufPool.Get().([]byte)
+	n := copy(ibuf, buf)
+	p.queue = append(p.queue, pacedPacket{
+		w: w, buf: ibuf, n: n, time: time.Now(),
+	})
+	p.bytes += n
+	if !p.running {
+		p.running = true
+		go p.run()
+	}
+	return nil
+}
+
+// dropUnlocked discards the packets queued for w, or all the packets if
+// w is nil.  Called locked.
+func (p *pacer) dropUnlocked(w io.Writer) {
+	queue := p.queue[:0]
+	for _, pkt := range p.queue {
+		if w == nil || pkt.w == w {
+			p.bytes -= pkt.n
+			packetBufPool.Put(pkt.buf)
+			continue
+		}
+		queue = append(queue, pkt)
+	}
+	for i := len(queue); i < len(p.queue); i++ {
+		p.queue[i] = pacedPacket{}
+	}
+	p.queue = queue
+}
+
+// remove discards the packets queued for w, and causes any further
+// packets for w to be refused.  It is called when a track is removed.
+func (p *pacer) remove(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropUnlocked(w)
+	if p.dead == nil {
+		p.dead = make(map[io.Writer]error)
+	}
+	p.dead[w] = io.ErrClosedPipe
+}
+
+// close discards all queued packets and causes the pacer to refuse any
+// further packets.  It is called when the connection is closed.
+func (p *pacer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.dropUnlocked(nil)
+}
+
+// pacingRate returns the rate, in bytes per second, at which packets are
+// sent, given the amount of data queued.
+func pacingRate(r uint64, queued int) float64 {
+	rate := float64(r) * pacingFactor / 8
+	return max(rate, float64(queued)/pacingMaxDelay.Seconds(),
+		minPacingRate)
+}
+
+func pacingBudget(rate float64) float64 {
+	return max(rate*pacingBurst.Seconds(), 2*1500)
+}
+
+func (p *pacer) run() {
+	var r uint64
+	var rateTime time.Time
+	var budget float64
+	first := true
+	last := time.Now()
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running = false
+			p.queue = nil
+			p.mu.Unlock()
+			return
+		}
+		pkt := p.queue[0]
+		queued := p.bytes
+		p.mu.Unlock()
+
+		now := time.Now()
+		if first || now.Sub(rateTime) >= pacingRateInterval {
+			r = p.rate()
+			rateTime = now
+		}
+		rate := pacingRate(r, queued)
+		if first {
+			// we were idle, allow a full burst
+			budget = pacingBudget(rate)
+			first = false
+		} else {
+			budget = min(budget+now.Sub(last).Seconds()*rate,
+				pacingBudget(rate))
+		}
+		last = now
+
+		// never delay a packet by more than pacingMaxDelay
+		if budget < float64(pkt.n) &&
+			now.Sub(pkt.time) < pacingMaxDelay {
+			time.Sleep(time.Duration(
+				(float64(pkt.n) - budget) / rate * float64(time.Second),
+			))
+			continue
+		}
+
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			// the packets were dropped in the meantime
+			p.mu.Unlock()
+			continue
+		}
+		// the queue may have been modified, don't use a stale packet
+		pkt = p.queue[0]
+		p.queue[0] = pacedPacket{}
+		p.queue = p.queue[1:]
+		p.bytes -= pkt.n
+		p.mu.Unlock()
+		budget = max(budget-float64(pkt.n), 0)
+
+		_, err := pkt.w.Write(pkt.buf[:pkt.n])
+		packetBufPool.Put(pkt.buf)
+		if err != nil {
+			p.mu.Lock()
+			if p.dead == nil {
+				p.dead = make(map[io.Writer]error)
+			}
+			p.dead[pkt.w] = err
+			p.dropUnlocked(pkt.w)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// targetRate returns the sum of the rates estimated by the congestion
+// controller for the video tracks of a connection.
+func (down *rtpDownConnection) targetRate() uint64 {
+	var rate uint64
+	for _, t := range down.getTracks() {
+		if t.queue == nil {
+			continue
+		}
+		r, _, _ := t.GetMaxBitrate()
+		rate += r
+	}
+	return rate
+}
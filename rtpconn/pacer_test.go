@@ -0,0 +1,157 @@
+package rtpconn
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type timedWriter struct {
+	mu    sync.Mutex
+	times []time.Time
+	first []byte
+}
+
+func (w *timedWriter) Write(buf []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.times = append(w.times, time.Now())
+	w.first = append(w.first, buf[0])
+	return len(buf), nil
+}
+
+func TestPacer(t *testing.T) {
+	p := newPacer(func() uint64 { return 1000000 })
+	w := &timedWriter{}
+
+	buf := make([]byte, 1200)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		buf[0] = byte(i)
+		p.push(w, buf)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mu.Lock()
+		n := len(w.times)
+		w.mu.Unlock()
+		if n >= 100 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.times) != 100 {
+		t.Fatalf("Expected 100 packets, got %v", len(w.times))
+	}
+	for i, b := range w.first {
+		if b != byte(i) {
+			t.Errorf("Packet %v out of order (%v)", i, b)
+		}
+	}
+	if w.times[99].Sub(start) < 5*time.Millisecond {
+		t.Errorf("Packets were not paced (%v)", w.times[99].Sub(start))
+	}
+	if w.times[0].Sub(start) > pacingMaxDelay {
+		t.Errorf("First packet delayed by %v", w.times[0].Sub(start))
+	}
+
+	p.mu.Lock()
+	running := p.running
+	p.mu.Unlock()
+	if running {
+		time.Sleep(10 * time.Millisecond)
+		p.mu.Lock()
+		running = p.running
+		p.mu.Unlock()
+		if running {
+			t.Errorf("Pacer still running")
+		}
+	}
+}
+
+func TestPacingRate(t *testing.T) {
+	if r := pacingRate(8000000, 0); r != 2500000 {
+		t.Errorf("Expected 2500000, got %v", r)
+	}
+	if r := pacingRate(0, 0); r != minPacingRate {
+		t.Errorf("Expected %v, got %v", minPacingRate, r)
+	}
+	if r := pacingRate(8000000, 200000); r != 10000000 {
+		t.Errorf("Expected 10000000, got %v", r)
+	}
+}
+
+type failingWriter struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (w *failingWriter) Write(buf []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.count++
+	return 0, io.ErrClosedPipe
+}
+
+func TestPacerClose(t *testing.T) {
+	// slow enough that packets stay queued
+	p := newPacer(func() uint64 { return 0 })
+	w := &timedWriter{}
+	buf := make([]byte, 1200)
+	for i := 0; i < 100; i++ {
+		err := p.push(w, buf)
+		if err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+	p.close()
+	p.mu.Lock()
+	queued := len(p.queue)
+	p.mu.Unlock()
+	if queued != 0 {
+		t.Errorf("%v packets still queued", queued)
+	}
+	if err := p.push(w, buf); err != io.ErrClosedPipe {
+		t.Errorf("Push after close: %v", err)
+	}
+}
+
+func TestPacerWriteError(t *testing.T) {
+	p := newPacer(func() uint64 { return 1000000 })
+	w := &failingWriter{}
+	buf := make([]byte, 1200)
+	for i := 0; i < 10; i++ {
+		p.push(w, buf)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var err error
+	for err == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		err = p.push(w, buf)
+	}
+	if err != io.ErrClosedPipe {
+		t.Errorf("Push after write error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	w.mu.Lock()
+	count := w.count
+	w.mu.Unlock()
+	if count != 1 {
+		t.Errorf("Wrote %v packets to a failed writer", count)
+	}
+
+	w2 := &timedWriter{}
+	if err := p.push(w2, buf); err != nil {
+		t.Errorf("Push to other writer: %v", err)
+	}
+	p.remove(w2)
+	if err := p.push(w2, buf); err != io.ErrClosedPipe {
+		t.Errorf("Push after remove: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package rtpconn
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jech/galene/group"
+)
+
+// qualityProfile returns the quality profile of a group, or nil.
+func qualityProfile(g *group.Group) *group.QualityProfile {
+	if g == nil {
+		return nil
+	}
+	return g.Description().Profile()
+}
+
+// limitResolution applies the maximum dimensions of a group's quality
+// profile to the size at which a subscriber renders a video.  An unknown
+// size is treated as the maximum.
+func limitResolution(g *group.Group, width, height uint32) (uint32, uint32) {
+	p := qualityProfile(g)
+	if p == nil || p.MaxWidth <= 0 || p.MaxHeight <= 0 {
+		return width, height
+	}
+	mw, mh := uint32(p.MaxWidth), uint32(p.MaxHeight)
+	if width == 0 || height == 0 {
+		return mw, mh
+	}
+	return min(width, mw), min(height, mh)
+}
+
+// limitAudioBitrate modifies an SDP answer so that the peer sends Opus
+// at no more than the given bitrate.
+func limitAudioBitrate(sdp string, bitrate uint64) string {
+	if bitrate == 0 {
+		return sdp
+	}
+	lines := strings.Split(sdp, "\r\n")
+
+	opus := make(map[string]bool)
+	for _, l := range lines {
+		rtpmap, ok := strings.CutPrefix(l, "a=rtpmap:")
+		if !ok {
+			continue
+		}
+		pt, codec, ok := strings.Cut(rtpmap, " ")
+		if ok && strings.HasPrefix(strings.ToLower(codec), "opus/") {
+			opus[pt] = true
+		}
+	}
+	if len(opus) == 0 {
+		return sdp
+	}
+
+	param := "maxaveragebitrate=" + strconv.FormatUint(bitrate, 10)
+	for i, l := range lines {
+		fmtp, ok := strings.CutPrefix(l, "a=fmtp:")
+		if !ok {
+			continue
+		}
+		pt, params, _ := strings.Cut(fmtp, " ")
+		if !opus[pt] {
+			continue
+		}
+		var ps []string
+		for _, p := range strings.Split(params, ";") {
+			if p != "" && !strings.HasPrefix(p, "maxaveragebitrate=") {
+				ps = append(ps, p)
+			}
+		}
+		ps = append(ps, param)
+		lines[i] = "a=fmtp:" + pt + " " + strings.Join(ps, ";")
+		delete(opus, pt)
+	}
+
+	// payload types without an fmtp line
+	if len(opus) > 0 {
+		var result []string
+		for _, l := range lines {
+			result = append(result, l)
+			rtpmap, ok := strings.CutPrefix(l, "a=rtpmap:")
+			if !ok {
+				continue
+			}
+			pt, _, _ := strings.Cut(rtpmap, " ")
+			if opus[pt] {
+				result = append(result, "a=fmtp:"+pt+" "+param)
+			}
+		}
+		lines = result
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// offersSimulcast returns true if an SDP offer requests simulcast.
+func offersSimulcast(sdp string) bool {
+	for _, l := range strings.Split(sdp, "\r\n") {
+		if strings.HasPrefix(l, "a=simulcast:") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSimulcast returns an error if an SDP offer requests simulcast
+// in a group whose quality profile disables it.
+func checkSimulcast(g *group.Group, sdp string) error {
+	p := qualityProfile(g)
+	if p == nil || p.Simulcast != "off" || !offersSimulcast(sdp) {
+		return nil
+	}
+	return group.UserError("simulcast is disabled in this group")
+}
+
+// profileFramerate returns the maximum framerate of a group's quality
+// profile, or 0.
+func profileFramerate(g *group.Group) float64 {
+	p := qualityProfile(g)
+	if p == nil {
+		return 0
+	}
+	return p.MaxFramerate
+}
+
+// profileAudioBitrate returns the audio bitrate required by a group's
+// quality profile, or 0.
+func profileAudioBitrate(g *group.Group) uint64 {
+	p := qualityProfile(g)
+	if p == nil {
+		return 0
+	}
+	return p.AudioBitrate
+}
@@ -0,0 +1,62 @@
+package rtpconn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimitAudioBitrate(t *testing.T) {
+	sdp := strings.Join([]string{
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 9",
+		"a=rtpmap:111 opus/48000/2",
+		"a=fmtp:111 minptime=10;useinbandfec=1;maxaveragebitrate=128000",
+		"a=rtpmap:9 G722/8000",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=rtpmap:96 VP8/90000",
+		"",
+	}, "\r\n")
+	expected := strings.Replace(sdp,
+		"useinbandfec=1;maxaveragebitrate=128000",
+		"useinbandfec=1;maxaveragebitrate=32000", 1)
+
+	if s := limitAudioBitrate(sdp, 0); s != sdp {
+		t.Errorf("Expected unchanged, got %v", s)
+	}
+	if s := limitAudioBitrate(sdp, 32000); s != expected {
+		t.Errorf("Expected %q, got %q", expected, s)
+	}
+
+	nofmtp := strings.Replace(sdp,
+		"a=fmtp:111 minptime=10;useinbandfec=1;maxaveragebitrate=128000\r\n",
+		"", 1)
+	expected = strings.Replace(nofmtp,
+		"a=rtpmap:111 opus/48000/2\r\n",
+		"a=rtpmap:111 opus/48000/2\r\na=fmtp:111 maxaveragebitrate=32000\r\n",
+		1)
+	if s := limitAudioBitrate(nofmtp, 32000); s != expected {
+		t.Errorf("Expected %q, got %q", expected, s)
+	}
+}
+
+func TestOffersSimulcast(t *testing.T) {
+	sdp := strings.Join([]string{
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=rtpmap:96 VP8/90000",
+		"a=rid:h send",
+		"a=rid:l send",
+		"a=simulcast:send h;l",
+		"",
+	}, "\r\n")
+	if !offersSimulcast(sdp) {
+		t.Errorf("Simulcast not detected")
+	}
+
+	plain := strings.Join([]string{
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=rtpmap:96 VP8/90000",
+		"",
+	}, "\r\n")
+	if offersSimulcast(plain) {
+		t.Errorf("Simulcast detected")
+	}
+}
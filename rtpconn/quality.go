@@ -0,0 +1,83 @@
+package rtpconn
+
+import (
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/stats"
+)
+
+// qualityKey is the key, in a client's data, of the connection quality
+// computed by the server.  Clients may not set it themselves.
+const qualityKey = "quality"
+
+// connectionQuality computes a coarse estimate of the quality of
+// a client's connection, one of "good", "fair" or "poor", from the
+// statistics of its tracks.  It returns the empty string if the client
+// has no tracks.
+func connectionQuality(cs *stats.Client) string {
+	var loss float64
+	var rtt time.Duration
+	var maxBitrate uint64
+	tracks := 0
+	check := func(t *stats.Track) {
+		tracks++
+		loss = max(loss, t.Loss)
+		rtt = max(rtt, time.Duration(t.Rtt))
+	}
+	for _, c := range cs.Up {
+		for i := range c.Tracks {
+			check(&c.Tracks[i])
+		}
+	}
+	for _, c := range cs.Down {
+		for i := range c.Tracks {
+			t := &c.Tracks[i]
+			check(t)
+			if maxBitrate == 0 || t.MaxBitrate < maxBitrate {
+				maxBitrate = t.MaxBitrate
+			}
+		}
+	}
+
+	if tracks == 0 {
+		return ""
+	}
+	if loss >= 0.1 || rtt >= time.Second ||
+		(maxBitrate > 0 && maxBitrate < group.LowBitrate) {
+		return "poor"
+	}
+	if loss >= 0.02 || rtt >= 300*time.Millisecond ||
+		(maxBitrate > 0 && maxBitrate < group.MinBitrate) {
+		return "fair"
+	}
+	return "good"
+}
+
+// updateQuality recomputes the quality of the client's connection, and
+// informs the group if it changed.  It is called from the client's
+// goroutine.
+func (c *webClient) updateQuality() {
+	g := c.group
+	if g == nil {
+		return
+	}
+	q := connectionQuality(c.GetStats())
+	if q == c.quality {
+		return
+	}
+	c.quality = q
+
+	// other goroutines may hold a reference to the old map
+	data := make(map[string]interface{}, len(c.data)+1)
+	for k, v := range c.data {
+		data[k] = v
+	}
+	if q == "" {
+		delete(data, qualityKey)
+	} else {
+		data[qualityKey] = q
+	}
+	c.data = data
+	pushData(c, g)
+}
@@ -0,0 +1,48 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jech/galene/stats"
+)
+
+func TestConnectionQuality(t *testing.T) {
+	down := func(loss float64, rtt time.Duration, maxBitrate uint64) *stats.Client {
+		return &stats.Client{
+			Down: []stats.Conn{{
+				Tracks: []stats.Track{{
+					Loss:       loss,
+					Rtt:        stats.Duration(rtt),
+					MaxBitrate: maxBitrate,
+				}},
+			}},
+		}
+	}
+	tests := []struct {
+		stats   *stats.Client
+		quality string
+	}{
+		{&stats.Client{}, ""},
+		{down(0, 50*time.Millisecond, 1000000), "good"},
+		{down(0.05, 50*time.Millisecond, 1000000), "fair"},
+		{down(0.2, 50*time.Millisecond, 1000000), "poor"},
+		{down(0, 500*time.Millisecond, 1000000), "fair"},
+		{down(0, 2*time.Second, 1000000), "poor"},
+		{down(0, 50*time.Millisecond, 150*1024), "fair"},
+		{down(0, 50*time.Millisecond, 50*1024), "poor"},
+		{&stats.Client{
+			Up: []stats.Conn{{
+				Tracks: []stats.Track{{Loss: 0.5}},
+			}},
+		}, "poor"},
+	}
+
+	for i, test := range tests {
+		q := connectionQuality(test.stats)
+		if q != test.quality {
+			t.Errorf("test %v: expected %v, got %v",
+				i, test.quality, q)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package rtpconn
+
+import (
+	"github.com/jech/galene/group"
+)
+
+// the keys, in a client's data, of the display name and avatar of
+// a registered user
+const (
+	displayNameKey = "displayName"
+	avatarKey      = "avatar"
+)
+
+// serverData returns true if the given key of a client's data is
+// maintained by the server, and may not be set by the client.
+func serverData(key string) bool {
+	return key == qualityKey || key == displayNameKey || key == avatarKey
+}
+
+// setRegisteredData sets the display name and avatar of a registered
+// user in the client's data.
+func (c *webClient) setRegisteredData() {
+	delete(c.data, displayNameKey)
+	delete(c.data, avatarKey)
+
+	u, err := group.GetRegisteredUser(c.username)
+	if err != nil {
+		c.log().Warn("Read user registry", "err", err)
+		return
+	}
+	if u == nil || (u.DisplayName == "" && u.Avatar == "") {
+		return
+	}
+	if c.data == nil {
+		c.data = make(map[string]interface{})
+	}
+	if u.DisplayName != "" {
+		c.data[displayNameKey] = u.DisplayName
+	}
+	if u.Avatar != "" {
+		c.data[avatarKey] = u.Avatar
+	}
+}
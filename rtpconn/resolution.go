@@ -0,0 +1,140 @@
+package rtpconn
+
+import (
+	"github.com/pion/webrtc/v3"
+
+	"github.com/jech/galene/conn"
+)
+
+func packDimensions(width, height uint32) uint64 {
+	return uint64(width)<<32 | uint64(height)
+}
+
+func unpackDimensions(d uint64) (uint32, uint32) {
+	return uint32(d >> 32), uint32(d)
+}
+
+// getDimensions returns the dimensions of the last keyframe received on
+// a video track, or zero if unknown.
+func (up *rtpUpTrack) getDimensions() (uint32, uint32) {
+	return unpackDimensions(up.dimensions.Load())
+}
+
+// setResolution records the size at which the subscriber renders
+// a connection, in pixels.  Zero means unknown.
+func (down *rtpDownConnection) setResolution(width, height uint32) {
+	down.resolution.Store(packDimensions(width, height))
+}
+
+func (down *rtpDownConnection) getResolution() (uint32, uint32) {
+	return unpackDimensions(down.resolution.Load())
+}
+
+// sufficient returns true if a video of the given dimensions is large
+// enough to be rendered at the given size without upscaling.  Since the
+// video is scaled to fit, it is enough for one of the dimensions to be
+// large enough.
+func sufficient(width, height, rwidth, rheight uint32) bool {
+	return width >= rwidth || height >= rheight
+}
+
+// pickVideoTrack chooses among the video tracks of a simulcast
+// connection the smallest one that is sufficient to render it at the
+// given size.  It returns nil if the choice cannot be made.
+func pickVideoTrack(tracks []conn.UpTrack, rwidth, rheight uint32) conn.UpTrack {
+	if rwidth == 0 || rheight == 0 {
+		return nil
+	}
+	var best, largest *rtpUpTrack
+	var bestWidth, largestWidth uint32
+	for _, t := range tracks {
+		if t.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		up, ok := t.(*rtpUpTrack)
+		if !ok {
+			return nil
+		}
+		w, h := up.getDimensions()
+		if w == 0 || h == 0 {
+			return nil
+		}
+		if largest == nil || w > largestWidth {
+			largest, largestWidth = up, w
+		}
+		if sufficient(w, h, rwidth, rheight) &&
+			(best == nil || w < bestWidth) {
+			best, bestWidth = up, w
+		}
+	}
+	if best != nil {
+		return best
+	}
+	if largest != nil {
+		return largest
+	}
+	return nil
+}
+
+// sidLimit returns the highest spatial layer that should be sent on
+// a down track, assuming that every spatial layer has twice the
+// dimensions of the one below.
+func (down *rtpDownTrack) sidLimit(layer layerInfo) uint8 {
+	if layer.limitSid {
+		return 0
+	}
+	rwidth, rheight := down.conn.getResolution()
+	remote, ok := down.remote.(*rtpUpTrack)
+	if rwidth == 0 || rheight == 0 || !ok {
+		return layer.maxSid
+	}
+	width, height := remote.getDimensions()
+	if width == 0 || height == 0 {
+		return layer.maxSid
+	}
+	for sid := uint8(0); sid < layer.maxSid; sid++ {
+		shift := layer.maxSid - sid
+		if sufficient(width>>shift, height>>shift, rwidth, rheight) {
+			return sid
+		}
+	}
+	return layer.maxSid
+}
+
+// getFramerate returns the number of frames per second received on
+// a video track, or zero if unknown.
+func (up *rtpUpTrack) getFramerate() uint32 {
+	if up.frames == nil {
+		return 0
+	}
+	_, frames := up.frames.Estimate()
+	return frames
+}
+
+// tidLimit returns the highest temporal layer that should be sent on
+// a down track in order to respect the maximum framerate.
+func (down *rtpDownTrack) tidLimit(layer layerInfo) uint8 {
+	max := down.conn.maxFramerate
+	remote, ok := down.remote.(*rtpUpTrack)
+	if max <= 0 || !ok {
+		return layer.maxTid
+	}
+	return framerateTid(remote.getFramerate(), layer.maxTid, max)
+}
+
+// framerateTid returns the highest temporal layer whose framerate is no
+// larger than max, assuming that every temporal layer doubles the
+// framerate of the one below.
+func framerateTid(framerate uint32, maxTid uint8, max float64) uint8 {
+	if framerate == 0 {
+		return maxTid
+	}
+	// allow some slack for the inaccuracy of the estimate
+	for tid := maxTid; tid > 0; tid-- {
+		shift := maxTid - tid
+		if float64(framerate>>shift) <= max*9/8 {
+			return tid
+		}
+	}
+	return 0
+}
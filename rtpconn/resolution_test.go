@@ -0,0 +1,90 @@
+package rtpconn
+
+import (
+	"testing"
+)
+
+func TestSidLimit(t *testing.T) {
+	up := &rtpUpTrack{}
+	down := &rtpDownTrack{
+		conn:   &rtpDownConnection{},
+		remote: up,
+	}
+	layer := layerInfo{maxSid: 2}
+
+	if l := down.sidLimit(layer); l != 2 {
+		t.Errorf("Unknown dimensions, got %v", l)
+	}
+
+	up.dimensions.Store(packDimensions(1280, 720))
+	if l := down.sidLimit(layer); l != 2 {
+		t.Errorf("Unknown resolution, got %v", l)
+	}
+
+	tests := []struct {
+		width, height uint32
+		sid           uint8
+	}{
+		{200, 100, 0},
+		{320, 180, 0},
+		{400, 200, 1},
+		{640, 1000, 1},
+		{1000, 700, 2},
+		{4000, 4000, 2},
+	}
+	for _, tt := range tests {
+		down.conn.setResolution(tt.width, tt.height)
+		if l := down.sidLimit(layer); l != tt.sid {
+			t.Errorf("%vx%v: got %v, expected %v",
+				tt.width, tt.height, l, tt.sid)
+		}
+	}
+
+	layer.limitSid = true
+	if l := down.sidLimit(layer); l != 0 {
+		t.Errorf("limitSid, got %v", l)
+	}
+}
+
+func TestFramerateTid(t *testing.T) {
+	tests := []struct {
+		framerate uint32
+		maxTid    uint8
+		max       float64
+		tid       uint8
+	}{
+		{0, 2, 15, 2},
+		{30, 0, 15, 0},
+		{30, 2, 30, 2},
+		{31, 2, 30, 2},
+		{30, 2, 15, 1},
+		{30, 2, 10, 0},
+		{60, 2, 15, 0},
+		{30, 1, 5, 0},
+	}
+	for _, tt := range tests {
+		tid := framerateTid(tt.framerate, tt.maxTid, tt.max)
+		if tid != tt.tid {
+			t.Errorf("%v fps, maxTid %v, max %v: got %v, expected %v",
+				tt.framerate, tt.maxTid, tt.max, tid, tt.tid)
+		}
+	}
+}
+
+func TestTidLimit(t *testing.T) {
+	up := &rtpUpTrack{}
+	down := &rtpDownTrack{
+		conn:   &rtpDownConnection{maxFramerate: 15},
+		remote: up,
+	}
+	layer := layerInfo{maxTid: 2}
+
+	if l := down.tidLimit(layer); l != 2 {
+		t.Errorf("Unknown framerate, got %v", l)
+	}
+
+	down.conn.maxFramerate = 0
+	if l := down.tidLimit(layer); l != 2 {
+		t.Errorf("Unlimited framerate, got %v", l)
+	}
+}
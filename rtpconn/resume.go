@@ -0,0 +1,157 @@
+package rtpconn
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// the time during which a client that lost its websocket may
+	// resume its session
+	resumeTimeout = 30 * time.Second
+	// the number of messages buffered for a suspended client
+	maxPendingMessages = 1024
+)
+
+var errSessionExpired = errors.New("session expired")
+
+// sessions maps session tokens to clients that may be resumed.
+var sessions struct {
+	mu sync.Mutex
+	m  map[string]*webClient
+}
+
+// A resumption is sent to a suspended client when a new websocket is
+// received for its session.
+type resumption struct {
+	conn    *websocket.Conn
+	release func()
+}
+
+// An attachment is sent to the writer to make it use a new websocket.
+// The greeting is sent before any pending messages.
+type attachment struct {
+	conn     *websocket.Conn
+	greeting clientMessage
+}
+
+// newSession makes a client resumable.
+func newSession(c *webClient) {
+	buf := make([]byte, 16)
+	crand.Read(buf)
+	c.session = base64.RawURLEncoding.EncodeToString(buf)
+	c.resume = make(chan resumption)
+	c.attach = make(chan attachment)
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	if sessions.m == nil {
+		sessions.m = make(map[string]*webClient)
+	}
+	sessions.m[c.session] = c
+}
+
+func delSession(c *webClient) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	if sessions.m[c.session] == c {
+		delete(sessions.m, c.session)
+	}
+}
+
+// resumeSession hands a new websocket over to the client that owns
+// the given session.  If it succeeds, the client becomes responsible for
+// calling release.
+func resumeSession(ws *websocket.Conn, session, id string, release func()) error {
+	sessions.mu.Lock()
+	c := sessions.m[session]
+	sessions.mu.Unlock()
+
+	if c == nil || c.id != id {
+		return errSessionExpired
+	}
+
+	select {
+	case c.resume <- resumption{conn: ws, release: release}:
+		return nil
+	case <-c.done:
+		return errSessionExpired
+	}
+}
+
+// suspendable returns true if a client whose reader failed with the
+// given error should wait for the session to be resumed rather than
+// leave its group.
+func (c *webClient) suspendable(err error) bool {
+	if c.session == "" || c.group == nil {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		websocket.IsCloseError(err, websocket.CloseAbnormalClosure)
+}
+
+// attachWebsocket causes the writer to switch to a new websocket,
+// send the greeting, then flush any messages queued in the meantime.
+func (c *webClient) attachWebsocket(ws *websocket.Conn, greeting clientMessage) error {
+	select {
+	case c.attach <- attachment{conn: ws, greeting: greeting}:
+		return nil
+	case <-c.writerDone:
+		ws.Close()
+		return ErrClientDead
+	}
+}
+
+// detachWebsocket causes the writer to close the websocket and queue
+// messages until a new one is attached.
+func (c *webClient) detachWebsocket() error {
+	select {
+	case c.attach <- attachment{}:
+		return nil
+	case <-c.writerDone:
+		return ErrClientDead
+	}
+}
+
+// releaseConnection stops counting the current websocket against the
+// client's address.  Called from the client goroutine.
+func (c *webClient) releaseConnection() {
+	if c.release != nil {
+		c.release()
+		c.release = nil
+	}
+}
+
+// resumed is called after a session has been resumed.  It restarts ICE
+// on the down connections that lost connectivity; up connections are
+// restarted by the client.
+func resumed(c *webClient) {
+	c.mu.Lock()
+	down := make([]*rtpDownConnection, 0, len(c.down))
+	for _, d := range c.down {
+		down = append(down, d)
+	}
+	c.mu.Unlock()
+
+	for _, d := range down {
+		switch d.pc.ICEConnectionState() {
+		case webrtc.ICEConnectionStateDisconnected,
+			webrtc.ICEConnectionStateFailed:
+			err := negotiate(c, d, true, "")
+			if err != nil {
+				c.log().Warn("ICE restart",
+					"conn", d.id, "err", err)
+			}
+		}
+	}
+}
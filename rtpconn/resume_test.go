@@ -0,0 +1,166 @@
+package rtpconn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketPair returns the server and client sides of a websocket.
+func websocketPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	ch := make(chan *websocket.Conn, 1)
+	var upgrader websocket.Upgrader
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade: %v", err)
+				return
+			}
+			ch <- conn
+		},
+	))
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-ch, client
+}
+
+func readType(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var m clientMessage
+	err := conn.ReadJSON(&m)
+	if err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	return m.Type
+}
+
+func TestClientWriterResume(t *testing.T) {
+	server1, client1 := websocketPair(t)
+	server2, client2 := websocketPair(t)
+
+	ch := make(chan interface{}, 10)
+	done := make(chan struct{})
+	attach := make(chan attachment)
+	go clientWriter(server1, ch, done, attach)
+
+	ch <- clientMessage{Type: "a"}
+	if tp := readType(t, client1); tp != "a" {
+		t.Errorf("Expected a, got %v", tp)
+	}
+
+	attach <- attachment{}
+	ch <- clientMessage{Type: "b"}
+	ch <- []byte(`{"type":"c"}`)
+	attach <- attachment{
+		conn:     server2,
+		greeting: clientMessage{Type: "handshake"},
+	}
+	for _, expected := range []string{"handshake", "b", "c"} {
+		if tp := readType(t, client2); tp != expected {
+			t.Errorf("Expected %v, got %v", expected, tp)
+		}
+	}
+
+	ch <- closeMessage{}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Writer didn't terminate")
+	}
+}
+
+func TestClientWriterOverflow(t *testing.T) {
+	server, _ := websocketPair(t)
+
+	ch := make(chan interface{})
+	done := make(chan struct{})
+	attach := make(chan attachment)
+	go clientWriter(server, ch, done, attach)
+
+	attach <- attachment{}
+	for i := 0; i <= maxPendingMessages; i++ {
+		select {
+		case ch <- clientMessage{Type: "chat"}:
+		case <-done:
+			t.Fatalf("Writer terminated early (%v)", i)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Writer didn't terminate")
+	}
+}
+
+func TestResumeRelease(t *testing.T) {
+	var released int
+	release := func() { released++ }
+
+	server, client := websocketPair(t)
+	err := client.WriteJSON(clientMessage{
+		Type:    "handshake",
+		Id:      "a",
+		Session: "unknown",
+	})
+	if err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	err = StartClient(server, nil, release)
+	if err != nil {
+		t.Errorf("StartClient: %v", err)
+	}
+	if released != 1 {
+		t.Errorf("Failed resume: released %v times", released)
+	}
+
+	released = 0
+	c := &webClient{id: "a", done: make(chan struct{})}
+	newSession(c)
+	defer delSession(c)
+
+	server, client = websocketPair(t)
+	err = client.WriteJSON(clientMessage{
+		Type:    "handshake",
+		Id:      "a",
+		Session: c.session,
+	})
+	if err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- StartClient(server, nil, release)
+	}()
+
+	var r resumption
+	select {
+	case r = <-c.resume:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for resumption")
+	}
+	err = <-result
+	if err != nil {
+		t.Errorf("StartClient: %v", err)
+	}
+	if r.conn != server {
+		t.Errorf("Resumed with the wrong websocket")
+	}
+	if released != 0 {
+		t.Errorf("Released %v times before the session", released)
+	}
+	r.release()
+	if released != 1 {
+		t.Errorf("Session released %v times", released)
+	}
+}
@@ -3,9 +3,9 @@ package rtpconn
 import (
 	"errors"
 	"io"
-	"log"
 	"math/bits"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,12 +20,15 @@ import (
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/ice"
 	"github.com/jech/galene/jitter"
+	"github.com/jech/galene/logging"
 	"github.com/jech/galene/packetcache"
 	"github.com/jech/galene/packetmap"
 	"github.com/jech/galene/rtptime"
 	"github.com/jech/galene/unbounded"
 )
 
+var logger = logging.New("rtpconn")
+
 type bitrate struct {
 	bitrate uint64
 	jiffies uint64
@@ -93,6 +96,7 @@ type rtpDownTrack struct {
 	stats          *receiverStats
 	atomics        *downTrackAtomics
 	cname          atomic.Value
+	queue          *downQueue // nil for audio tracks
 }
 
 func (down *rtpDownTrack) SetTimeOffset(ntp uint64, rtp uint32) {
@@ -180,11 +184,33 @@ type rtpDownConnection struct {
 	iceCandidates     []*webrtc.ICECandidateInit
 	negotiationNeeded int
 	requested         []string
+	trace             setupTrace
+
+	// called when the subscriber is too slow to receive this connection
+	congested func()
+	// called when the connection switches to or from audio-only
+	audioOnly func(bool)
+	// only accessed by rtcpDownSender
+	fallback audioOnlyState
+	// the size at which the subscriber renders this connection
+	resolution atomic.Uint64
+	// the maximum framerate of video, zero if unlimited
+	maxFramerate float64
+	// incremented by the number of bytes sent, may be nil
+	sent *atomic.Uint64
+	// returns false if a keyframe request should be dropped, may be nil
+	allowKeyframe func() bool
+	// paces the video packets, may be nil
+	pacer *pacer
 
 	mu     sync.Mutex
 	tracks []*rtpDownTrack
 }
 
+func (down *rtpDownConnection) keyframeAllowed() bool {
+	return down.allowKeyframe == nil || down.allowKeyframe()
+}
+
 func (down *rtpDownConnection) getTracks() []*rtpDownTrack {
 	down.mu.Lock()
 	defer down.mu.Unlock()
@@ -204,7 +230,7 @@ func newDownConn(c group.Client, id string, remote conn.Up) (*rtpDownConnection,
 	}
 
 	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		log.Printf("Got track on downstream connection")
+		logger.Warn("Got track on downstream connection")
 	})
 
 	conn := &rtpDownConnection{
@@ -212,6 +238,7 @@ func newDownConn(c group.Client, id string, remote conn.Up) (*rtpDownConnection,
 		pc:     pc,
 		remote: remote,
 	}
+	conn.pacer = newPacer(conn.targetRate)
 
 	return conn, nil
 }
@@ -236,18 +263,20 @@ func (down *rtpDownTrack) Write(buf []byte) (int, error) {
 		if flags.Tid > layer.maxTid {
 			// increase eagerly if this is the first time we
 			// see a given layer
-			if layer.tid == layer.maxTid {
+			eager := layer.tid == layer.maxTid
+			layer.maxTid = flags.Tid
+			if eager && flags.Tid <= down.tidLimit(layer) {
 				layer.wantedTid = flags.Tid
 				layer.tid = flags.Tid
 			}
-			layer.maxTid = flags.Tid
 		}
 		if flags.Sid > layer.maxSid {
-			if layer.sid == layer.maxSid && !layer.limitSid {
+			eager := layer.sid == layer.maxSid
+			layer.maxSid = flags.Sid
+			if eager && flags.Sid <= down.sidLimit(layer) {
 				layer.wantedSid = flags.Sid
 				layer.sid = flags.Sid
 			}
-			layer.maxSid = flags.Sid
 		}
 		down.setLayerInfo(layer)
 		down.adjustLayer()
@@ -284,6 +313,21 @@ func (down *rtpDownTrack) Write(buf []byte) (int, error) {
 		}
 	}
 
+	if down.queue != nil {
+		now := rtptime.Jiffies()
+		drop, kf := down.queue.drop(flags.Start, flags.Keyframe,
+			down.congestionRate(now), now)
+		if kf {
+			down.remote.RequestKeyframe()
+		}
+		if drop {
+			ok := down.packetmap.Drop(flags.Seqno, flags.Pid)
+			if ok {
+				return 0, nil
+			}
+		}
+	}
+
 	ok, newseqno, piddelta := down.packetmap.Map(flags.Seqno, flags.Pid)
 	if !ok {
 		return 0, nil
@@ -308,9 +352,24 @@ func (down *rtpDownTrack) Write(buf []byte) (int, error) {
 }
 
 func (down *rtpDownTrack) write(buf []byte) (int, error) {
-	n, err := down.track.Write(buf)
+	var n int
+	var err error
+	if down.queue != nil && down.conn.pacer != nil {
+		err = down.conn.pacer.push(down.track, buf)
+		if err == nil {
+			n = len(buf)
+		}
+	} else {
+		n, err = down.track.Write(buf)
+	}
 	if err == nil {
 		down.rate.Accumulate(uint32(n))
+		if down.conn.sent != nil {
+			down.conn.sent.Add(uint64(n))
+		}
+		if down.queue != nil {
+			down.queue.sent(n)
+		}
 	}
 	return n, err
 }
@@ -331,36 +390,44 @@ func (t *rtpDownTrack) GetMaxBitrate() (uint64, int, int) {
 
 // adjustLayer checks the allowable bitrate reported for a down track and
 // adjusts the layer by one step.  It prefers temporal layers, and only
-// uses spatial layers as a last resort.
+// uses spatial layers as a last resort.  It never goes above the spatial
+// layer needed for the size at which the subscriber renders the video,
+// or above the temporal layer allowed by the maximum framerate.
 func (t *rtpDownTrack) adjustLayer() {
+	layer := t.getLayerInfo()
+	limit := t.sidLimit(layer)
+	tlimit := t.tidLimit(layer)
+	if layer.wantedSid > limit || layer.wantedTid > tlimit {
+		layer.wantedSid = min(layer.wantedSid, limit)
+		layer.wantedTid = min(layer.wantedTid, tlimit)
+		t.setLayerInfo(layer)
+		return
+	}
+
 	max, _, _ := t.GetMaxBitrate()
 	r, _ := t.rate.Estimate()
 	rate := uint64(r) * 8
 	if rate < max*7/8 {
 		// switch up
-		layer := t.getLayerInfo()
-		if layer.limitSid && layer.wantedSid != 0 {
-			layer.wantedSid = 0
-			t.setLayerInfo(layer)
-		} else if !layer.limitSid && layer.sid < layer.maxSid {
+		if layer.sid < limit {
 			layer.wantedSid = layer.sid + 1
 			t.setLayerInfo(layer)
-		} else if layer.tid < layer.maxTid {
+		} else if layer.tid < tlimit {
 			layer.wantedTid = layer.tid + 1
 			t.setLayerInfo(layer)
 		}
 	} else if rate > max*3/2 {
 		// switch down
-		layer := t.getLayerInfo()
 		if layer.tid > 0 {
 			layer.wantedTid = layer.tid - 1
 			t.setLayerInfo(layer)
 		} else if layer.sid > 0 {
-			if layer.limitSid {
-				layer.wantedSid = 0
-			} else {
-				layer.wantedSid = layer.sid - 1
+			// screen shares prefer resolution over framerate,
+			// reduce their resolution reluctantly
+			if t.screenshare() && rate <= max*2 {
+				return
 			}
+			layer.wantedSid = min(layer.sid-1, limit)
 			t.setLayerInfo(layer)
 		}
 	}
@@ -403,6 +470,10 @@ type rtpUpTrack struct {
 	cache    *packetcache.Cache
 	jitter   *jitter.Estimator
 	cname    atomic.Value
+	// the dimensions of the last keyframe, see getDimensions
+	dimensions atomic.Uint64
+	// counts the frames received, see getFramerate
+	frames *estimator.Estimator
 
 	actions    *unbounded.Channel[trackAction]
 	readerDone chan struct{}
@@ -508,6 +579,7 @@ type rtpUpConnection struct {
 	label         string
 	pc            *webrtc.PeerConnection
 	iceCandidates []*webrtc.ICECandidateInit
+	trace         setupTrace
 
 	mu      sync.Mutex
 	closed  bool
@@ -515,6 +587,8 @@ type rtpUpConnection struct {
 	replace string
 	tracks  []*rtpUpTrack
 	local   []conn.Down
+
+	capture atomic.Pointer[capture]
 }
 
 func (up *rtpUpConnection) getTracks() []*rtpUpTrack {
@@ -611,8 +685,29 @@ func pushConnNow(up *rtpUpConnection, g *group.Group, cs []group.Client) {
 	up.mu.Unlock()
 
 	for _, c := range cs {
-		c.PushConn(g, up.id, up, tracks, replace)
+		c.PushConn(g, echoId(c, up.client, up.id), up, tracks,
+			echoId(c, up.client, replace))
+	}
+}
+
+// pushTargets returns the clients that receive the streams published by
+// a client.  In echo groups, streams are only sent back to the client
+// that published them.
+func pushTargets(c group.Client, g *group.Group) []group.Client {
+	if g.Description().Echo {
+		return []group.Client{c}
+	}
+	return g.GetClients(c)
+}
+
+// echoId returns the id of the down connection that carries the up
+// connection id, published by owner, to target.  When a stream is sent
+// back to its publisher, the two connections must have distinct ids.
+func echoId(target, owner group.Client, id string) string {
+	if id == "" || target != owner {
+		return id
 	}
+	return id + "-echo"
 }
 
 // pushConn schedules a call to pushConnNow
@@ -671,8 +766,9 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 			track:      remote,
 			receiver:   receiver,
 			conn:       up,
-			cache:      packetcache.New(minPacketCache(remote)),
+			cache:      packetcache.New(minPacketCache(remote, up.isScreenshare())),
 			rate:       estimator.New(time.Second),
+			frames:     estimator.New(time.Second),
 			jitter:     jitter.New(remote.Codec().ClockRate),
 			actions:    unbounded.New[trackAction](),
 			readerDone: make(chan struct{}),
@@ -686,10 +782,10 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 
 		up.mu.Unlock()
 
-		pushConn(up, c.Group(), c.Group().GetClients(c))
+		pushConn(up, c.Group(), pushTargets(c, c.Group()))
 	})
 
-	pushConn(up, c.Group(), c.Group().GetClients(c))
+	pushConn(up, c.Group(), pushTargets(c, c.Group()))
 	go rtcpUpSender(up)
 
 	return up, nil
@@ -739,7 +835,7 @@ func (track *rtpUpTrack) sendNACKs(seqnos []uint16) error {
 
 	for len(seqnos) > 0 {
 		if len(nacks) >= 240 {
-			log.Printf("NACK: packet overflow")
+			logger.Warn("NACK: packet overflow")
 			break
 		}
 		var f, b uint16
@@ -777,7 +873,7 @@ func gotNACK(track *rtpDownTrack, p *rtcp.TransportLayerNack) {
 			}
 			_, err := track.Write(buf[:l])
 			if err != nil {
-				log.Printf("Write: %v", err)
+				logger.Warn("Write", "err", err)
 				return false
 			}
 			return true
@@ -817,13 +913,16 @@ func rtcpUpListener(track *rtpUpTrack) {
 		n, _, err := track.receiver.ReadSimulcast(buf, track.track.RID())
 		if err != nil {
 			if err != io.EOF && err != io.ErrClosedPipe {
-				log.Printf("Read RTCP: %v", err)
+				logger.Warn("Read RTCP", "err", err)
 			}
 			return
 		}
+		if c := track.conn.capture.Load(); c != nil {
+			c.rtcp(buf[:n])
+		}
 		ps, err := rtcp.Unmarshal(buf[:n])
 		if err != nil {
-			log.Printf("Unmarshal RTCP: %v", err)
+			logger.Warn("Unmarshal RTCP", "err", err)
 			continue
 		}
 
@@ -871,7 +970,7 @@ func rtcpUpListener(track *rtpUpTrack) {
 				if ok {
 					err := sendSR(l)
 					if err != nil {
-						log.Printf("sendSR: %v", err)
+						logger.Warn("sendSR", "err", err)
 					}
 				}
 			}
@@ -929,6 +1028,35 @@ func maxUpBitrate(t *rtpUpTrack) uint64 {
 	return maxrate
 }
 
+// maxFECLoss is the highest downstream loss rate, in units of 1/256, that
+// is reported to the publisher of an Opus track.  It is just under the
+// 10% threshold at which browsers reduce their sending rate.
+const maxFECLoss = 25
+
+// fecLoss returns the loss rate that should be reported to the publisher
+// of a track with the given codec and local tracks.  Opus encoders enable inband FEC and adjust its
+// redundancy according to the loss rate in receiver reports, so we report
+// the loss seen by the subscribers if it is higher than our own.
+func fecLoss(codec webrtc.RTPCodecCapability, local []conn.DownTrack, loss uint8, now uint64) uint8 {
+	if !strings.EqualFold(codec.MimeType, "audio/opus") {
+		return loss
+	}
+	for _, l := range local {
+		down, ok := l.(*rtpDownTrack)
+		if !ok {
+			continue
+		}
+		dl, _ := down.stats.Get(now)
+		if dl > maxFECLoss {
+			dl = maxFECLoss
+		}
+		if dl > loss {
+			loss = dl
+		}
+	}
+	return loss
+}
+
 func sendUpRTCP(up *rtpUpConnection) error {
 	tracks := up.getTracks()
 
@@ -971,8 +1099,11 @@ func sendUpRTCP(up *rtpUpConnection) error {
 		}
 
 		reports = append(reports, rtcp.ReceptionReport{
-			SSRC:               uint32(t.track.SSRC()),
-			FractionLost:       uint8(fractionLost),
+			SSRC: uint32(t.track.SSRC()),
+			FractionLost: fecLoss(
+				t.Codec(), t.getLocal(),
+				uint8(fractionLost), now,
+			),
 			TotalLost:          totalLost,
 			LastSequenceNumber: stats.ESeqno,
 			Jitter:             t.jitter.Jitter(),
@@ -999,7 +1130,11 @@ func sendUpRTCP(up *rtpUpConnection) error {
 		} else if t.Label() == "l" {
 			rate = sadd(rate, group.LowBitrate)
 		} else {
-			rate = sadd(rate, maxUpBitrate(t))
+			r := maxUpBitrate(t)
+			if m := maxVideoBitrate(up); m > 0 && r > m {
+				r = m
+			}
+			rate = sadd(rate, r)
 		}
 	}
 
@@ -1025,11 +1160,27 @@ func rtcpUpSender(conn *rtpUpConnection) {
 			if err == io.EOF || err == io.ErrClosedPipe {
 				return
 			}
-			log.Printf("sendUpRTCP: %v", err)
+			logger.Warn("sendUpRTCP", "err", err)
 		}
 	}
 }
 
+// rtpTime returns the RTP timestamp of the track corresponding to a given
+// time, as derived from the last sender report of the publisher.  It
+// returns false if no recent sender report has been received.
+func (t *rtpDownTrack) rtpTime(now time.Time) (uint32, bool) {
+	remoteNTP, remoteRTP := t.getTimeOffset()
+	if remoteNTP == 0 {
+		return 0, false
+	}
+	d := now.Sub(rtptime.NTPToTime(remoteNTP))
+	if d <= 0 || d >= time.Hour {
+		return 0, false
+	}
+	delay := rtptime.FromDuration(d, t.track.Codec().ClockRate)
+	return remoteRTP + uint32(delay), true
+}
+
 func sendSR(conn *rtpDownConnection) error {
 	tracks := conn.getTracks()
 
@@ -1040,21 +1191,9 @@ func sendSR(conn *rtpDownConnection) error {
 	jiffies := rtptime.TimeToJiffies(now)
 
 	for _, t := range tracks {
-		clockrate := t.track.Codec().ClockRate
-
-		var nowRTP uint32
-
-		remoteNTP, remoteRTP := t.getTimeOffset()
+		remoteNTP, _ := t.getTimeOffset()
 		if remoteNTP != 0 {
-			srTime := rtptime.NTPToTime(remoteNTP)
-			d := now.Sub(srTime)
-			if d > 0 && d < time.Hour {
-				delay := rtptime.FromDuration(
-					d, clockrate,
-				)
-				nowRTP = remoteRTP + uint32(delay)
-			}
-
+			nowRTP, _ := t.rtpTime(now)
 			p, b := t.rate.Totals()
 			packets = append(packets,
 				&rtcp.SenderReport{
@@ -1098,6 +1237,7 @@ func sendSR(conn *rtpDownConnection) error {
 }
 
 func rtcpDownSender(conn *rtpDownConnection) {
+	congested := false
 	for {
 		time.Sleep(time.Second / 2)
 		err := sendSR(conn)
@@ -1105,7 +1245,19 @@ func rtcpDownSender(conn *rtpDownConnection) {
 			if err == io.EOF || err == io.ErrClosedPipe {
 				return
 			}
-			log.Printf("sendSR: %v", err)
+			logger.Warn("sendSR", "err", err)
+		}
+		conn.checkAudioOnly(rtptime.Jiffies())
+		if !congested && conn.congested != nil {
+			now := rtptime.Jiffies()
+			for _, t := range conn.getTracks() {
+				if t.queue != nil && t.queue.check(now) {
+					congested = true
+				}
+			}
+			if congested {
+				conn.congested()
+			}
 		}
 	}
 }
@@ -1155,13 +1307,13 @@ func rtcpDownListener(track *rtpDownTrack) {
 		n, _, err := track.sender.Read(buf)
 		if err != nil {
 			if err != io.EOF && err != io.ErrClosedPipe {
-				log.Printf("Read RTCP: %v", err)
+				logger.Warn("Read RTCP", "err", err)
 			}
 			return
 		}
 		ps, err := rtcp.Unmarshal(buf[:n])
 		if err != nil {
-			log.Printf("Unmarshal RTCP: %v", err)
+			logger.Warn("Unmarshal RTCP", "err", err)
 			continue
 		}
 
@@ -1171,7 +1323,9 @@ func rtcpDownListener(track *rtpDownTrack) {
 		for _, p := range ps {
 			switch p := p.(type) {
 			case *rtcp.PictureLossIndication:
-				track.remote.RequestKeyframe()
+				if track.conn.keyframeAllowed() {
+					track.remote.RequestKeyframe()
+				}
 			case *rtcp.FullIntraRequest:
 				found := false
 				var seqno uint8
@@ -1183,11 +1337,12 @@ func rtcpDownListener(track *rtpDownTrack) {
 					}
 				}
 				if !found {
-					log.Printf("Misdirected FIR")
+					logger.Warn("Misdirected FIR")
 					continue
 				}
 
-				if seqno != lastFirSeqno {
+				if seqno != lastFirSeqno &&
+					track.conn.keyframeAllowed() {
 					track.remote.RequestKeyframe()
 				}
 			case *rtcp.ReceiverEstimatedMaximumBitrate:
@@ -1244,13 +1399,6 @@ func handleReport(track *rtpDownTrack, report rtcp.ReceptionReport, jiffies uint
 	}
 }
 
-func minPacketCache(track *webrtc.TrackRemote) int {
-	if track.Kind() == webrtc.RTPCodecTypeVideo {
-		return 128
-	}
-	return 24
-}
-
 func updateUpTrack(track *rtpUpTrack) {
 	now := rtptime.Jiffies()
 
@@ -1272,7 +1420,7 @@ func updateUpTrack(track *rtpUpTrack) {
 	}
 	_, r := track.rate.Estimate()
 	packets := int((uint64(r) * maxrto * 4) / rtptime.JiffiesPerSec)
-	min := minPacketCache(track.track)
+	min := minPacketCache(track.track, track.conn.isScreenshare())
 	if packets < min {
 		packets = min
 	}
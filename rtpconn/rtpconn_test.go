@@ -3,6 +3,9 @@ package rtpconn
 import (
 	"testing"
 
+	"github.com/pion/webrtc/v3"
+
+	"github.com/jech/galene/conn"
 	"github.com/jech/galene/rtptime"
 )
 
@@ -54,3 +57,58 @@ func TestSadd(t *testing.T) {
 		}
 	}
 }
+
+func TestEchoId(t *testing.T) {
+	a := &webClient{id: "a"}
+	b := &webClient{id: "b"}
+
+	if id := echoId(b, a, "conn"); id != "conn" {
+		t.Errorf("Expected conn, got %v", id)
+	}
+	if id := echoId(a, a, "conn"); id != "conn-echo" {
+		t.Errorf("Expected conn-echo, got %v", id)
+	}
+	if id := echoId(a, a, ""); id != "" {
+		t.Errorf("Expected empty id, got %v", id)
+	}
+}
+
+func TestFECLoss(t *testing.T) {
+	now := rtptime.Jiffies()
+	opus := webrtc.RTPCodecCapability{MimeType: "audio/opus"}
+	vp8 := webrtc.RTPCodecCapability{MimeType: "video/VP8"}
+
+	down := func(loss uint8) *rtpDownTrack {
+		d := &rtpDownTrack{stats: new(receiverStats)}
+		d.stats.Set(loss, 0, now)
+		return d
+	}
+
+	tests := []struct {
+		codec    webrtc.RTPCodecCapability
+		local    []conn.DownTrack
+		loss     uint8
+		expected uint8
+	}{
+		{opus, nil, 3, 3},
+		{opus, []conn.DownTrack{down(10), down(2)}, 3, 10},
+		{opus, []conn.DownTrack{down(1)}, 3, 3},
+		{opus, []conn.DownTrack{down(100)}, 3, maxFECLoss},
+		{opus, []conn.DownTrack{down(100)}, 50, 50},
+		{vp8, []conn.DownTrack{down(10)}, 3, 3},
+	}
+	for i, tt := range tests {
+		l := fecLoss(tt.codec, tt.local, tt.loss, now)
+		if l != tt.expected {
+			t.Errorf("Test %v: got %v, expected %v",
+				i, l, tt.expected)
+		}
+	}
+
+	// stale receiver reports are ignored
+	local := []conn.DownTrack{down(10)}
+	l := fecLoss(opus, local, 3, now+2*receiverReportTimeout)
+	if l != 3 {
+		t.Errorf("Stale report: got %v, expected 3", l)
+	}
+}
@@ -2,7 +2,6 @@ package rtpconn
 
 import (
 	"io"
-	"log"
 	"time"
 
 	"github.com/pion/rtp"
@@ -15,19 +14,26 @@ import (
 
 func readLoop(track *rtpUpTrack) {
 	writers := rtpWriterPool{track: track}
+	isvideo := track.track.Kind() == webrtc.RTPCodecTypeVideo
+	var talk talkDetector
+	if !isvideo {
+		talk.id = audioLevelId(track.receiver)
+	}
 	defer func() {
+		track.addTalkTime(talk.flush(time.Now(), true))
 		writers.close()
 		close(track.readerDone)
 	}()
 
-	isvideo := track.track.Kind() == webrtc.RTPCodecTypeVideo
 	codec := track.track.Codec()
 	sendNACK := track.hasRtcpFb("nack", "")
 	sendPLI := track.hasRtcpFb("nack", "pli")
 	var kfNeeded bool
 	var kfRequested time.Time
+	kfInterval := keyframeInterval(track.conn.isScreenshare())
 	buf := make([]byte, packetcache.BufSize)
 	var packet rtp.Packet
+	log := logger.With("conn", track.conn.id, "track", track.track.ID())
 	for {
 
 		select {
@@ -41,15 +47,14 @@ func readLoop(track *rtpUpTrack) {
 						action.action == trackActionAdd,
 					)
 					if err != nil {
-						log.Printf(
-							"add/remove track: %v",
-							err,
+						log.Warn("Add/remove track",
+							"err", err,
 						)
 					}
 				case trackActionKeyframe:
 					kfNeeded = true
 				default:
-					log.Printf("Unknown action")
+					log.Warn("Unknown action")
 				}
 			}
 		default:
@@ -58,30 +63,52 @@ func readLoop(track *rtpUpTrack) {
 		bytes, _, err := track.track.Read(buf)
 		if err != nil {
 			if err != io.EOF {
-				log.Printf("%v", err)
+				log.Warn("Read", "err", err)
 			}
 			break
 		}
 		track.rate.Accumulate(uint32(bytes))
 
+		if c := track.conn.capture.Load(); c != nil {
+			c.rtp(buf[:bytes])
+		}
+
 		err = packet.Unmarshal(buf[:bytes])
 		if err != nil {
-			log.Printf("%v", err)
+			log.Warn("Unmarshal RTP", "err", err)
 			continue
 		}
 
 		track.jitter.Accumulate(packet.Timestamp)
+		if isvideo && packet.Marker {
+			// the marker bit is set on the last packet of a frame
+			track.frames.Accumulate(0)
+		}
 
 		kf, kfKnown := codecs.Keyframe(codec.MimeType, &packet)
 		if kf || !kfKnown {
 			kfNeeded = false
 		}
+		if kf && isvideo {
+			w, h := codecs.KeyframeDimensions(codec.MimeType, &packet)
+			if w > 0 && h > 0 {
+				track.dimensions.Store(packDimensions(w, h))
+			}
+			if track.conn.trace.running("keyframe") {
+				track.conn.trace.done(nil)
+			}
+		}
 		if packet.Extension {
+			if talk.id != 0 {
+				now := time.Now()
+				talk.packet(&packet, now)
+				track.addTalkTime(talk.flush(now, false))
+			}
 			packet.Extension = false
 			packet.Extensions = nil
 			bytes, err = packet.MarshalTo(buf)
 			if err != nil {
-				log.Printf("%v", err)
+				log.Warn("Marshal RTP", "err", err)
 				continue
 			}
 		}
@@ -120,7 +147,7 @@ func readLoop(track *rtpUpTrack) {
 			if found && sendNACK {
 				err := track.sendNACK(first, bitmap)
 				if err != nil {
-					log.Printf("%v", err)
+					log.Warn("sendNACK", "err", err)
 				}
 			}
 		}
@@ -134,11 +161,11 @@ func readLoop(track *rtpUpTrack) {
 			isvideo, packet.Marker)
 
 		now := time.Now()
-		if kfNeeded && now.Sub(kfRequested) > time.Second/2 {
+		if kfNeeded && now.Sub(kfRequested) > kfInterval {
 			if sendPLI {
 				err := track.sendPLI()
 				if err != nil {
-					log.Printf("sendPLI: %v", err)
+					log.Warn("sendPLI", "err", err)
 					kfNeeded = false
 				}
 			} else {
@@ -13,7 +13,8 @@ func (c *webClient) GetStats() *stats.Client {
 	defer c.mu.Unlock()
 
 	cs := stats.Client{
-		Id: c.id,
+		Id:       c.id,
+		Username: c.username,
 	}
 
 	for _, up := range c.up {
@@ -31,11 +32,14 @@ func (c *webClient) GetStats() *stats.Client {
 			jitter := time.Duration(t.jitter.Jitter()) *
 				(time.Second / time.Duration(t.jitter.HZ()))
 			rate, _ := t.rate.Estimate()
+			width, height := t.getDimensions()
 			conns.Tracks = append(conns.Tracks, stats.Track{
 				Bitrate:    uint64(rate) * 8,
 				MaxBitrate: maxUpBitrate(t),
 				Loss:       loss,
 				Jitter:     stats.Duration(jitter),
+				Width:      width,
+				Height:     height,
 			})
 		}
 		cs.Up = append(cs.Up, conns)
@@ -62,6 +66,13 @@ func (c *webClient) GetStats() *stats.Client {
 			loss, jitter := t.stats.Get(jiffies)
 			j := time.Duration(jitter) * time.Second /
 				time.Duration(t.track.Codec().ClockRate)
+			var width, height uint32
+			if remote, ok := t.remote.(*rtpUpTrack); ok {
+				// the dimensions of the spatial layer being sent
+				width, height = remote.getDimensions()
+				width >>= maxSid - sid
+				height >>= maxSid - sid
+			}
 			conns.Tracks = append(conns.Tracks, stats.Track{
 				Tid:        &tid,
 				MaxTid:     &maxTid,
@@ -72,6 +83,8 @@ func (c *webClient) GetStats() *stats.Client {
 				Loss:       float64(loss) / 256.0,
 				Rtt:        stats.Duration(rtt),
 				Jitter:     stats.Duration(j),
+				Width:      width,
+				Height:     height,
 			})
 		}
 		cs.Down = append(cs.Down, conns)
@@ -82,3 +95,48 @@ func (c *webClient) GetStats() *stats.Client {
 
 	return &cs
 }
+
+// setStatsInterval sets the interval at which statistics about the
+// group are sent to the client, or stops sending them if interval is 0.
+func (c *webClient) setStatsInterval(interval time.Duration) {
+	if c.statsTicker != nil {
+		c.statsTicker.Stop()
+		c.statsTicker = nil
+	}
+	if interval > 0 {
+		c.statsTicker = time.NewTicker(interval)
+	}
+}
+
+func (c *webClient) sendStats() error {
+	g := c.group
+	if g == nil {
+		return nil
+	}
+	var gs *stats.GroupStats
+	if g.Description().Echo && !member("op", c.permissions) {
+		// only show the client its own statistics
+		gs = &stats.GroupStats{
+			Name:    g.Name(),
+			Clients: []*stats.Client{c.GetStats()},
+		}
+	} else {
+		gs = stats.GetGroup(g.Name())
+		if gs == nil {
+			return nil
+		}
+	}
+	return c.write(clientMessage{
+		Type:  "stats",
+		Value: gs,
+	})
+}
+
+// tickerChannel returns the channel of a ticker, or nil if the ticker is
+// nil, which is convenient in select statements.
+func tickerChannel(t *time.Ticker) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
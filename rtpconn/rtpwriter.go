@@ -2,7 +2,6 @@ package rtpconn
 
 import (
 	"errors"
-	"log"
 	"sort"
 	"time"
 
@@ -59,7 +58,7 @@ func (wp *rtpWriterPool) add(track conn.DownTrack, add bool) error {
 				if wp.count > 0 {
 					wp.count--
 				} else {
-					log.Printf("Negative writer count!")
+					logger.Warn("Negative writer count!")
 				}
 			}
 			return nil
@@ -258,7 +257,10 @@ func rtpWriterLoop(writer *rtpWriter, track *rtpUpTrack) {
 				last, foundLast := track.cache.Last()
 				kf, foundKf := track.cache.Keyframe()
 				if foundLast && foundKf {
-					if last-kf < 40 { // modulo 2^16
+					window := keyframeWindow(
+						track.conn.isScreenshare(),
+					)
+					if last-kf < window { // modulo 2^16
 						go sendSequence(
 							kf, last,
 							action.track,
@@ -0,0 +1,74 @@
+package rtpconn
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Screen shares contain text that must remain readable, and they change
+// rarely, so that browsers encode them with large keyframes that are sent
+// infrequently.  We avoid requesting keyframes too often, and keep
+// enough packets in the cache to send the last keyframe to new
+// subscribers.
+
+// isScreenshare returns true if a connection carries a screen share.
+func (up *rtpUpConnection) isScreenshare() bool {
+	return up.label == "screenshare"
+}
+
+// keyframeInterval returns the minimum interval between two keyframe
+// requests sent to the publisher of a track.
+func keyframeInterval(screenshare bool) time.Duration {
+	if screenshare {
+		return 2 * time.Second
+	}
+	return time.Second / 2
+}
+
+// keyframeWindow returns the maximum number of packets since the last
+// keyframe for which a new subscriber is served from the cache rather
+// than by requesting a new keyframe.
+func keyframeWindow(screenshare bool) uint16 {
+	if screenshare {
+		return 400
+	}
+	return 40
+}
+
+// maxVideoBitrate returns the maximum bitrate configured for the video
+// tracks of a connection, or 0 if none.  For cameras, this is the smaller
+// of the group's limit and that of its quality profile.
+func maxVideoBitrate(up *rtpUpConnection) uint64 {
+	g := up.client.Group()
+	if g == nil {
+		return 0
+	}
+	desc := g.Description()
+	if up.isScreenshare() {
+		return desc.MaxScreenshareBitrate
+	}
+	rate := desc.MaxVideoBitrate
+	if p := desc.Profile(); p != nil && p.MaxVideoBitrate > 0 {
+		if rate == 0 || p.MaxVideoBitrate < rate {
+			rate = p.MaxVideoBitrate
+		}
+	}
+	return rate
+}
+
+// screenshare returns true if a down track carries a screen share.
+func (down *rtpDownTrack) screenshare() bool {
+	up, ok := down.remote.(*rtpUpTrack)
+	return ok && up.conn.isScreenshare()
+}
+
+func minPacketCache(track *webrtc.TrackRemote, screenshare bool) int {
+	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		if screenshare {
+			return 512
+		}
+		return 128
+	}
+	return 24
+}
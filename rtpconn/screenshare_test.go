@@ -0,0 +1,66 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestScreensharePolicy(t *testing.T) {
+	g, err := group.Add("screenshare-test", &group.Description{
+		MaxVideoBitrate:       1000000,
+		MaxScreenshareBitrate: 3000000,
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("screenshare-test")
+
+	c := &webClient{group: g, id: "id"}
+	camera := &rtpUpConnection{client: c, label: "camera"}
+	screen := &rtpUpConnection{client: c, label: "screenshare"}
+
+	if camera.isScreenshare() || !screen.isScreenshare() {
+		t.Errorf("isScreenshare: got %v %v",
+			camera.isScreenshare(), screen.isScreenshare())
+	}
+
+	if r := maxVideoBitrate(camera); r != 1000000 {
+		t.Errorf("Camera bitrate: got %v", r)
+	}
+	if r := maxVideoBitrate(screen); r != 3000000 {
+		t.Errorf("Screenshare bitrate: got %v", r)
+	}
+
+	down := &rtpDownTrack{remote: &rtpUpTrack{conn: camera}}
+	if down.screenshare() {
+		t.Errorf("Camera down track is a screen share")
+	}
+	down = &rtpDownTrack{remote: &rtpUpTrack{conn: screen}}
+	if !down.screenshare() {
+		t.Errorf("Screenshare down track is not a screen share")
+	}
+
+	if keyframeInterval(true) <= keyframeInterval(false) {
+		t.Errorf("Keyframe interval: got %v for screen shares, %v",
+			keyframeInterval(true), keyframeInterval(false))
+	}
+	if keyframeWindow(true) <= keyframeWindow(false) {
+		t.Errorf("Keyframe window: got %v for screen shares, %v",
+			keyframeWindow(true), keyframeWindow(false))
+	}
+}
+
+func TestScreenshareUnlimited(t *testing.T) {
+	g, err := group.Add("screenshare-unlimited-test", &group.Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("screenshare-unlimited-test")
+
+	c := &webClient{group: g, id: "id"}
+	screen := &rtpUpConnection{client: c, label: "screenshare"}
+	if r := maxVideoBitrate(screen); r != 0 {
+		t.Errorf("Expected 0, got %v", r)
+	}
+}
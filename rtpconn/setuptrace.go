@@ -0,0 +1,84 @@
+package rtpconn
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/jech/galene/tracing"
+)
+
+// setupTrace traces the establishment of a connection.  The root span
+// covers the whole setup, and is divided into successive phases (SDP
+// exchange, ICE, waiting for a keyframe).  It is a no-op if tracing is
+// disabled.
+type setupTrace struct {
+	mu       sync.Mutex
+	root     *tracing.Span
+	phase    *tracing.Span
+	finished bool
+}
+
+func (t *setupTrace) start(name string, kv ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.root != nil || t.finished {
+		return
+	}
+	t.root = tracing.Start(nil, name, kv...)
+}
+
+// next ends the current phase and starts a new one.
+func (t *setupTrace) next(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.root == nil {
+		return
+	}
+	t.phase.End()
+	t.phase = tracing.Start(t.root, name)
+}
+
+// running returns true if the setup hasn't completed yet, and phase is
+// the current phase.
+func (t *setupTrace) running(phase string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.root != nil && t.phase != nil && t.phase.Name() == phase
+}
+
+func (t *setupTrace) event(name string, kv ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root.AddEvent(name, kv...)
+}
+
+// done terminates the trace.  Further calls are ignored.
+func (t *setupTrace) done(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.root == nil {
+		return
+	}
+	t.phase.SetError(err)
+	t.phase.End()
+	t.root.SetError(err)
+	t.root.End()
+	t.phase = nil
+	t.root = nil
+	t.finished = true
+}
+
+var errClosedDuringSetup = errors.New("closed during setup")
+var errICEFailed = errors.New("ICE failed")
+
+// hasVideo returns true if a connection carries video.
+func hasVideo(pc *webrtc.PeerConnection) bool {
+	for _, t := range pc.GetTransceivers() {
+		if t.Kind() == webrtc.RTPCodecTypeVideo {
+			return true
+		}
+	}
+	return false
+}
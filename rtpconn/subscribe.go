@@ -0,0 +1,35 @@
+package rtpconn
+
+import (
+	"errors"
+
+	"github.com/jech/galene/conn"
+)
+
+// setSubscriptions sets the tracks requested from individual clients.
+// Subscriptions map client ids to a list of kinds, and take precedence
+// over the labels requested by setRequested.  An empty list causes no
+// tracks to be sent by the given client.
+func (c *webClient) setSubscriptions(subscriptions map[string][]string) error {
+	if c.group == nil {
+		return errors.New("attempted to subscribe with no group joined")
+	}
+	c.subscriptions = subscriptions
+
+	requestConns(c, c.group, "")
+	return nil
+}
+
+// requestedFor returns the kinds of tracks that a client requests from
+// a given up connection, in the absence of a per-stream request.
+func (c *webClient) requestedFor(up conn.Up) []string {
+	id, _ := up.User()
+	if req, ok := c.subscriptions[id]; ok {
+		return req
+	}
+	req, ok := c.requested[up.Label()]
+	if !ok {
+		req = c.requested[""]
+	}
+	return req
+}
@@ -0,0 +1,52 @@
+package rtpconn
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jech/galene/conn"
+)
+
+type fakeUp struct {
+	id, label, client string
+}
+
+func (up fakeUp) AddLocal(conn.Down) error { return nil }
+func (up fakeUp) DelLocal(conn.Down) bool  { return false }
+func (up fakeUp) Id() string               { return up.id }
+func (up fakeUp) Label() string            { return up.label }
+func (up fakeUp) User() (string, string)   { return up.client, "" }
+
+func TestRequestedFor(t *testing.T) {
+	c := &webClient{
+		requested: map[string][]string{
+			"":       {"audio", "video"},
+			"screen": {"video"},
+		},
+		subscriptions: map[string][]string{
+			"presenter": {"audio", "video"},
+			"muted":     {},
+			"listener":  {"audio"},
+		},
+	}
+
+	tests := []struct {
+		up       fakeUp
+		expected []string
+	}{
+		{fakeUp{"a", "camera", "other"}, []string{"audio", "video"}},
+		{fakeUp{"b", "screen", "other"}, []string{"video"}},
+		{fakeUp{"c", "camera", "listener"}, []string{"audio"}},
+		{fakeUp{"d", "screen", "listener"}, []string{"audio"}},
+		{fakeUp{"e", "camera", "muted"}, []string{}},
+		{fakeUp{"f", "camera", "presenter"}, []string{"audio", "video"}},
+	}
+
+	for _, test := range tests {
+		req := c.requestedFor(test.up)
+		if !reflect.DeepEqual(req, test.expected) {
+			t.Errorf("%v: expected %v, got %v",
+				test.up.id, test.expected, req)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package rtpconn
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// the audio level, in -dBov, below which we consider that the
+	// user is speaking
+	talkLevel = 50
+	// gaps longer than this are not counted as speech
+	maxTalkGap = 200 * time.Millisecond
+	// how often we report talk time to the group
+	talkInterval = 2 * time.Second
+)
+
+// talkDetector accumulates the time during which a user is speaking,
+// based on the audio level header extension (RFC 6464).
+type talkDetector struct {
+	id       uint8
+	last     time.Time
+	time     time.Duration
+	reported time.Time
+}
+
+// audioLevelId returns the id of the audio level header extension
+// negotiated for a receiver, or 0 if it has not been negotiated.
+func audioLevelId(receiver *webrtc.RTPReceiver) uint8 {
+	for _, e := range receiver.GetParameters().HeaderExtensions {
+		if e.URI == sdp.AudioLevelURI {
+			return uint8(e.ID)
+		}
+	}
+	return 0
+}
+
+// packet accounts for a received packet.
+func (t *talkDetector) packet(p *rtp.Packet, now time.Time) {
+	if t.id == 0 {
+		return
+	}
+	b := p.GetExtension(t.id)
+	if b == nil {
+		return
+	}
+	var ext rtp.AudioLevelExtension
+	err := ext.Unmarshal(b)
+	if err != nil {
+		return
+	}
+	if ext.Level > talkLevel {
+		t.last = time.Time{}
+		return
+	}
+	if !t.last.IsZero() {
+		d := now.Sub(t.last)
+		if d > 0 && d <= maxTalkGap {
+			t.time += d
+		}
+	}
+	t.last = now
+}
+
+// flush returns the accumulated talk time if it is time to report it,
+// or if force is true, and resets it.
+func (t *talkDetector) flush(now time.Time, force bool) time.Duration {
+	if !force && now.Sub(t.reported) < talkInterval {
+		return 0
+	}
+	d := t.time
+	t.time = 0
+	t.reported = now
+	return d
+}
+
+// waitReaders waits for the reader goroutines of a closed connection to
+// terminate.  The readers flush their talk time when they terminate, and
+// it is only recorded while the client is still in the group.
+func (up *rtpUpConnection) waitReaders(timeout time.Duration) {
+	up.mu.Lock()
+	tracks := append([]*rtpUpTrack(nil), up.tracks...)
+	up.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for _, t := range tracks {
+		select {
+		case <-t.readerDone:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func (track *rtpUpTrack) addTalkTime(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c := track.conn.client
+	g := c.Group()
+	if g != nil {
+		g.AddTalkTime(c, d)
+	}
+}
@@ -0,0 +1,85 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func audioLevelPacket(t *testing.T, id uint8, level uint8) *rtp.Packet {
+	ext, err := rtp.AudioLevelExtension{Level: level}.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	p := &rtp.Packet{
+		Header: rtp.Header{Version: 2},
+	}
+	err = p.SetExtension(id, ext)
+	if err != nil {
+		t.Fatalf("SetExtension: %v", err)
+	}
+	return p
+}
+
+func TestTalkDetector(t *testing.T) {
+	talk := talkDetector{id: 3}
+	now := time.Now()
+
+	// 1s of speech, 20ms per packet
+	for i := 0; i <= 50; i++ {
+		talk.packet(audioLevelPacket(t, 3, 20), now)
+		now = now.Add(20 * time.Millisecond)
+	}
+	// 1s of silence
+	for i := 0; i < 50; i++ {
+		talk.packet(audioLevelPacket(t, 3, 100), now)
+		now = now.Add(20 * time.Millisecond)
+	}
+	// a long gap, as with DTX
+	now = now.Add(time.Second)
+	talk.packet(audioLevelPacket(t, 3, 20), now)
+	now = now.Add(time.Second)
+	talk.packet(audioLevelPacket(t, 3, 20), now)
+	// an unrelated extension
+	talk.packet(audioLevelPacket(t, 4, 20), now)
+
+	d := talk.flush(now, true)
+	if d != time.Second {
+		t.Errorf("Expected 1s, got %v", d)
+	}
+	d = talk.flush(now, true)
+	if d != 0 {
+		t.Errorf("Expected 0, got %v", d)
+	}
+}
+
+func TestWaitReaders(t *testing.T) {
+	up := &rtpUpConnection{}
+	for i := 0; i < 2; i++ {
+		up.tracks = append(up.tracks, &rtpUpTrack{
+			readerDone: make(chan struct{}),
+		})
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		for _, t := range up.tracks {
+			close(t.readerDone)
+		}
+	}()
+	now := time.Now()
+	up.waitReaders(10 * time.Second)
+	if d := time.Since(now); d < 10*time.Millisecond || d > 5*time.Second {
+		t.Errorf("Waited for %v", d)
+	}
+
+	up.tracks = append(up.tracks, &rtpUpTrack{
+		readerDone: make(chan struct{}),
+	})
+	now = time.Now()
+	up.waitReaders(10 * time.Millisecond)
+	if d := time.Since(now); d > 5*time.Second {
+		t.Errorf("Waited for %v", d)
+	}
+}
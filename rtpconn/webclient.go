@@ -6,21 +6,28 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
 
+	"github.com/jech/galene/captions"
+	"github.com/jech/galene/codecs"
 	"github.com/jech/galene/conn"
 	"github.com/jech/galene/diskwriter"
 	"github.com/jech/galene/estimator"
+	"github.com/jech/galene/filedrop"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/ice"
+	"github.com/jech/galene/ratelimit"
 	"github.com/jech/galene/token"
+	"github.com/jech/galene/tracing"
 	"github.com/jech/galene/unbounded"
 )
 
@@ -58,17 +65,57 @@ func isWSNormalError(err error) bool {
 }
 
 type webClient struct {
-	group       *group.Group
-	addr        net.Addr
-	id          string
-	username    string
-	permissions []string
-	data        map[string]interface{}
-	requested   map[string][]string
-	done        chan struct{}
-	writeCh     chan interface{}
-	writerDone  chan struct{}
-	actions     *unbounded.Channel[any]
+	group        *group.Group
+	addr         net.Addr
+	id           string
+	username     string
+	permissions  []string
+	data         map[string]interface{}
+	requested    map[string][]string
+	capabilities []string
+	done         chan struct{}
+	writeCh      chan interface{}
+	writerDone   chan struct{}
+	actions      *unbounded.Channel[any]
+
+	// the tracks requested from individual clients, indexed by id
+	subscriptions map[string][]string
+
+	// the session token, empty if the client cannot be resumed
+	session string
+	resume  chan resumption
+	attach  chan attachment
+	// stops counting the current websocket against the client's
+	// address, only accessed by the client goroutine
+	release func()
+
+	// the sequence number of the last whiteboard operation sent to
+	// the client, only meaningful once the whiteboard has been sent.
+	whiteboardSent bool
+	whiteboardSeq  uint64
+
+	// limits the rate at which the client may send reactions
+	reactions *ratelimit.Limiter
+	// limits the rate of other messages, indexed by class; not
+	// modified after the client is created
+	guards map[string]*messageGuard
+	// messages delayed by the rate limits, only accessed by the client
+	// goroutine
+	pending      []pendingMessage
+	pendingTimer *time.Timer
+
+	// the last computed connection quality
+	quality string
+
+	// the number of bytes forwarded to the client and not yet accounted
+	sent atomic.Uint64
+
+	// the time of the last activity, used for detecting idle clients
+	active     time.Time
+	idleWarned bool
+
+	// non-nil if the client has subscribed to statistics
+	statsTicker *time.Ticker
 
 	mu   sync.Mutex
 	down map[string]*rtpDownConnection
@@ -91,8 +138,18 @@ func (c *webClient) Username() string {
 	return c.username
 }
 
+// log returns a logger that annotates messages with the client's id and
+// group.  It must only be called from the client's goroutine.
+func (c *webClient) log() *slog.Logger {
+	if c.group == nil {
+		return logger.With("client", c.id)
+	}
+	return logger.With("client", c.id, "group", c.group.Name())
+}
+
 func (c *webClient) SetUsername(username string) {
 	c.username = username
+	c.setRegisteredData()
 }
 
 func (c *webClient) Permissions() []string {
@@ -117,6 +174,7 @@ func (c *webClient) PushClient(group, kind, id string, username string, perms []
 type clientMessage struct {
 	Type             string                   `json:"type"`
 	Version          []string                 `json:"version,omitempty"`
+	Capabilities     []string                 `json:"capabilities,omitempty"`
 	Kind             string                   `json:"kind,omitempty"`
 	Error            string                   `json:"error,omitempty"`
 	Id               string                   `json:"id,omitempty"`
@@ -139,6 +197,7 @@ type clientMessage struct {
 	Label            string                   `json:"label,omitempty"`
 	Request          interface{}              `json:"request,omitempty"`
 	RTCConfiguration *webrtc.Configuration    `json:"rtcConfiguration,omitempty"`
+	Session          string                   `json:"session,omitempty"`
 }
 
 type closeMessage struct {
@@ -188,12 +247,27 @@ func addUpConn(c *webClient, id, label string, offer string) (*rtpUpConnection,
 
 	c.up[id] = conn
 
+	conn.trace.start("up connection",
+		"group", c.group.Name(), "client", c.id,
+		"conn", id, "label", label,
+	)
+
 	conn.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		sendICE(c, id, candidate)
 	})
 
 	conn.pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
-		if state == webrtc.ICEConnectionStateFailed {
+		switch state {
+		case webrtc.ICEConnectionStateConnected:
+			if conn.trace.running("ice") {
+				if hasVideo(conn.pc) {
+					conn.trace.next("keyframe")
+				} else {
+					conn.trace.done(nil)
+				}
+			}
+		case webrtc.ICEConnectionStateFailed:
+			conn.trace.done(errICEFailed)
 			c.action(connectionFailedAction{id: id})
 		}
 	})
@@ -227,20 +301,28 @@ func delUpConn(c *webClient, id string, userId string, push bool) error {
 	replace := conn.getReplace(false)
 
 	delete(c.up, id)
+	last := len(c.up) == 0
 	g := c.group
 	c.mu.Unlock()
 
+	if last && g != nil {
+		g.DelPublisher(c)
+	}
+
 	conn.mu.Lock()
 	conn.closed = true
 	conn.mu.Unlock()
 
+	conn.trace.done(errClosedDuringSetup)
+	conn.stopCapture()
 	conn.pc.Close()
 
 	if push && g != nil {
-		for _, c := range g.GetClients(c) {
-			err := c.PushConn(g, id, nil, nil, replace)
+		for _, cc := range pushTargets(c, g) {
+			err := cc.PushConn(g, echoId(cc, c, id), nil, nil,
+				echoId(cc, c, replace))
 			if err != nil {
-				log.Printf("PushConn: %v", err)
+				logger.Warn("PushConn", "conn", id, "err", err)
 			}
 		}
 	}
@@ -274,9 +356,7 @@ func getConn(c *webClient, id string) iceConnection {
 	return nil
 }
 
-func addDownConn(c *webClient, remote conn.Up) (*rtpDownConnection, bool, error) {
-	id := remote.Id()
-
+func addDownConn(c *webClient, id string, remote conn.Up) (*rtpDownConnection, bool, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -297,18 +377,48 @@ func addDownConn(c *webClient, remote conn.Up) (*rtpDownConnection, bool, error)
 		return nil, false, err
 	}
 
+	source, _ := remote.User()
+	down.trace.start("down connection",
+		"group", c.group.Name(), "client", c.id,
+		"conn", id, "source", source,
+	)
+
 	down.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		sendICE(c, down.id, candidate)
 	})
 
 	down.pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
 		if state == webrtc.ICEConnectionStateFailed {
+			down.trace.done(errICEFailed)
 			c.action(connectionFailedAction{id: down.id})
 		}
 	})
 
+	down.congested = func() {
+		c.action(congestedAction{id: down.id})
+	}
+
+	down.audioOnly = func(audioOnly bool) {
+		c.action(audioOnlyAction{id: down.id, audioOnly: audioOnly})
+	}
+
+	down.sent = &c.sent
+
+	down.maxFramerate = profileFramerate(c.group)
+
+	down.allowKeyframe = func() bool {
+		allow, kick := c.allowMessage("keyframe")
+		if kick {
+			c.log().Warn("Disconnecting client",
+				"reason", "too many keyframe requests")
+			c.Kick("", nil, "too many keyframe requests")
+		}
+		return allow
+	}
+
 	err = remote.AddLocal(down)
 	if err != nil {
+		down.trace.done(err)
 		down.pc.Close()
 		return nil, false, err
 	}
@@ -323,6 +433,10 @@ func addDownConn(c *webClient, remote conn.Up) (*rtpDownConnection, bool, error)
 func delDownConn(c *webClient, id string) error {
 	conn := delDownConnHelper(c, id)
 	if conn != nil {
+		conn.trace.done(errClosedDuringSetup)
+		if conn.pacer != nil {
+			conn.pacer.close()
+		}
 		conn.pc.Close()
 		return nil
 	}
@@ -346,6 +460,7 @@ func delDownConnHelper(c *webClient, id string) *rtpDownConnection {
 		// we only insert the track after we get an answer, so
 		// ignore errors here.
 		track.remote.DelLocal(track)
+		track.queue.release()
 	}
 	delete(c.down, id)
 	return conn
@@ -366,7 +481,7 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 
 	id := remoteTrack.track.ID()
 	if id == "" {
-		log.Println("Got track with empty id")
+		logger.Warn("Got track with empty id", "conn", conn.id)
 		id = remoteTrack.track.RID()
 	}
 	if id == "" {
@@ -374,7 +489,7 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 	}
 	msid := remoteTrack.track.StreamID()
 	if msid == "" || msid == "-" {
-		log.Println("Got track with empty msid")
+		logger.Warn("Got track with empty msid", "conn", conn.id)
 		msid = remoteTrack.conn.Label()
 	}
 	if msid == "" {
@@ -398,10 +513,10 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 	}
 
 	codec := local.Codec()
-	ptype, err := group.CodecPayloadType(local.Codec())
+	ptype, err := codecs.PayloadType(local.Codec())
 	if err != nil {
-		log.Printf("Couldn't determine ptype for codec %v: %v",
-			codec.MimeType, err)
+		logger.Warn("Couldn't determine ptype",
+			"codec", codec.MimeType, "err", err)
 	} else {
 		err := transceiver.SetCodecPreferences(
 			[]webrtc.RTPCodecParameters{
@@ -412,8 +527,8 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 			},
 		)
 		if err != nil {
-			log.Printf("Couldn't set ptype for codec %v: %v",
-				codec.MimeType, err)
+			logger.Warn("Couldn't set ptype",
+				"codec", codec.MimeType, "err", err)
 		}
 	}
 
@@ -434,6 +549,9 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 		rate:           estimator.New(time.Second),
 		atomics:        &downTrackAtomics{},
 	}
+	if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
+		track.queue = &downQueue{}
+	}
 
 	conn.tracks = append(conn.tracks, track)
 
@@ -446,6 +564,10 @@ func delDownTrackUnlocked(conn *rtpDownConnection, track *rtpDownTrack) error {
 	for i := range conn.tracks {
 		if conn.tracks[i] == track {
 			track.remote.DelLocal(track)
+			track.queue.release()
+			if conn.pacer != nil {
+				conn.pacer.remove(track.track)
+			}
 			conn.tracks =
 				append(conn.tracks[:i], conn.tracks[i+1:]...)
 			return conn.pc.RemoveTrack(track.sender)
@@ -541,6 +663,7 @@ func negotiate(c *webClient, down *rtpDownConnection, restartIce bool, replace s
 	}
 
 	down.negotiationNeeded = negotiationUnneeded
+	down.trace.next("sdp")
 
 	options := webrtc.OfferOptions{ICERestart: restartIce}
 	offer, err := down.pc.CreateOffer(&options)
@@ -579,6 +702,11 @@ func sendICE(c *webClient, id string, candidate *webrtc.ICECandidate) error {
 }
 
 func gotOffer(c *webClient, id, label string, sdp string, replace string) error {
+	err := checkSimulcast(c.group, sdp)
+	if err != nil {
+		return err
+	}
+
 	up, _, err := addUpConn(c, id, label, sdp)
 	if err != nil {
 		return err
@@ -589,27 +717,33 @@ func gotOffer(c *webClient, id, label string, sdp string, replace string) error
 		delUpConn(c, replace, c.Id(), false)
 	}
 
+	up.trace.next("sdp")
 	err = up.pc.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  sdp,
 	})
 	if err != nil {
+		up.trace.done(err)
 		return err
 	}
 
 	answer, err := up.pc.CreateAnswer(nil)
 	if err != nil {
+		up.trace.done(err)
 		return err
 	}
+	answer.SDP = limitAudioBitrate(answer.SDP, profileAudioBitrate(c.group))
 
 	err = up.pc.SetLocalDescription(answer)
 	if err != nil {
+		up.trace.done(err)
 		return err
 	}
+	up.trace.next("ice")
 
 	err = up.flushICECandidates()
 	if err != nil {
-		log.Printf("ICE: %v", err)
+		c.log().Warn("ICE", "conn", id, "err", err)
 	}
 
 	return c.write(clientMessage{
@@ -632,20 +766,23 @@ func gotAnswer(c *webClient, id string, sdp string) error {
 		SDP:  sdp,
 	})
 	if err != nil {
+		down.trace.done(err)
 		return err
 	}
+	down.trace.next("ice")
 
 	err = down.flushICECandidates()
 	if err != nil {
-		log.Printf("ICE: %v", err)
+		c.log().Warn("ICE", "conn", id, "err", err)
 	}
 
 	add := func() {
+		down.trace.done(nil)
 		down.pc.OnConnectionStateChange(nil)
 		for _, t := range down.tracks {
 			err := t.remote.AddLocal(t)
 			if err != nil && err != os.ErrClosed {
-				log.Printf("Add track: %v", err)
+				logger.Warn("Add track", "conn", id, "err", err)
 			}
 		}
 	}
@@ -741,13 +878,16 @@ func (c *webClient) RequestConns(target group.Client, g *group.Group, id string)
 }
 
 func requestConns(target group.Client, g *group.Group, id string) {
-	clients := g.GetClients(target)
+	clients := pushTargets(target, g)
 	for _, c := range clients {
 		c.RequestConns(target, g, id)
 	}
 }
 
-func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack) ([]conn.UpTrack, bool) {
+// requestedTracks returns the tracks that should be sent to a client,
+// and whether to stick to the lowest spatial layer.  Rwidth and rheight
+// are the size at which the client renders the video, or zero.
+func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack, rwidth, rheight uint32) ([]conn.UpTrack, bool) {
 	if len(requested) == 0 {
 		return nil, false
 	}
@@ -761,7 +901,8 @@ func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack) ([
 		case "video-low":
 			videoLow = true
 		default:
-			log.Printf("client requested unknown value %v", s)
+			c.log().Warn("Client requested unknown value",
+				"value", s)
 		}
 	}
 
@@ -790,7 +931,10 @@ func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack) ([
 		}
 	}
 	if video {
-		t, _ := find(webrtc.RTPCodecTypeVideo, false)
+		t := pickVideoTrack(tracks, rwidth, rheight)
+		if t == nil {
+			t, _ = find(webrtc.RTPCodecTypeVideo, false)
+		}
 		if t != nil {
 			ts = append(ts, t)
 		}
@@ -824,7 +968,44 @@ func readMessage(conn *websocket.Conn, m *clientMessage) error {
 
 const protocolVersion = "2"
 
-func StartClient(conn *websocket.Conn, addr net.Addr) (err error) {
+// Bounds on the interval at which statistics are sent to subscribers.
+const (
+	defaultStatsInterval = 5 * time.Second
+	minStatsInterval     = time.Second
+	maxStatsInterval     = time.Minute
+)
+
+// Reactions are transient, and are rate-limited per client.
+const (
+	reactionRate      = 2
+	reactionBurst     = 5
+	maxReactionLength = 32
+)
+
+// serverCapabilities are the optional protocol features implemented by
+// the server.  They are announced in the handshake, and the server only
+// sends the corresponding messages to clients that announced them too.
+var serverCapabilities = []string{
+	"captions", "shutdown", "whiteboard", "polls", "reactions", "stats",
+	"resume", "recording", "audioonly", "clock", "announcement",
+}
+
+// hasCapability returns true if the client announced the given
+// capability in its handshake.
+func (c *webClient) hasCapability(capability string) bool {
+	return member(capability, c.capabilities)
+}
+
+// StartClient runs a client on a new websocket.  Release, if not nil, is
+// called once the websocket is no longer in use, which may happen after
+// StartClient returns if the websocket resumed another client's session.
+func StartClient(conn *websocket.Conn, addr net.Addr, release func()) (err error) {
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+
 	var m clientMessage
 
 	err = readMessage(conn, &m)
@@ -845,6 +1026,24 @@ func StartClient(conn *websocket.Conn, addr net.Addr) (err error) {
 		return
 	}
 
+	if m.Session != "" {
+		err = resumeSession(conn, m.Session, m.Id, release)
+		if err == nil {
+			// the session now owns the websocket
+			release = nil
+		} else {
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(
+					websocket.CloseNormalClosure,
+					err.Error(),
+				),
+			)
+			conn.Close()
+			err = nil
+		}
+		return
+	}
+
 	versionError := true
 	if m.Version != nil {
 		for _, v := range m.Version {
@@ -855,17 +1054,28 @@ func StartClient(conn *websocket.Conn, addr net.Addr) (err error) {
 	}
 
 	c := &webClient{
-		addr:    addr,
-		id:      m.Id,
-		actions: unbounded.New[any](),
-		done:    make(chan struct{}),
+		addr:         addr,
+		id:           m.Id,
+		capabilities: m.Capabilities,
+		actions:      unbounded.New[any](),
+		done:         make(chan struct{}),
+		reactions:    ratelimit.New(reactionRate, reactionBurst),
+		guards:       newMessageGuards(group.GetMessageLimits()),
+		release:      release,
 	}
+	release = nil
 
 	defer close(c.done)
+	defer c.releaseConnection()
+
+	if c.hasCapability("resume") {
+		newSession(c)
+		defer delSession(c)
+	}
 
 	c.writeCh = make(chan interface{}, 100)
 	c.writerDone = make(chan struct{})
-	go clientWriter(conn, c.writeCh, c.writerDone)
+	go clientWriter(conn, c.writeCh, c.writerDone, c.attach)
 	defer func() {
 		m, e := errorToWSCloseMessage(c.id, err)
 		if isWSNormalError(err) {
@@ -900,6 +1110,15 @@ type connectionFailedAction struct {
 	id string
 }
 
+type congestedAction struct {
+	id string
+}
+
+type audioOnlyAction struct {
+	id        string
+	audioOnly bool
+}
+
 type pushClientAction struct {
 	group       string
 	kind        string
@@ -922,6 +1141,10 @@ type kickAction struct {
 	message  string
 }
 
+type whiteboardAction struct {
+	op group.WhiteboardOp
+}
+
 var errEmptyId = group.ProtocolError("empty id")
 
 func member(v string, l []string) bool {
@@ -953,19 +1176,37 @@ func addnew(v string, l []string) []string {
 
 func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 	read := make(chan interface{}, 1)
-	go clientReader(ws, read, c.done)
+	stop := make(chan struct{})
+	go clientReader(ws, read, stop)
+	defer func() {
+		close(stop)
+	}()
 
 	defer leaveGroup(c)
+	defer c.setStatsInterval(0)
+	defer func() {
+		if c.pendingTimer != nil {
+			c.pendingTimer.Stop()
+		}
+	}()
 
 	readTime := time.Now()
+	c.markActive(readTime)
+
+	// non-zero if the websocket was lost and we are waiting for the
+	// client to resume the session
+	var suspended time.Time
 
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	err := c.write(clientMessage{
-		Type:    "handshake",
-		Version: []string{protocolVersion},
-	})
+	handshake := clientMessage{
+		Type:         "handshake",
+		Version:      []string{protocolVersion},
+		Capabilities: serverCapabilities,
+		Session:      c.session,
+	}
+	err := c.write(handshake)
 	if err != nil {
 		return err
 	}
@@ -991,13 +1232,46 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 			switch m := m.(type) {
 			case clientMessage:
 				readTime = time.Now()
+				if m.Type != "ping" && m.Type != "pong" &&
+					m.Type != "clock" {
+					c.markActive(readTime)
+				}
 				err := handleClientMessage(c, m)
 				if err != nil {
 					return err
 				}
 			case error:
+				if c.suspendable(m) {
+					c.log().Info("Client suspended",
+						"err", m)
+					suspended = time.Now()
+					read = nil
+					c.releaseConnection()
+					err := c.detachWebsocket()
+					if err != nil {
+						return err
+					}
+					continue
+				}
 				return m
 			}
+		case r := <-c.resume:
+			// the previous reader terminates when the writer
+			// closes the old websocket
+			close(stop)
+			read = make(chan interface{}, 1)
+			stop = make(chan struct{})
+			go clientReader(r.conn, read, stop)
+			c.releaseConnection()
+			c.release = r.release
+			err := c.attachWebsocket(r.conn, handshake)
+			if err != nil {
+				return err
+			}
+			c.log().Info("Client resumed")
+			suspended = time.Time{}
+			readTime = time.Now()
+			resumed(c)
 		case <-c.actions.Ch:
 			actions := c.actions.Get()
 			for _, a := range actions {
@@ -1007,12 +1281,15 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 				}
 			}
 		case <-ticker.C:
-			if time.Since(readTime) > 45*time.Second {
+			if !suspended.IsZero() {
+				if time.Since(suspended) > resumeTimeout {
+					return errSessionExpired
+				}
+			} else if time.Since(readTime) > 45*time.Second {
 				return errors.New("client is dead")
-			}
-			// Some reverse proxies timeout connexions at 60
-			// seconds, make sure we generate some activity
-			if time.Since(readTime) > 20*time.Second {
+			} else if time.Since(readTime) > 20*time.Second {
+				// Some reverse proxies timeout connexions at 60
+				// seconds, make sure we generate some activity
 				err := c.write(clientMessage{
 					Type: "ping",
 				})
@@ -1020,6 +1297,25 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 					return err
 				}
 			}
+			c.updateQuality()
+			err := c.checkIdle(time.Now())
+			if err != nil {
+				return err
+			}
+			err = c.accountBandwidth()
+			if err != nil {
+				return err
+			}
+		case <-timerChannel(c.pendingTimer):
+			err := c.flushPending()
+			if err != nil {
+				return err
+			}
+		case <-tickerChannel(c.statsTicker):
+			err := c.sendStats()
+			if err != nil {
+				return err
+			}
 		}
 	}
 }
@@ -1027,31 +1323,39 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
 	var requested []conn.UpTrack
 	limitSid := false
+	var rwidth, rheight uint32
 	if up != nil {
 		var old *rtpDownConnection
 		if replace != "" {
 			old = getDownConn(c, replace)
 		} else {
-			old = getDownConn(c, up.Id())
+			old = getDownConn(c, id)
 		}
 		var req []string
 		if old != nil {
 			req = old.requested
+			rwidth, rheight = old.getResolution()
 		}
+		rwidth, rheight = limitResolution(c.group, rwidth, rheight)
 		if req == nil {
-			var ok bool
-			req, ok = c.requested[up.Label()]
-			if !ok {
-				req = c.requested[""]
-			}
+			req = c.requestedFor(up)
+		}
+		requested, limitSid = requestedTracks(
+			c, req, tracks, rwidth, rheight,
+		)
+		if old != nil && replace != "" {
+			defer func() {
+				if down := getDownConn(c, id); down != nil {
+					down.setResolution(rwidth, rheight)
+				}
+			}()
 		}
-		requested, limitSid = requestedTracks(c, req, tracks)
 	}
 
 	if replace != "" {
 		err := delDownConn(c, replace)
 		if err != nil {
-			log.Printf("Replace: %v", err)
+			c.log().Warn("Replace", "conn", id, "err", err)
 		}
 	}
 
@@ -1067,20 +1371,23 @@ func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, re
 		return nil
 	}
 
-	down, _, err := addDownConn(c, up)
+	down, _, err := addDownConn(c, id, up)
 	if err != nil {
 		if errors.Is(err, os.ErrClosed) {
 			return nil
 		}
 		return err
 	}
+	if w, h := down.getResolution(); w == 0 || h == 0 {
+		down.setResolution(rwidth, rheight)
+	}
 	done, err := replaceTracks(down, requested, limitSid)
 	if err != nil || !done {
 		return err
 	}
 	err = negotiate(c, down, false, replace)
 	if err != nil {
-		log.Printf("Negotiation failed: %v", err)
+		c.log().Warn("Negotiation failed", "conn", id, "err", err)
 		closeDownConn(c, down.id, err.Error())
 		return err
 	}
@@ -1092,14 +1399,14 @@ func handleAction(c *webClient, a any) error {
 	switch a := a.(type) {
 	case pushConnAction:
 		if c.group == nil || c.group != a.group {
-			log.Printf("Got connectsions for wrong group")
+			c.log().Warn("Got connections for wrong group")
 			return nil
 		}
 		return pushDownConn(c, a.id, a.conn, a.tracks, a.replace)
 	case requestConnsAction:
 		g := c.group
 		if g == nil || a.group != g {
-			log.Printf("Misdirected pushConns")
+			c.log().Warn("Misdirected pushConns")
 			return nil
 		}
 		for _, u := range c.up {
@@ -1113,9 +1420,10 @@ func handleAction(c *webClient, a any) error {
 			for i, t := range tracks {
 				ts[i] = t
 			}
-			err := a.target.PushConn(g, u.id, u, ts, replace)
+			err := a.target.PushConn(g, echoId(a.target, c, u.id),
+				u, ts, echoId(a.target, c, replace))
 			if err != nil {
-				log.Printf("PushConn: %v", err)
+				c.log().Warn("PushConn", "conn", u.id, "err", err)
 			}
 		}
 	case connectionFailedAction:
@@ -1132,7 +1440,7 @@ func handleAction(c *webClient, a any) error {
 			}
 			c.PushConn(
 				c.group,
-				down.remote.Id(), down.remote,
+				down.id, down.remote,
 				tracks, "",
 			)
 		} else if up := getUpConn(c, a.id); up != nil {
@@ -1141,13 +1449,41 @@ func handleAction(c *webClient, a any) error {
 				Id:   a.id,
 			})
 		} else {
-			log.Printf("Attempting to renegotiate " +
-				"unknown connection")
+			c.log().Warn("Attempting to renegotiate unknown connection",
+				"conn", a.id)
 		}
 
+	case congestedAction:
+		if getDownConn(c, a.id) == nil {
+			return nil
+		}
+		c.log().Info("Closing congested connection", "conn", a.id)
+		return closeDownConn(c, a.id,
+			"your connection is too slow to receive this stream",
+		)
+
+	case audioOnlyAction:
+		if getDownConn(c, a.id) == nil {
+			return nil
+		}
+		c.log().Info("Audio-only fallback",
+			"conn", a.id, "audio-only", a.audioOnly)
+		if !c.hasCapability("audioonly") {
+			return nil
+		}
+		return c.write(clientMessage{
+			Type:  "audioonly",
+			Id:    a.id,
+			Value: a.audioOnly,
+		})
+
 	case pushClientAction:
 		if a.group != c.group.Name() {
-			log.Printf("got client for wrong group")
+			c.log().Warn("Got client for wrong group")
+			return nil
+		}
+		if a.id != c.id && c.group.Description().Echo {
+			// users of an echo group don't see each other
 			return nil
 		}
 		perms := append([]string(nil), a.permissions...)
@@ -1182,14 +1518,14 @@ func handleAction(c *webClient, a any) error {
 			Permissions:      perms,
 			Status:           status,
 			Data:             data,
-			RTCConfiguration: ice.ICEConfiguration(),
+			RTCConfiguration: ice.ICEConfigurationFor(c.addr),
 		})
 		if err != nil {
 			return err
 		}
 		if a.kind == "join" {
 			if g == nil {
-				log.Println("g is null when joining" +
+				c.log().Error("g is null when joining, " +
 					"this shouldn't happen")
 				return nil
 			}
@@ -1207,6 +1543,21 @@ func handleAction(c *webClient, a any) error {
 					return err
 				}
 			}
+			if c.hasCapability("whiteboard") &&
+				g.Description().AllowWhiteboard {
+				err := sendWhiteboard(c, g)
+				if err != nil {
+					return err
+				}
+			}
+			for _, p := range g.GetPolls() {
+				err := c.Poll(p)
+				if err != nil {
+					return err
+				}
+			}
+		} else if a.kind == "leave" {
+			c.whiteboardSent = false
 		}
 	case permissionsChangedAction:
 		g := c.Group()
@@ -1223,7 +1574,7 @@ func handleAction(c *webClient, a any) error {
 			Username:         &username,
 			Permissions:      perms,
 			Status:           &status,
-			RTCConfiguration: ice.ICEConfiguration(),
+			RTCConfiguration: ice.ICEConfigurationFor(c.addr),
 		})
 		if !member("present", c.permissions) {
 			up := getUpConns(c)
@@ -1254,8 +1605,17 @@ func handleAction(c *webClient, a any) error {
 		return group.KickError{
 			a.id, a.username, a.message,
 		}
+	case whiteboardAction:
+		// operations older than the state we sent are already
+		// accounted for
+		if !c.whiteboardSent || a.op.Seq <= c.whiteboardSeq {
+			return nil
+		}
+		c.whiteboardSeq = a.op.Seq
+		return c.write(whiteboardMessage(a.op))
 	default:
-		log.Printf("unexpected action %T", a)
+		c.log().Warn("Unexpected action",
+			"type", fmt.Sprintf("%T", a))
 		return errors.New("unexpected action")
 	}
 	return nil
@@ -1285,8 +1645,10 @@ func leaveGroup(c *webClient) {
 		return
 	}
 
+	var ups []*rtpUpConnection
 	if c.up != nil {
-		for id := range c.up {
+		for id, up := range c.up {
+			ups = append(ups, up)
 			delUpConn(c, id, c.id, true)
 		}
 	}
@@ -1296,17 +1658,26 @@ func leaveGroup(c *webClient) {
 		}
 	}
 
+	// make sure the talk time is recorded before the session is logged
+	for _, up := range ups {
+		up.waitReaders(time.Second)
+	}
+
+	c.accountBandwidth()
 	group.DelClient(c)
 	c.permissions = nil
 	c.data = nil
+	c.quality = ""
+	c.setStatsInterval(0)
 	c.requested = make(map[string][]string)
+	c.subscriptions = nil
 	c.group = nil
 }
 
 func closeDownConn(c *webClient, id string, message string) error {
 	err := delDownConn(c, id)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		log.Printf("Close down connection: %v", err)
+		c.log().Warn("Close down connection", "conn", id, "err", err)
 	}
 	err = c.write(clientMessage{
 		Type: "close",
@@ -1393,6 +1764,17 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 		}
 	}
 
+	done, err := c.limitMessage(m)
+	if done || err != nil {
+		return err
+	}
+
+	return processClientMessage(c, m)
+}
+
+// processClientMessage handles a message that has passed the checks
+// performed by handleClientMessage.
+func processClientMessage(c *webClient, m clientMessage) error {
 	switch m.Type {
 	case "join":
 		if m.Kind == "leave" {
@@ -1413,6 +1795,16 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			)
 		}
 		c.data = m.Data
+		for k := range c.data {
+			if serverData(k) {
+				delete(c.data, k)
+			}
+		}
+		span := tracing.Start(nil, "join",
+			"group", m.Group, "client", c.id,
+		)
+		defer span.End()
+		auth := tracing.Start(span, "auth")
 		g, err := group.AddClient(m.Group, c,
 			group.ClientCredentials{
 				Username: m.Username,
@@ -1420,26 +1812,30 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				Token:    m.Token,
 			},
 		)
+		auth.SetError(err)
+		auth.End()
 		if err != nil {
+			span.SetError(err)
 			var e, s string
 			var autherr *group.NotAuthorisedError
 			if errors.Is(err, token.ErrUsernameRequired) {
 				s = err.Error()
 				e = "need-username"
-			} else if errors.Is(err, group.ErrDuplicateUsername) {
+			} else if errors.Is(err, group.ErrDuplicateUsername) ||
+				errors.Is(err, group.ErrRegisteredUsername) {
 				s = err.Error()
 				e = "duplicate-username"
 			} else if errors.As(err, &autherr) {
 				s = "not authorised"
 				time.Sleep(200 * time.Millisecond)
-				log.Printf("Join group: %v", err)
+				c.log().Warn("Join group", "err", err)
 			} else if errors.Is(err, os.ErrNotExist) {
 				s = "group does not exist"
 			} else if _, ok := err.(group.UserError); ok {
 				s = err.Error()
 			} else {
 				s = "internal server error"
-				log.Printf("Join group: %v", err)
+				c.log().Warn("Join group", "err", err)
 			}
 			username := c.username
 			return c.write(clientMessage{
@@ -1470,6 +1866,12 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			return err
 		}
 		return c.setRequested(requested)
+	case "subscribe":
+		subscriptions, err := parseRequested(m.Request)
+		if err != nil {
+			return err
+		}
+		return c.setSubscriptions(subscriptions)
 	case "requestStream":
 		down := getDownConn(c, m.Id)
 		if down == nil {
@@ -1480,6 +1882,38 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			return err
 		}
 		c.setRequestedStream(down, requested)
+	case "resolution":
+		down := getDownConn(c, m.Id)
+		if down == nil {
+			return ErrUnknownId
+		}
+		v, ok := m.Value.(map[string]interface{})
+		if !ok {
+			return group.ProtocolError("bad value in resolution")
+		}
+		width, _ := v["width"].(float64)
+		height, _ := v["height"].(float64)
+		if width < 0 || height < 0 || width > 1<<16 || height > 1<<16 {
+			return group.ProtocolError("bad resolution")
+		}
+		rwidth, rheight := limitResolution(
+			c.group, uint32(width), uint32(height),
+		)
+		w, h := down.getResolution()
+		if rwidth == w && rheight == h {
+			return nil
+		}
+		down.setResolution(rwidth, rheight)
+		for _, t := range down.getTracks() {
+			t.adjustLayer()
+		}
+		// this causes the simulcast layer to be reconsidered
+		remote, ok := down.remote.(*rtpUpConnection)
+		if ok {
+			return remote.client.RequestConns(
+				c, c.group, remote.id,
+			)
+		}
 	case "offer":
 		if m.Id == "" {
 			return errEmptyId
@@ -1494,9 +1928,29 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			})
 			return c.error(group.UserError("not authorised"))
 		}
+		if err := c.group.AddPublisher(c); err != nil {
+			if m.Replace != "" {
+				delUpConn(c, m.Replace, c.id, true)
+			}
+			c.write(clientMessage{
+				Type: "abort",
+				Id:   m.Id,
+			})
+			return c.write(clientMessage{
+				Type:       "usermessage",
+				Kind:       "error",
+				Error:      "too-many-publishers",
+				Dest:       c.id,
+				Privileged: true,
+				Value:      err.Error(),
+			})
+		}
 		err := gotOffer(c, m.Id, m.Label, m.SDP, m.Replace)
 		if err != nil {
-			log.Printf("gotOffer: %v", err)
+			c.log().Warn("gotOffer", "conn", m.Id, "err", err)
+			if len(getUpConns(c)) == 0 {
+				c.group.DelPublisher(c)
+			}
 			return failUpConnection(c, m.Id, err.Error())
 		}
 	case "answer":
@@ -1505,7 +1959,7 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 		}
 		err := gotAnswer(c, m.Id, m.SDP)
 		if err != nil {
-			log.Printf("gotAnswer: %v", err)
+			c.log().Warn("gotAnswer", "conn", m.Id, "err", err)
 			message := ""
 			if err != ErrUnknownId {
 				message = err.Error()
@@ -1537,7 +1991,8 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				return closeDownConn(c, m.Id, err.Error())
 			}
 		} else {
-			log.Printf("Trying to renegotiate unknown connection")
+			c.log().Warn("Trying to renegotiate unknown connection",
+				"conn", m.Id)
 		}
 	case "close":
 		if m.Id == "" {
@@ -1545,8 +2000,8 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 		}
 		err := delUpConn(c, m.Id, c.id, true)
 		if err != nil {
-			log.Printf("Deleting up connection %v: %v",
-				m.Id, err)
+			c.log().Warn("Deleting up connection",
+				"conn", m.Id, "err", err)
 			return nil
 		}
 	case "abort":
@@ -1563,7 +2018,7 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 		}
 		err := gotICE(c, m.Candidate, m.Id)
 		if err != nil {
-			log.Printf("ICE: %v", err)
+			c.log().Warn("ICE", "conn", m.Id, "err", err)
 		}
 	case "chat", "usermessage":
 		g := c.group
@@ -1575,15 +2030,22 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			return c.error(group.UserError("not authorised"))
 		}
 
+		if m.Dest == "" && g.Description().Echo {
+			return c.error(group.UserError(
+				"messages are disabled in echo groups",
+			))
+		}
+
 		now := time.Now()
 
-		if m.Type == "chat" {
-			if m.Dest == "" {
-				g.AddToChatHistory(
-					m.Source, m.Username, now, m.Kind, m.Value,
-				)
-			}
+		if m.Type == "chat" && m.Dest == "" {
+			g.Chat(nil, m.Source, m.Username,
+				member("op", c.permissions), m.NoEcho, now,
+				m.Kind, m.Value,
+			)
+			return nil
 		}
+
 		mm := clientMessage{
 			Type:       m.Type,
 			Source:     m.Source,
@@ -1602,7 +2064,7 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			}
 			err := broadcast(g.GetClients(except), mm)
 			if err != nil {
-				log.Printf("broadcast(chat): %v", err)
+				c.log().Warn("broadcast(chat)", "err", err)
 			}
 		} else {
 			cc := g.GetClient(m.Dest)
@@ -1617,6 +2079,163 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			}
 			ccc.write(mm)
 		}
+	case "announcement":
+		g := c.group
+		if g == nil {
+			return c.error(group.UserError("join a group first"))
+		}
+		if !member("op", c.permissions) {
+			return c.error(group.UserError("not authorised"))
+		}
+		message, ok := m.Value.(string)
+		if !ok || message == "" {
+			return group.ProtocolError("bad value in announcement")
+		}
+		g.Announce(c.id, c.username, time.Now(), message)
+	case "stats":
+		g := c.group
+		if g == nil {
+			return c.error(group.UserError("join a group first"))
+		}
+		if !member("op", c.permissions) && !g.Description().Echo {
+			return c.error(group.UserError("not authorised"))
+		}
+		switch m.Kind {
+		case "subscribe":
+			interval := defaultStatsInterval
+			v, ok := m.Value.(float64)
+			if ok {
+				interval = time.Duration(v * float64(time.Second))
+			}
+			interval = min(max(interval, minStatsInterval),
+				maxStatsInterval)
+			c.setStatsInterval(interval)
+			return c.sendStats()
+		case "unsubscribe":
+			c.setStatsInterval(0)
+		default:
+			return group.ProtocolError("unknown kind in stats")
+		}
+	case "reaction":
+		g := c.group
+		if g == nil {
+			return c.error(group.UserError("join a group first"))
+		}
+		if !member("message", c.permissions) {
+			return c.error(group.UserError("not authorised"))
+		}
+		v, ok := m.Value.(string)
+		if !ok || v == "" || len(v) > maxReactionLength {
+			return group.ProtocolError("bad value in reaction")
+		}
+		if !c.reactions.Allow() {
+			// drop silently, this is expected to happen when
+			// a user clicks repeatedly
+			return nil
+		}
+		mm := clientMessage{
+			Type:   "reaction",
+			Source: c.id,
+			Time:   time.Now().Format(time.RFC3339),
+			Value:  v,
+		}
+		if c.username != "" {
+			username := c.username
+			mm.Username = &username
+		}
+		var clients []group.Client
+		for _, cc := range g.Recipients(c.id, nil) {
+			ccc, ok := cc.(*webClient)
+			if ok && ccc.hasCapability("reactions") {
+				clients = append(clients, ccc)
+			}
+		}
+		err := broadcast(clients, mm)
+		if err != nil {
+			c.log().Warn("broadcast(reaction)", "err", err)
+		}
+	case "whiteboard":
+		g := c.group
+		if g == nil {
+			return c.error(group.UserError("join a group first"))
+		}
+		if !g.Description().AllowWhiteboard {
+			return c.error(group.UserError(
+				"the whiteboard is not enabled in this group",
+			))
+		}
+		if !member("present", c.permissions) &&
+			!member("op", c.permissions) {
+			return c.error(group.UserError("not authorised"))
+		}
+		op := group.WhiteboardOp{
+			Kind:   m.Kind,
+			Source: c.id,
+			Value:  m.Value,
+		}
+		if c.username != "" {
+			username := c.username
+			op.Username = &username
+		}
+		if m.Kind == "undo" {
+			target, err := strconv.ParseUint(m.Id, 10, 64)
+			if err != nil {
+				return group.ProtocolError("bad whiteboard id")
+			}
+			op.Target = target
+		}
+		err := g.Whiteboard(op, member("op", c.permissions))
+		if err != nil {
+			return c.error(err)
+		}
+	case "poll":
+		g := c.group
+		if g == nil {
+			return c.error(group.UserError("join a group first"))
+		}
+		switch m.Kind {
+		case "create":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			req, err := parsePollRequest(m.Value)
+			if err != nil {
+				return c.error(err)
+			}
+			_, err = g.CreatePoll(c.username, req.Question,
+				req.Options, req.Anonymous,
+				time.Duration(req.Duration*float64(time.Second)),
+			)
+			if err != nil {
+				return c.error(err)
+			}
+		case "vote":
+			if !member("message", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			option, ok := m.Value.(float64)
+			if !ok || option != float64(int(option)) {
+				return c.error(group.UserError("bad vote"))
+			}
+			voter := c.username
+			if voter == "" {
+				voter = c.id
+			}
+			err := g.Vote(voter, m.Id, int(option))
+			if err != nil {
+				return c.error(err)
+			}
+		case "close":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			err := g.ClosePoll(m.Id)
+			if err != nil {
+				return c.error(err)
+			}
+		default:
+			return group.ProtocolError("unknown poll action")
+		}
 	case "groupaction":
 		g := c.group
 		if g == nil {
@@ -1635,7 +2254,25 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			}
 			err := broadcast(g.GetClients(nil), m)
 			if err != nil {
-				log.Printf("broadcast(clearchat): %v", err)
+				c.log().Warn("broadcast(clearchat)", "err", err)
+			}
+		case "deletefile":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			id, ok := m.Value.(string)
+			if !ok {
+				return group.ProtocolError("bad value in deletefile")
+			}
+			err := filedrop.Delete(g.Name(), id)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return c.error(group.UserError("unknown file"))
+				}
+				c.log().Warn("Delete file", "err", err)
+				return c.error(group.UserError(
+					"couldn't delete file",
+				))
 			}
 		case "lock", "unlock":
 			if !member("op", c.permissions) {
@@ -1647,6 +2284,19 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				message = v
 			}
 			g.SetLocked(m.Kind == "lock", message)
+		case "lockguests", "unlockguests":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			err := g.SetGuestsLocked(
+				m.Kind == "lockguests", c.id, &c.username,
+			)
+			if err != nil {
+				c.log().Warn("Update guest tokens", "err", err)
+				return c.error(group.UserError(
+					"couldn't update the guest links",
+				))
+			}
 		case "record":
 			if !member("record", c.permissions) {
 				return c.error(group.UserError("not authorised"))
@@ -1679,6 +2329,48 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 					group.DelClient(disk)
 				}
 			}
+		case "captions":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			if !g.Description().AllowCaptions {
+				return c.error(group.UserError(
+					"captions are not allowed in this group",
+				))
+			}
+			for _, cc := range g.GetClients(c) {
+				_, ok := cc.(*captions.Client)
+				if ok {
+					return c.error(group.UserError(
+						"captions are already enabled",
+					))
+				}
+			}
+			client, err := captions.New(g)
+			if err != nil {
+				return c.error(group.UserError(err.Error()))
+			}
+			_, err = group.AddClient(g.Name(), client,
+				group.ClientCredentials{
+					System: true,
+				},
+			)
+			if err != nil {
+				client.Close()
+				return c.error(err)
+			}
+			requestConns(client, c.group, "")
+		case "uncaptions":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			for _, cc := range g.GetClients(c) {
+				client, ok := cc.(*captions.Client)
+				if ok {
+					client.Close()
+					group.DelClient(client)
+				}
+			}
 		case "subgroups":
 			if !member("op", c.permissions) {
 				return c.error(group.UserError("not authorised"))
@@ -1700,6 +2392,71 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				Time:     time.Now().Format(time.RFC3339),
 				Value:    s,
 			})
+		case "talktime":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			users := g.GetTalkTime()
+			var total time.Duration
+			for _, u := range users {
+				total += u.Time
+			}
+			s := ""
+			for _, u := range users {
+				name := u.Username
+				if name == "" {
+					name = "(anonymous " + u.Id + ")"
+				}
+				s = s + fmt.Sprintf("%v: %v (%.0f%%)\n",
+					name, u.Time.Round(time.Second),
+					100*u.Time.Seconds()/total.Seconds())
+			}
+			if s == "" {
+				s = "Nobody has spoken yet.\n"
+			}
+			username := "Server"
+			c.write(clientMessage{
+				Type:     "chat",
+				Dest:     c.id,
+				Username: &username,
+				Time:     time.Now().Format(time.RFC3339),
+				Value:    s,
+			})
+		case "capture":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			v, ok := m.Value.(map[string]interface{})
+			if !ok {
+				return c.error(group.UserError(
+					"Bad value in capture",
+				))
+			}
+			id, _ := v["id"].(string)
+			duration := 30 * time.Second
+			if d, ok := v["duration"].(float64); ok {
+				duration = time.Duration(d * float64(time.Second))
+			}
+			payloads, _ := v["payloads"].(bool)
+			up := findUpConn(g, id)
+			if up == nil {
+				return c.error(group.UserError("unknown stream"))
+			}
+			filename, err := startCapture(up, duration, payloads)
+			if err != nil {
+				return c.error(group.UserError(
+					"couldn't start capture: " + err.Error(),
+				))
+			}
+			username := "Server"
+			c.write(clientMessage{
+				Type:     "chat",
+				Dest:     c.id,
+				Username: &username,
+				Time:     time.Now().Format(time.RFC3339),
+				Value: fmt.Sprintf("Capturing stream %v for %v "+
+					"to %v.\n", id, duration, filename),
+			})
 		case "setdata":
 			if !member("op", c.permissions) {
 				return c.error(group.UserError("not authorised"))
@@ -1751,6 +2508,13 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				return terror("error", "that username is taken")
 			}
 
+			if tok.Guest && tok.Username != nil {
+				return terror(
+					"error", "guest links cannot specify "+
+						"a username",
+				)
+			}
+
 			for _, p := range tok.Permissions {
 				if !member(p, c.permissions) {
 					return terror(
@@ -1928,35 +2692,36 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				c.data = make(map[string]interface{})
 			}
 			for k, v := range data {
+				if serverData(k) {
+					continue
+				}
 				if v == nil {
 					delete(c.data, k)
 				} else {
 					c.data[k] = v
 				}
 			}
-			id := c.Id()
-			user := c.Username()
-			perms := c.Permissions()
-			data = c.Data()
-			go func(clients []group.Client) {
-				for _, cc := range clients {
-					cc.PushClient(
-						g.Name(), "change",
-						id, user, perms, data,
-					)
-				}
-			}(g.GetClients(nil))
+			pushData(c, g)
 		default:
 			return group.UserError("unknown user action")
 		}
 	case "pong":
 		// nothing
+	case "active":
+		// this only resets the idle timer, see checkIdle
 	case "ping":
 		return c.write(clientMessage{
 			Type: "pong",
 		})
+	case "clock":
+		receive := time.Now()
+		origin, ok := m.Value.(float64)
+		if !ok {
+			return group.ProtocolError("bad value in clock")
+		}
+		return c.clockSync(origin, receive)
 	default:
-		log.Printf("unexpected message: %v", m.Type)
+		c.log().Warn("Unexpected message", "type", m.Type)
 		return group.ProtocolError("unexpected message")
 	}
 	return nil
@@ -2049,6 +2814,13 @@ func parseStatefulToken(value interface{}) (*token.Stateful, error) {
 	if err != nil {
 		return nil, err
 	}
+	guest := false
+	if v := data["guest"]; v != nil {
+		guest, ok = v.(bool)
+		if !ok {
+			return nil, errors.New("bad guest value")
+		}
+	}
 	return &token.Stateful{
 		Token:       tt,
 		Group:       gg,
@@ -2056,6 +2828,7 @@ func parseStatefulToken(value interface{}) (*token.Stateful, error) {
 		Permissions: p,
 		Expires:     e,
 		NotBefore:   n,
+		Guest:       guest,
 	}, nil
 }
 
@@ -2080,49 +2853,101 @@ func clientReader(conn *websocket.Conn, read chan<- interface{}, done <-chan str
 	}
 }
 
-func clientWriter(conn *websocket.Conn, ch <-chan interface{}, done chan<- struct{}) {
+// clientWriter writes the messages sent on ch to the websocket.  If
+// attach is not nil, the client is resumable: when the websocket fails,
+// messages are queued until a new websocket is received on attach.
+// Receiving an attachment with a nil websocket causes messages to be
+// queued straight away.
+func clientWriter(conn *websocket.Conn, ch <-chan interface{}, done chan<- struct{}, attach <-chan attachment) {
+	var pending []interface{}
+
 	defer func() {
 		close(done)
-		conn.Close()
+		if conn != nil {
+			conn.Close()
+		}
 	}()
 
 	for {
-		m, ok := <-ch
-		if !ok {
-			break
-		}
-		err := conn.SetWriteDeadline(
-			time.Now().Add(500 * time.Millisecond),
-		)
-		if err != nil {
-			return
-		}
-		switch m := m.(type) {
-		case clientMessage:
-			err := conn.WriteJSON(m)
-			if err != nil {
+		select {
+		case m, ok := <-ch:
+			if !ok {
 				return
 			}
-		case []byte:
-			err := conn.WriteMessage(websocket.TextMessage, m)
-			if err != nil {
+			switch m := m.(type) {
+			case clientMessage, []byte:
+			case closeMessage:
+				if conn != nil && m.data != nil {
+					conn.SetWriteDeadline(
+						time.Now().Add(500 * time.Millisecond),
+					)
+					conn.WriteMessage(
+						websocket.CloseMessage,
+						m.data,
+					)
+				}
+				return
+			default:
+				logger.Warn("clientWriter: unexpected message",
+					"type", fmt.Sprintf("%T", m))
 				return
 			}
-		case closeMessage:
-			if m.data != nil {
-				conn.WriteMessage(
-					websocket.CloseMessage,
-					m.data,
-				)
+			if conn == nil {
+				if len(pending) >= maxPendingMessages {
+					return
+				}
+				pending = append(pending, m)
+				continue
+			}
+			err := writeClientMessage(conn, m)
+			if err != nil {
+				if attach == nil {
+					return
+				}
+				conn.Close()
+				conn = nil
+				pending = append(pending, m)
+			}
+		case a := <-attach:
+			if conn != nil {
+				conn.Close()
+			}
+			conn = a.conn
+			if conn == nil {
+				continue
+			}
+			err := writeClientMessage(conn, a.greeting)
+			for err == nil && len(pending) > 0 {
+				err = writeClientMessage(conn, pending[0])
+				if err == nil {
+					pending = pending[1:]
+				}
+			}
+			if err != nil {
+				conn.Close()
+				conn = nil
 			}
-			return
-		default:
-			log.Printf("clientWriter: unexpected message %T", m)
-			return
 		}
 	}
 }
 
+func writeClientMessage(conn *websocket.Conn, m interface{}) error {
+	err := conn.SetWriteDeadline(
+		time.Now().Add(500 * time.Millisecond),
+	)
+	if err != nil {
+		return err
+	}
+	switch m := m.(type) {
+	case clientMessage:
+		return conn.WriteJSON(m)
+	case []byte:
+		return conn.WriteMessage(websocket.TextMessage, m)
+	default:
+		return errors.New("unexpected message")
+	}
+}
+
 func (c *webClient) Warn(oponly bool, message string) error {
 	if oponly && !member("op", c.permissions) {
 		return nil
@@ -2137,6 +2962,183 @@ func (c *webClient) Warn(oponly bool, message string) error {
 	})
 }
 
+func (c *webClient) Drain(message, redirect string, deadline time.Time) error {
+	if !c.hasCapability("shutdown") {
+		return c.write(clientMessage{
+			Type:       "usermessage",
+			Kind:       "warning",
+			Dest:       c.id,
+			Privileged: true,
+			Value:      message,
+		})
+	}
+
+	value := map[string]interface{}{
+		"message":  message,
+		"deadline": deadline.Format(time.RFC3339),
+	}
+	if redirect != "" {
+		value["redirect"] = redirect
+	}
+	return c.write(clientMessage{
+		Type:       "usermessage",
+		Kind:       "shutdown",
+		Dest:       c.id,
+		Privileged: true,
+		Value:      value,
+	})
+}
+
+func (c *webClient) Chat(source string, username *string, privileged, noecho bool, t time.Time, kind string, value interface{}) error {
+	return c.write(clientMessage{
+		Type:       "chat",
+		Source:     source,
+		Username:   username,
+		Privileged: privileged,
+		Time:       t.Format(time.RFC3339),
+		Kind:       kind,
+		NoEcho:     noecho,
+		Value:      value,
+	})
+}
+
+func (c *webClient) Caption(source, id, username, text string) error {
+	if !c.hasCapability("captions") {
+		return nil
+	}
+
+	return c.write(clientMessage{
+		Type:     "caption",
+		Source:   source,
+		Id:       id,
+		Username: &username,
+		Time:     time.Now().Format(time.RFC3339),
+		Value:    text,
+	})
+}
+
+func (c *webClient) Whiteboard(op group.WhiteboardOp) error {
+	if !c.hasCapability("whiteboard") {
+		return nil
+	}
+	c.action(whiteboardAction{op})
+	return nil
+}
+
+func (c *webClient) Poll(status group.PollStatus) error {
+	if !c.hasCapability("polls") {
+		return nil
+	}
+	return c.write(clientMessage{
+		Type:  "poll",
+		Id:    status.Id,
+		Value: status,
+	})
+}
+
+func (c *webClient) RecordingStatus(status group.RecordingStatus) error {
+	if !member("op", c.permissions) || !c.hasCapability("recording") {
+		return nil
+	}
+	return c.write(clientMessage{
+		Type:  "recording",
+		Value: status,
+	})
+}
+
+// Announce sends an announcement to the client.  Clients that don't
+// implement announcements get a user message instead.
+func (c *webClient) Announce(source, username string, tm time.Time, message string) error {
+	if !c.hasCapability("announcement") {
+		return c.write(clientMessage{
+			Type:       "usermessage",
+			Kind:       "info",
+			Source:     source,
+			Username:   &username,
+			Privileged: true,
+			Time:       tm.Format(time.RFC3339),
+			Value:      message,
+		})
+	}
+	return c.write(clientMessage{
+		Type:     "announcement",
+		Source:   source,
+		Username: &username,
+		Time:     tm.Format(time.RFC3339),
+		Value:    message,
+	})
+}
+
+type pollRequest struct {
+	Question  string   `json:"question"`
+	Options   []string `json:"options"`
+	Anonymous bool     `json:"anonymous"`
+	// in seconds
+	Duration float64 `json:"duration"`
+}
+
+func parsePollRequest(value interface{}) (*pollRequest, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var req pollRequest
+	err = json.Unmarshal(data, &req)
+	if err != nil {
+		return nil, group.UserError("bad poll")
+	}
+	return &req, nil
+}
+
+type whiteboardEntry struct {
+	Id       string      `json:"id"`
+	Source   string      `json:"source,omitempty"`
+	Username *string     `json:"username,omitempty"`
+	Time     string      `json:"time"`
+	Value    interface{} `json:"value"`
+}
+
+func whiteboardMessage(op group.WhiteboardOp) clientMessage {
+	m := clientMessage{
+		Type:     "whiteboard",
+		Kind:     op.Kind,
+		Source:   op.Source,
+		Username: op.Username,
+		Time:     op.Time.Format(time.RFC3339),
+	}
+	switch op.Kind {
+	case "draw":
+		m.Id = strconv.FormatUint(op.Seq, 10)
+		m.Value = op.Value
+	case "undo":
+		m.Id = strconv.FormatUint(op.Target, 10)
+	}
+	return m
+}
+
+// sendWhiteboard sends the current state of the whiteboard.  It is
+// called from the client loop.
+func sendWhiteboard(c *webClient, g *group.Group) error {
+	seq, ops := g.GetWhiteboard()
+	entries := make([]whiteboardEntry, len(ops))
+	for i, op := range ops {
+		entries[i] = whiteboardEntry{
+			Id:       strconv.FormatUint(op.Seq, 10),
+			Source:   op.Source,
+			Username: op.Username,
+			Time:     op.Time.Format(time.RFC3339),
+			Value:    op.Value,
+		}
+	}
+	c.whiteboardSent = true
+	c.whiteboardSeq = seq
+	return c.write(clientMessage{
+		Type:  "whiteboard",
+		Kind:  "state",
+		Value: entries,
+	})
+}
+
 var ErrClientDead = errors.New("client is dead")
 
 func (c *webClient) action(a interface{}) {
@@ -2170,6 +3172,23 @@ func broadcast(cs []group.Client, m clientMessage) error {
 	return nil
 }
 
+// pushData informs the members of the group that the client's data has
+// changed.
+func pushData(c *webClient, g *group.Group) {
+	id := c.Id()
+	user := c.Username()
+	perms := c.Permissions()
+	data := c.Data()
+	go func(clients []group.Client) {
+		for _, cc := range clients {
+			cc.PushClient(
+				g.Name(), "change",
+				id, user, perms, data,
+			)
+		}
+	}(g.GetClients(nil))
+}
+
 func (c *webClient) close(data []byte) error {
 	select {
 	case c.writeCh <- closeMessage{data}:
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/jech/galene/token"
 )
@@ -50,3 +51,32 @@ func TestParseStatefulToken(t *testing.T) {
 		}
 	}
 }
+
+func TestAnnounce(t *testing.T) {
+	for _, capable := range []bool{true, false} {
+		ch := make(chan interface{}, 1)
+		c := &webClient{
+			writeCh:    ch,
+			writerDone: make(chan struct{}),
+		}
+		if capable {
+			c.capabilities = []string{"announcement"}
+		}
+		err := c.Announce("id", "op", time.Now(), "hello")
+		if err != nil {
+			t.Fatalf("Announce: %v", err)
+		}
+		m := (<-ch).(clientMessage)
+		expected := "announcement"
+		if !capable {
+			expected = "usermessage"
+		}
+		if m.Type != expected || m.Value != "hello" ||
+			m.Source != "id" || *m.Username != "op" {
+			t.Errorf("Capable %v: got %v", capable, m)
+		}
+		if !capable && (m.Kind != "info" || !m.Privileged) {
+			t.Errorf("Bad fallback %v", m)
+		}
+	}
+}
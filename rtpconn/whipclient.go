@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/jech/galene/conn"
 	"github.com/jech/galene/group"
@@ -112,8 +113,10 @@ func (c *WhipClient) Close() error {
 	}
 	if c.connection != nil {
 		id := c.connection.Id()
+		c.connection.stopCapture()
 		c.connection.pc.OnICEConnectionStateChange(nil)
 		c.connection.pc.Close()
+		c.connection.waitReaders(time.Second)
 		c.connection = nil
 		for _, c := range g.GetClients(c) {
 			c.PushConn(g, id, nil, nil, "")
@@ -126,6 +129,11 @@ func (c *WhipClient) Close() error {
 }
 
 func (c *WhipClient) NewConnection(ctx context.Context, offer []byte) ([]byte, error) {
+	err := c.group.AddPublisher(c)
+	if err != nil {
+		return nil, err
+	}
+
 	conn, err := newUpConn(c, c.id, "", string(offer))
 	if err != nil {
 		return nil, err
@@ -167,8 +175,13 @@ func (c *WhipClient) GotOffer(ctx context.Context, offer []byte) ([]byte, error)
 
 // called locked
 func (c *WhipClient) gotOffer(ctx context.Context, offer []byte) ([]byte, error) {
+	err := checkSimulcast(c.group, string(offer))
+	if err != nil {
+		return nil, err
+	}
+
 	conn := c.connection
-	err := conn.pc.SetRemoteDescription(webrtc.SessionDescription{
+	err = conn.pc.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  string(offer),
 	})
@@ -180,6 +193,7 @@ func (c *WhipClient) gotOffer(ctx context.Context, offer []byte) ([]byte, error)
 	if err != nil {
 		return nil, err
 	}
+	answer.SDP = limitAudioBitrate(answer.SDP, profileAudioBitrate(c.group))
 
 	gatherComplete := webrtc.GatheringCompletePromise(conn.pc)
 
@@ -0,0 +1,65 @@
+// Package rtpdump implements writing RTP and RTCP packets in the rtpdump
+// format, which is understood by rtptools and Wireshark.
+
+package rtpdump
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// A Writer writes packets to an rtpdump file.
+type Writer struct {
+	w     io.Writer
+	start time.Time
+}
+
+// NewWriter writes the file header, and returns a writer.  Source is the
+// address that is recorded as the origin of the packets.
+func NewWriter(w io.Writer, source netip.AddrPort, start time.Time) (*Writer, error) {
+	_, err := fmt.Fprintf(w, "#!rtpplay1.0 %v/%v\n",
+		source.Addr(), source.Port())
+	if err != nil {
+		return nil, err
+	}
+
+	var hdr [16]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(start.Unix()))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(start.Nanosecond()/1000))
+	if source.Addr().Is4() {
+		a := source.Addr().As4()
+		copy(hdr[8:12], a[:])
+	}
+	binary.BigEndian.PutUint16(hdr[12:], source.Port())
+	_, err = w.Write(hdr[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w, start: start}, nil
+}
+
+// WritePacket writes a packet received at time t.  Data may be truncated,
+// in which case length is the length of the original packet.
+func (w *Writer) WritePacket(data []byte, length int, rtcp bool, t time.Time) error {
+	if len(data)+8 > 0xFFFF || length > 0xFFFF || length < len(data) {
+		return errors.New("bad packet length")
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint16(hdr[0:], uint16(len(data)+8))
+	if !rtcp {
+		// the original length is set to 0 for RTCP packets
+		binary.BigEndian.PutUint16(hdr[2:], uint16(length))
+	}
+	binary.BigEndian.PutUint32(hdr[4:], uint32(t.Sub(w.start).Milliseconds()))
+	_, err := w.w.Write(hdr[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(data)
+	return err
+}
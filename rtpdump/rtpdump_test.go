@@ -0,0 +1,46 @@
+package rtpdump
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1000, 5000)
+	w, err := NewWriter(&buf,
+		netip.MustParseAddrPort("192.0.2.1:1234"), start,
+	)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	err = w.WritePacket([]byte{1, 2, 3}, 10, false,
+		start.Add(1500*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	err = w.WritePacket([]byte{4, 5}, 2, true,
+		start.Add(2*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	err = w.WritePacket([]byte{4, 5}, 1, false, start)
+	if err == nil {
+		t.Errorf("WritePacket succeeded with bad length")
+	}
+
+	expected := []byte("#!rtpplay1.0 192.0.2.1/1234\n")
+	expected = append(expected,
+		0, 0, 0x03, 0xe8, 0, 0, 0, 5,
+		192, 0, 2, 1, 0x04, 0xd2, 0, 0,
+		0, 11, 0, 10, 0, 0, 0x05, 0xdc, 1, 2, 3,
+		0, 10, 0, 0, 0, 0, 0x07, 0xd0, 4, 5,
+	)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Got %v, expected %v", buf.Bytes(), expected)
+	}
+}
@@ -0,0 +1,96 @@
+// Package sockbuf configures the kernel buffers of the UDP sockets used
+// for media.
+
+package sockbuf
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/pion/transport/v2"
+	"github.com/pion/transport/v2/stdnet"
+
+	"github.com/jech/galene/logging"
+)
+
+var logger = logging.New("ice")
+
+// ReadBuffer and WriteBuffer are the sizes of the receive and send
+// buffers of UDP sockets, in bytes.  Zero means the system default.
+var ReadBuffer, WriteBuffer int
+
+var warnedRead, warnedWrite atomic.Bool
+
+// Enabled returns true if any buffer size has been configured.
+func Enabled() bool {
+	return ReadBuffer > 0 || WriteBuffer > 0
+}
+
+// Set sets the buffer sizes of a UDP socket.  If the kernel clamps the
+// buffers to a smaller size, it logs a warning the first time.
+func Set(conn *net.UDPConn) error {
+	if ReadBuffer > 0 {
+		n, err := setBuffer(conn, ReadBuffer, false)
+		if err != nil {
+			return err
+		}
+		if n >= 0 && n < ReadBuffer && !warnedRead.Swap(true) {
+			logger.Warn("UDP receive buffer clamped by the kernel, "+
+				"consider increasing net.core.rmem_max",
+				"requested", ReadBuffer, "actual", n)
+		}
+	}
+	if WriteBuffer > 0 {
+		n, err := setBuffer(conn, WriteBuffer, true)
+		if err != nil {
+			return err
+		}
+		if n >= 0 && n < WriteBuffer && !warnedWrite.Swap(true) {
+			logger.Warn("UDP send buffer clamped by the kernel, "+
+				"consider increasing net.core.wmem_max",
+				"requested", WriteBuffer, "actual", n)
+		}
+	}
+	return nil
+}
+
+// Net is an implementation of transport.Net that sets the buffer sizes
+// of the UDP sockets that it creates.
+type Net struct {
+	*stdnet.Net
+}
+
+// NewNet creates a Net for use with webrtc.SettingEngine.SetNet.
+func NewNet() (*Net, error) {
+	n, err := stdnet.NewNet()
+	if err != nil {
+		return nil, err
+	}
+	return &Net{n}, nil
+}
+
+func (n *Net) ListenUDP(network string, addr *net.UDPAddr) (transport.UDPConn, error) {
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	err = Set(conn)
+	if err != nil {
+		logger.Warn("Set UDP buffers", "err", err)
+	}
+	return conn, nil
+}
+
+func (n *Net) ListenPacket(network string, address string) (net.PacketConn, error) {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := conn.(*net.UDPConn); ok {
+		err = Set(c)
+		if err != nil {
+			logger.Warn("Set UDP buffers", "err", err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,42 @@
+package sockbuf
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setBuffer sets a socket buffer, and returns its actual size.  If the
+// process has CAP_NET_ADMIN, it overrides the system-wide maximum.
+func setBuffer(conn *net.UDPConn, size int, write bool) (int, error) {
+	opt, force := unix.SO_RCVBUF, unix.SO_RCVBUFFORCE
+	if write {
+		opt, force = unix.SO_SNDBUF, unix.SO_SNDBUFFORCE
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var n int
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, force, size)
+		if serr != nil {
+			serr = unix.SetsockoptInt(
+				int(fd), unix.SOL_SOCKET, opt, size,
+			)
+			if serr != nil {
+				return
+			}
+		}
+		n, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, opt)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if serr != nil {
+		return -1, serr
+	}
+	// Linux doubles the requested value to account for overhead
+	return n / 2, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package sockbuf
+
+import (
+	"net"
+)
+
+// setBuffer sets a socket buffer.  The actual size is not known, so it
+// returns -1.
+func setBuffer(conn *net.UDPConn, size int, write bool) (int, error) {
+	var err error
+	if write {
+		err = conn.SetWriteBuffer(size)
+	} else {
+		err = conn.SetReadBuffer(size)
+	}
+	return -1, err
+}
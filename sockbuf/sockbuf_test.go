@@ -0,0 +1,33 @@
+package sockbuf
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNet(t *testing.T) {
+	ReadBuffer = 100000
+	WriteBuffer = 100000
+	defer func() {
+		ReadBuffer = 0
+		WriteBuffer = 0
+	}()
+
+	n, err := NewNet()
+	if err != nil {
+		t.Fatalf("NewNet: %v", err)
+	}
+	conn, err := n.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer conn.Close()
+
+	size, err := setBuffer(conn.(*net.UDPConn), 50000, false)
+	if err != nil {
+		t.Fatalf("setBuffer: %v", err)
+	}
+	if size >= 0 && size != 50000 {
+		t.Errorf("Expected 50000, got %v", size)
+	}
+}
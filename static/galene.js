@@ -438,6 +438,17 @@ function gotClose(code, reason) {
     form.active = true;
 }
 
+/**
+ * @this {ServerConnection}
+ * @param {boolean} resumed
+ */
+function gotReconnect(resumed) {
+    if(resumed)
+        displayMessage('Reconnected to the server');
+    else
+        displayWarning('Connection to the server lost, reconnecting...');
+}
+
 /**
  * @this {ServerConnection}
  * @param {Stream} c
@@ -460,6 +471,9 @@ function gotDownStream(c) {
     c.onstatus = function(status) {
         setMediaStatus(c);
     };
+    c.onaudioonly = function(audioOnly) {
+        setLabel(c);
+    };
     c.onstats = gotDownStats;
     if(getSettings().activityDetection)
         c.setStatsInterval(activityDetectionInterval);
@@ -645,22 +659,42 @@ getSelectElement('filterselect').onchange = async function(e) {
     }
 };
 
+/**
+ * Returns the group's quality profile, or null.
+ *
+ * @returns {Object<string,any>}
+ */
+function getProfile() {
+    return groupStatus.profile || null;
+}
+
 /** @returns {number} */
 function getMaxVideoThroughput() {
     let v = getSettings().send;
+    let bps;
     switch(v) {
     case 'lowest':
-        return 150000;
+        bps = 150000;
+        break;
     case 'low':
-        return 300000;
+        bps = 300000;
+        break;
     case 'normal':
-        return 700000;
+        bps = 700000;
+        break;
     case 'unlimited':
-        return null;
+        bps = null;
+        break;
     default:
         console.error('Unknown video quality', v);
-        return 700000;
+        bps = 700000;
+        break;
     }
+    let profile = getProfile();
+    let max = profile && profile['max-video-bitrate'];
+    if(max && (!bps || bps > max))
+        return max;
+    return bps;
 }
 
 getSelectElement('sendselect').onchange = async function(e) {
@@ -1227,7 +1261,9 @@ const hqAudioRate = 128000;
  * @returns {boolean}
  */
 function doSimulcast() {
-    switch(getSettings().simulcast) {
+    let profile = getProfile();
+    let policy = (profile && profile.simulcast) || getSettings().simulcast;
+    switch(policy) {
     case 'on':
         return true;
     case 'off':
@@ -1292,6 +1328,9 @@ function setUpStream(c, stream) {
                     t.contentHint = 'detail';
                 }
             }
+        } else if(c.label === 'screenshare') {
+            if(t.kind == 'video' && !t.contentHint)
+                t.contentHint = 'detail';
         }
         t.onended = e => {
             stream.onaddtrack = null;
@@ -1321,7 +1360,12 @@ function setUpStream(c, stream) {
                 });
             }
         } else {
-            if(settings.hqaudio) {
+            let profile = getProfile();
+            if(profile && profile['audio-bitrate']) {
+                encodings.push({
+                    maxBitrate: profile['audio-bitrate'],
+                });
+            } else if(settings.hqaudio) {
                 encodings.push({
                     maxBitrate: hqAudioRate,
                 });
@@ -1343,6 +1387,18 @@ function setUpStream(c, stream) {
             }
         } catch(e) {
         }
+
+        // text must remain readable, reduce the framerate instead
+        if(t.kind === 'video' && t.contentHint === 'detail') {
+            try {
+                let p = tr.sender.getParameters();
+                /** @ts-ignore */
+                p.degradationPreference = 'maintain-resolution';
+                tr.sender.setParameters(p);
+            } catch(e) {
+                console.warn(e);
+            }
+        }
     }
 
     // c.stream might be different from stream if there's a filter
@@ -1433,6 +1489,35 @@ function replaceCameraStream() {
         addLocalMedia(c.localId);
 }
 
+/**
+ * Applies the limits of the group's quality profile to the constraints
+ * used for opening the camera.
+ *
+ * @param {MediaTrackConstraints} video
+ */
+function profileConstraints(video) {
+    let profile = getProfile();
+    if(!profile)
+        return;
+
+    /**
+     * @param {any} c
+     * @param {number} max
+     * @returns {ConstrainULongRange}
+     */
+    function cap(c, max) {
+        let ideal = (c && c.ideal) || max;
+        return {ideal: Math.min(ideal, max), max: max};
+    }
+
+    if(profile['max-width'] && profile['max-height']) {
+        video.width = cap(video.width, profile['max-width']);
+        video.height = cap(video.height, profile['max-height']);
+    }
+    if(profile['max-framerate'])
+        video.frameRate = {max: profile['max-framerate']};
+}
+
 /**
  * @param {string} [localId]
  */
@@ -1455,6 +1540,7 @@ async function addLocalMedia(localId) {
         } else {
             video.aspectRatio = { ideal: 4/3 };
         }
+        profileConstraints(video);
     }
 
     if(audio) {
@@ -1720,20 +1806,33 @@ function reconsiderDownRate(id) {
     if(requestlow === null)
         return;
 
+    let media = /** @type {HTMLVideoElement} */
+        (document.getElementById('media-' + c.localId));
+    if(!media)
+        throw new Error("No media for stream");
+    let w = media.scrollWidth;
+    let h = media.scrollHeight;
+
     let old = c.userdata.requested;
     let low = false;
     if(old && ('force' in old)) {
         low = old.force;
-    } else {
-        let media = /** @type {HTMLVideoElement} */
-            (document.getElementById('media-' + c.localId));
-        if(!media)
-            throw new Error("No media for stream");
-        let w = media.scrollWidth;
-        let h = media.scrollHeight;
-        if(w && h && w * h <= 320 * 240) {
-            low = true;
-        }
+    } else if(c.label !== 'screenshare' && w && h && w * h <= 320 * 240) {
+        // screen shares are only useful at high enough resolution
+        low = true;
+    }
+
+    // tell the server the size in device pixels, unless we're forcing
+    // a given layer
+    let size = [0, 0];
+    if(w && h && !(old && ('force' in old))) {
+        let ratio = window.devicePixelRatio || 1;
+        size = [Math.round(w * ratio), Math.round(h * ratio)];
+    }
+    let oldsize = c.userdata.resolution;
+    if(!oldsize || oldsize[0] !== size[0] || oldsize[1] !== size[1]) {
+        c.userdata.resolution = size;
+        c.setResolution(size[0], size[1]);
     }
 
     if(low !== !!(old && old.low)) {
@@ -2078,6 +2177,59 @@ function setMediaStatus(c) {
 }
 
 
+/**
+ * captionTimers holds the timers that remove stale captions, indexed
+ * by stream localId.
+ *
+ * @type {Object<string,number>}
+ */
+let captionTimers = {};
+
+/**
+ * @param {string} source
+ * @param {string} id
+ * @param {string} username
+ * @param {Date} time
+ * @param {string} text
+ */
+function gotCaption(source, id, username, time, text) {
+    let c = serverConnection.down[id] || serverConnection.up[id];
+    if(!c)
+        return;
+    let div = document.getElementById('peer-' + c.localId);
+    if(!div)
+        return;
+    let caption = document.getElementById('caption-' + c.localId);
+    if(!caption) {
+        caption = document.createElement('div');
+        caption.id = 'caption-' + c.localId;
+        caption.classList.add('caption');
+        div.appendChild(caption);
+    }
+    caption.textContent = text;
+    if(captionTimers[c.localId])
+        clearTimeout(captionTimers[c.localId]);
+    captionTimers[c.localId] = setTimeout(() => {
+        delete(captionTimers[c.localId]);
+        caption.remove();
+    }, 8000);
+}
+
+/**
+ * displayName returns the name under which a user should be displayed,
+ * which is their registered display name if they have one.
+ *
+ * @param {string} id
+ * @param {string} username
+ * @returns {string}
+ */
+function displayName(id, username) {
+    let u = id && serverConnection && serverConnection.users[id];
+    if(u && u.data && u.data.displayName)
+        return u.data.displayName;
+    return username;
+}
+
 /**
  * @param {Stream} c
  * @param {string} [fallback]
@@ -2086,7 +2238,7 @@ function setLabel(c, fallback) {
     let label = document.getElementById('label-' + c.localId);
     if(!label)
         return;
-    let l = c.username;
+    let l = displayName(c.source, c.username);
     if(l) {
         label.textContent = l;
         label.classList.remove('label-fallback');
@@ -2097,6 +2249,8 @@ function setLabel(c, fallback) {
         label.textContent = '';
         label.classList.remove('label-fallback');
     }
+    if(!c.up && c.audioOnly)
+        label.textContent += ' (audio only, slow connection)';
 }
 
 function resizePeers() {
@@ -2367,11 +2521,23 @@ function changeUser(id, userinfo) {
  * @param {user} userinfo
  */
 function setUserStatus(id, elt, userinfo) {
-    elt.textContent = userinfo.username ? userinfo.username : '(anon)';
+    let displayName = userinfo.data.displayName;
+    elt.textContent = displayName ||
+        (userinfo.username ? userinfo.username : '(anon)');
+    elt.title = displayName ? userinfo.username : '';
+    if(userinfo.data.avatar) {
+        let img = document.createElement('img');
+        img.src = userinfo.data.avatar;
+        img.alt = '';
+        img.classList.add('user-avatar');
+        elt.prepend(img);
+    }
     if(userinfo.data.raisehand)
         elt.classList.add('user-status-raisehand');
     else
         elt.classList.remove('user-status-raisehand');
+    elt.classList.toggle('user-quality-fair', userinfo.data.quality === 'fair');
+    elt.classList.toggle('user-quality-poor', userinfo.data.quality === 'poor');
 
     let microphone=false, camera = false;
     for(let label in userinfo.streams) {
@@ -2467,6 +2633,48 @@ function setTitle(title) {
         set('Galène');
 }
 
+/**
+ * setBranding applies the logo, background and stylesheet of a group.
+ *
+ * @param {Object<string,string>} branding
+ */
+function setBranding(branding) {
+    let logo = /** @type{HTMLImageElement} */
+        (document.getElementById('logo'));
+    if(branding && branding.logo) {
+        if(logo.getAttribute('src') !== branding.logo)
+            logo.src = branding.logo;
+        logo.classList.remove('invisible');
+    } else {
+        logo.removeAttribute('src');
+        logo.classList.add('invisible');
+    }
+
+    let container = document.getElementById('video-container');
+    if(branding && branding.background) {
+        container.style.backgroundImage = `url("${branding.background}")`;
+        container.style.backgroundSize = 'cover';
+        container.style.backgroundPosition = 'center';
+    } else {
+        container.style.backgroundImage = '';
+    }
+
+    let link = /** @type{HTMLLinkElement} */
+        (document.getElementById('branding-stylesheet'));
+    if(branding && branding.stylesheet) {
+        if(!link) {
+            link = document.createElement('link');
+            link.id = 'branding-stylesheet';
+            link.rel = 'stylesheet';
+            document.head.appendChild(link);
+        }
+        if(link.getAttribute('href') !== branding.stylesheet)
+            link.href = branding.stylesheet;
+    } else if(link) {
+        link.remove();
+    }
+}
+
 
 /**
  * @this {ServerConnection}
@@ -2502,6 +2710,7 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
         this.close();
         setButtonsVisibility();
         setChangePassword(null);
+        setBranding(null);
         return;
     case 'join':
     case 'change':
@@ -2519,6 +2728,7 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
         for(let key in status)
             groupStatus[key] = status[key];
         setTitle((status && status.displayName) || capitalise(group));
+        setBranding(status && status.branding);
         displayUsername();
         setButtonsVisibility();
         setChangePassword(pwAuth && !!groupStatus.canChangePassword &&
@@ -2542,6 +2752,12 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
     if(status.locked)
         displayWarning('This group is locked');
 
+    if(status.echo) {
+        displayMessage('This is a test group: ' +
+                       'your streams will be sent back to you');
+        this.subscribeStats(2);
+    }
+
     if(typeof RTCPeerConnection === 'undefined')
         displayWarning("This browser doesn't support WebRTC");
     else
@@ -2573,6 +2789,66 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
     }
 }
 
+/**
+ * gotServerStats is called when the server sends statistics, which only
+ * happens in echo groups.  It displays the round-trip time of every
+ * stream sent back by the server.
+ *
+ * @param {Object<string,any>} stats
+ */
+function gotServerStats(stats) {
+    let me = stats.clients &&
+        stats.clients.find(c => c.id === serverConnection.id);
+    if(!me || !me.down)
+        return;
+    for(let conn of me.down) {
+        let c = serverConnection.down[conn.id];
+        let label = c && document.getElementById('label-' + c.localId);
+        if(!label)
+            continue;
+        let rtt = 0;
+        for(let t of conn.tracks)
+            rtt = Math.max(rtt, t.rtt || 0);
+        label.textContent =
+            `${c.username || '(anon)'} (echo, RTT ${Math.round(rtt)}ms)`;
+    }
+}
+
+/**
+ * The last recording status received from the server.
+ *
+ * @type {Object<string,any>}
+ */
+let recordingStatus = null;
+
+/**
+ * The amount of free disk space below which we warn about a recording.
+ */
+const lowDiskSpace = 1024 * 1024 * 1024;
+
+/**
+ * gotRecordingStatus is called periodically while the group is being
+ * recorded.  It warns the operator when the recording stalls or the
+ * server runs out of disk space.
+ *
+ * @param {Object<string,any>} status
+ */
+function gotRecordingStatus(status) {
+    let old = recordingStatus;
+    recordingStatus = status;
+    if(status.stalled && !(old && old.stalled))
+        displayWarning('The recording has stalled');
+    let low = typeof status.available === 'number' &&
+        status.available < lowDiskSpace;
+    let wasLow = old && typeof old.available === 'number' &&
+        old.available < lowDiskSpace;
+    if(low && !wasLow)
+        displayWarning(
+            'The server is running out of disk space for recording ' +
+                `(${Math.round(status.available / (1024 * 1024))}MB left)`,
+        );
+}
+
 /**
  * @param {TransferredFile} f
  */
@@ -2749,6 +3025,25 @@ function gotFileTransferEvent(state, data) {
     }
 }
 
+/**
+ * waitForActivity informs the server as soon as the user interacts with
+ * the page, after the server has warned that we are idle.
+ */
+function waitForActivity() {
+    let events = ['keydown', 'pointerdown', 'pointermove', 'touchstart'];
+    function active() {
+        for(let e of events)
+            document.removeEventListener(e, active);
+        try {
+            serverConnection.active();
+        } catch(e) {
+            console.error(e);
+        }
+    }
+    for(let e of events)
+        document.addEventListener(e, active);
+}
+
 /**
  * @param {string} id
  * @param {string} dest
@@ -2771,6 +3066,8 @@ function gotUserMessage(id, dest, username, time, privileged, kind, error, messa
         }
         let from = id ? (username || 'Anonymous') : 'The Server';
         displayError(`${from} said: ${message}`, kind);
+        if(kind === 'warning' && !id && error === 'idle')
+            waitForActivity();
         break;
     case 'mute':
         if(!privileged) {
@@ -2831,6 +3128,25 @@ function gotUserMessage(id, dest, username, time, privileged, kind, error, messa
         }
         localMessage(s);
         break;
+    case 'shutdown': {
+        if(!privileged) {
+            console.error(`Got unprivileged message of kind ${kind}`);
+            return;
+        }
+        let m = /** @type {Object<string,string>} */(message);
+        if(!m.redirect) {
+            displayWarning(m.message);
+            return;
+        }
+        displayWarning(`${m.message}, moving to another server`);
+        setTimeout(() => {
+            if(serverConnection)
+                serverConnection.close();
+            token = null;
+            document.location.href = m.redirect;
+        }, 3000);
+        break;
+    }
     case 'userinfo':
         if(!privileged) {
             console.error(`Got unprivileged message of kind ${kind}`);
@@ -2862,6 +3178,8 @@ function formatToken(token, details) {
     let foruser = '', by = '', togroup = '';
     if(token.username)
         foruser = ` for user ${token.username}`;
+    else if(token.guest)
+        foruser = ' for guests';
     if(details) {
         if(token.issuedBy)
             by = ' issued by ' + token.issuedBy;
@@ -3012,10 +3330,11 @@ function addToChatbox(peerId, dest, nick, time, privileged, history, kind, messa
             let header = document.createElement('p');
             let user = document.createElement('span');
             let u = dest && serverConnection.users[dest];
-            let name = (u && u.username);
+            let name = displayName(dest, u && u.username);
+            let from = displayName(peerId, nick);
             user.textContent = dest ?
-                `${nick || '(anon)'} \u2192 ${name || '(anon)'}` :
-                (nick || '(anon)');
+                `${from || '(anon)'} \u2192 ${name || '(anon)'}` :
+                (from || '(anon)');
             user.classList.add('message-user');
             header.appendChild(user);
             header.classList.add('message-header');
@@ -3238,6 +3557,22 @@ commands.unrecord = {
     }
 };
 
+commands.captions = {
+    predicate: operatorPredicate,
+    description: 'enable live captions',
+    f: (c, r) => {
+        serverConnection.groupAction('captions');
+    }
+};
+
+commands.uncaptions = {
+    predicate: operatorPredicate,
+    description: 'disable live captions',
+    f: (c, r) => {
+        serverConnection.groupAction('uncaptions');
+    }
+};
+
 commands.subgroups = {
     predicate: operatorPredicate,
     description: 'list subgroups',
@@ -3246,6 +3581,33 @@ commands.subgroups = {
     }
 };
 
+commands.talktime = {
+    predicate: operatorPredicate,
+    description: 'show how long each user has spoken',
+    f: (c, r) => {
+        serverConnection.groupAction('talktime');
+    }
+};
+
+commands.capture = {
+    predicate: operatorPredicate,
+    description: 'capture the packets of a stream for debugging',
+    parameters: 'stream [seconds] [payloads]',
+    f: (c, r) => {
+        let p = r.split(' ').filter(s => s);
+        if(p.length < 1)
+            throw new Error('which stream?');
+        let value = {id: p[0], payloads: p[2] === 'payloads'};
+        if(p.length > 1) {
+            let d = parseInt(p[1]);
+            if(isNaN(d) || d <= 0)
+                throw new Error(`bad duration ${p[1]}`);
+            value.duration = d;
+        }
+        serverConnection.groupAction('capture', value);
+    }
+};
+
 /**
  * @type {Object<string,number>}
  */
@@ -3307,6 +3669,8 @@ function makeToken(template) {
         v.expires = units.d;
     if('not-before' in template)
         v["not-before"] = template["not-before"];
+    if(template.guest)
+        v.guest = true;
     if('permissions' in template)
         v.permissions = template.permissions;
     else {
@@ -3335,6 +3699,35 @@ commands.invite = {
     }
 }
 
+commands.guestlink = {
+    predicate: makeTokenPredicate,
+    description: "create a link for guests, who are named automatically",
+    parameters: "[expiration]",
+    f: (c, r) => {
+        let template = {guest: true, permissions: ['message']};
+        let expires = parseExpiration(r.trim());
+        if(expires)
+            template.expires = expires;
+        makeToken(template);
+    }
+}
+
+commands.lockguests = {
+    predicate: operatorPredicate,
+    description: 'disable guest links and disconnect all guests',
+    f: (c, r) => {
+        serverConnection.groupAction('lockguests');
+    }
+};
+
+commands.unlockguests = {
+    predicate: operatorPredicate,
+    description: 'enable guest links, revert the effect of /lockguests',
+    f: (c, r) => {
+        serverConnection.groupAction('unlockguests');
+    }
+};
+
 /**
  * @param {string} t
  */
@@ -3393,6 +3786,50 @@ function renegotiateStreams() {
         serverConnection.down[id].restartIce();
 }
 
+/**
+ * gotAnnouncement is called when an operator sends an announcement.  It
+ * is displayed even if the chat is hidden.
+ *
+ * @param {string} source
+ * @param {string} username
+ * @param {Date} time
+ * @param {string} message
+ */
+function gotAnnouncement(source, username, time, message) {
+    let from = displayName(source, username) || 'An operator';
+    displayError(`${from} announced: ${message}`, 'announcement');
+    localMessage(`Announcement from ${from}: ${message}`);
+}
+
+/**
+ * gotClock is called when the server replies to a clock request.
+ *
+ * @param {number} offset
+ * @param {number} rtt
+ * @param {Object<string,any>} value
+ */
+function gotClock(offset, rtt, value) {
+    let tracks = value.tracks ? value.tracks.length : 0;
+    localMessage(
+        `Server clock offset ${Math.round(offset)}ms, ` +
+            `round-trip time ${Math.round(rtt)}ms, ` +
+            `${tracks} synchronised track${tracks === 1 ? '' : 's'}`
+    );
+}
+
+commands.clock = {
+    description: "measure the offset between the local and server clocks",
+    predicate: () => {
+        if(!serverConnection ||
+           serverConnection.serverCapabilities.indexOf('clock') < 0)
+            return 'The server does not support clock synchronisation';
+        return null;
+    },
+    f: (c, r) => {
+        serverConnection.clock();
+    }
+};
+
 commands.renegotiate = {
     description: 'renegotiate media streams',
     f: (c, r) => {
@@ -3593,6 +4030,17 @@ commands.wall = {
     },
 };
 
+commands.announce = {
+    parameters: 'message',
+    description: 'send an announcement to all users',
+    predicate: operatorPredicate,
+    f: (c, r) => {
+        if(!r)
+            throw new Error('empty message');
+        serverConnection.announce(r);
+    },
+};
+
 commands.raise = {
     description: 'raise hand',
     f: (c, r) => {
@@ -3881,6 +4329,7 @@ function displayError(message, level) {
     var background = 'linear-gradient(to right, #e20a0a, #df2d2d)';
     var position = 'center';
     var gravity = 'top';
+    var duration = 4000;
 
     switch(level) {
     case "info":
@@ -3894,12 +4343,16 @@ function displayError(message, level) {
     case "kicked":
         level = "error";
         break;
+    case "announcement":
+        background = 'linear-gradient(to right, #1f5fbf, #3d8bdf)';
+        duration = 15000;
+        break;
     }
 
     /** @ts-ignore */
     Toastify({
         text: message,
-        duration: 4000,
+        duration: duration,
         close: true,
         position: position,
         gravity: gravity,
@@ -4020,12 +4473,22 @@ async function serverConnect() {
     };
     serverConnection.onpeerconnection = onPeerConnection;
     serverConnection.onclose = gotClose;
+    serverConnection.onreconnect = gotReconnect;
     serverConnection.ondownstream = gotDownStream;
     serverConnection.onuser = gotUser;
     serverConnection.onjoined = gotJoined;
     serverConnection.onchat = addToChatbox;
     serverConnection.onusermessage = gotUserMessage;
     serverConnection.onfiletransfer = gotFileTransfer;
+    serverConnection.oncaption = gotCaption;
+    serverConnection.onstats = gotServerStats;
+    serverConnection.onrecording = gotRecordingStatus;
+    serverConnection.onclock = gotClock;
+    serverConnection.onannouncement = gotAnnouncement;
+    serverConnection.capabilities = [
+        'captions', 'shutdown', 'stats', 'resume', 'recording',
+        'audioonly', 'announcement',
+    ];
 
     let url = groupStatus.endpoint;
     if(!url) {
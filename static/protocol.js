@@ -112,6 +112,19 @@ function ServerConnection() {
      * @type {string}
      */
     this.version = null;
+    /**
+     * The optional protocol features that we announce to the server.
+     * This may be set before the connection is established.
+     *
+     * @type {Array<string>}
+     */
+    this.capabilities = [];
+    /**
+     * The optional protocol features announced by the server.
+     *
+     * @type {Array<string>}
+     */
+    this.serverCapabilities = [];
     /**
      * The set of all up streams, indexed by their id.
      *
@@ -155,6 +168,20 @@ function ServerConnection() {
      * @type {number}
      */
     this.pingHandler = null;
+    /**
+     * The token that allows resuming the session after the websocket
+     * has been lost, or null if the server doesn't support resumption.
+     *
+     * @type {string}
+     */
+    this.session = null;
+    /**
+     * The time at which we lost the websocket, if we are attempting to
+     * resume the session, null otherwise.
+     *
+     * @type {number}
+     */
+    this.resuming = null;
 
     /* Callbacks */
 
@@ -177,6 +204,15 @@ function ServerConnection() {
      * @type{(this: ServerConnection, code: number, reason: string) => void}
      */
     this.onclose = null;
+    /**
+     * onreconnect is called with resumed set to false when the websocket
+     * has been lost and we are attempting to resume the session, and with
+     * resumed set to true once the session has been resumed.  If the
+     * session cannot be resumed, onclose is called normally.
+     *
+     * @type{(this: ServerConnection, resumed: boolean) => void}
+     */
+    this.onreconnect = null;
     /**
      * onpeerconnection is called before we establish a new peer connection.
      * It may either return null, or a new RTCConfiguration that overrides
@@ -227,6 +263,70 @@ function ServerConnection() {
      * @type {(this: ServerConnection, id: string, dest: string, username: string, time: Date, privileged: boolean, kind: string, error: string, message: unknown) => void}
      */
     this.onusermessage = null;
+    /**
+     * oncaption is called whenever a line of live captions is received.
+     * Id is the id of the stream being transcribed.  The server only
+     * sends captions if 'captions' is included in capabilities.
+     *
+     * @type {(this: ServerConnection, source: string, id: string, username: string, time: Date, text: string) => void}
+     */
+    this.oncaption = null;
+    /**
+     * onwhiteboard is called whenever a whiteboard operation is
+     * received.  Kind is 'state', in which case value is the list of
+     * drawing operations currently on the board, 'draw', 'undo' or
+     * 'clear'.  The server only sends whiteboard operations if
+     * 'whiteboard' is included in capabilities.
+     *
+     * @type {(this: ServerConnection, kind: string, id: string, source: string, username: string, time: Date, value: unknown) => void}
+     */
+    this.onwhiteboard = null;
+    /**
+     * onpoll is called whenever a poll is created or updated, and when
+     * it is closed.  The server only sends polls if 'polls' is included
+     * in capabilities.
+     *
+     * @type {(this: ServerConnection, id: string, poll: Object<string,any>) => void}
+     */
+    this.onpoll = null;
+    /**
+     * onreaction is called whenever a user sends a reaction.  The server
+     * only sends reactions if 'reactions' is included in capabilities.
+     *
+     * @type {(this: ServerConnection, source: string, username: string, time: Date, value: string) => void}
+     */
+    this.onreaction = null;
+    /**
+     * onstats is called whenever the server sends statistics about
+     * the group, after a call to subscribeStats.
+     *
+     * @type {(this: ServerConnection, stats: Object<string,any>) => void}
+     */
+    this.onstats = null;
+    /**
+     * onrecording is called periodically while the group is being
+     * recorded.  The server only sends the recording status to operators,
+     * and only if 'recording' is included in capabilities.
+     *
+     * @type {(this: ServerConnection, status: Object<string,any>) => void}
+     */
+    this.onrecording = null;
+    /**
+     * onclock is called when the server replies to a call to clock.
+     * Offset is the amount by which the server's clock is ahead of ours,
+     * and rtt is the round-trip time, both in milliseconds.
+     *
+     * @type {(this: ServerConnection, offset: number, rtt: number, value: Object<string,any>) => void}
+     */
+    this.onclock = null;
+    /**
+     * onannouncement is called whenever an operator sends an
+     * announcement.  The server only sends announcements if
+     * 'announcement' is included in capabilities.
+     *
+     * @type {(this: ServerConnection, source: string, username: string, time: Date, message: string) => void}
+     */
+    this.onannouncement = null;
     /**
      * The set of files currently being transferred.
      *
@@ -249,6 +349,7 @@ function ServerConnection() {
   * @typedef {Object} message
   * @property {string} type
   * @property {Array<string>} [version]
+  * @property {Array<string>} [capabilities]
   * @property {string} [kind]
   * @property {string} [error]
   * @property {string} [id]
@@ -271,6 +372,7 @@ function ServerConnection() {
   * @property {string} [label]
   * @property {Object<string,Array<string>>|Array<string>} [request]
   * @property {Object<string,any>} [rtcConfiguration]
+  * @property {string} [session]
   */
 
 /**
@@ -278,8 +380,58 @@ function ServerConnection() {
  * be called when the connection is effectively closed.
  */
 ServerConnection.prototype.close = function() {
-    this.socket && this.socket.close(1000, 'Close requested by client');
-    this.socket = null;
+    if(this.socket) {
+        this.socket.close(1000, 'Close requested by client');
+        this.socket = null;
+    } else if(this.resuming) {
+        this.closed(1000, 'Close requested by client');
+    }
+};
+
+/**
+ * The time during which we attempt to resume a session, in milliseconds.
+ * This is slightly shorter than the time during which the server keeps
+ * the session.
+ *
+ * @type {number}
+ */
+const resumeTimeout = 25000;
+
+/**
+ * closed is called when the connection has been closed and won't be
+ * resumed.  Don't call this.
+ *
+ * @param {number} code
+ * @param {string} reason
+ */
+ServerConnection.prototype.closed = function(code, reason) {
+    let sc = this;
+    sc.resuming = null;
+    sc.session = null;
+    sc.permissions = [];
+    for(let id in sc.up) {
+        let c = sc.up[id];
+        c.close();
+    }
+    for(let id in sc.down) {
+        let c = sc.down[id];
+        c.close();
+    }
+    for(let id in sc.users) {
+        delete(sc.users[id]);
+        if(sc.onuser)
+            sc.onuser.call(sc, id, 'delete');
+    }
+    if(sc.group && sc.onjoined)
+        sc.onjoined.call(sc, 'leave', sc.group, [], {}, {}, '', '');
+    sc.group = null;
+    sc.username = null;
+    if(sc.pingHandler) {
+        clearInterval(sc.pingHandler);
+        sc.pingHandler = null;
+    }
+    if(sc.onclose)
+        sc.onclose.call(sc, code, reason);
 };
 
 /**
@@ -318,212 +470,289 @@ ServerConnection.prototype.connect = function(url) {
     if(sc.socket)
         throw new Error("Attempting to connect stale connection");
 
-    sc.socket = new WebSocket(url);
-
-    this.pingHandler = setInterval(e => {
-        if(!sc.lastServerMessage) {
-            sc.error(new Error('Timeout'));
-            return;
-        }
-        let d = new Date().valueOf() - sc.lastServerMessage;
-        if(d > 65000) {
-            sc.error(new Error('Timeout'));
-            return;
-        }
-        if(sc.version && d >= 15000)
-            sc.send({type: 'ping'});
-    }, 10000);
+    function open() {
+        let socket = new WebSocket(url);
+        sc.socket = socket;
 
-    this.socket.onerror = function(e) {
-        if(sc.onerror)
-            sc.onerror.call(sc, new Error('Socket error: ' + e));
-    };
-    this.socket.onopen = function(e) {
-        try {
-            sc.send({
-                type: 'handshake',
-                version: ['2'],
-                id: sc.id,
-            });
-        } catch(e) {
-            sc.error(e);
-            return;
-        }
-    };
-    this.socket.onclose = function(e) {
-        sc.permissions = [];
-        for(let id in sc.up) {
-            let c = sc.up[id];
-            c.close();
-        }
-        for(let id in sc.down) {
-            let c = sc.down[id];
-            c.close();
-        }
-        for(let id in sc.users) {
-            delete(sc.users[id]);
-            if(sc.onuser)
-                sc.onuser.call(sc, id, 'delete');
-        }
-        if(sc.group && sc.onjoined)
-            sc.onjoined.call(sc, 'leave', sc.group, [], {}, {}, '', '');
-        sc.group = null;
-        sc.username = null;
-        if(sc.pingHandler) {
-            clearInterval(sc.pingHandler);
-            sc.pingHandler = null;
-        }
-        if(sc.onclose)
-            sc.onclose.call(sc, e.code, e.reason);
-    };
-    this.socket.onmessage = function(e) {
-        let m;
-        try {
-            m = JSON.parse(e.data);
-        } catch(e) {
-            sc.error(e);
-            return;
-        }
-        if(m.type !== 'handshake' && !sc.version) {
-            sc.error(new Error("Server didn't send handshake"));
-            return;
-        }
-        sc.lastServerMessage = new Date().valueOf();
-        switch(m.type) {
-        case 'handshake': {
-            if((m.version instanceof Array) && m.version.includes('2')) {
-                sc.version = '2';
-            } else {
-                sc.version = null;
-                sc.error(new Error(`Unknown protocol version ${m.version}`));
+        socket.onerror = function(e) {
+            if(sc.onerror)
+                sc.onerror.call(sc, new Error('Socket error: ' + e));
+        };
+        socket.onopen = function(e) {
+            try {
+                sc.send({
+                    type: 'handshake',
+                    version: ['2'],
+                    capabilities: sc.capabilities,
+                    id: sc.id,
+                    session: sc.resuming ? sc.session : undefined,
+                });
+            } catch(e) {
+                sc.error(e);
                 return;
             }
-            if(sc.onconnected)
-                sc.onconnected.call(sc);
-            break;
-        }
-        case 'offer':
-            sc.gotOffer(m.id, m.label, m.source, m.username,
-                        m.sdp, m.replace);
-            break;
-        case 'answer':
-            sc.gotAnswer(m.id, m.sdp);
-            break;
-        case 'renegotiate':
-            sc.gotRenegotiate(m.id);
-            break;
-        case 'close':
-            sc.gotClose(m.id);
-            break;
-        case 'abort':
-            sc.gotAbort(m.id);
-            break;
-        case 'ice':
-            sc.gotRemoteIce(m.id, m.candidate);
-            break;
-        case 'joined':
-            if(m.kind === 'leave' || m.kind === 'fail') {
-                for(let id in sc.users) {
-                    delete(sc.users[id]);
-                    if(sc.onuser)
-                        sc.onuser.call(sc, id, 'delete');
+        };
+        socket.onclose = function(e) {
+            if(sc.socket !== socket && sc.socket)
+                return;
+            if(sc.socket === socket && sc.session && sc.group &&
+               e.code === 1006 &&
+               (!sc.resuming ||
+                new Date().valueOf() - sc.resuming < resumeTimeout)) {
+                sc.socket = null;
+                if(!sc.resuming) {
+                    sc.resuming = new Date().valueOf();
+                    if(sc.onreconnect)
+                        sc.onreconnect.call(sc, false);
                 }
-                sc.username = null;
-                sc.permissions = [];
-                sc.rtcConfiguration = null;
-            } else if(m.kind === 'join' || m.kind == 'change') {
-                if(m.kind === 'join' && sc.group) {
-                    throw new Error('Joined multiple groups');
-                } else if(m.kind === 'change' && m.group != sc.group) {
-                    console.warn('join(change) for inconsistent group');
+                setTimeout(() => {
+                    if(sc.resuming && !sc.socket)
+                        open();
+                }, 2000);
+                return;
+            }
+            sc.closed(e.code, e.reason);
+        };
+        socket.onmessage = function(e) {
+            let m;
+            try {
+                m = JSON.parse(e.data);
+            } catch(e) {
+                sc.error(e);
+                return;
+            }
+            if(m.type !== 'handshake' && !sc.version) {
+                sc.error(new Error("Server didn't send handshake"));
+                return;
+            }
+            sc.lastServerMessage = new Date().valueOf();
+            switch(m.type) {
+            case 'handshake': {
+                if((m.version instanceof Array) && m.version.includes('2')) {
+                    sc.version = '2';
+                } else {
+                    sc.version = null;
+                    sc.error(new Error(`Unknown protocol version ${m.version}`));
+                    return;
+                }
+                if(m.capabilities instanceof Array)
+                    sc.serverCapabilities = m.capabilities;
+                else
+                    sc.serverCapabilities = [];
+                sc.session = m.session || null;
+                if(sc.resuming) {
+                    sc.resuming = null;
+                    for(let id in sc.up)
+                        sc.up[id].restartIce();
+                    if(sc.onreconnect)
+                        sc.onreconnect.call(sc, true);
                     break;
                 }
-                sc.group = m.group;
-                sc.username = m.username;
-                sc.permissions = m.permissions || [];
-                sc.rtcConfiguration = m.rtcConfiguration || null;
+                if(sc.onconnected)
+                    sc.onconnected.call(sc);
+                break;
             }
-            if(sc.onjoined)
-                sc.onjoined.call(sc, m.kind, m.group,
-                                 m.permissions || [],
-                                 m.status, m.data,
-                                 m.error || null, m.value || null);
-            break;
-        case 'user':
-            switch(m.kind) {
-            case 'add':
-                if(m.id in sc.users)
-                    console.warn(`Duplicate user ${m.id} ${m.username}`);
-                sc.users[m.id] = {
-                    username: m.username,
-                    permissions: m.permissions || [],
-                    data: m.data || {},
-                    streams: {},
-                };
+            case 'offer':
+                sc.gotOffer(m.id, m.label, m.source, m.username,
+                            m.sdp, m.replace);
+                break;
+            case 'answer':
+                sc.gotAnswer(m.id, m.sdp);
+                break;
+            case 'renegotiate':
+                sc.gotRenegotiate(m.id);
                 break;
-            case 'change':
-                if(!(m.id in sc.users)) {
-                    console.warn(`Unknown user ${m.id} ${m.username}`);
+            case 'close':
+                sc.gotClose(m.id);
+                break;
+            case 'abort':
+                sc.gotAbort(m.id);
+                break;
+            case 'audioonly': {
+                let c = sc.down[m.id];
+                if(!c) {
+                    console.warn('unknown down stream', m.id);
+                    break;
+                }
+                c.audioOnly = !!m.value;
+                if(c.onaudioonly)
+                    c.onaudioonly.call(c, c.audioOnly);
+                break;
+            }
+            case 'ice':
+                sc.gotRemoteIce(m.id, m.candidate);
+                break;
+            case 'joined':
+                if(m.kind === 'leave' || m.kind === 'fail') {
+                    for(let id in sc.users) {
+                        delete(sc.users[id]);
+                        if(sc.onuser)
+                            sc.onuser.call(sc, id, 'delete');
+                    }
+                    sc.username = null;
+                    sc.permissions = [];
+                    sc.rtcConfiguration = null;
+                } else if(m.kind === 'join' || m.kind == 'change') {
+                    if(m.kind === 'join' && sc.group) {
+                        throw new Error('Joined multiple groups');
+                    } else if(m.kind === 'change' && m.group != sc.group) {
+                        console.warn('join(change) for inconsistent group');
+                        break;
+                    }
+                    sc.group = m.group;
+                    sc.username = m.username;
+                    sc.permissions = m.permissions || [];
+                    sc.rtcConfiguration = m.rtcConfiguration || null;
+                }
+                if(sc.onjoined)
+                    sc.onjoined.call(sc, m.kind, m.group,
+                                     m.permissions || [],
+                                     m.status, m.data,
+                                     m.error || null, m.value || null);
+                break;
+            case 'user':
+                switch(m.kind) {
+                case 'add':
+                    if(m.id in sc.users)
+                        console.warn(`Duplicate user ${m.id} ${m.username}`);
                     sc.users[m.id] = {
                         username: m.username,
                         permissions: m.permissions || [],
                         data: m.data || {},
                         streams: {},
                     };
-                } else {
-                    sc.users[m.id].username = m.username;
-                    sc.users[m.id].permissions = m.permissions || [];
-                    sc.users[m.id].data = m.data || {};
+                    break;
+                case 'change':
+                    if(!(m.id in sc.users)) {
+                        console.warn(`Unknown user ${m.id} ${m.username}`);
+                        sc.users[m.id] = {
+                            username: m.username,
+                            permissions: m.permissions || [],
+                            data: m.data || {},
+                            streams: {},
+                        };
+                    } else {
+                        sc.users[m.id].username = m.username;
+                        sc.users[m.id].permissions = m.permissions || [];
+                        sc.users[m.id].data = m.data || {};
+                    }
+                    break;
+                case 'delete':
+                    if(!(m.id in sc.users))
+                        console.warn(`Unknown user ${m.id} ${m.username}`);
+                    for(let t in sc.transferredFiles) {
+                        let f = sc.transferredFiles[t];
+                        if(f.userid === m.id)
+                            f.fail('user has gone away');
+                    }
+                    delete(sc.users[m.id]);
+                    break;
+                default:
+                    console.warn(`Unknown user action ${m.kind}`);
+                    return;
                 }
+                if(sc.onuser)
+                    sc.onuser.call(sc, m.id, m.kind);
                 break;
-            case 'delete':
-                if(!(m.id in sc.users))
-                    console.warn(`Unknown user ${m.id} ${m.username}`);
-                for(let t in sc.transferredFiles) {
-                    let f = sc.transferredFiles[t];
-                    if(f.userid === m.id)
-                        f.fail('user has gone away');
-                }
-                delete(sc.users[m.id]);
+            case 'chat':
+            case 'chathistory':
+                if(sc.onchat)
+                    sc.onchat.call(
+                        sc, m.source, m.dest, m.username, parseTime(m.time),
+                        m.privileged, m.type === 'chathistory', m.kind,
+                        '' + m.value,
+                    );
+                break;
+            case 'usermessage':
+                if(m.kind === 'filetransfer')
+                    sc.fileTransfer(m.source, m.username, m.value);
+                else if(sc.onusermessage)
+                    sc.onusermessage.call(
+                        sc, m.source, m.dest, m.username, parseTime(m.time),
+                        m.privileged, m.kind, m.error, m.value,
+                    );
+                break;
+            case 'caption':
+                if(sc.oncaption)
+                    sc.oncaption.call(
+                        sc, m.source, m.id, m.username, parseTime(m.time),
+                        '' + m.value,
+                    );
+                break;
+            case 'whiteboard':
+                if(sc.onwhiteboard)
+                    sc.onwhiteboard.call(
+                        sc, m.kind, m.id, m.source, m.username,
+                        parseTime(m.time), m.value,
+                    );
+                break;
+            case 'poll':
+                if(sc.onpoll)
+                    sc.onpoll.call(sc, m.id, m.value);
+                break;
+            case 'stats':
+                if(sc.onstats)
+                    sc.onstats.call(sc, m.value);
+                break;
+            case 'recording':
+                if(sc.onrecording)
+                    sc.onrecording.call(sc, m.value);
+                break;
+            case 'announcement':
+                if(sc.onannouncement)
+                    sc.onannouncement.call(
+                        sc, m.source, m.username, parseTime(m.time), m.value,
+                    );
+                break;
+            case 'clock': {
+                let now = Date.now();
+                let v = m.value;
+                let rtt = (now - v.origin) - (v.transmit - v.receive);
+                let offset =
+                    ((v.receive - v.origin) + (v.transmit - now)) / 2;
+                if(sc.onclock)
+                    sc.onclock.call(sc, offset, rtt, v);
+                break;
+            }
+            case 'reaction':
+                if(sc.onreaction)
+                    sc.onreaction.call(
+                        sc, m.source, m.username, parseTime(m.time), m.value,
+                    );
+                break;
+            case 'ping':
+                sc.send({
+                    type: 'pong',
+                });
+                break;
+            case 'pong':
+                /* nothing */
                 break;
             default:
-                console.warn(`Unknown user action ${m.kind}`);
+                console.warn('Unexpected server message', m.type);
                 return;
             }
-            if(sc.onuser)
-                sc.onuser.call(sc, m.id, m.kind);
-            break;
-        case 'chat':
-        case 'chathistory':
-            if(sc.onchat)
-                sc.onchat.call(
-                    sc, m.source, m.dest, m.username, parseTime(m.time),
-                    m.privileged, m.type === 'chathistory', m.kind,
-                    '' + m.value,
-                );
-            break;
-        case 'usermessage':
-            if(m.kind === 'filetransfer')
-                sc.fileTransfer(m.source, m.username, m.value);
-            else if(sc.onusermessage)
-                sc.onusermessage.call(
-                    sc, m.source, m.dest, m.username, parseTime(m.time),
-                    m.privileged, m.kind, m.error, m.value,
-                );
-            break;
-        case 'ping':
-            sc.send({
-                type: 'pong',
-            });
-            break;
-        case 'pong':
-            /* nothing */
-            break;
-        default:
-            console.warn('Unexpected server message', m.type);
+        };
+    }
+
+    open();
+
+    this.pingHandler = setInterval(e => {
+        if(sc.resuming)
+            return;
+        if(!sc.lastServerMessage) {
+            sc.error(new Error('Timeout'));
             return;
         }
-    };
+        let d = new Date().valueOf() - sc.lastServerMessage;
+        if(d > 65000) {
+            sc.error(new Error('Timeout'));
+            return;
+        }
+        if(sc.version && d >= 15000)
+            sc.send({type: 'ping'});
+    }, 10000);
+
 };
 
 /**
@@ -656,6 +885,22 @@ ServerConnection.prototype.request = function(what) {
     });
 };
 
+/**
+ * subscribe sets the list of tracks requested from individual users.
+ * Subscriptions take precedence over the labels passed to request.
+ *
+ * @param {Object<string,Array<string>>} what
+ *     - A dictionary that maps user ids to a sequence of 'audio', 'video'
+ *       or 'video-low'.  An empty sequence unsubscribes from the user,
+ *       while users that are not in the dictionary obey request.
+ */
+ServerConnection.prototype.subscribe = function(what) {
+    this.send({
+        type: 'subscribe',
+        request: what,
+    });
+};
+
 /**
  * findByLocalId finds an active connection with the given localId.
  * It returns null if none was find.
@@ -769,6 +1014,16 @@ ServerConnection.prototype.chat = function(kind, dest, value) {
     });
 };
 
+/**
+ * active informs the server that the user is present, which prevents
+ * the server from disconnecting us for inactivity.
+ */
+ServerConnection.prototype.active = function() {
+    this.send({
+        type: 'active',
+    });
+};
+
 /**
  * userAction sends a request to act on a user.
  *
@@ -808,6 +1063,145 @@ ServerConnection.prototype.userMessage = function(kind, dest, value, noecho) {
     });
 };
 
+/**
+ * whiteboard sends an operation on the group's whiteboard.
+ *
+ * @param {string} kind - One of 'draw', 'undo' or 'clear'.
+ * @param {string} [id] - For 'undo', the id of the operation to undo.
+ * @param {unknown} [value] - For 'draw', the drawing operation.
+ */
+ServerConnection.prototype.whiteboard = function(kind, id, value) {
+    this.send({
+        type: 'whiteboard',
+        source: this.id,
+        kind: kind,
+        id: id,
+        value: value,
+    });
+};
+
+/**
+ * createPoll requests that the server create a poll.  This requires the
+ * 'op' permission.
+ *
+ * @param {string} question
+ * @param {Array<string>} options
+ * @param {boolean} [anonymous] - If set, the votes are not disclosed.
+ * @param {number} [duration] - The poll's duration in seconds.
+ */
+ServerConnection.prototype.createPoll = function(question, options,
+                                                 anonymous, duration) {
+    this.send({
+        type: 'poll',
+        kind: 'create',
+        source: this.id,
+        value: {
+            question: question,
+            options: options,
+            anonymous: !!anonymous,
+            duration: duration || 0,
+        },
+    });
+};
+
+/**
+ * vote votes in a poll.
+ *
+ * @param {string} id - The id of the poll.
+ * @param {number} option - The index of the chosen option.
+ */
+ServerConnection.prototype.vote = function(id, option) {
+    this.send({
+        type: 'poll',
+        kind: 'vote',
+        source: this.id,
+        id: id,
+        value: option,
+    });
+};
+
+/**
+ * closePoll requests that the server close a poll.
+ *
+ * @param {string} id - The id of the poll.
+ */
+ServerConnection.prototype.closePoll = function(id) {
+    this.send({
+        type: 'poll',
+        kind: 'close',
+        source: this.id,
+        id: id,
+    });
+};
+
+/**
+ * reaction sends a transient reaction, such as an emoji, to all the
+ * members of the group.  Reactions are rate-limited by the server.
+ *
+ * @param {string} value - The reaction.
+ */
+ServerConnection.prototype.reaction = function(value) {
+    this.send({
+        type: 'reaction',
+        source: this.id,
+        username: this.username,
+        value: value,
+    });
+};
+
+/**
+ * subscribeStats requests that the server periodically send statistics
+ * about the group.  This requires operator privileges.
+ *
+ * @param {number} [interval] - The interval in seconds.
+ */
+ServerConnection.prototype.subscribeStats = function(interval) {
+    this.send({
+        type: 'stats',
+        kind: 'subscribe',
+        source: this.id,
+        value: interval,
+    });
+};
+
+/**
+ * announce sends an announcement to all members of the group.  This
+ * requires operator privileges.
+ *
+ * @param {string} message
+ */
+ServerConnection.prototype.announce = function(message) {
+    this.send({
+        type: 'announcement',
+        source: this.id,
+        username: this.username,
+        value: message,
+    });
+};
+
+/**
+ * clock requests that the server send its current time, which causes
+ * onclock to be called.  This requires 'clock' to be included in the
+ * server's capabilities.
+ */
+ServerConnection.prototype.clock = function() {
+    this.send({
+        type: 'clock',
+        value: Date.now(),
+    });
+};
+
+/**
+ * unsubscribeStats requests that the server stop sending statistics.
+ */
+ServerConnection.prototype.unsubscribeStats = function() {
+    this.send({
+        type: 'stats',
+        kind: 'unsubscribe',
+        source: this.id,
+    });
+};
+
 /**
  * groupAction sends a request to act on the current group.
  *
@@ -1124,6 +1518,13 @@ function Stream(sc, id, localId, pc, up) {
      * @type {string}
      */
     this.replace = null;
+    /**
+     * For down streams, indicates whether the server has stopped
+     * sending video because our connection is too slow.
+     *
+     * @type {boolean}
+     */
+    this.audioOnly = false;
     /**
      * Indicates whether we have already sent a local description.
      *
@@ -1204,6 +1605,14 @@ function Stream(sc, id, localId, pc, up) {
      * @type{(this: Stream, status: string) => void}
      */
     this.onstatus = null;
+    /**
+     * onaudioonly is called when the server stops or resumes sending
+     * video on a down stream.  The server only does that if 'audioonly'
+     * is included in capabilities.
+     *
+     * @type{(this: Stream, audioOnly: boolean) => void}
+     */
+    this.onaudioonly = null;
     /**
      * onstats is called when we have new statistics about the connection
      *
@@ -1470,6 +1879,22 @@ Stream.prototype.request = function(what) {
     });
 };
 
+/**
+ * setResolution indicates the size at which a down stream is rendered,
+ * which allows the server to avoid sending a larger video than needed.
+ *
+ * @param {number} width - the width in pixels, 0 if unknown.
+ * @param {number} height - the height in pixels, 0 if unknown.
+ */
+Stream.prototype.setResolution = function(width, height) {
+    let c = this;
+    c.sc.send({
+        type: 'resolution',
+        id: c.id,
+        value: {width: width, height: height},
+    });
+};
+
 /**
  * updateStats is called periodically, if requested by setStatsInterval,
  * in order to recompute stream statistics and invoke the onstats handler.
@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// the interval at which statistics are sampled
+	historyInterval = 10 * time.Second
+	// the duration for which samples are kept
+	historyDuration = 10 * time.Minute
+)
+
+// A Sample is the statistics of all running groups at a given time.
+type Sample struct {
+	Time   time.Time    `json:"time"`
+	Groups []GroupStats `json:"groups"`
+}
+
+var history struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+// addSample records a sample and discards the samples that are older
+// than historyDuration.
+func addSample(s Sample) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	i := 0
+	for i < len(history.samples) &&
+		s.Time.Sub(history.samples[i].Time) > historyDuration {
+		i++
+	}
+	history.samples = append(history.samples[i:], s)
+}
+
+// History returns the recently sampled statistics, oldest first.
+func History() []Sample {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	return append([]Sample(nil), history.samples...)
+}
+
+// SampleHistory samples the statistics of all running groups periodically.
+// It never returns.
+func SampleHistory() {
+	ticker := time.NewTicker(historyInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		addSample(Sample{Time: now, Groups: GetGroups()})
+	}
+}
@@ -0,0 +1,29 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	defer func() { history.samples = nil }()
+
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		addSample(Sample{
+			Time: now.Add(time.Duration(i) * historyInterval),
+		})
+	}
+	h := History()
+	expected := int(historyDuration/historyInterval) + 1
+	if len(h) != expected {
+		t.Errorf("Expected %v samples, got %v", expected, len(h))
+	}
+	last := now.Add(99 * historyInterval)
+	if !h[len(h)-1].Time.Equal(last) {
+		t.Errorf("Last sample at %v, expected %v", h[len(h)-1].Time, last)
+	}
+	if last.Sub(h[0].Time) > historyDuration {
+		t.Errorf("First sample at %v is too old", h[0].Time)
+	}
+}
@@ -14,9 +14,10 @@ type GroupStats struct {
 }
 
 type Client struct {
-	Id   string `json:"id"`
-	Up   []Conn `json:"up,omitempty"`
-	Down []Conn `json:"down,omitempty"`
+	Id       string `json:"id"`
+	Username string `json:"username,omitempty"`
+	Up       []Conn `json:"up,omitempty"`
+	Down     []Conn `json:"down,omitempty"`
 }
 
 type Statable interface {
@@ -56,6 +57,37 @@ type Track struct {
 	Loss       float64  `json:"loss"`
 	Rtt        Duration `json:"rtt,omitempty"`
 	Jitter     Duration `json:"jitter,omitempty"`
+	Width      uint32   `json:"width,omitempty"`
+	Height     uint32   `json:"height,omitempty"`
+}
+
+// GetGroup returns the statistics of a single group, or nil if the group
+// is not running.
+func GetGroup(name string) *GroupStats {
+	g := group.Get(name)
+	if g == nil {
+		return nil
+	}
+	clients := g.GetClients(nil)
+	stats := GroupStats{
+		Name:    name,
+		Clients: make([]*Client, 0, len(clients)),
+	}
+	for _, c := range clients {
+		s, ok := c.(Statable)
+		if ok {
+			cs := s.GetStats()
+			stats.Clients = append(stats.Clients, cs)
+		} else {
+			stats.Clients = append(stats.Clients,
+				&Client{Id: c.Id(), Username: c.Username()},
+			)
+		}
+	}
+	sort.Slice(stats.Clients, func(i, j int) bool {
+		return stats.Clients[i].Id < stats.Clients[j].Id
+	})
+	return &stats
 }
 
 func GetGroups() []GroupStats {
@@ -63,30 +95,11 @@ func GetGroups() []GroupStats {
 
 	gs := make([]GroupStats, 0, len(names))
 	for _, name := range names {
-		g := group.Get(name)
-		if g == nil {
+		stats := GetGroup(name)
+		if stats == nil {
 			continue
 		}
-		clients := g.GetClients(nil)
-		stats := GroupStats{
-			Name:    name,
-			Clients: make([]*Client, 0, len(clients)),
-		}
-		for _, c := range clients {
-			s, ok := c.(Statable)
-			if ok {
-				cs := s.GetStats()
-				stats.Clients = append(stats.Clients, cs)
-			} else {
-				stats.Clients = append(stats.Clients,
-					&Client{Id: c.Id()},
-				)
-			}
-		}
-		sort.Slice(stats.Clients, func(i, j int) bool {
-			return stats.Clients[i].Id < stats.Clients[j].Id
-		})
-		gs = append(gs, stats)
+		gs = append(gs, *stats)
 	}
 	sort.Slice(gs, func(i, j int) bool {
 		return gs[i].Name < gs[j].Name
@@ -0,0 +1,66 @@
+package store
+
+// SetBandwidth records the number of bytes forwarded to a user during
+// a given day and month.
+func SetBandwidth(username, day string, daily uint64, month string, monthly uint64) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, p := range []struct {
+		period string
+		bytes  uint64
+	}{{day, daily}, {month, monthly}} {
+		_, err = tx.Exec(
+			"INSERT INTO bandwidth (username, period, bytes) "+
+				"VALUES (?, ?, ?) ON CONFLICT (username, period) "+
+				"DO UPDATE SET bytes = excluded.bytes",
+			username, p.period, int64(p.bytes),
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Bandwidth returns the number of bytes forwarded to each user during
+// the given day and month, in that order.
+func Bandwidth(day, month string) (map[string][2]uint64, error) {
+	db := getDB()
+	if db == nil {
+		return nil, ErrNotOpen
+	}
+	rows, err := db.Query(
+		"SELECT username, period, bytes FROM bandwidth "+
+			"WHERE period = ? OR period = ?",
+		day, month,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][2]uint64)
+	for rows.Next() {
+		var username, period string
+		var bytes int64
+		err := rows.Scan(&username, &period, &bytes)
+		if err != nil {
+			return nil, err
+		}
+		b := result[username]
+		if period == day {
+			b[0] = uint64(bytes)
+		} else {
+			b[1] = uint64(bytes)
+		}
+		result[username] = b
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"time"
+)
+
+// A ChatOp is a modification of the chat history of a group: it
+// appends Data to the history, or clears the history if Data is nil.
+type ChatOp struct {
+	Group string
+	Time  time.Time
+	Data  []byte
+}
+
+// AddChat appends an entry to the chat history of a group, and discards
+// all but the last max entries.
+func AddChat(group string, tm time.Time, data []byte, max int) error {
+	return WriteChat([]ChatOp{{Group: group, Time: tm, Data: data}}, max)
+}
+
+// WriteChat applies a sequence of modifications to the chat history in
+// a single transaction, and discards all but the last max entries of
+// the groups that have been appended to.
+func WriteChat(ops []ChatOp, max int) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	trim := make(map[string]bool)
+	for _, op := range ops {
+		if op.Data == nil {
+			_, err = tx.Exec("DELETE FROM chat WHERE grp = ?", op.Group)
+			delete(trim, op.Group)
+		} else {
+			_, err = tx.Exec(
+				"INSERT INTO chat (grp, time, data) "+
+					"VALUES (?, ?, ?)",
+				op.Group, op.Time.UnixMilli(), op.Data,
+			)
+			trim[op.Group] = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for group := range trim {
+		_, err = tx.Exec(
+			"DELETE FROM chat WHERE grp = ? AND id NOT IN "+
+				"(SELECT id FROM chat WHERE grp = ? "+
+				"ORDER BY id DESC LIMIT ?)",
+			group, group, max,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ChatHistory returns the chat history of a group, oldest first.
+func ChatHistory(group string) ([][]byte, error) {
+	db := getDB()
+	if db == nil {
+		return nil, ErrNotOpen
+	}
+	rows, err := db.Query(
+		"SELECT data FROM chat WHERE grp = ? ORDER BY id", group,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries [][]byte
+	for rows.Next() {
+		var data []byte
+		err := rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, data)
+	}
+	return entries, rows.Err()
+}
+
+// ClearChat discards the chat history of a group.
+func ClearChat(group string) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	_, err := db.Exec("DELETE FROM chat WHERE grp = ?", group)
+	return err
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// A Recording is an entry in the list of recordings.  Filename is
+// relative to the recordings directory, and uses slashes.  Ended is zero
+// for recordings in progress, or that were interrupted by a crash.
+type Recording struct {
+	Id       int64
+	Group    string
+	Filename string
+	Username string
+	Label    string
+	Started  time.Time
+	Ended    time.Time
+	Size     int64
+}
+
+// AddRecording records the start of a recording, and returns its id.
+func AddRecording(group, filename, username, label string, started time.Time) (int64, error) {
+	db := getDB()
+	if db == nil {
+		return 0, ErrNotOpen
+	}
+	r, err := db.Exec(
+		"INSERT INTO recordings "+
+			"(grp, filename, username, label, started) "+
+			"VALUES (?, ?, ?, ?, ?)",
+		group, filename, username, label, started.UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return r.LastInsertId()
+}
+
+// FinishRecording records the end of a recording.
+func FinishRecording(id int64, ended time.Time, size int64) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	_, err := db.Exec(
+		"UPDATE recordings SET ended = ?, size = ? WHERE id = ?",
+		ended.UnixMilli(), size, id,
+	)
+	return err
+}
+
+// Recordings returns the recordings of a group, or of all groups if
+// group is empty, oldest first.
+func Recordings(group string) ([]Recording, error) {
+	db := getDB()
+	if db == nil {
+		return nil, ErrNotOpen
+	}
+	rows, err := db.Query(
+		"SELECT id, grp, filename, username, label, "+
+			"started, ended, size FROM recordings "+
+			"WHERE ? = '' OR grp = ? ORDER BY started, id",
+		group, group,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recordings []Recording
+	for rows.Next() {
+		var r Recording
+		var started int64
+		var ended, size sql.NullInt64
+		err := rows.Scan(&r.Id, &r.Group, &r.Filename,
+			&r.Username, &r.Label, &started, &ended, &size)
+		if err != nil {
+			return nil, err
+		}
+		r.Started = time.UnixMilli(started)
+		if ended.Valid {
+			r.Ended = time.UnixMilli(ended.Int64)
+		}
+		r.Size = size.Int64
+		recordings = append(recordings, r)
+	}
+	return recordings, rows.Err()
+}
+
+// DeleteRecording removes the recording stored in the given file from
+// the list.
+func DeleteRecording(filename string) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	_, err := db.Exec(
+		"DELETE FROM recordings WHERE filename = ?", filename,
+	)
+	return err
+}
+
+// RenameRecording records that a recording has been replaced with
+// a different file, for example after it has been transcoded.
+func RenameRecording(id int64, filename string, size int64) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	_, err := db.Exec(
+		"UPDATE recordings SET filename = ?, size = ? WHERE id = ?",
+		filename, size, id,
+	)
+	return err
+}
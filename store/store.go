@@ -0,0 +1,203 @@
+// Package store implements an optional SQLite database that holds the
+// persistent state of the server: stateful tokens, chat history, usage
+// and bandwidth accounting, and the list of recordings.  When no database
+// is open, the callers fall back to their own files or to memory.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+var ErrNotOpen = errors.New("database is not open")
+
+var state struct {
+	mu sync.Mutex
+	db *sql.DB
+}
+
+// migrations are applied in order to bring the schema up to date.
+// The schema version is stored in the database's user_version, and is
+// the number of migrations that have been applied.  Never modify an
+// existing migration, add a new one instead.
+var migrations = []string{
+	`CREATE TABLE tokens (
+		token TEXT PRIMARY KEY,
+		grp TEXT NOT NULL,
+		expires INTEGER,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX tokens_grp ON tokens (grp);
+	CREATE TABLE chat (
+		id INTEGER PRIMARY KEY,
+		grp TEXT NOT NULL,
+		time INTEGER NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX chat_grp ON chat (grp, id);
+	CREATE TABLE usage (
+		id INTEGER PRIMARY KEY,
+		grp TEXT NOT NULL,
+		time INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		value TEXT
+	);
+	CREATE INDEX usage_grp ON usage (grp, time);
+	CREATE TABLE recordings (
+		id INTEGER PRIMARY KEY,
+		grp TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		username TEXT NOT NULL,
+		label TEXT NOT NULL,
+		started INTEGER NOT NULL,
+		ended INTEGER,
+		size INTEGER
+	);
+	CREATE INDEX recordings_grp ON recordings (grp, started);`,
+	`CREATE TABLE bandwidth (
+		username TEXT NOT NULL,
+		period TEXT NOT NULL,
+		bytes INTEGER NOT NULL,
+		PRIMARY KEY (username, period)
+	);`,
+}
+
+// Open opens the database in the given file, creating it if necessary,
+// and applies any pending migrations.
+func Open(filename string) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.db != nil {
+		return errors.New("database is already open")
+	}
+
+	dsn := "file:" + (&url.URL{Path: filename}).EscapedPath() +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return err
+	}
+	// SQLite only allows a single writer, avoid spurious busy errors
+	db.SetMaxOpenConns(1)
+
+	err = migrate(db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	state.db = db
+	return nil
+}
+
+// Close closes the database.
+func Close() error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.db == nil {
+		return nil
+	}
+	err := state.db.Close()
+	state.db = nil
+	return err
+}
+
+// Enabled returns true if a database is open.
+func Enabled() bool {
+	return getDB() != nil
+}
+
+func getDB() *sql.DB {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.db
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+func migrate(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf(
+			"database schema version %v is too recent", version,
+		)
+	}
+	for version < len(migrations) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(migrations[version])
+		if err == nil {
+			_, err = tx.Exec(
+				fmt.Sprintf("PRAGMA user_version = %d", version+1),
+			)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %v: %w", version+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		version++
+	}
+	return nil
+}
+
+// Version returns the schema version of the open database.
+func Version() (int, error) {
+	db := getDB()
+	if db == nil {
+		return 0, ErrNotOpen
+	}
+	return schemaVersion(db)
+}
+
+// Check runs SQLite's integrity check, and returns the problems found.
+func Check() ([]string, error) {
+	db := getDB()
+	if db == nil {
+		return nil, ErrNotOpen
+	}
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var p string
+		err := rows.Scan(&p)
+		if err != nil {
+			return nil, err
+		}
+		if p != "ok" {
+			problems = append(problems, p)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// Vacuum rebuilds the database, reclaiming unused space.
+func Vacuum() error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	_, err := db.Exec("VACUUM")
+	return err
+}
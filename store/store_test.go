@@ -0,0 +1,225 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTest(t *testing.T) {
+	err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { Close() })
+}
+
+func TestMigrate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	for i := 0; i < 2; i++ {
+		err := Open(filename)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		v, err := Version()
+		if err != nil || v != len(migrations) {
+			t.Errorf("Version: got %v %v", v, err)
+		}
+		problems, err := Check()
+		if err != nil || len(problems) > 0 {
+			t.Errorf("Check: got %v %v", problems, err)
+		}
+		Close()
+	}
+	if Enabled() {
+		t.Errorf("Enabled after Close")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	openTest(t)
+
+	_, err := GetToken("a")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+
+	none := func(old []byte) error {
+		if old != nil {
+			return errors.New("exists")
+		}
+		return nil
+	}
+	past := time.Now().Add(-time.Hour)
+	err = UpdateToken("a", "g1", &past, []byte(`"a"`), none)
+	if err != nil {
+		t.Fatalf("UpdateToken: %v", err)
+	}
+	err = UpdateToken("b", "g2", nil, []byte(`"b"`), none)
+	if err != nil {
+		t.Fatalf("UpdateToken: %v", err)
+	}
+	err = UpdateToken("a", "g1", nil, []byte(`"a"`), none)
+	if err == nil {
+		t.Errorf("Check was ignored")
+	}
+
+	data, err := GetToken("a")
+	if err != nil || string(data) != `"a"` {
+		t.Errorf("GetToken: got %v %v", string(data), err)
+	}
+	l, err := ListTokens("g2")
+	if err != nil || len(l) != 1 || string(l[0]) != `"b"` {
+		t.Errorf("ListTokens: got %v %v", l, err)
+	}
+
+	err = ExpireTokens(time.Now())
+	if err != nil {
+		t.Errorf("ExpireTokens: %v", err)
+	}
+	l, err = ListTokens("")
+	if err != nil || len(l) != 1 {
+		t.Errorf("ListTokens after expiry: got %v %v", l, err)
+	}
+
+	err = DeleteToken("b", func([]byte) error { return nil })
+	if err != nil {
+		t.Errorf("DeleteToken: %v", err)
+	}
+	_, err = GetToken("b")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestChat(t *testing.T) {
+	openTest(t)
+
+	for _, v := range []string{"1", "2", "3"} {
+		err := AddChat("g", time.Now(), []byte(v), 2)
+		if err != nil {
+			t.Fatalf("AddChat: %v", err)
+		}
+	}
+	AddChat("other", time.Now(), []byte("x"), 2)
+
+	h, err := ChatHistory("g")
+	if err != nil || len(h) != 2 ||
+		string(h[0]) != "2" || string(h[1]) != "3" {
+		t.Errorf("ChatHistory: got %q %v", h, err)
+	}
+
+	err = ClearChat("g")
+	if err != nil {
+		t.Errorf("ClearChat: %v", err)
+	}
+	h, _ = ChatHistory("g")
+	if len(h) != 0 {
+		t.Errorf("History not cleared: %q", h)
+	}
+	h, _ = ChatHistory("other")
+	if len(h) != 1 {
+		t.Errorf("Cleared wrong group: %q", h)
+	}
+
+	now := time.Now()
+	err = WriteChat([]ChatOp{
+		{Group: "g", Time: now, Data: []byte("4")},
+		{Group: "other"},
+		{Group: "g", Time: now, Data: []byte("5")},
+		{Group: "g", Time: now, Data: []byte("6")},
+		{Group: "other", Time: now, Data: []byte("y")},
+	}, 2)
+	if err != nil {
+		t.Errorf("WriteChat: %v", err)
+	}
+	h, _ = ChatHistory("g")
+	if len(h) != 2 || string(h[0]) != "5" || string(h[1]) != "6" {
+		t.Errorf("WriteChat: got %q", h)
+	}
+	h, _ = ChatHistory("other")
+	if len(h) != 1 || string(h[0]) != "y" {
+		t.Errorf("WriteChat other: got %q", h)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	openTest(t)
+
+	now := time.Now()
+	LogUsage("g", now.Add(-time.Hour), "old", nil)
+	LogUsage("g", now, "talktime", []byte(`{"a":1}`))
+	LogUsage("h", now, "poll", nil)
+
+	e, err := Usage("g", now.Add(-time.Minute))
+	if err != nil || len(e) != 1 || e[0].Kind != "talktime" ||
+		string(e[0].Value) != `{"a":1}` {
+		t.Errorf("Usage: got %v %v", e, err)
+	}
+	e, err = Usage("", time.Time{})
+	if err != nil || len(e) != 3 || e[2].Value != nil {
+		t.Errorf("Usage: got %v %v", e, err)
+	}
+}
+
+func TestRecordings(t *testing.T) {
+	openTest(t)
+
+	id, err := AddRecording("g", "g/a.webm", "john", "camera", time.Now())
+	if err != nil {
+		t.Fatalf("AddRecording: %v", err)
+	}
+	AddRecording("h", "h/b.webm", "paul", "camera", time.Now())
+
+	r, err := Recordings("g")
+	if err != nil || len(r) != 1 || !r[0].Ended.IsZero() {
+		t.Fatalf("Recordings: got %v %v", r, err)
+	}
+
+	err = FinishRecording(id, time.Now(), 42)
+	if err != nil {
+		t.Errorf("FinishRecording: %v", err)
+	}
+	r, _ = Recordings("g")
+	if len(r) != 1 || r[0].Ended.IsZero() || r[0].Size != 42 ||
+		r[0].Username != "john" {
+		t.Errorf("Recordings: got %v", r)
+	}
+
+	err = DeleteRecording("g/a.webm")
+	if err != nil {
+		t.Errorf("DeleteRecording: %v", err)
+	}
+	r, _ = Recordings("")
+	if len(r) != 1 || r[0].Group != "h" {
+		t.Errorf("Recordings after delete: got %v", r)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	openTest(t)
+
+	err := SetBandwidth("bob", "2024-05-01", 10, "2024-05", 100)
+	if err != nil {
+		t.Fatalf("SetBandwidth: %v", err)
+	}
+	err = SetBandwidth("bob", "2024-05-02", 20, "2024-05", 120)
+	if err != nil {
+		t.Fatalf("SetBandwidth: %v", err)
+	}
+
+	b, err := Bandwidth("2024-05-02", "2024-05")
+	if err != nil {
+		t.Fatalf("Bandwidth: %v", err)
+	}
+	if len(b) != 1 || b["bob"] != [2]uint64{20, 120} {
+		t.Errorf("Bandwidth: got %v", b)
+	}
+
+	b, err = Bandwidth("2024-06-01", "2024-06")
+	if err != nil || len(b) != 0 {
+		t.Errorf("Bandwidth: got %v %v", b, err)
+	}
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+)
+
+// GetToken returns the JSON representation of a stateful token.  It
+// returns os.ErrNotExist if the token doesn't exist.
+func GetToken(token string) ([]byte, error) {
+	db := getDB()
+	if db == nil {
+		return nil, ErrNotOpen
+	}
+	var data []byte
+	err := db.QueryRow(
+		"SELECT data FROM tokens WHERE token = ?", token,
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	return data, err
+}
+
+// ListTokens returns the JSON representations of the stateful tokens of
+// a group, or of all groups if group is empty.
+func ListTokens(group string) ([][]byte, error) {
+	db := getDB()
+	if db == nil {
+		return nil, ErrNotOpen
+	}
+	var rows *sql.Rows
+	var err error
+	if group == "" {
+		rows, err = db.Query("SELECT data FROM tokens ORDER BY token")
+	} else {
+		rows, err = db.Query(
+			"SELECT data FROM tokens WHERE grp = ? ORDER BY token",
+			group,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens [][]byte
+	for rows.Next() {
+		var data []byte
+		err := rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, data)
+	}
+	return tokens, rows.Err()
+}
+
+// UpdateToken adds or replaces a stateful token.  Check is called
+// within the same transaction with the previous representation of the
+// token, or nil if it doesn't exist; if it returns an error, the token
+// is not modified.
+func UpdateToken(token, group string, expires *time.Time, data []byte, check func([]byte) error) error {
+	return updateToken(token, func(tx *sql.Tx) error {
+		var exp *int64
+		if expires != nil {
+			e := expires.Unix()
+			exp = &e
+		}
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO tokens (token, grp, expires, data) "+
+				"VALUES (?, ?, ?, ?)",
+			token, group, exp, data,
+		)
+		return err
+	}, check)
+}
+
+// DeleteToken deletes a stateful token.  Check is as in UpdateToken.
+func DeleteToken(token string, check func([]byte) error) error {
+	return updateToken(token, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM tokens WHERE token = ?", token)
+		return err
+	}, check)
+}
+
+func updateToken(token string, f func(tx *sql.Tx) error, check func([]byte) error) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old []byte
+	err = tx.QueryRow(
+		"SELECT data FROM tokens WHERE token = ?", token,
+	).Scan(&old)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	err = check(old)
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExpireTokens deletes the tokens that expired before cutoff.
+func ExpireTokens(cutoff time.Time) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	_, err := db.Exec(
+		"DELETE FROM tokens WHERE expires < ?", cutoff.Unix(),
+	)
+	return err
+}
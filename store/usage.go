@@ -0,0 +1,67 @@
+package store
+
+import (
+	"time"
+)
+
+// LogUsage records an entry in a group's usage log.  Value is the JSON
+// representation of the entry's value, or nil.
+func LogUsage(group string, tm time.Time, kind string, value []byte) error {
+	db := getDB()
+	if db == nil {
+		return ErrNotOpen
+	}
+	var v *string
+	if value != nil {
+		s := string(value)
+		v = &s
+	}
+	_, err := db.Exec(
+		"INSERT INTO usage (grp, time, kind, value) VALUES (?, ?, ?, ?)",
+		group, tm.UnixMilli(), kind, v,
+	)
+	return err
+}
+
+// A UsageEntry is an entry in a group's usage log.
+type UsageEntry struct {
+	Group string
+	Time  time.Time
+	Kind  string
+	Value []byte
+}
+
+// Usage returns the usage log of a group, or of all groups if group is
+// empty, starting at the given time.
+func Usage(group string, since time.Time) ([]UsageEntry, error) {
+	db := getDB()
+	if db == nil {
+		return nil, ErrNotOpen
+	}
+	rows, err := db.Query(
+		"SELECT grp, time, kind, value FROM usage "+
+			"WHERE (? = '' OR grp = ?) AND time >= ? ORDER BY id",
+		group, group, since.UnixMilli(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []UsageEntry
+	for rows.Next() {
+		var e UsageEntry
+		var tm int64
+		var value *string
+		err := rows.Scan(&e.Group, &tm, &e.Kind, &value)
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(tm)
+		if value != nil {
+			e.Value = []byte(*value)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -10,9 +10,12 @@ import (
 	"sort"
 	"sync"
 	"time"
+
+	"github.com/jech/galene/store"
 )
 
 var ErrTagMismatch = errors.New("tag mismatch")
+var ErrDisabled = errors.New("token has been disabled")
 
 // A stateful token
 type Stateful struct {
@@ -24,6 +27,8 @@ type Stateful struct {
 	NotBefore   *time.Time `json:"not-before,omitempty"`
 	IssuedAt    *time.Time `json:"issuedAt,omitempty"`
 	IssuedBy    *string    `json:"issuedBy,omitempty"`
+	Guest       bool       `json:"guest,omitempty"`
+	Disabled    bool       `json:"disabled,omitempty"`
 }
 
 func (token *Stateful) Clone() *Stateful {
@@ -36,6 +41,8 @@ func (token *Stateful) Clone() *Stateful {
 		NotBefore:   token.NotBefore,
 		IssuedAt:    token.IssuedAt,
 		IssuedBy:    token.IssuedBy,
+		Guest:       token.Guest,
+		Disabled:    token.Disabled,
 	}
 }
 
@@ -61,6 +68,9 @@ func SetStatefulFilename(filename string) {
 }
 
 func (state *state) Get(token string) (*Stateful, string, error) {
+	if store.Enabled() {
+		return getStored(token)
+	}
 	state.mu.Lock()
 	defer state.mu.Unlock()
 	etag, err := state.load()
@@ -94,6 +104,20 @@ func (token *Stateful) Check(host, group string, username *string) (string, []st
 	if token.NotBefore != nil && now.Before(*token.NotBefore) {
 		return "", nil, errors.New("token is in the future")
 	}
+	if token.Disabled {
+		return "", nil, ErrDisabled
+	}
+
+	// guests are named by the server, and may at most present
+	if token.Guest {
+		perms := make([]string, 0, len(token.Permissions)+1)
+		for _, p := range token.Permissions {
+			if p == "present" || p == "message" {
+				perms = append(perms, p)
+			}
+		}
+		return "", append(perms, "guest"), nil
+	}
 
 	// the username from the token overrides the one from the client.
 	user := ""
@@ -201,6 +225,9 @@ func (state *state) etag() string {
 // If etag is the empty string, it is added if it didn't exist.  If etag
 // is not empty, it is added if it matches the state's etag.
 func (state *state) Update(token *Stateful, etag string) (*Stateful, error) {
+	if store.Enabled() {
+		return updateStored(token, etag)
+	}
 	tokens.mu.Lock()
 	defer tokens.mu.Unlock()
 
@@ -245,6 +272,9 @@ func Delete(token string, etag string) error {
 }
 
 func (state *state) Delete(token string, etag string) error {
+	if store.Enabled() {
+		return deleteStored(token, etag)
+	}
 	tokens.mu.Lock()
 	defer tokens.mu.Unlock()
 
@@ -389,6 +419,12 @@ func (state *state) list(group string) ([]*Stateful, string, error) {
 		}
 		a = append(a, t)
 	}
+	sortTokens(a)
+	return a, state.etag(), nil
+}
+
+// sortTokens sorts tokens by expiry date.
+func sortTokens(a []*Stateful) {
 	sort.Slice(a, func(i, j int) bool {
 		if a[j].Expires == nil {
 			return false
@@ -398,10 +434,12 @@ func (state *state) list(group string) ([]*Stateful, string, error) {
 		}
 		return (*a[i].Expires).Before(*a[j].Expires)
 	})
-	return a, state.etag(), nil
 }
 
 func (state *state) List(group string) ([]*Stateful, string, error) {
+	if store.Enabled() {
+		return listStored(group)
+	}
 	state.mu.Lock()
 	defer state.mu.Unlock()
 	return state.list(group)
@@ -412,6 +450,13 @@ func List(group string) ([]*Stateful, string, error) {
 }
 
 func (state *state) Expire() error {
+	now := time.Now()
+	cutoff := now.Add(-time.Hour * 24 * 7)
+
+	if store.Enabled() {
+		return expireStored(cutoff)
+	}
+
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
@@ -420,9 +465,6 @@ func (state *state) Expire() error {
 		return err
 	}
 
-	now := time.Now()
-	cutoff := now.Add(-time.Hour * 24 * 7)
-
 	modified := false
 	for k, t := range state.tokens {
 		if t.Expires != nil && t.Expires.Before(cutoff) {
@@ -443,3 +485,30 @@ func (state *state) Expire() error {
 func Expire() error {
 	return tokens.Expire()
 }
+
+// SetGuestsDisabled disables or enables all of the guest tokens of a
+// group.
+func SetGuestsDisabled(group string, disabled bool) error {
+	ts, _, err := List(group)
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if !t.Guest || t.Disabled == disabled {
+			continue
+		}
+		old, etag, err := Get(t.Token)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		tt := old.Clone()
+		tt.Disabled = disabled
+		_, err = Update(tt, etag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	"sort"
 	"testing"
 	"time"
+
+	"github.com/jech/galene/store"
 )
 
 func timeEqual(a, b *time.Time) bool {
@@ -72,6 +74,13 @@ func TestStatefulCheck(t *testing.T) {
 		Expires:     &future,
 		NotBefore:   &nearFuture,
 	}
+	token5 := &Stateful{
+		Token:       "token",
+		Group:       "group",
+		Permissions: []string{"op", "present", "message"},
+		Expires:     &future,
+		Guest:       true,
+	}
 
 	success := []struct {
 		token          *Stateful
@@ -107,6 +116,12 @@ func TestStatefulCheck(t *testing.T) {
 			expUsername:    "",
 			expPermissions: []string{"present", "message"},
 		},
+		{
+			token:          token5,
+			group:          "group",
+			expUsername:    "",
+			expPermissions: []string{"present", "message", "guest"},
+		},
 	}
 
 	for i, s := range success {
@@ -378,3 +393,143 @@ func TestExpire(t *testing.T) {
 	expectTokens(t, s.tokens, tokens[:len(tokens)-1])
 	expectTokenFile(t, s.filename, tokens[:len(tokens)-1])
 }
+
+func TestTokenDatabase(t *testing.T) {
+	err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	var s state
+	future := time.Now().Add(time.Hour)
+	user := "user"
+	token := &Stateful{
+		Token:       "tok",
+		Group:       "test",
+		Username:    &user,
+		Permissions: []string{"present", "message"},
+		Expires:     &future,
+	}
+
+	_, err = s.Update(token, "\"bad\"")
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Update: got %v, expected ErrTagMismatch", err)
+	}
+	_, err = s.Update(token, "")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	t1, etag, err := s.Get("tok")
+	if err != nil || !equal(t1, token) {
+		t.Fatalf("Get: got %v %v", t1, err)
+	}
+	t2 := t1.Clone()
+	t2.Permissions = []string{"message"}
+	_, err = s.Update(t2, "")
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Update: got %v, expected ErrTagMismatch", err)
+	}
+	_, err = s.Update(t2, etag)
+	if err != nil {
+		t.Errorf("Update: %v", err)
+	}
+
+	l, _, err := s.List("test")
+	if err != nil || len(l) != 1 || !equal(l[0], t2) {
+		t.Errorf("List: got %v %v", l, err)
+	}
+
+	err = s.Delete("tok", etag)
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Delete: got %v, expected ErrTagMismatch", err)
+	}
+	_, etag, _ = s.Get("tok")
+	err = s.Delete("tok", etag)
+	if err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	_, _, err = s.Get("tok")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get: got %v, expected ErrNotExist", err)
+	}
+	err = s.Delete("tok", etag)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Delete: got %v, expected ErrNotExist", err)
+	}
+}
+
+func TestSetGuestsDisabled(t *testing.T) {
+	SetStatefulFilename(filepath.Join(t.TempDir(), "test.jsonl"))
+	defer SetStatefulFilename("")
+
+	future := time.Now().Add(time.Hour)
+	user := "user"
+	for _, tok := range []*Stateful{
+		{
+			Token:       "guest",
+			Group:       "test",
+			Permissions: []string{"message"},
+			Expires:     &future,
+			Guest:       true,
+		},
+		{
+			Token:       "other",
+			Group:       "other",
+			Permissions: []string{"message"},
+			Expires:     &future,
+			Guest:       true,
+		},
+		{
+			Token:       "user",
+			Group:       "test",
+			Username:    &user,
+			Permissions: []string{"present", "message"},
+			Expires:     &future,
+		},
+	} {
+		_, err := Update(tok, "")
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	check := func(tok, group string) error {
+		t.Helper()
+		s, _, err := Get(tok)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		_, _, err = s.Check("", group, nil)
+		return err
+	}
+
+	err := SetGuestsDisabled("test", true)
+	if err != nil {
+		t.Fatalf("SetGuestsDisabled: %v", err)
+	}
+	if err := check("guest", "test"); err != ErrDisabled {
+		t.Errorf("Disabled guest: got %v", err)
+	}
+	if err := check("other", "other"); err != nil {
+		t.Errorf("Guest of other group: got %v", err)
+	}
+	if err := check("user", "test"); err != nil {
+		t.Errorf("User: got %v", err)
+	}
+
+	// the state is persistent
+	SetStatefulFilename(tokens.filename)
+	if err := check("guest", "test"); err != ErrDisabled {
+		t.Errorf("Reloaded guest: got %v", err)
+	}
+
+	err = SetGuestsDisabled("test", false)
+	if err != nil {
+		t.Fatalf("SetGuestsDisabled: %v", err)
+	}
+	if err := check("guest", "test"); err != nil {
+		t.Errorf("Enabled guest: got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package token
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jech/galene/store"
+)
+
+// When a database is open, stateful tokens are kept in the database
+// rather than in the JSONL file.  The etag of a token is then derived
+// from its contents.
+
+func storedEtag(data []byte) string {
+	h := sha256.Sum256(data)
+	return fmt.Sprintf("\"%x\"", h[:12])
+}
+
+func getStored(token string) (*Stateful, string, error) {
+	data, err := store.GetToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+	var t Stateful
+	err = json.Unmarshal(data, &t)
+	if err != nil {
+		return nil, "", err
+	}
+	return &t, storedEtag(data), nil
+}
+
+// checkEtag returns a function suitable for store.UpdateToken.
+func checkEtag(etag string, mustExist bool) func([]byte) error {
+	return func(old []byte) error {
+		if old == nil {
+			if mustExist {
+				return os.ErrNotExist
+			}
+			if etag != "" {
+				return ErrTagMismatch
+			}
+			return nil
+		}
+		if etag != storedEtag(old) {
+			return ErrTagMismatch
+		}
+		return nil
+	}
+}
+
+func updateStored(token *Stateful, etag string) (*Stateful, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	err = store.UpdateToken(token.Token, token.Group, token.Expires,
+		data, checkEtag(etag, false))
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func deleteStored(token string, etag string) error {
+	return store.DeleteToken(token, checkEtag(etag, true))
+}
+
+func listStored(group string) ([]*Stateful, string, error) {
+	data, err := store.ListTokens(group)
+	if err != nil {
+		return nil, "", err
+	}
+	a := make([]*Stateful, 0, len(data))
+	for _, d := range data {
+		var t Stateful
+		err := json.Unmarshal(d, &t)
+		if err != nil {
+			return nil, "", err
+		}
+		a = append(a, &t)
+	}
+	sortTokens(a)
+	return a, storedEtag(bytes.Join(data, []byte{'\n'})), nil
+}
+
+func expireStored(cutoff time.Time) error {
+	return store.ExpireTokens(cutoff)
+}
+
+// ImportFile copies the tokens from the JSONL file to the database,
+// skipping the tokens that already exist in the database.  It returns
+// the number of tokens copied.
+func ImportFile() (int, error) {
+	tokens.mu.Lock()
+	defer tokens.mu.Unlock()
+
+	_, err := tokens.load()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, t := range tokens.tokens {
+		_, err := updateStored(t, "")
+		if errors.Is(err, ErrTagMismatch) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,411 @@
+// Package tracing implements a minimal OpenTelemetry tracer.  Spans are
+// exported in batches to a collector using OTLP over HTTP with the JSON
+// encoding, which avoids depending on the OpenTelemetry SDK.
+package tracing
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/logging"
+)
+
+var logger = logging.New("tracing")
+
+// Endpoint is the base URL of the OTLP/HTTP collector, for example
+// "http://localhost:4318".  If it is empty, tracing is disabled.
+var Endpoint string
+
+// ServiceName is the value of the service.name resource attribute.
+var ServiceName = "galene"
+
+const (
+	maxQueued = 2048
+	batchSize = 256
+	interval  = 5 * time.Second
+)
+
+// A Span represents a single operation.  All methods are safe to call on
+// a nil span, which is what Start returns when tracing is disabled, and
+// may be called concurrently.
+type Span struct {
+	traceId [16]byte
+	spanId  [8]byte
+	parent  [8]byte
+	name    string
+	start   time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []attribute
+	events []event
+	err    string
+	ended  bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type event struct {
+	time  time.Time
+	name  string
+	attrs []attribute
+}
+
+func attributes(kv []interface{}) []attribute {
+	attrs := make([]attribute, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		attrs = append(attrs, attribute{key, kv[i+1]})
+	}
+	return attrs
+}
+
+// Start starts a new span.  If parent is nil, the span is the root of a
+// new trace.  The attributes are given as alternating keys and values.
+func Start(parent *Span, name string, kv ...interface{}) *Span {
+	if Endpoint == "" {
+		return nil
+	}
+	s := &Span{
+		name:  name,
+		start: time.Now(),
+		attrs: attributes(kv),
+	}
+	if parent != nil {
+		s.traceId = parent.traceId
+		s.parent = parent.spanId
+	} else {
+		crand.Read(s.traceId[:])
+	}
+	crand.Read(s.spanId[:])
+	return s
+}
+
+// Name returns the name of a span.
+func (s *Span) Name() string {
+	if s == nil {
+		return ""
+	}
+	return s.name
+}
+
+// SetAttributes adds attributes to a span.
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attributes(kv)...)
+}
+
+// AddEvent records an event that happened during the span.
+func (s *Span) AddEvent(name string, kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.events = append(s.events, event{time.Now(), name, attributes(kv)})
+}
+
+// SetError marks a span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End terminates a span and queues it for export.  Only the first call
+// has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	exporter.start()
+	select {
+	case exporter.queue <- s:
+	default:
+		// the collector is not keeping up
+	}
+}
+
+type spanExporter struct {
+	once  sync.Once
+	queue chan *Span
+	flush chan chan struct{}
+}
+
+var exporter spanExporter
+
+func (e *spanExporter) start() {
+	e.once.Do(func() {
+		e.queue = make(chan *Span, maxQueued)
+		e.flush = make(chan chan struct{})
+		go e.run()
+	})
+}
+
+// Shutdown exports all queued spans.
+func Shutdown() {
+	if Endpoint == "" {
+		return
+	}
+	exporter.start()
+	done := make(chan struct{})
+	select {
+	case exporter.flush <- done:
+	case <-time.After(10 * time.Second):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+	}
+}
+
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := export(Endpoint, batch)
+		if err != nil {
+			logger.Warn("Export",
+				"spans", len(batch), "err", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flush:
+		loop:
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+				default:
+					break loop
+				}
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+// The JSON encoding of OTLP, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(attrs []attribute) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch value := a.value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int:
+			i := strconv.FormatInt(int64(value), 10)
+			v.IntValue = &i
+		case int64:
+			i := strconv.FormatInt(value, 10)
+			v.IntValue = &i
+		case uint32:
+			i := strconv.FormatUint(uint64(value), 10)
+			v.IntValue = &i
+		case uint64:
+			i := strconv.FormatUint(value, 10)
+			v.IntValue = &i
+		case float64:
+			v.DoubleValue = &value
+		case time.Duration:
+			f := value.Seconds()
+			v.DoubleValue = &f
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		result = append(result, otlpAttribute{a.key, v})
+	}
+	return result
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceId:           hex.EncodeToString(s.traceId[:]),
+		SpanId:            hex.EncodeToString(s.spanId[:]),
+		Name:              s.name,
+		Kind:              2, // SPAN_KIND_SERVER
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(s.end),
+		Attributes:        otlpAttributes(s.attrs),
+	}
+	if s.parent != ([8]byte{}) {
+		span.ParentSpanId = hex.EncodeToString(s.parent[:])
+		span.Kind = 1 // SPAN_KIND_INTERNAL
+	}
+	for _, e := range s.events {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: unixNano(e.time),
+			Name:         e.name,
+			Attributes:   otlpAttributes(e.attrs),
+		})
+	}
+	if s.err != "" {
+		span.Status = otlpStatus{Code: 2, Message: s.err}
+	}
+	return span
+}
+
+func tracesURL(endpoint string) string {
+	if strings.HasSuffix(endpoint, "/v1/traces") {
+		return endpoint
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+}
+
+var client = http.Client{Timeout: 30 * time.Second}
+
+func export(endpoint string, spans []*Span) error {
+	ss := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		ss = append(ss, s.otlp())
+	}
+	request := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes([]attribute{
+					{"service.name", ServiceName},
+				}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/jech/galene"},
+				Spans: ss,
+			}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(
+		tracesURL(endpoint), "application/json", bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisabled(t *testing.T) {
+	Endpoint = ""
+	s := Start(nil, "test")
+	if s != nil {
+		t.Errorf("Expected nil span")
+	}
+	// methods must not crash on a nil span
+	s.SetAttributes("a", 1)
+	s.AddEvent("event")
+	s.SetError(errors.New("error"))
+	s.End()
+	Shutdown()
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := [][2]string{
+		{"http://localhost:4318", "http://localhost:4318/v1/traces"},
+		{"http://localhost:4318/", "http://localhost:4318/v1/traces"},
+		{"http://localhost:4318/v1/traces",
+			"http://localhost:4318/v1/traces"},
+	}
+	for _, test := range tests {
+		u := tracesURL(test[0])
+		if u != test[1] {
+			t.Errorf("%v: got %v, expected %v", test[0], u, test[1])
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	received := make(chan otlpRequest, 4)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/traces" {
+				t.Errorf("Path %v", r.URL.Path)
+			}
+			var req otlpRequest
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				t.Errorf("Decode: %v", err)
+			}
+			received <- req
+		},
+	))
+	defer server.Close()
+
+	Endpoint = server.URL
+	defer func() {
+		Endpoint = ""
+	}()
+
+	root := Start(nil, "root", "group", "test")
+	child := Start(root, "child")
+	child.AddEvent("event", "count", 42)
+	child.SetError(errors.New("failed"))
+	child.End()
+	root.End()
+	Shutdown()
+
+	var req otlpRequest
+	select {
+	case req = <-received:
+	default:
+		t.Fatalf("Nothing exported")
+	}
+
+	if len(req.ResourceSpans) != 1 ||
+		len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected request %#v", req)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %v", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if c.Name != "child" || r.Name != "root" {
+		t.Errorf("Got %v %v", c.Name, r.Name)
+	}
+	if c.TraceId != r.TraceId || len(r.TraceId) != 32 {
+		t.Errorf("Bad trace ids %v %v", c.TraceId, r.TraceId)
+	}
+	if c.ParentSpanId != r.SpanId || r.ParentSpanId != "" {
+		t.Errorf("Bad parent %v %v", c.ParentSpanId, r.SpanId)
+	}
+	if c.Status.Code != 2 || c.Status.Message != "failed" ||
+		r.Status.Code != 0 {
+		t.Errorf("Bad status %v %v", c.Status, r.Status)
+	}
+	if len(c.Events) != 1 || c.Events[0].Name != "event" ||
+		len(c.Events[0].Attributes) != 1 ||
+		*c.Events[0].Attributes[0].Value.IntValue != "42" {
+		t.Errorf("Bad events %#v", c.Events)
+	}
+	if len(r.Attributes) != 1 || r.Attributes[0].Key != "group" ||
+		*r.Attributes[0].Value.StringValue != "test" {
+		t.Errorf("Bad attributes %#v", r.Attributes)
+	}
+}
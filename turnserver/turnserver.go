@@ -4,15 +4,21 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
-	"log"
+	"fmt"
 	"net"
 	"strconv"
 	"sync"
 
+	"github.com/pion/transport/v2"
 	"github.com/pion/turn/v2"
 	"github.com/pion/webrtc/v3"
+
+	"github.com/jech/galene/logging"
+	"github.com/jech/galene/sockbuf"
 )
 
+var logger = logging.New("turn")
+
 var username string
 var password string
 var Address string
@@ -57,26 +63,45 @@ func listener(a net.IP, port int, relay net.IP) (*turn.PacketConnConfig, *turn.L
 	var lc *turn.ListenerConfig
 	s := net.JoinHostPort(a.String(), strconv.Itoa(port))
 
+	// relayed media goes through the relay sockets
+	var n transport.Net
+	if sockbuf.Enabled() {
+		sn, err := sockbuf.NewNet()
+		if err != nil {
+			logger.Warn("Create network", "err", err)
+		} else {
+			n = sn
+		}
+	}
+
 	var g turn.RelayAddressGenerator
 	if relay == nil || relay.IsUnspecified() {
 		g = &turn.RelayAddressGeneratorNone{
 			Address: a.String(),
+			Net:     n,
 		}
 	} else {
 		g = &turn.RelayAddressGeneratorStatic{
 			RelayAddress: relay,
 			Address:      a.String(),
+			Net:          n,
 		}
 	}
 
 	p, err := net.ListenPacket("udp4", s)
 	if err == nil {
+		if c, ok := p.(*net.UDPConn); ok {
+			err := sockbuf.Set(c)
+			if err != nil {
+				logger.Warn("Set UDP buffers", "err", err)
+			}
+		}
 		pcc = &turn.PacketConnConfig{
 			PacketConn:            p,
 			RelayAddressGenerator: g,
 		}
 	} else {
-		log.Printf("TURN: listenPacket(%v): %v", s, err)
+		logger.Warn("ListenPacket", "addr", s, "err", err)
 	}
 
 	l, err := net.Listen("tcp4", s)
@@ -86,7 +111,7 @@ func listener(a net.IP, port int, relay net.IP) (*turn.PacketConnConfig, *turn.L
 			RelayAddressGenerator: g,
 		}
 	} else {
-		log.Printf("TURN: listen(%v): %v", s, err)
+		logger.Warn("Listen", "addr", s, "err", err)
 	}
 
 	return pcc, lc
@@ -184,7 +209,7 @@ func Start() error {
 		return errors.New("couldn't establish any listeners")
 	}
 
-	log.Printf("Starting built-in TURN server on %v", addr.String())
+	logger.Info("Starting built-in TURN server", "addr", addr.String())
 
 	server.server, err = turn.NewServer(turn.ServerConfig{
 		Realm: "galene.org",
@@ -222,7 +247,8 @@ func ICEServers() []webrtc.ICEServer {
 		case *net.TCPAddr:
 			urls = append(urls, "turn:"+a.String()+"?transport=tcp")
 		default:
-			log.Printf("unexpected TURN address %T", a)
+			logger.Warn("Unexpected TURN address",
+				"type", fmt.Sprintf("%T", a))
 		}
 	}
 
@@ -244,7 +270,7 @@ func Stop() error {
 	if server.server == nil {
 		return nil
 	}
-	log.Printf("Stopping built-in TURN server")
+	logger.Info("Stopping built-in TURN server")
 	err := server.server.Close()
 	server.server = nil
 	return err
@@ -0,0 +1,121 @@
+package webserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEDomains is the list of host names for which a certificate is
+// requested using ACME.  If it is empty, the certificate is read from
+// the data directory.
+var ACMEDomains []string
+
+// ACMEEmail is the contact address sent to the certificate authority.
+var ACMEEmail string
+
+// ACMEDirectory is the directory URL of the certificate authority.  If
+// it is empty, Let's Encrypt is used.
+var ACMEDirectory string
+
+// ACMEHTTPAddress is the address of the HTTP server used for HTTP-01
+// challenges.  If it is empty, only TLS-ALPN-01 challenges are
+// possible, which requires the main server to listen on port 443.
+var ACMEHTTPAddress string
+
+var acmeServer *http.Server
+
+// acmeConfig returns a TLS configuration that obtains and renews
+// certificates automatically, and starts the HTTP-01 server if
+// required.
+func acmeConfig(dataDir string) (*tls.Config, error) {
+	if len(ACMEDomains) == 0 {
+		return nil, errors.New("no ACME domains")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(dataDir, "acme")),
+		HostPolicy: autocert.HostWhitelist(ACMEDomains...),
+		Email:      ACMEEmail,
+	}
+	if ACMEDirectory != "" {
+		m.Client = &acme.Client{DirectoryURL: ACMEDirectory}
+	}
+
+	if ACMEHTTPAddress != "" {
+		s := &http.Server{
+			Addr:              ACMEHTTPAddress,
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: 60 * time.Second,
+		}
+		go func() {
+			err := s.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logger.Warn("ACME HTTP server", "err", err)
+			}
+		}()
+		acmeServer = s
+	}
+
+	getCertificate = func() (*tls.Certificate, error) {
+		// don't call m.GetCertificate, which would request a new
+		// certificate if none is available.
+		return cachedCertificate(m.Cache, ACMEDomains[0])
+	}
+
+	// this includes the ALPN protocol used by TLS-ALPN-01
+	return m.TLSConfig(), nil
+}
+
+// cachedCertificate returns the certificate for domain stored in the
+// autocert cache, without contacting the certificate authority.
+func cachedCertificate(cache autocert.Cache, domain string) (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// autocert stores ECDSA certificates under the domain name, and
+	// RSA certificates under the domain name suffixed with "+rsa".
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	data, err := cache.Get(ctx, domain)
+	if err == autocert.ErrCacheMiss {
+		data, err = cache.Get(ctx, domain+"+rsa")
+	}
+	if err == autocert.ErrCacheMiss {
+		return nil, errors.New("no certificate yet")
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the private key is followed by the certificate chain
+	var c tls.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			c.Certificate = append(c.Certificate, block.Bytes)
+		}
+	}
+	if len(c.Certificate) == 0 {
+		return nil, errors.New("empty certificate")
+	}
+	return &c, nil
+}
+
+func shutdownACME(ctx context.Context) {
+	if acmeServer != nil {
+		acmeServer.Shutdown(ctx)
+		acmeServer = nil
+	}
+}
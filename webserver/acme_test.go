@@ -0,0 +1,100 @@
+package webserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestACMEConfig(t *testing.T) {
+	defer func(domains []string) {
+		ACMEDomains = domains
+		getCertificate = nil
+	}(ACMEDomains)
+
+	ACMEDomains = nil
+	_, err := acmeConfig(t.TempDir())
+	if err == nil {
+		t.Errorf("acmeConfig succeeded with no domains")
+	}
+
+	ACMEDomains = []string{"galene.example.org"}
+	config, err := acmeConfig(t.TempDir())
+	if err != nil {
+		t.Fatalf("acmeConfig: %v", err)
+	}
+	found := false
+	for _, p := range config.NextProtos {
+		if p == acme.ALPNProto {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ALPN protocol not found: %v", config.NextProtos)
+	}
+	if getCertificate == nil {
+		t.Fatalf("getCertificate is nil")
+	}
+	_, err = getCertificate()
+	if err == nil {
+		t.Errorf("getCertificate succeeded with an empty cache")
+	}
+}
+
+func TestCachedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cache := autocert.DirCache(dir)
+
+	_, err := cachedCertificate(cache, "galene.example.org")
+	if err == nil {
+		t.Errorf("cachedCertificate succeeded with an empty cache")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"galene.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}, &x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: k})
+	data = append(data,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...,
+	)
+	err = cache.Put(context.Background(), "galene.example.org", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cachedCertificate(cache, "Galene.example.org.")
+	if err != nil {
+		t.Fatalf("cachedCertificate: %v", err)
+	}
+	if len(c.Certificate) != 1 {
+		t.Fatalf("Got %v certificates", len(c.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil || !leaf.NotAfter.Equal(notAfter) {
+		t.Errorf("Got %v, %v", leaf, err)
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 	"golang.org/x/crypto/pbkdf2"
 
+	"github.com/jech/galene/diskwriter"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/stats"
 	"github.com/jech/galene/token"
@@ -130,6 +131,23 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 	} else if first == "/v0" && kind == ".groups" {
 		apiGroupHandler(w, r, rest)
 		return
+	} else if first == "/v0" && kind == ".transcode" && rest == "" {
+		if !checkAdmin(w, r) {
+			return
+		}
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD", "GET")
+			return
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, diskwriter.Transcodes())
+		return
+	} else if first == "/v0" && kind == ".bandwidth" {
+		bandwidthHandler(w, r, rest)
+		return
+	} else if first == "/v0" && kind == ".diagnostics" && rest == "" {
+		diagnosticsHandler(w, r)
+		return
 	}
 
 	http.NotFound(w, r)
@@ -175,6 +193,15 @@ func apiGroupHandler(w http.ResponseWriter, r *http.Request, pth string) {
 	} else if kind == ".tokens" {
 		tokensHandler(w, r, g, rest)
 		return
+	} else if kind == ".stats" && rest == "" {
+		groupStatsHandler(w, r, g)
+		return
+	} else if kind == ".assets" {
+		groupAssetsHandler(w, r, g, rest)
+		return
+	} else if kind == ".attendance" && rest == "" {
+		attendanceHandler(w, r, g)
+		return
 	} else if kind != "" {
 		if !checkAdmin(w, r) {
 			return
@@ -451,6 +478,69 @@ type jwkset = struct {
 	Keys []map[string]any `json:"keys"`
 }
 
+func groupStatsHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD", "GET")
+		return
+	}
+	gs := stats.GetGroup(g)
+	if gs == nil {
+		notFound(w)
+		return
+	}
+	w.Header().Set("cache-control", "no-cache")
+	sendJSON(w, r, gs)
+}
+
+// bandwidthHandler exposes the bandwidth consumed by users during the
+// current day and month.
+func bandwidthHandler(w http.ResponseWriter, r *http.Request, pth string) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	w.Header().Set("cache-control", "no-cache")
+	if pth == "" || pth == "/" {
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD", "GET")
+			return
+		}
+		users, err := group.GetBandwidthUsers()
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		sendJSON(w, r, users)
+		return
+	}
+
+	username := pth[1:]
+	if username == "" || strings.Contains(username, "/") {
+		notFound(w)
+		return
+	}
+	if r.Method == "HEAD" || r.Method == "GET" {
+		u, err := group.GetBandwidthUsage(username)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		sendJSON(w, r, u)
+		return
+	} else if r.Method == "DELETE" {
+		err := group.ResetBandwidth(username)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD", "GET", "DELETE")
+}
+
 func keysHandler(w http.ResponseWriter, r *http.Request, g string) {
 	if !checkAdmin(w, r) {
 		return
@@ -529,6 +619,11 @@ func tokensHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 					http.StatusBadRequest)
 				return
 			}
+			if newtoken.Guest && newtoken.Username != nil {
+				http.Error(w, "guest token with username",
+					http.StatusBadRequest)
+				return
+			}
 			buf := make([]byte, 8)
 			rand.Read(buf)
 			newtoken.Token =
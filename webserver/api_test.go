@@ -114,6 +114,13 @@ func TestApi(t *testing.T) {
 		t.Errorf("Get groups: %v", err)
 	}
 
+	var diag diagnostics
+	err = getJSON("/galene-api/v0/.diagnostics", &diag)
+	if err != nil || diag.Config.Groups != 0 || diag.Config.Users != 1 ||
+		diag.GoVersion == "" {
+		t.Errorf("Get diagnostics: %v %v", err, diag)
+	}
+
 	resp, err := do("PUT", "/galene-api/v0/.groups/test/",
 		"application/json", "\"foo\"", "",
 		"{}")
@@ -136,6 +143,66 @@ func TestApi(t *testing.T) {
 		t.Errorf("Create group: %v %v", err, resp.StatusCode)
 	}
 
+	resp, err = do("GET", "/galene-api/v0/.groups/test/.stats",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get stats (not running): %v %v",
+			err, resp.StatusCode)
+	}
+
+	resp, err = do("PUT", "/galene-api/v0/.groups/test/.assets/logo.png",
+		"image/png", "", "", "logo")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Put asset: %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("PUT", "/galene-api/v0/.groups/test/.assets/.logo",
+		"image/png", "", "", "logo")
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Put asset (bad name): %v %v", err, resp.StatusCode)
+	}
+
+	var assets []string
+	err = getJSON("/galene-api/v0/.groups/test/.assets/", &assets)
+	if err != nil || len(assets) != 1 || assets[0] != "logo.png" {
+		t.Errorf("Get assets: %v %v", err, assets)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.assets/logo.png",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete asset: %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("GET", "/galene-api/v0/.groups/test/.assets/logo.png",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get asset (deleted): %v %v", err, resp.StatusCode)
+	}
+
+	var attendance []group.Attendance
+	err = getJSON("/galene-api/v0/.groups/test/.attendance", &attendance)
+	if err != nil || len(attendance) != 0 {
+		t.Errorf("Get attendance: %v %v", err, attendance)
+	}
+
+	resp, err = do("GET",
+		"/galene-api/v0/.groups/test/.attendance?format=ics",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusOK ||
+		parseContentType(resp.Header.Get("Content-Type")) !=
+			"text/calendar" {
+		t.Errorf("Get attendance (ics): %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("GET",
+		"/galene-api/v0/.groups/test/.attendance?format=xml",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("Get attendance (bad format): %v %v",
+			err, resp.StatusCode)
+	}
+
 	var desc *group.Description
 	err = getJSON("/galene-api/v0/.groups/test/", &desc)
 	if err != nil || len(desc.Users) != 0 {
@@ -430,6 +497,7 @@ func TestApiBadAuth(t *testing.T) {
 	}
 
 	do("GET", "/galene-api/v0/.stats")
+	do("GET", "/galene-api/v0/.diagnostics")
 	do("GET", "/galene-api/v0/.groups/")
 	do("PUT", "/galene-api/v0/.groups/test/")
 
@@ -454,6 +522,10 @@ func TestApiBadAuth(t *testing.T) {
 	do("PUT", "/galene-api/v0/.groups/test/.users/jch/.password")
 	do("POST", "/galene-api/v0/.groups/test/.users/jch/.password")
 	do("GET", "/galene-api/v0/.groups/test/.tokens/")
+	do("GET", "/galene-api/v0/.groups/test/.stats")
+	do("GET", "/galene-api/v0/.groups/test/.assets/")
+	do("PUT", "/galene-api/v0/.groups/test/.assets/logo.png")
+	do("GET", "/galene-api/v0/.groups/test/.attendance")
 	do("POST", "/galene-api/v0/.groups/test/.tokens/")
 	do("GET", "/galene-api/v0/.groups/test/.tokens/token")
 	do("PUT", "/galene-api/v0/.groups/test/.tokens/token")
@@ -0,0 +1,144 @@
+package webserver
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// assetsHandler serves the branding assets of a group.  Requests are
+// authorised either by the credentials of a member of the group, or by
+// the key included in the URLs announced in the group's status.
+func assetsHandler(w http.ResponseWriter, r *http.Request) {
+	if redirect(w, r) {
+		return
+	}
+
+	dir, asset := path.Split(strings.TrimPrefix(r.URL.Path, "/assets"))
+	name := parseGroupName("/", strings.TrimSuffix(dir, "/"))
+	if name == "" || asset == "" {
+		notFound(w)
+		return
+	}
+
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD", "GET")
+		return
+	}
+
+	g := group.Get(name)
+	if g == nil {
+		notFound(w)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" || !group.CheckAssetKey(name, asset, key) {
+		_, _, err := requestPermissions(r, g)
+		if err != nil {
+			var autherr *group.NotAuthorisedError
+			if errors.As(err, &autherr) {
+				time.Sleep(200 * time.Millisecond)
+			}
+			failAuthentication(w, "assets/"+name)
+			return
+		}
+	}
+
+	f, err := group.OpenAsset(name, asset, true)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	serveAsset(w, r, f, "private, max-age=3600")
+}
+
+func serveAsset(w http.ResponseWriter, r *http.Request, f *os.File, cc string) {
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		notFound(w)
+		return
+	}
+
+	ctype := mime.TypeByExtension(filepath.Ext(f.Name()))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("content-type", ctype)
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.Header().Set("content-security-policy", "sandbox")
+	w.Header().Set("cache-control", cc)
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// groupAssetsHandler manages the assets of a group through the
+// administrative API.
+func groupAssetsHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	if pth == "" || pth == "/" {
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD", "GET")
+			return
+		}
+		names, err := group.GetAssetNames(g)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, names)
+		return
+	}
+
+	asset := pth[1:]
+	if strings.Contains(asset, "/") {
+		notFound(w)
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		f, err := group.OpenAsset(g, asset, false)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		serveAsset(w, r, f, "no-cache")
+		return
+	} else if r.Method == "PUT" {
+		body := http.MaxBytesReader(w, r.Body, group.MaxAssetSize)
+		err := group.UpdateAsset(g, asset, body)
+		if errors.Is(err, group.ErrBadAssetName) {
+			http.Error(w, "bad asset name", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if r.Method == "DELETE" {
+		err := group.DeleteAsset(g, asset)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD", "GET", "PUT", "DELETE")
+}
@@ -0,0 +1,189 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// attendanceFormat returns the format requested by the client, one of
+// "json", "csv" or "ics".
+func attendanceFormat(r *http.Request) string {
+	f := r.URL.Query().Get("format")
+	if f != "" {
+		return f
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/csv") {
+		return "csv"
+	} else if strings.Contains(accept, "text/calendar") {
+		return "ics"
+	}
+	return "json"
+}
+
+// attendanceHandler exports the attendance report of a group.
+func attendanceHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD", "GET")
+		return
+	}
+
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "bad time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := attendanceFormat(r)
+	if format != "json" && format != "csv" && format != "ics" {
+		http.Error(w, "unknown format", http.StatusNotAcceptable)
+		return
+	}
+
+	_, err := group.GetDescription(g)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	as, err := group.GetAttendance(g, since)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.Header().Set("cache-control", "no-cache")
+	if format == "json" {
+		if as == nil {
+			as = []group.Attendance{}
+		}
+		sendJSON(w, r, as)
+		return
+	}
+
+	now := time.Now()
+	var buf bytes.Buffer
+	var ctype string
+	if format == "csv" {
+		ctype = "text/csv; charset=utf-8"
+		err = attendanceCSV(&buf, as, now)
+	} else {
+		ctype = "text/calendar; charset=utf-8"
+		attendanceICS(&buf, g, as, now)
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.Header().Set("content-type", ctype)
+	w.Header().Set("content-disposition", mime.FormatMediaType(
+		"attachment", map[string]string{
+			"filename": "attendance-" +
+				strings.ReplaceAll(g, "/", "-") + "." + format,
+		},
+	))
+	if r.Method == "HEAD" {
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// attendanceDuration returns the duration of a session, counting
+// sessions in progress up to now.
+func attendanceDuration(a group.Attendance, now time.Time) time.Duration {
+	if a.Left != nil {
+		return a.Left.Sub(a.Joined)
+	}
+	return now.Sub(a.Joined)
+}
+
+func attendanceCSV(buf *bytes.Buffer, as []group.Attendance, now time.Time) error {
+	w := csv.NewWriter(buf)
+	w.Write([]string{"id", "username", "joined", "left", "seconds"})
+	for _, a := range as {
+		left := ""
+		if a.Left != nil {
+			left = a.Left.UTC().Format(time.RFC3339)
+		}
+		w.Write([]string{
+			a.Id, a.Username, a.Joined.UTC().Format(time.RFC3339),
+			left, strconv.FormatInt(
+				int64(attendanceDuration(a, now).Seconds()), 10,
+			),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// icsEscape escapes a text value as required by RFC 5545.
+func icsEscape(s string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n", "\r", "",
+	).Replace(s)
+}
+
+// icsLine writes a content line, folding it at 75 octets.
+func icsLine(buf *bytes.Buffer, line string) {
+	for len(line) > 75 {
+		n := 75
+		for n > 1 && line[n]&0xC0 == 0x80 {
+			// don't split a UTF-8 sequence
+			n--
+		}
+		buf.WriteString(line[:n])
+		buf.WriteString("\r\n ")
+		line = line[n:]
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+func attendanceICS(buf *bytes.Buffer, g string, as []group.Attendance, now time.Time) {
+	const layout = "20060102T150405Z"
+	icsLine(buf, "BEGIN:VCALENDAR")
+	icsLine(buf, "VERSION:2.0")
+	icsLine(buf, "PRODID:-//Galene//Attendance//EN")
+	for _, a := range as {
+		name := a.Username
+		if name == "" {
+			name = "(anonymous)"
+		}
+		joined := a.Joined.UTC()
+		desc := "Joined " + joined.Format(time.RFC3339)
+		if a.Left != nil {
+			desc += ", left " + a.Left.UTC().Format(time.RFC3339)
+		} else {
+			desc += ", still present"
+		}
+		desc += fmt.Sprintf(" (%v)",
+			attendanceDuration(a, now).Truncate(time.Second))
+
+		icsLine(buf, "BEGIN:VJOURNAL")
+		icsLine(buf, "UID:"+icsEscape(fmt.Sprintf("%v-%v@%v",
+			a.Id, joined.Unix(), g)))
+		icsLine(buf, "DTSTAMP:"+now.UTC().Format(layout))
+		icsLine(buf, "DTSTART:"+joined.Format(layout))
+		icsLine(buf, "SUMMARY:"+icsEscape(name+" in "+g))
+		icsLine(buf, "DESCRIPTION:"+icsEscape(desc))
+		icsLine(buf, "CATEGORIES:"+icsEscape("attendance"))
+		icsLine(buf, "END:VJOURNAL")
+	}
+	icsLine(buf, "END:VCALENDAR")
+}
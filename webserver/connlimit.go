@@ -0,0 +1,62 @@
+package webserver
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// connections counts the websocket connections from each address.
+var connections struct {
+	mu sync.Mutex
+	m  map[netip.Addr]int
+}
+
+// connectionKey returns the key under which connections from a given
+// address are counted.  IPv6 addresses are counted per /64, since
+// a single host usually has a whole prefix at its disposal.
+func connectionKey(addr net.Addr) (netip.Addr, bool) {
+	a, ok := addr.(*net.TCPAddr)
+	if !ok || a == nil {
+		return netip.Addr{}, false
+	}
+	ip := a.AddrPort().Addr().Unmap()
+	if ip.Is6() {
+		return netip.PrefixFrom(ip, 64).Masked().Addr(), true
+	}
+	return ip, true
+}
+
+// addConnection records a new connection from the given address.  It
+// returns false if the address already has max connections.  The key
+// must be passed to delConnection when the connection is closed.
+func addConnection(addr net.Addr, max int) (netip.Addr, bool) {
+	key, ok := connectionKey(addr)
+	if !ok {
+		return key, true
+	}
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	if max > 0 && connections.m[key] >= max {
+		return netip.Addr{}, false
+	}
+	if connections.m == nil {
+		connections.m = make(map[netip.Addr]int)
+	}
+	connections.m[key]++
+	return key, true
+}
+
+func delConnection(key netip.Addr) {
+	if !key.IsValid() {
+		return
+	}
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	n := connections.m[key] - 1
+	if n <= 0 {
+		delete(connections.m, key)
+	} else {
+		connections.m[key] = n
+	}
+}
@@ -0,0 +1,50 @@
+package webserver
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnectionLimit(t *testing.T) {
+	a1 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	a2 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1235}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1234}
+	v6a := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+	v6b := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1234}
+
+	k1, ok := addConnection(a1, 1)
+	if !ok {
+		t.Fatalf("First connection refused")
+	}
+	if _, ok := addConnection(a2, 1); ok {
+		t.Errorf("Second connection from the same address accepted")
+	}
+	kb, ok := addConnection(b, 1)
+	if !ok {
+		t.Errorf("Connection from another address refused")
+	}
+	k6, ok := addConnection(v6a, 1)
+	if !ok {
+		t.Errorf("IPv6 connection refused")
+	}
+	if _, ok := addConnection(v6b, 1); ok {
+		t.Errorf("Second connection from the same /64 accepted")
+	}
+
+	delConnection(k1)
+	k2, ok := addConnection(a2, 1)
+	if !ok {
+		t.Errorf("Connection refused after close")
+	}
+
+	if _, ok := addConnection(nil, 1); !ok {
+		t.Errorf("Connection with unknown address refused")
+	}
+
+	delConnection(k2)
+	delConnection(kb)
+	delConnection(k6)
+	if len(connections.m) != 0 {
+		t.Errorf("Expected empty map, got %v", connections.m)
+	}
+}
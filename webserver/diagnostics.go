@@ -0,0 +1,97 @@
+package webserver
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/logging"
+	"github.com/jech/galene/stats"
+)
+
+// configSummary describes the server's configuration without
+// revealing any secrets.
+type configSummary struct {
+	PublicServer             bool   `json:"publicServer"`
+	CanonicalHost            string `json:"canonicalHost,omitempty"`
+	ProxyURL                 string `json:"proxyURL,omitempty"`
+	WritableGroups           bool   `json:"writableGroups"`
+	Users                    int    `json:"users"`
+	TrustedProxies           int    `json:"trustedProxies"`
+	Captions                 bool   `json:"captions"`
+	Transcode                bool   `json:"transcode"`
+	MaxConnectionsPerAddress int    `json:"maxConnectionsPerAddress,omitempty"`
+	MaxConnectionsPerUser    int    `json:"maxConnectionsPerUser,omitempty"`
+	BandwidthCaps            bool   `json:"bandwidthCaps"`
+	Groups                   int    `json:"groups"`
+	RunningGroups            int    `json:"runningGroups"`
+	Insecure                 bool   `json:"insecure"`
+	Error                    string `json:"error,omitempty"`
+}
+
+// diagnostics is the bundle returned by the .diagnostics endpoint.
+type diagnostics struct {
+	Time       time.Time             `json:"time"`
+	GoVersion  string                `json:"goVersion"`
+	Goroutines int                   `json:"goroutines"`
+	Config     configSummary         `json:"config"`
+	Stats      []stats.GroupStats    `json:"stats"`
+	History    []stats.Sample        `json:"history"`
+	Logs       []logging.RecentEntry `json:"logs"`
+}
+
+func getConfigSummary() configSummary {
+	var s configSummary
+	s.Insecure = Insecure
+	s.RunningGroups = len(group.GetNames())
+	names, err := group.GetDescriptionNames()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.Groups = len(names)
+
+	conf, err := group.GetConfiguration()
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	s.PublicServer = conf.PublicServer
+	s.CanonicalHost = conf.CanonicalHost
+	s.ProxyURL = conf.ProxyURL
+	s.WritableGroups = conf.WritableGroups
+	s.Users = len(conf.Users)
+	s.TrustedProxies = len(conf.TrustedProxies)
+	s.Captions = conf.Captions != nil
+	s.Transcode = conf.Transcode != nil
+	s.MaxConnectionsPerAddress = conf.MaxConnectionsPerAddress
+	s.MaxConnectionsPerUser = conf.MaxConnectionsPerUser
+	s.BandwidthCaps = conf.BandwidthCaps != nil
+	return s
+}
+
+func diagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD", "GET")
+		return
+	}
+
+	now := time.Now()
+	d := diagnostics{
+		Time:       now,
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		Config:     getConfigSummary(),
+		Stats:      stats.GetGroups(),
+		History:    stats.History(),
+		Logs:       logging.Recent(),
+	}
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("content-disposition",
+		`attachment; filename="galene-diagnostics-`+
+			now.UTC().Format("20060102T150405Z")+`.json"`)
+	sendJSON(w, r, d)
+}
@@ -0,0 +1,219 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/filedrop"
+	"github.com/jech/galene/group"
+)
+
+// requestPermissions authenticates a request against a group, using
+// either HTTP basic authentication or a bearer token, and returns the
+// username and permissions of the requester.
+func requestPermissions(r *http.Request, g *group.Group) (string, []string, error) {
+	var creds group.ClientCredentials
+	user, pass, ok := r.BasicAuth()
+	if ok {
+		creds.Username = &user
+		creds.Password = pass
+	} else {
+		t, found := strings.CutPrefix(
+			r.Header.Get("Authorization"), "Bearer ",
+		)
+		if !found {
+			return "", nil, &group.NotAuthorisedError{}
+		}
+		creds.Token = t
+	}
+	return g.GetPermission(creds)
+}
+
+// filesHandler handles the files shared in a group.  The list of files
+// and uploads require authentication, downloads are authorised by the
+// knowledge of the file's id, which is only announced to the members of
+// the group.
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	if redirect(w, r) {
+		return
+	}
+
+	dir, id := path.Split(strings.TrimPrefix(r.URL.Path, "/files"))
+	name := parseGroupName("/", strings.TrimSuffix(dir, "/"))
+	if name == "" {
+		notFound(w)
+		return
+	}
+
+	if id != "" {
+		if r.Method == "HEAD" || r.Method == "GET" {
+			serveSharedFile(w, r, name, id)
+			return
+		} else if r.Method == "DELETE" {
+			deleteSharedFile(w, r, name, id)
+			return
+		}
+		methodNotAllowed(w, "HEAD", "GET", "DELETE")
+		return
+	}
+
+	g := group.Get(name)
+	if g == nil || !g.Description().AllowFiles {
+		notFound(w)
+		return
+	}
+
+	username, perms, err := requestPermissions(r, g)
+	if err != nil {
+		var autherr *group.NotAuthorisedError
+		if errors.As(err, &autherr) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		failAuthentication(w, "files/"+name)
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		files, err := filedrop.List(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			httpError(w, err)
+			return
+		}
+		if files == nil {
+			files = []*filedrop.File{}
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, files)
+		return
+	} else if r.Method == "POST" {
+		if !slices.Contains(perms, "message") {
+			http.Error(w, "not authorised", http.StatusForbidden)
+			return
+		}
+		uploadSharedFile(w, r, g, username)
+		return
+	}
+	methodNotAllowed(w, "HEAD", "GET", "POST")
+}
+
+func uploadSharedFile(w http.ResponseWriter, r *http.Request, g *group.Group, username string) {
+	desc := g.Description()
+	maxSize := group.MaxFileSize(desc)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+64*1024)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var file *filedrop.File
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			httpError(w, err)
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		file, err = filedrop.Add(g.Name(), part.FileName(), username,
+			part, maxSize, desc.FileTypes,
+		)
+		part.Close()
+		if errors.Is(err, filedrop.ErrTooLarge) {
+			http.Error(w, "file too large",
+				http.StatusRequestEntityTooLarge)
+			return
+		} else if errors.Is(err, filedrop.ErrBadType) {
+			http.Error(w, "file type not allowed",
+				http.StatusUnsupportedMediaType)
+			return
+		} else if errors.Is(err, filedrop.ErrTooManyFiles) {
+			http.Error(w, "too many files", http.StatusConflict)
+			return
+		} else if err != nil {
+			httpError(w, err)
+			return
+		}
+		break
+	}
+	if file == nil {
+		http.Error(w, "no file", http.StatusBadRequest)
+		return
+	}
+
+	u := path.Join("/files/", g.Name(), file.Id)
+	base, err := baseURL(r)
+	if err == nil {
+		u = base.JoinPath("files", g.Name(), file.Id).String()
+	}
+
+	user := username
+	g.Chat(nil, "", &user, false, false, time.Now(), "",
+		fmt.Sprintf("%v (%v bytes): %v", file.Name, file.Size, u),
+	)
+
+	w.Header().Set("location", u)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	sendJSON(w, r, file)
+}
+
+func serveSharedFile(w http.ResponseWriter, r *http.Request, g, id string) {
+	file, err := filedrop.Get(g, id)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	f, err := filedrop.Open(g, id)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+
+	// never let the browser interpret the file, which could contain
+	// active content
+	w.Header().Set("content-type", file.ContentType)
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.Header().Set("content-security-policy", "sandbox")
+	w.Header().Set("content-disposition", mime.FormatMediaType(
+		"attachment", map[string]string{"filename": file.Name},
+	))
+	w.Header().Set("cache-control", "private, max-age=86400")
+	http.ServeContent(w, r, "", file.Time, f)
+}
+
+func deleteSharedFile(w http.ResponseWriter, r *http.Request, name, id string) {
+	g := group.Get(name)
+	if g == nil {
+		notFound(w)
+		return
+	}
+	_, perms, err := requestPermissions(r, g)
+	if err != nil {
+		failAuthentication(w, "files/"+name)
+		return
+	}
+	if !slices.Contains(perms, "op") {
+		http.Error(w, "not authorised", http.StatusForbidden)
+		return
+	}
+	err = filedrop.Delete(name, id)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,187 @@
+package webserver
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/diskwriter"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/ice"
+)
+
+// listenerState records whether the main HTTP listener is running.
+type listenerState struct {
+	mu      sync.Mutex
+	address string
+	err     error
+}
+
+var listenerStatus listenerState
+
+func (l *listenerState) set(address string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.address = address
+	l.err = err
+}
+
+type healthListener struct {
+	Address   string `json:"address,omitempty"`
+	Listening bool   `json:"listening"`
+	Error     string `json:"error,omitempty"`
+}
+
+type healthCertificate struct {
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type healthRecordings struct {
+	Free  *uint64 `json:"free,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// healthRelayTest is the result of the latest test of the TURN relays
+// performed by ice.RelayTest.  The reachability of the UDP ports used
+// for media is not tested.
+type healthRelayTest struct {
+	Time  time.Time `json:"time"`
+	RTT   float64   `json:"rtt,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+type healthLoad struct {
+	Groups  int `json:"groups"`
+	Clients int `json:"clients"`
+}
+
+type healthReport struct {
+	Status      string             `json:"status"`
+	Errors      []string           `json:"errors,omitempty"`
+	Draining    bool               `json:"draining,omitempty"`
+	Listener    healthListener     `json:"listener"`
+	Certificate *healthCertificate `json:"certificate,omitempty"`
+	Recordings  healthRecordings   `json:"recordings"`
+	TURNRelay   *healthRelayTest   `json:"turnRelayTest,omitempty"`
+	Load        healthLoad         `json:"load"`
+}
+
+func certificateValidity() (time.Time, time.Time, error) {
+	if getCertificate == nil {
+		return time.Time{}, time.Time{}, errors.New("no certificate")
+	}
+	c, err := getCertificate()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	leaf := c.Leaf
+	if leaf == nil {
+		if len(c.Certificate) == 0 {
+			return time.Time{}, time.Time{},
+				errors.New("empty certificate")
+		}
+		leaf, err = x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return leaf.NotBefore, leaf.NotAfter, nil
+}
+
+// getHealth returns a report about the state of the server, with the
+// status left empty.  The boolean is true if the server is alive, the
+// errors indicate why the server is not ready to accept clients.
+func getHealth() (healthReport, bool) {
+	var report healthReport
+	alive := true
+
+	listenerStatus.mu.Lock()
+	report.Listener.Address = listenerStatus.address
+	report.Listener.Listening = listenerStatus.address != ""
+	if listenerStatus.err != nil {
+		report.Listener.Error = listenerStatus.err.Error()
+	}
+	listenerStatus.mu.Unlock()
+	if !report.Listener.Listening {
+		alive = false
+		report.Errors = append(report.Errors, "listener is not running")
+	}
+
+	if !Insecure {
+		notBefore, notAfter, err := certificateValidity()
+		report.Certificate = &healthCertificate{
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+		}
+		now := time.Now()
+		if err != nil {
+			report.Certificate.Error = err.Error()
+			report.Errors = append(report.Errors,
+				"certificate: "+err.Error())
+		} else if now.Before(notBefore) || now.After(notAfter) {
+			report.Errors = append(report.Errors,
+				"certificate is not valid")
+		}
+	}
+
+	free, err := diskwriter.FreeSpace()
+	if err != nil {
+		report.Recordings.Error = err.Error()
+	} else {
+		report.Recordings.Free = &free
+	}
+
+	relay := ice.LastRelayTest()
+	if relay != nil {
+		report.TURNRelay = &healthRelayTest{
+			Time: relay.Time,
+		}
+		if relay.Error != nil {
+			report.TURNRelay.Error = relay.Error.Error()
+		} else {
+			report.TURNRelay.RTT =
+				float64(relay.RTT) / float64(time.Millisecond)
+		}
+	}
+
+	report.Load.Groups = len(group.GetNames())
+	report.Load.Clients = group.CountClients()
+
+	if group.Draining() {
+		report.Draining = true
+		report.Errors = append(report.Errors, "server is shutting down")
+	}
+
+	return report, alive
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request, ready bool) {
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD", "GET")
+		return
+	}
+
+	w.Header().Set("cache-control", "no-cache")
+
+	report, alive := getHealth()
+	if (ready && len(report.Errors) > 0) || (!ready && !alive) {
+		report.Status = "unavailable"
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		report.Status = "ok"
+	}
+	sendJSON(w, r, report)
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	healthHandler(w, r, false)
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	healthHandler(w, r, true)
+}
@@ -0,0 +1,34 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := http.Get("http://localhost:1234" + path)
+		if err != nil {
+			t.Fatalf("Get %v: %v", path, err)
+		}
+		var report healthReport
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("Decode %v: %v", path, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%v: status %v, errors %v",
+				path, resp.StatusCode, report.Errors)
+		}
+		if report.Status != "ok" || !report.Listener.Listening {
+			t.Errorf("%v: unexpected report %v", path, report)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// ProxyProtocol indicates that the server expects every incoming
+// connection to start with a PROXY protocol header (version 1 or 2).
+// Unless listening on a Unix socket, this requires trustedProxies to be
+// set in the configuration file.
+var ProxyProtocol bool
+
+// trustedProxies returns the list of trusted proxies from the
+// configuration file.
+func trustedProxies() []netip.Prefix {
+	conf, err := group.GetConfiguration()
+	if err != nil || len(conf.TrustedProxies) == 0 {
+		return nil
+	}
+	prefixes, err := group.ParsePrefixes(conf.TrustedProxies)
+	if err != nil {
+		logger.Warn("Parse trustedProxies", "err", err)
+		return nil
+	}
+	return prefixes
+}
+
+func isTrusted(a netip.Addr, trusted []netip.Prefix) bool {
+	a = a.Unmap()
+	for _, p := range trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedAddr returns the address of the client, as determined from
+// the peer address and the headers set by trusted proxies.
+func forwardedAddr(remote netip.AddrPort, h http.Header, trusted []netip.Prefix) netip.AddrPort {
+	if !isTrusted(remote.Addr(), trusted) {
+		return remote
+	}
+
+	// walk the list from the right, the leftmost entries are
+	// controlled by the client.
+	var forwarded []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	result := remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			return result
+		}
+		result = netip.AddrPortFrom(a.Unmap(), 0)
+		if !isTrusted(a, trusted) {
+			return result
+		}
+	}
+	if len(forwarded) > 0 {
+		return result
+	}
+
+	real := strings.TrimSpace(h.Get("X-Real-IP"))
+	if real != "" {
+		a, err := netip.ParseAddr(real)
+		if err == nil {
+			return netip.AddrPortFrom(a.Unmap(), 0)
+		}
+	}
+	return remote
+}
+
+// clientAddr returns the address of the client that made a request,
+// taking trusted proxies into account.  It returns nil if the address
+// cannot be determined, which happens when listening on a Unix socket.
+func clientAddr(r *http.Request) net.Addr {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	a := forwardedAddr(remote, r.Header, trustedProxies())
+	return net.TCPAddrFromAddrPort(a)
+}
+
+// clientAddrString is like clientAddr, but returns a string suitable
+// for logging.
+func clientAddrString(r *http.Request) string {
+	a := clientAddr(r)
+	if a == nil {
+		return r.RemoteAddr
+	}
+	return a.String()
+}
+
+// proxyListener is a listener that expects connections to start with
+// a PROXY protocol header.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c}, nil
+}
+
+// proxyConn is a connection that starts with a PROXY header.  The header
+// is parsed lazily, in order to avoid blocking the accept loop.
+type proxyConn struct {
+	net.Conn
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+var errProxyUntrusted = errors.New("PROXY header from untrusted peer")
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		c.remote = c.Conn.RemoteAddr()
+
+		// anyone able to connect to a Unix socket is trusted,
+		// otherwise the peer must be in trustedProxies.
+		if c.remote.Network() != "unix" {
+			a, err := netip.ParseAddrPort(c.remote.String())
+			if err != nil || !isTrusted(a.Addr(), trustedProxies()) {
+				c.err = errProxyUntrusted
+				return
+			}
+		}
+
+		c.Conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		addr, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = err
+			return
+		}
+		if addr != nil {
+			c.remote = addr
+		}
+	})
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("bad PROXY header")
+
+// readProxyHeader reads a PROXY protocol header, and returns the source
+// address, or nil if the header doesn't carry an address.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, found := strings.CutSuffix(string(line), "\r\n")
+	if !found {
+		return nil, errProxyHeader
+	}
+	fields := strings.Split(s, " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, errProxyHeader
+		}
+		a, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			return nil, errProxyHeader
+		}
+		port, err := strconv.ParseUint(fields[4], 10, 16)
+		if err != nil {
+			return nil, errProxyHeader
+		}
+		return net.TCPAddrFromAddrPort(
+			netip.AddrPortFrom(a.Unmap(), uint16(port)),
+		), nil
+	default:
+		return nil, errProxyHeader
+	}
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	command := header[12] & 0xF
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0:
+		// LOCAL, health checks by the proxy itself
+		return nil, nil
+	case 1:
+		// PROXY
+	default:
+		return nil, errProxyHeader
+	}
+
+	switch family >> 4 {
+	case 1:
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		a := netip.AddrFrom4([4]byte(body[0:4]))
+		port := binary.BigEndian.Uint16(body[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, port)), nil
+	case 2:
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		a := netip.AddrFrom16([16]byte(body[0:16])).Unmap()
+		port := binary.BigEndian.Uint16(body[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, port)), nil
+	default:
+		// AF_UNSPEC or AF_UNIX
+		return nil, nil
+	}
+}
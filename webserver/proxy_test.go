@@ -0,0 +1,184 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestForwardedAddr(t *testing.T) {
+	trusted, err := group.ParsePrefixes([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatalf("ParsePrefixes: %v", err)
+	}
+
+	tests := []struct {
+		remote    string
+		forwarded []string
+		real      string
+		result    string
+	}{
+		{"192.0.2.1:1234", nil, "", "192.0.2.1:1234"},
+		{"192.0.2.1:1234", []string{"198.51.100.1"}, "",
+			"192.0.2.1:1234"},
+		{"10.0.0.1:1234", []string{"198.51.100.1"}, "",
+			"198.51.100.1:0"},
+		{"10.0.0.1:1234", []string{"203.0.113.1, 198.51.100.1"}, "",
+			"198.51.100.1:0"},
+		{"10.0.0.1:1234", []string{"203.0.113.1", "10.0.0.2"}, "",
+			"203.0.113.1:0"},
+		{"10.0.0.1:1234", []string{"garbage, 10.0.0.2"}, "",
+			"10.0.0.2:0"},
+		{"[::1]:1234", nil, "198.51.100.1", "198.51.100.1:0"},
+		{"[::1]:1234", nil, "garbage", "[::1]:1234"},
+		{"[::ffff:10.0.0.1]:1234", nil, "198.51.100.1",
+			"198.51.100.1:0"},
+	}
+
+	for _, test := range tests {
+		h := make(http.Header)
+		for _, f := range test.forwarded {
+			h.Add("X-Forwarded-For", f)
+		}
+		if test.real != "" {
+			h.Set("X-Real-IP", test.real)
+		}
+		remote := netip.MustParseAddrPort(test.remote)
+		result := forwardedAddr(remote, h, trusted)
+		if result.String() != test.result {
+			t.Errorf("%v %v %v: got %v, expected %v",
+				test.remote, test.forwarded, test.real,
+				result, test.result)
+		}
+	}
+}
+
+func TestProxyV1(t *testing.T) {
+	tests := []struct {
+		header string
+		addr   string
+	}{
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 1234 443\r\n", "192.0.2.1:1234"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n",
+			"[2001:db8::1]:1234"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{"PROXY TCP4 192.0.2.1\r\n", "error"},
+		{"GET / HTTP/1.1\r\n", "error"},
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 1234 443\n", "error"},
+	}
+
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(test.header + "rest"))
+		addr, err := readProxyHeader(r)
+		if err != nil {
+			if test.addr != "error" {
+				t.Errorf("%q: %v", test.header, err)
+			}
+			continue
+		}
+		if test.addr == "error" {
+			t.Errorf("%q: succeeded", test.header)
+			continue
+		}
+		a := ""
+		if addr != nil {
+			a = addr.String()
+		}
+		if a != test.addr {
+			t.Errorf("%q: got %v, expected %v", test.header, a, test.addr)
+		}
+		rest, _ := r.ReadString(0)
+		if rest != "rest" {
+			t.Errorf("%q: remaining %q", test.header, rest)
+		}
+	}
+}
+
+func TestProxyV2(t *testing.T) {
+	var b bytes.Buffer
+	b.Write(proxyV2Signature)
+	b.Write([]byte{0x21, 0x11, 0, 12})
+	b.Write([]byte{192, 0, 2, 1, 192, 0, 2, 2, 0x04, 0xD2, 0x01, 0xBB})
+	b.WriteString("rest")
+
+	r := bufio.NewReader(&b)
+	addr, err := readProxyHeader(r)
+	if err != nil {
+		t.Fatalf("readProxyHeader: %v", err)
+	}
+	if addr == nil || addr.String() != "192.0.2.1:1234" {
+		t.Errorf("Got %v", addr)
+	}
+	rest, _ := r.ReadString(0)
+	if rest != "rest" {
+		t.Errorf("Remaining %q", rest)
+	}
+
+	b.Reset()
+	b.Write(proxyV2Signature)
+	b.Write([]byte{0x20, 0x00, 0, 0})
+	addr, err = readProxyHeader(bufio.NewReader(&b))
+	if err != nil || addr != nil {
+		t.Errorf("LOCAL: got %v %v", addr, err)
+	}
+}
+
+func TestProxyTrusted(t *testing.T) {
+	defer func(d string) { group.DataDirectory = d }(group.DataDirectory)
+	group.DataDirectory = t.TempDir()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	pl := proxyListener{l}
+
+	try := func() (net.Addr, error) {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		_, err = c.Write([]byte(
+			"PROXY TCP4 192.0.2.1 192.0.2.2 1234 443\r\nrest",
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(s, buf)
+		return s.RemoteAddr(), err
+	}
+
+	_, err = try()
+	if err != errProxyUntrusted {
+		t.Errorf("No trusted proxies: got %v", err)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.DataDirectory, "config.json"),
+		[]byte(`{"trustedProxies": ["127.0.0.1"]}`), 0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := try()
+	if err != nil || addr.String() != "192.0.2.1:1234" {
+		t.Errorf("Trusted proxy: got %v %v", addr, err)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"html"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -24,11 +23,18 @@ import (
 	"github.com/jech/cert"
 	"github.com/jech/galene/diskwriter"
 	"github.com/jech/galene/group"
+	"github.com/jech/galene/logging"
 	"github.com/jech/galene/rtpconn"
 )
 
+var logger = logging.New("webserver")
+
 var server *http.Server
 
+// getCertificate returns the server's certificate, it is nil if running
+// in insecure mode.
+var getCertificate func() (*tls.Certificate, error)
+
 var StaticRoot string
 
 var Insecure bool
@@ -42,20 +48,31 @@ func Serve(address string, dataDir string) error {
 				"/recordings/", http.StatusPermanentRedirect)
 		})
 	http.HandleFunc("/recordings/", recordingsHandler)
+	http.HandleFunc("/files/", filesHandler)
+	http.HandleFunc("/assets/", assetsHandler)
 	http.HandleFunc("/ws", wsHandler)
 	http.HandleFunc("/public-groups.json", publicHandler)
 	http.HandleFunc("/galene-api/", apiHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
 
 	s := &http.Server{
 		Addr:              address,
 		ReadHeaderTimeout: 60 * time.Second,
 		IdleTimeout:       120 * time.Second,
 	}
-	if !Insecure {
+	if !Insecure && len(ACMEDomains) > 0 {
+		config, err := acmeConfig(dataDir)
+		if err != nil {
+			return err
+		}
+		s.TLSConfig = config
+	} else if !Insecure {
 		certificate := cert.New(
 			filepath.Join(dataDir, "cert.pem"),
 			filepath.Join(dataDir, "key.pem"),
 		)
+		getCertificate = certificate.Get
 		s.TLSConfig = &tls.Config{
 			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 				return certificate.Get()
@@ -73,17 +90,35 @@ func Serve(address string, dataDir string) error {
 		proto = "unix"
 	}
 
+	if ProxyProtocol && proto != "unix" && len(trustedProxies()) == 0 {
+		return errors.New(
+			"the PROXY protocol requires trustedProxies to be set",
+		)
+	}
+
 	listener, err := net.Listen(proto, address)
 	if err != nil {
 		return err
 	}
+	if ProxyProtocol {
+		listener = proxyListener{listener}
+	}
+	listenerStatus.set(listener.Addr().String(), nil)
 	go func() {
 		defer listener.Close()
+		var err error
 		if !Insecure {
 			err = s.ServeTLS(listener, "", "")
 		} else {
 			err = s.Serve(listener)
 		}
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		if err != nil {
+			logger.Warn("Serve", "err", err)
+		}
+		listenerStatus.set("", err)
 	}()
 	return nil
 }
@@ -126,7 +161,7 @@ func httpError(w http.ResponseWriter, err error) {
 	}
 	var autherr *group.NotAuthorisedError
 	if errors.As(err, &autherr) {
-		log.Printf("HTTP server error: %v", err)
+		logger.Warn("HTTP server error", "err", err)
 		http.Error(w, "not authorised", http.StatusUnauthorized)
 		return
 	}
@@ -136,7 +171,7 @@ func httpError(w http.ResponseWriter, err error) {
 			http.StatusRequestEntityTooLarge)
 		return
 	}
-	log.Printf("HTTP server error: %v", err)
+	logger.Warn("HTTP server error", "err", err)
 	http.Error(w, "Internal server error",
 		http.StatusInternalServerError)
 }
@@ -428,7 +463,7 @@ func groupStatusHandler(w http.ResponseWriter, r *http.Request) {
 
 	base, err := baseURL(r)
 	if err != nil {
-		log.Printf("Parse ProxyURL: %v", err)
+		logger.Warn("Parse ProxyURL", "err", err)
 		http.Error(w, "Internal server error",
 			http.StatusInternalServerError)
 		return
@@ -448,7 +483,7 @@ func groupStatusHandler(w http.ResponseWriter, r *http.Request) {
 func publicHandler(w http.ResponseWriter, r *http.Request) {
 	base, err := baseURL(r)
 	if err != nil {
-		log.Printf("couldn't determine group base: %v", err)
+		logger.Warn("Couldn't determine group base", "err", err)
 		httpError(w, err)
 		return
 	}
@@ -509,6 +544,12 @@ var wsPublicUpgrader = websocket.Upgrader{
 }
 
 func wsHandler(w http.ResponseWriter, r *http.Request) {
+	if group.Draining() {
+		http.Error(w, "server is shutting down",
+			http.StatusServiceUnavailable)
+		return
+	}
+
 	conf, err := group.GetConfiguration()
 	if err != nil {
 		httpError(w, err)
@@ -519,24 +560,32 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		upgrader = wsPublicUpgrader
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("Websocket upgrade: %v", err)
+	addr := clientAddr(r)
+	key, ok := addConnection(addr, conf.MaxConnectionsPerAddress)
+	if !ok {
+		logger.Warn("Too many connections",
+			"addr", clientAddrString(r))
+		http.Error(w, "too many connections",
+			http.StatusTooManyRequests)
 		return
 	}
 
-	var addr net.Addr
-	tcpaddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("ResolveTCPAddr: %v", err)
-	} else {
-		addr = tcpaddr
+		delConnection(key)
+		logger.Warn("Websocket upgrade",
+			"addr", clientAddrString(r), "err", err)
+		return
 	}
 
 	go func() {
-		err := rtpconn.StartClient(conn, addr)
+		// if the websocket resumes a session, the connection is
+		// counted until the session loses it
+		err := rtpconn.StartClient(conn, addr, func() {
+			delConnection(key)
+		})
 		if err != nil {
-			log.Printf("client: %v", err)
+			logger.Warn("Client", "addr", addr, "err", err)
 		}
 	}()
 }
@@ -761,10 +810,11 @@ func serveGroupRecordings(w http.ResponseWriter, r *http.Request, f *os.File, gr
 
 func Shutdown() {
 	if server == nil {
-		log.Printf("Shutting down nonexistent server")
+		logger.Warn("Shutting down nonexistent server")
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	server.Shutdown(ctx)
 	server = nil
+	shutdownACME(ctx)
 }
@@ -1,12 +1,15 @@
 package webserver
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/pion/webrtc/v3"
 
@@ -284,3 +287,25 @@ func TestObfuscate(t *testing.T) {
 		t.Errorf("obfuscate: no errror")
 	}
 }
+
+func TestICSLine(t *testing.T) {
+	var buf bytes.Buffer
+	line := "SUMMARY:" + strings.Repeat("é", 50)
+	icsLine(&buf, line)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) != 2 {
+		t.Fatalf("Got %q", buf.String())
+	}
+	for _, l := range lines {
+		if len(l) > 76 || !utf8.ValidString(l) {
+			t.Errorf("Bad line %q", l)
+		}
+	}
+	if lines[0]+lines[1][1:] != line {
+		t.Errorf("Got %q", buf.String())
+	}
+
+	if e := icsEscape("a,b;c\\d\ne"); e != `a\,b\;c\\d\ne` {
+		t.Errorf("Got %q", e)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"path"
@@ -127,8 +126,8 @@ func formatICEServer(server webrtc.ICEServer, u string) string {
 	return ""
 }
 
-func whipICEServers(w http.ResponseWriter) {
-	conf := ice.ICEConfiguration()
+func whipICEServers(w http.ResponseWriter, r *http.Request) {
+	conf := ice.ICEConfigurationFor(clientAddr(r))
 	for _, server := range conf.ICEServers {
 		for _, u := range server.URLs {
 			v := formatICEServer(server, u)
@@ -181,7 +180,7 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 			"Authorization, Content-Type",
 		)
 		w.Header().Set("Access-Control-Expose-Headers", "Link")
-		whipICEServers(w)
+		whipICEServers(w, r)
 		return
 	}
 
@@ -219,19 +218,11 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var addr net.Addr
-	tcpaddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
-	if err != nil {
-		log.Printf("ResolveTCPAddr: %v", err)
-	} else {
-		addr = tcpaddr
-	}
-
-	c := rtpconn.NewWhipClient(g, id, token, addr)
+	c := rtpconn.NewWhipClient(g, id, token, clientAddr(r))
 
 	_, err = group.AddClient(g.Name(), c, creds)
 	if err != nil {
-		log.Printf("WHIP: %v", err)
+		logger.Warn("WHIP", "group", g.Name(), "err", err)
 		httpError(w, err)
 		return
 	}
@@ -245,7 +236,18 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 	answer, err := c.NewConnection(r.Context(), body)
 	if err != nil {
 		group.DelClient(c)
-		log.Printf("WHIP offer: %v", err)
+		if errors.Is(err, group.ErrTooManyPublishers) {
+			http.Error(w, err.Error(),
+				http.StatusServiceUnavailable)
+			return
+		}
+		var uerr group.UserError
+		if errors.As(err, &uerr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Warn("WHIP offer",
+			"group", g.Name(), "client", id, "err", err)
 		httpError(w, err)
 		return
 	}
@@ -253,7 +255,7 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Location", path.Join(r.URL.Path, obfuscated))
 	w.Header().Set("Access-Control-Expose-Headers",
 		"Location, Content-Type, Link")
-	whipICEServers(w)
+	whipICEServers(w, r)
 	w.Header().Set("Content-Type", "application/sdp")
 	w.WriteHeader(http.StatusCreated)
 	w.Write(answer)
@@ -387,7 +389,7 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			err := c.GotICECandidate(init)
 			if err != nil {
-				log.Printf("WHIP candidate: %v", err)
+				logger.Warn("WHIP candidate", "err", err)
 			}
 		}
 	}